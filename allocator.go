@@ -0,0 +1,65 @@
+package freecache
+
+// Allocator allocates the ring buffers of the segments, see WithAllocator, e.g. outside the Go heap
+// so the garbage collector doesn't account for them, or in a cgo arena.
+type Allocator interface {
+	// Alloc returns a zeroed buffer of size bytes.
+	Alloc(size int) ([]byte, error)
+	// Free releases a buffer returned by Alloc, it isn't used by the cache anymore.
+	Free(buf []byte)
+}
+
+// HeapAllocator allocates the ring buffers on the Go heap, the default.
+type HeapAllocator struct{}
+
+func (HeapAllocator) Alloc(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+func (HeapAllocator) Free(buf []byte) {}
+
+// MmapAllocator allocates the ring buffers in anonymous memory maps outside the Go heap, which are returned
+// to the OS when they are freed. It is only supported on unix systems, Alloc returns ErrMmapUnsupported
+// on other systems.
+type MmapAllocator struct{}
+
+func (MmapAllocator) Alloc(size int) ([]byte, error) {
+	return mmapAnon(size)
+}
+
+func (MmapAllocator) Free(buf []byte) {
+	munmap(buf)
+}
+
+// HugePageAllocator is like MmapAllocator, but asks the OS to back the ring buffers with transparent
+// huge pages, which cuts the TLB misses of the accesses spread over a large cache. The huge pages are
+// only requested on linux, it is the same as MmapAllocator on the other systems.
+type HugePageAllocator struct{}
+
+func (HugePageAllocator) Alloc(size int) ([]byte, error) {
+	buf, err := mmapAnon(size)
+	if err != nil {
+		return nil, err
+	}
+	adviseHugePages(buf)
+	return buf, nil
+}
+
+func (HugePageAllocator) Free(buf []byte) {
+	munmap(buf)
+}
+
+// allocBuf allocates a ring buffer of size bytes with the allocator of the segment, on the heap without one.
+func (seg *segment) allocBuf(size int) ([]byte, error) {
+	if seg.alloc == nil {
+		return make([]byte, size), nil
+	}
+	return seg.alloc.Alloc(size)
+}
+
+// freeBuf releases a ring buffer returned by allocBuf.
+func (seg *segment) freeBuf(buf []byte) {
+	if seg.alloc != nil {
+		seg.alloc.Free(buf)
+	}
+}
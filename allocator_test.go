@@ -0,0 +1,95 @@
+package freecache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// countingAllocator allocates on the heap and counts the live buffers, it fails after limit allocations.
+type countingAllocator struct {
+	live   int
+	allocs int
+	limit  int
+}
+
+var errAllocLimit = errors.New("allocation limit")
+
+func (a *countingAllocator) Alloc(size int) ([]byte, error) {
+	if a.limit > 0 && a.allocs == a.limit {
+		return nil, errAllocLimit
+	}
+	a.allocs++
+	a.live++
+	return make([]byte, size), nil
+}
+
+func (a *countingAllocator) Free(buf []byte) {
+	a.live--
+}
+
+func TestAllocator(t *testing.T) {
+	alloc := &countingAllocator{}
+	cache := NewCacheWithOptions(1024*1024, WithAllocator(alloc))
+	if alloc.live != segmentCount {
+		t.Fatalf("live = %d", alloc.live)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte("value"), 0)
+	}
+	if err := cache.Resize(2 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.live != segmentCount || alloc.allocs != 2*segmentCount {
+		t.Fatalf("live = %d, allocs = %d", alloc.live, alloc.allocs)
+	}
+	if v, err := cache.Get([]byte("999")); err != nil || string(v) != "value" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+
+	alloc.limit = alloc.allocs + 10
+	if err := cache.Resize(1024 * 1024); err != errAllocLimit {
+		t.Fatalf("Resize err = %v", err)
+	}
+	if alloc.live != segmentCount {
+		t.Fatalf("live = %d", alloc.live)
+	}
+	if v, err := cache.Get([]byte("999")); err != nil || string(v) != "value" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	cache.Close()
+	cache.Close()
+	if alloc.live != 0 {
+		t.Fatalf("live = %d after Close", alloc.live)
+	}
+
+	alloc = &countingAllocator{limit: 100}
+	if _, err := OpenCache(1024*1024, WithAllocator(alloc)); err != errAllocLimit {
+		t.Fatalf("OpenCache err = %v", err)
+	}
+	if alloc.live != 0 {
+		t.Fatalf("live = %d after a failed OpenCache", alloc.live)
+	}
+}
+
+func TestMmapAllocators(t *testing.T) {
+	for _, alloc := range []Allocator{MmapAllocator{}, HugePageAllocator{}} {
+		cache, err := OpenCache(1024*1024, WithAllocator(alloc))
+		if err == ErrMmapUnsupported {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			cache.Set([]byte(fmt.Sprint(i)), []byte("value"), 0)
+		}
+		if err = cache.Resize(2 * 1024 * 1024); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := cache.Get([]byte("999")); err != nil || string(v) != "value" {
+			t.Fatalf("%T: Get = %q, %v", alloc, v, err)
+		}
+		cache.Close()
+	}
+}
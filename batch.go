@@ -0,0 +1,261 @@
+package freecache
+
+import "time"
+
+// SetMulti sets multiple entries without expiration like Set, acquiring every segment lock only once.
+// The returned errors are in the same order as entries, with a nil error for every entry written.
+// With WithLargeValues or WithOverflowStore, the entries are set one by one by Set.
+func (cache *Cache) SetMulti(entries []Entry) (errs []error) {
+	errs = make([]error, len(entries))
+	if cache.overflow != nil || cache.largeValues {
+		for i := range entries {
+			errs[i] = cache.Set(entries[i].Key, entries[i].Value, 0)
+		}
+		return
+	}
+	cache.batch(len(entries), func(i int) []byte {
+		return entries[i].Key
+	}, func(seg *segment, i int, hashVal uint64) {
+		errs[i] = seg.set(entries[i].Key, entries[i].Value, hashVal, 0)
+	})
+	return
+}
+
+// GetMulti returns the values for multiple keys, acquiring every segment lock only once.
+// The values and errors are in the same order as keys, a missing key has a nil value and a not found error.
+func (cache *Cache) GetMulti(keys [][]byte) (values [][]byte, errs []error) {
+	values = make([][]byte, len(keys))
+	errs = make([]error, len(keys))
+	cache.batch(len(keys), func(i int) []byte {
+		return keys[i]
+	}, func(seg *segment, i int, hashVal uint64) {
+		values[i], _, errs[i] = seg.get(keys[i], nil, hashVal, false)
+	})
+	for i := range values {
+		if errs[i] == nil && cache.stubbed(values[i]) {
+			values[i], errs[i] = cache.unstub(keys[i], values[i], false)
+		}
+	}
+	return
+}
+
+// DelMulti deletes multiple keys, acquiring every segment lock only once, and returns the number of entries deleted.
+// With WithLargeValues or WithOverflowStore, the keys are deleted one by one by Del.
+func (cache *Cache) DelMulti(keys [][]byte) (affected int) {
+	if cache.hasStubs() {
+		for _, key := range keys {
+			if cache.Del(key) {
+				affected++
+			}
+		}
+		return
+	}
+	cache.batch(len(keys), func(i int) []byte {
+		return keys[i]
+	}, func(seg *segment, i int, hashVal uint64) {
+		if seg.del(keys[i], hashVal) {
+			affected++
+		}
+	})
+	return
+}
+
+// TouchMulti updates the expiration of multiple keys like Touch, acquiring every segment lock only once.
+// The returned errors are in the same order as keys, with a nil error for every entry touched.
+func (cache *Cache) TouchMulti(keys [][]byte, expireSeconds int) (errs []error) {
+	errs = make([]error, len(keys))
+	cache.batch(len(keys), func(i int) []byte {
+		return keys[i]
+	}, func(seg *segment, i int, hashVal uint64) {
+		errs[i] = seg.touch(keys[i], hashVal, expireSeconds)
+	})
+	return
+}
+
+// TTLMulti returns the time left of multiple keys like TTL, acquiring every segment lock only once.
+// The time left and errors are in the same order as keys, a missing key has a not found error.
+func (cache *Cache) TTLMulti(keys [][]byte) (timeLeft []uint32, errs []error) {
+	timeLeft = make([]uint32, len(keys))
+	errs = make([]error, len(keys))
+	cache.batch(len(keys), func(i int) []byte {
+		return keys[i]
+	}, func(seg *segment, i int, hashVal uint64) {
+		timeLeft[i], errs[i] = seg.ttl(keys[i], hashVal)
+	})
+	return
+}
+
+// UpdateMulti updates multiple keys like Update in one atomic operation, no other operation sees a part of the
+// updates. The segments of the keys are locked together, in the order of their ids so that concurrent calls
+// don't deadlock, so the keys should be few. fn is called for every key in order with its index in keys, its
+// value and whether it was found, and returns the new value, whether to replace the value and expireSeconds.
+// The first error of a set stops the updates and is returned, the updates before it are rolled back. The sets
+// are sent to the channels of Events and propagated to the stores once every set succeeded.
+func (cache *Cache) UpdateMulti(keys [][]byte, fn func(idx int, old []byte, found bool) (value []byte, replace bool, expireSeconds int)) (err error) {
+	hashVals := make([]uint64, len(keys))
+	var locked [segmentCount]bool
+	for i, key := range keys {
+		hashVals[i] = cache.hash(key)
+		locked[hashVals[i]&segmentAndOpVal] = true
+	}
+	for segID := range locked {
+		if locked[segID] {
+			cache.lockStub(uint64(segID))
+			defer cache.unlockStub(uint64(segID))
+		}
+	}
+	var stubs []Entry
+	rolledBack := false
+	// the values the stubs replaced stood for are dropped once the segments are unlocked.
+	defer func() {
+		if rolledBack {
+			return
+		}
+		for i := range stubs {
+			cache.dropStub(stubs[i].Key, stubs[i].Value)
+		}
+	}()
+	for segID := range locked {
+		if locked[segID] {
+			cache.locks[segID].Lock()
+			defer cache.locks[segID].Unlock()
+		}
+	}
+	// the writers and the set events are held back until every set succeeded.
+	var writes [segmentCount]*writeLog
+	for segID := range locked {
+		if locked[segID] {
+			seg := &cache.segments[segID]
+			writes[segID], seg.writes = seg.writes, nil
+			seg.batched = true
+		}
+	}
+	type update struct {
+		seg               *segment
+		key, value        []byte
+		hashVal           uint64
+		nowMs, expireAtMs int64
+	}
+	var saved []savedEntry
+	var updates []update
+	for i, key := range keys {
+		seg := &cache.segments[hashVals[i]&segmentAndOpVal]
+		old, _, getErr := seg.get(key, nil, hashVals[i], false)
+		value, replace, expireSeconds := fn(i, old, getErr == nil)
+		if !replace {
+			continue
+		}
+		if err = cache.checkValue(value); err != nil {
+			break
+		}
+		saved = append(saved, seg.save(key, hashVals[i]))
+		nowMs := seg.timer.NowMilli()
+		expireAtMs := expireAtMilli(nowMs, seg.setPolicy(time.Duration(expireSeconds)*time.Second))
+		if err = seg.setAt(key, value, hashVals[i], nowMs, expireAtMs, 0, 0, 1); err != nil {
+			break
+		}
+		updates = append(updates, update{seg, key, value, hashVals[i], nowMs, expireAtMs})
+		if getErr == nil && cache.stubbed(old) {
+			stubs = append(stubs, Entry{Key: key, Value: old})
+		}
+	}
+	if err != nil {
+		// the rollback is neither sent to the subscribers nor to the writers, which haven't seen the sets.
+		rolledBack = true
+		for j := len(saved) - 1; j >= 0; j-- {
+			seg := &cache.segments[saved[j].hashVal&segmentAndOpVal]
+			events := seg.events
+			seg.events = nil
+			seg.restoreSaved(&saved[j])
+			seg.events = events
+		}
+	}
+	for segID := range locked {
+		if locked[segID] {
+			seg := &cache.segments[segID]
+			seg.writes = writes[segID]
+			seg.batched = false
+		}
+	}
+	if err != nil {
+		return
+	}
+	for _, u := range updates {
+		if u.seg.events.enabled() {
+			u.seg.events.emit(EventSet, u.key, u.value)
+		}
+		if logErr := u.seg.logSet(u.key, u.value, u.hashVal, u.nowMs, u.expireAtMs, false); logErr != nil && err == nil {
+			err = logErr
+		}
+	}
+	return
+}
+
+// savedEntry is an entry saved by UpdateMulti before replacing it, to restore it if a later set fails.
+type savedEntry struct {
+	key, value     []byte
+	hashVal        uint64
+	found          bool
+	expireAtMs     int64
+	softExpireAtMs int64
+	flags          uint8
+	cost           int64
+}
+
+// save returns the current entry of key, or a not found entry.
+func (seg *segment) save(key []byte, hashVal uint64) (saved savedEntry) {
+	saved.key, saved.hashVal = key, hashVal
+	hdr, off, err := seg.locate(key, hashVal, true)
+	if err != nil {
+		return
+	}
+	saved.found = true
+	saved.value = make([]byte, hdr.valLen)
+	seg.rb.ReadAt(saved.value, off+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+	saved.expireAtMs = hdr.expireAtMilli()
+	saved.softExpireAtMs = seg.softExpireAt(&hdr, off)
+	saved.flags = hdr.flags
+	saved.cost = seg.entryCost(&hdr, off)
+	return
+}
+
+// restoreSaved sets back the entry saved by save, or deletes the key if it wasn't found.
+func (seg *segment) restoreSaved(saved *savedEntry) {
+	if !saved.found {
+		seg.del(saved.key, saved.hashVal)
+		return
+	}
+	seg.setAt(saved.key, saved.value, saved.hashVal, seg.timer.NowMilli(), saved.expireAtMs, saved.softExpireAtMs, saved.flags, saved.cost)
+}
+
+// batch groups n keys by segment and calls fn for every key with the segment lock held,
+// so each segment is locked at most once.
+func (cache *Cache) batch(n int, keyAt func(i int) []byte, fn func(seg *segment, i int, hashVal uint64)) {
+	hashVals := make([]uint64, n)
+	// starts[segID] is the position of the first key of the segment in order.
+	var starts [segmentCount + 1]int
+	for i := 0; i < n; i++ {
+		hashVals[i] = cache.hash(keyAt(i))
+		starts[hashVals[i]&segmentAndOpVal+1]++
+	}
+	for i := 1; i <= segmentCount; i++ {
+		starts[i] += starts[i-1]
+	}
+	order := make([]int, n)
+	next := starts
+	for i := 0; i < n; i++ {
+		segID := hashVals[i] & segmentAndOpVal
+		order[next[segID]] = i
+		next[segID]++
+	}
+	for segID := 0; segID < segmentCount; segID++ {
+		if starts[segID] == starts[segID+1] {
+			continue
+		}
+		cache.locks[segID].Lock()
+		for _, i := range order[starts[segID]:starts[segID+1]] {
+			fn(&cache.segments[segID], i, hashVals[i])
+		}
+		cache.locks[segID].Unlock()
+	}
+}
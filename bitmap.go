@@ -0,0 +1,64 @@
+package freecache
+
+// SetBit sets or clears the bit at bitOffset of the value of key and returns its previous value, like the
+// SETBIT command of Redis, the bit 0 being the most significant bit of the first byte. The value is extended
+// with zeros to hold the bit, in place when the entry has the capacity, and a missing key is set to a value
+// of zeros without expiration. The value is limited like the values of Set.
+func (cache *Cache) SetBit(key []byte, bitOffset uint32, bit bool) (old bool, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	old, err = cache.segments[segID].setBit(key, hashVal, bitOffset, bit)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// GetBit returns the bit at bitOffset of the value of key, see SetBit, false if the value is shorter.
+// Returns ErrNotFound if the key doesn't exist.
+func (cache *Cache) GetBit(key []byte, bitOffset uint32) (bit bool, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	b, err := cache.segments[segID].getRange(key, hashVal, int(bitOffset/8), 1)
+	cache.locks[segID].Unlock()
+	return len(b) == 1 && b[0]&bitMask(bitOffset) != 0, err
+}
+
+// bitMask returns the mask of the bit at bitOffset in its byte.
+func bitMask(bitOffset uint32) byte {
+	return 0x80 >> (bitOffset % 8)
+}
+
+func (seg *segment) setBit(key []byte, hashVal uint64, bitOffset uint32, bit bool) (old bool, err error) {
+	byteOff := int(bitOffset / 8)
+	if byteOff >= len(seg.rb.data) {
+		return false, ErrLargeEntry
+	}
+	mask := bitMask(bitOffset)
+	cur, err := seg.getRange(key, hashVal, byteOff, 1)
+	if err == ErrNotFound || err == ErrExpired || err == ErrNegativeCached {
+		value := make([]byte, byteOff+1)
+		if bit {
+			value[byteOff] = mask
+		}
+		return false, seg.set(key, value, hashVal, 0)
+	}
+	if err != nil {
+		return
+	}
+	var b [1]byte
+	if len(cur) == 1 {
+		b[0] = cur[0]
+		old = b[0]&mask != 0
+		if old == bit {
+			return
+		}
+	}
+	if bit {
+		b[0] |= mask
+	} else {
+		b[0] &^= mask
+	}
+	err = seg.setRange(key, hashVal, byteOff, b[:])
+	return
+}
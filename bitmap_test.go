@@ -0,0 +1,52 @@
+package freecache
+
+import (
+	"testing"
+)
+
+func TestSetBitGetBit(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	key := []byte("flags")
+	if _, err := cache.GetBit(key, 0); err != ErrNotFound {
+		t.Fatalf("GetBit of a missing key err = %v", err)
+	}
+	if old, err := cache.SetBit(key, 7, true); old || err != nil {
+		t.Fatalf("SetBit = %v, %v", old, err)
+	}
+	if v, _ := cache.Get(key); string(v) != "\x01" {
+		t.Fatalf("value = %q", v)
+	}
+	if old, err := cache.SetBit(key, 0, true); old || err != nil {
+		t.Fatalf("SetBit = %v, %v", old, err)
+	}
+	if old, err := cache.SetBit(key, 0, true); !old || err != nil {
+		t.Fatalf("SetBit of a set bit = %v, %v", old, err)
+	}
+	if v, _ := cache.Get(key); string(v) != "\x81" {
+		t.Fatalf("value = %q", v)
+	}
+	// the value grows with zeros.
+	if _, err := cache.SetBit(key, 100, true); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cache.Get(key); len(v) != 13 || v[12] != 0x08 || v[1] != 0 {
+		t.Fatalf("value = %q", v)
+	}
+	for _, c := range []struct {
+		offset uint32
+		bit    bool
+	}{{0, true}, {1, false}, {7, true}, {100, true}, {99, false}, {1000, false}} {
+		if bit, err := cache.GetBit(key, c.offset); err != nil || bit != c.bit {
+			t.Fatalf("GetBit(%d) = %v, %v", c.offset, bit, err)
+		}
+	}
+	if old, err := cache.SetBit(key, 100, false); !old || err != nil {
+		t.Fatalf("SetBit = %v, %v", old, err)
+	}
+	if bit, _ := cache.GetBit(key, 100); bit {
+		t.Fatal("bit 100 isn't cleared")
+	}
+	if _, err := cache.SetBit(key, 1<<31, true); err != ErrLargeEntry {
+		t.Fatalf("SetBit of a large offset err = %v", err)
+	}
+}
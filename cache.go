@@ -1,9 +1,13 @@
 package freecache
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
 )
@@ -16,18 +20,89 @@ const (
 	minBufSize      = 512 * 1024
 )
 
-// Cache is a freecache instance.
+// Cache is a freecache instance. The keys are compared in full once their hash matches, so a hash collision
+// never returns the value of another key.
 type Cache struct {
-	locks    [segmentCount]sync.Mutex
-	segments [segmentCount]segment
+	locks       [segmentCount]sync.Mutex
+	segments    [segmentCount]segment
+	stubLocks   [segmentCount]sync.Mutex // serialize the writes of the stubbed values, see lockStub.
+	done        chan struct{}            // closed by Close to stop background goroutines.
+	closeOnce   sync.Once
+	closeMu     sync.Mutex     // orders goBackground with Close, so no goroutine is started after Close waits.
+	closed      int32          // set by Close, the operations return ErrClosed after it.
+	clearGen    uint64         // incremented by Clear and ClearAsync, see segment.applyClear.
+	wg          sync.WaitGroup // background goroutines
+	hashSeed    uint64         // 0 means the key is hashed without seed.
+	codec       Codec          // used by SetObject and GetObject.
+	flights     flightGroup
+	loader      Loader        // loads missing keys in Get, may be nil.
+	writes      writeLog      // propagates the writes of the segments to the stores and logs.
+	replicator  *replicator   // queues Set and Del for WithReplication, may be nil.
+	mapped      *mmapFile     // backs the ring buffers with WithMmapFile, may be nil.
+	rebalancer  *rebalancer   // lends capacity to the overloaded segments with WithRebalancing, may be nil.
+	largeGen    uint64        // generation of the chunk keys of the last value split by SetLarge.
+	largeValues bool          // Set, Get and Del handle the values split in chunks, see WithLargeValues.
+	overflow    OverflowStore // stores the values too large for an entry, may be nil.
+	maxListLen  int           // items kept by LPush and RPush, 0 means no limit.
+	unsafeGet   bool          // GetUnsafe is enabled, see WithUnsafeGet.
+	nsMu        sync.Mutex
+	nsQuotas    uint8    // number of namespaces with quota, their ids start from 1.
+	observer    Observer // may be nil.
+	events      eventHub
+	computeBuf  sync.Pool // *[]byte buffers of GetOrSetFn.
 }
 
 type Updater func(value []byte, found bool) (newValue []byte, replace bool, expireSeconds int)
 
+// UpdateAction is what an UpdaterE does with the entry.
+type UpdateAction uint8
+
+const (
+	// UpdateKeep leaves the entry unchanged.
+	UpdateKeep UpdateAction = iota
+	// UpdateReplace sets the new value with expireSeconds.
+	UpdateReplace
+	// UpdateTouch keeps the value of the entry and sets its expiration to expireSeconds, like Touch.
+	UpdateTouch
+)
+
+// UpdaterE is like Updater, but returns what to do with the entry, and an error which aborts the update.
+type UpdaterE func(value []byte, found bool) (newValue []byte, action UpdateAction, expireSeconds int, err error)
+
 func hashFunc(data []byte) uint64 {
 	return xxhash.Sum64(data)
 }
 
+// seededHashFunc hashes data prefixed by seed, so the slot of a key can't be predicted without knowing the seed.
+func seededHashFunc(seed uint64, data []byte) uint64 {
+	var seedBuf [8]byte
+	binary.LittleEndian.PutUint64(seedBuf[:], seed)
+	var digest xxhash.Digest
+	digest.Reset()
+	digest.Write(seedBuf[:])
+	digest.Write(data)
+	return digest.Sum64()
+}
+
+// randomHashSeed returns a random non-zero hash seed.
+func randomHashSeed() uint64 {
+	var seedBuf [8]byte
+	if _, err := rand.Read(seedBuf[:]); err != nil {
+		return uint64(time.Now().UnixNano()) | 1
+	}
+	if seed := binary.LittleEndian.Uint64(seedBuf[:]); seed != 0 {
+		return seed
+	}
+	return 1
+}
+
+func (cache *Cache) hash(key []byte) uint64 {
+	if cache.hashSeed == 0 {
+		return hashFunc(key)
+	}
+	return seededHashFunc(cache.hashSeed, key)
+}
+
 // NewCache returns a newly initialize cache by size.
 // The cache size will be set to 512KB at minimum.
 // If the size is set relatively large, you should call
@@ -39,50 +114,387 @@ func NewCache(size int) (cache *Cache) {
 
 // NewCacheCustomTimer returns new cache with custom timer.
 func NewCacheCustomTimer(size int, timer Timer) (cache *Cache) {
+	return NewCacheWithOptions(size, WithTimer(timer))
+}
+
+// NewCacheWithOptions returns a newly initialize cache by size, configured by opts.
+// If an option starts background goroutines, Close must be called to stop them.
+// It panics if the file of WithMmapFile can't be mapped or an option is invalid, use OpenCache to get the
+// error instead.
+func NewCacheWithOptions(size int, opts ...Option) (cache *Cache) {
+	cache, err := OpenCache(size, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// OpenCache is like NewCacheWithOptions, but returns the error if the file of WithMmapFile can't be mapped
+// or an option is invalid.
+func OpenCache(size int, opts ...Option) (cache *Cache, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.opLog != nil && o.opLogPolicy != SyncAlways && o.opLogInterval <= 0 {
+		return nil, ErrInvalidInterval
+	}
+	if o.writeBehindStore != nil && o.writeBehindInterval <= 0 {
+		return nil, ErrInvalidInterval
+	}
 	if size < minBufSize {
 		size = minBufSize
 	}
+	timer := o.timer
+	if o.clock != nil {
+		timer = clockTimer{o.clock}
+	}
 	if timer == nil {
 		timer = defaultTimer{}
 	}
 	cache = new(Cache)
+	cache.loader = o.loader
+	cache.codec = o.codec
+	if cache.codec == nil {
+		cache.codec = JSONCodec{}
+	}
+	if o.fixedHashSeed {
+		cache.hashSeed = o.hashSeed
+	} else {
+		cache.hashSeed = randomHashSeed()
+	}
+	var onRefresh func(key []byte)
+	if o.refreshLoader != nil && o.refreshRatio > 0 {
+		onRefresh = newRefresher(cache, o.refreshLoader).refresh
+	}
+	if o.mmapPath != "" {
+		if err = cache.openMmap(o.mmapPath, size/segmentCount, timer, o.fixedHashSeed, o.checksums); err != nil {
+			return nil, err
+		}
+	}
 	for i := 0; i < segmentCount; i++ {
-		cache.segments[i] = newSegment(size/segmentCount, i, timer)
+		if cache.mapped == nil {
+			segSize := size / segmentCount
+			if len(o.segmentSizes) == segmentCount {
+				segSize = o.segmentSizes[i]
+				if segSize < minBufSize/segmentCount {
+					segSize = minBufSize / segmentCount
+				}
+			}
+			if o.allocator == nil {
+				cache.segments[i] = newSegment(segSize, i, timer)
+			} else {
+				var buf []byte
+				if buf, err = o.allocator.Alloc(segSize); err != nil {
+					cache.release()
+					return nil, err
+				}
+				cache.segments[i] = newSegmentBuf(buf, i, timer)
+				cache.segments[i].alloc = o.allocator
+			}
+		}
+		cache.segments[i].onExpired = o.onExpired
+		cache.segments[i].onEvicted = o.onEvicted
+		cache.segments[i].observer = o.observer
+		cache.segments[i].events = &cache.events
+		cache.segments[i].clearGen = &cache.clearGen
+		cache.segments[i].onRefresh = onRefresh
+		cache.segments[i].refreshRatio = o.refreshRatio
+		cache.segments[i].maxEntries = o.maxEntries
+		cache.segments[i].maxCost = o.maxCost
+		cache.segments[i].maxPinned = o.maxPinned
+		if o.maxEvacuationMoves > 0 {
+			cache.segments[i].maxMoves = o.maxEvacuationMoves
+		}
+		cache.segments[i].recentAccess = int64((o.recentAccess + time.Second - 1) / time.Second)
+		cache.segments[i].evacuation = o.evacuationPolicy
+		cache.segments[i].checksums = o.checksums
+		cache.segments[i].sliding = o.slidingExpiration
+		cache.segments[i].defaultTTL = o.defaultTTL
+		cache.segments[i].maxTTL = o.maxTTL
+		if o.viewTimeout != 0 {
+			cache.segments[i].viewTimeout = int64(o.viewTimeout / time.Millisecond)
+		}
+		if o.tinyLFU {
+			cache.segments[i].lfu = newTinyLFU(size / segmentCount / 64)
+		}
+		if o.histograms {
+			cache.segments[i].hist = &Histograms{}
+		}
+		if o.hitRateWindow > 0 {
+			cache.segments[i].window = newHitWindow(o.hitRateWindow)
+		}
+		if o.hotKeys > 0 {
+			cache.segments[i].hot = newHotKeys(o.hotKeys)
+		}
+	}
+	cache.observer = o.observer
+	cache.largeValues = o.largeValues
+	cache.overflow = o.overflowStore
+	cache.unsafeGet = o.unsafeGet
+	if o.maxListLen > 0 {
+		cache.maxListLen = o.maxListLen
+	}
+	cache.largeGen = randomHashSeed()
+	cache.done = make(chan struct{})
+	if o.rebalanceReserve > 0 && cache.mapped == nil {
+		cache.rebalancer = newRebalancer(o.rebalanceReserve, size/segmentCount)
+		if o.rebalanceInterval > 0 {
+			cache.goBackground(func() {
+				cache.runRebalancer(o.rebalanceInterval)
+			})
+		}
+	}
+	if o.activeExpirationInterval > 0 {
+		cache.goBackground(func() {
+			cache.runJanitor(o.activeExpirationInterval)
+		})
+	}
+	if o.opLog != nil {
+		log := newOpLog(o.opLog, o.opLogPolicy)
+		cache.writes.writers = append(cache.writes.writers, log)
+		if o.opLogPolicy != SyncAlways {
+			cache.goBackground(func() {
+				log.run(o.opLogInterval, cache.done)
+			})
+		}
+	}
+	switch {
+	case o.writeBehindStore != nil:
+		wb := newWriteBehind(o.writeBehindStore)
+		cache.writes.writers = append(cache.writes.writers, wb)
+		cache.goBackground(func() {
+			wb.run(o.writeBehindInterval, cache.done)
+		})
+	case o.writeThroughStore != nil:
+		cache.writes.writers = append(cache.writes.writers, writeThrough{o.writeThroughStore})
+	}
+	if o.replicationTarget != nil {
+		cache.replicator = newReplicator(o.replicationTarget, o.replicationQueueSize, o.replicationDropPolicy)
+		cache.writes.writers = append(cache.writes.writers, cache.replicator)
+		cache.goBackground(func() {
+			cache.replicator.run(cache.done)
+		})
+	}
+	if cache.writes.enabled() {
+		for i := range cache.segments {
+			cache.segments[i].writes = &cache.writes
+		}
 	}
 	return
 }
 
+// goBackground runs fn in a goroutine that Close waits for, fn must return when cache.done is closed.
+// It returns false without running fn if the cache is closed.
+func (cache *Cache) goBackground(fn func()) bool {
+	cache.closeMu.Lock()
+	defer cache.closeMu.Unlock()
+	if cache.isClosed() {
+		return false
+	}
+	cache.wg.Add(1)
+	go func() {
+		defer cache.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+// Close stops the background goroutines started by the options of the cache and waits for them,
+// pending writes of WithWriteBehind and WithOpLog are flushed, the writes queued by WithReplication are
+// applied and the channels of Events are closed. Then the ring buffers are released, a cache with
+// WithMmapFile saves its state to the file and unmaps it, and the ring buffers of a cache with
+// WithAllocator are freed, so the memory doesn't wait for the cache to be garbage collected. After Close,
+// the operations of the cache return ErrClosed, or find no entry, and Close itself returns ErrClosed.
+func (cache *Cache) Close() (err error) {
+	err = ErrClosed
+	cache.closeOnce.Do(func() {
+		cache.closeMu.Lock()
+		atomic.StoreInt32(&cache.closed, 1)
+		cache.closeMu.Unlock()
+		close(cache.done)
+		cache.wg.Wait()
+		cache.events.close()
+		// the segments are released before the file is unmapped, so the operations after Close never
+		// touch the unmapped memory.
+		if cache.mapped != nil {
+			cache.closeMmap()
+		} else {
+			cache.release()
+		}
+		err = nil
+	})
+	return
+}
+
+func (cache *Cache) isClosed() bool {
+	return atomic.LoadInt32(&cache.closed) != 0
+}
+
+// release releases the ring buffers of the segments, see segment.release.
+func (cache *Cache) release() {
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		cache.segments[i].release()
+		cache.locks[i].Unlock()
+	}
+}
+
 // Set sets a key, value and expiration for a cache entry and stores it in the cache.
 // If the key is larger than 65535 or value is larger than 1/1024 of the cache size,
 // the entry will not be written to the cache. expireSeconds <= 0 means no expire,
 // but it can be evicted when cache is full.
 func (cache *Cache) Set(key, value []byte, expireSeconds int) (err error) {
-	hashVal := hashFunc(key)
-	segID := hashVal & segmentAndOpVal
-	cache.locks[segID].Lock()
-	err = cache.segments[segID].set(key, value, hashVal, expireSeconds)
-	cache.locks[segID].Unlock()
+	start := cache.now()
+	err = cache.setValue(key, value, time.Duration(expireSeconds)*time.Second, 0, 1)
+	cache.observe(OpSet, start, err)
 	return
 }
 
+// WillFit returns whether an entry with a key of keyLen bytes and a value of valLen bytes is within the limits
+// of Set, so the caller can check it before encoding a large value instead of getting ErrLargeKey or
+// ErrLargeEntry. It doesn't account for the namespace quotas and the cost budget. The values too large for
+// an entry may still be set with SetLarge, WithLargeValues or WithOverflowStore.
+func (cache *Cache) WillFit(keyLen, valLen int) bool {
+	return keyLen >= 0 && valLen >= 0 && valLen <= cache.MaxValueLen(keyLen)
+}
+
+// MaxValueLen returns the length of the largest value Set accepts with a key of keyLen bytes, see WillFit,
+// or -1 if no entry fits with such a key. The segments can have different sizes, so it is the limit of the smallest.
+func (cache *Cache) MaxValueLen(keyLen int) int {
+	if keyLen > 65535 {
+		return -1
+	}
+	maxKeyValLen := int(^uint(0) >> 1)
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		if n := len(cache.segments[i].rb.data)/4 - ENTRY_HDR_SIZE; n < maxKeyValLen {
+			maxKeyValLen = n
+		}
+		cache.locks[i].Unlock()
+	}
+	if maxKeyValLen < keyLen {
+		return -1
+	}
+	return maxKeyValLen - keyLen
+}
+
+// SetWithDuration is like Set, but takes the expiration as a time.Duration. ttl <= 0 means no expire.
+// A sub-second ttl is honored with millisecond resolution if the cache timer implements MilliTimer,
+// which the default timer does. A ttl of whole seconds behaves exactly like Set.
+func (cache *Cache) SetWithDuration(key, value []byte, ttl time.Duration) (err error) {
+	return cache.setValue(key, value, ttl, 0, 1)
+}
+
+// SetWithCost is like Set, but the entry weighs cost against the budget of WithMaxCost instead of 1.
+// Entries are evicted to keep the total cost within the budget, and entries costlier than the average
+// are kept longer than cheap ones. ErrLargeCost is returned if cost is larger than 1/256 of the budget.
+func (cache *Cache) SetWithCost(key, value []byte, cost int64, expireSeconds int) (err error) {
+	if cost < 0 {
+		cost = 0
+	}
+	return cache.setValue(key, value, time.Duration(expireSeconds)*time.Second, 0, cost)
+}
+
+// SetNotFound caches key as known to be missing, e.g. not found in the backing store, without a value.
+// Until it expires or is overwritten, reading the key returns ErrNegativeCached instead of ErrNotFound.
+// expireSeconds <= 0 means no expire, but it can be evicted when cache is full.
+func (cache *Cache) SetNotFound(key []byte, expireSeconds int) (err error) {
+	return cache.setValue(key, nil, time.Duration(expireSeconds)*time.Second, flagNegative, 1)
+}
+
 // Touch updates the expiration time of an existing key. expireSeconds <= 0 means no expire,
 // but it can be evicted when cache is full.
 func (cache *Cache) Touch(key []byte, expireSeconds int) (err error) {
-	hashVal := hashFunc(key)
+	start := cache.now()
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	err = cache.segments[segID].touch(key, hashVal, expireSeconds)
 	cache.locks[segID].Unlock()
+	cache.observe(OpTouch, start, err)
+	return
+}
+
+// Pin keeps an existing entry from being evicted when the cache is full, it can still expire or be deleted.
+// It stays pinned when it is set again. ErrPinnedBudget is returned if the pinned entries of its segment
+// would exceed the budget of WithMaxPinnedBytes, which is 1/4 of the cache size by default.
+func (cache *Cache) Pin(key []byte) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	err = cache.segments[segID].pin(key, hashVal, true)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// Unpin makes a pinned entry evictable again.
+func (cache *Cache) Unpin(key []byte) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	err = cache.segments[segID].pin(key, hashVal, false)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// TouchWithDuration is like Touch, but takes the expiration as a time.Duration, with the
+// same resolution as SetWithDuration. ttl <= 0 means no expire.
+func (cache *Cache) TouchWithDuration(key []byte, ttl time.Duration) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	err = cache.segments[segID].touchTTL(key, hashVal, ttl)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// Persist removes the expiration of an existing key, so it only leaves the cache when evicted.
+func (cache *Cache) Persist(key []byte) (err error) {
+	return cache.Touch(key, 0)
+}
+
+// DelAt schedules the deletion of an existing key at the time at, by setting its expiration to at unless it
+// expires before, so it is removed by the active expiration of WithActiveExpiration, or when it is accessed,
+// without a timer per key. The expiration has a millisecond resolution, and a key deleted at a time already
+// passed is deleted right away like Del. A later Set or Touch of the key replaces the scheduled deletion.
+// Returns ErrNotFound if the key doesn't exist.
+func (cache *Cache) DelAt(key []byte, at time.Time) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	stub := cache.stubOf(seg, key, hashVal)
+	deleted, err := seg.expireBefore(key, hashVal, timerMilli(seg.timer, at))
+	cache.locks[segID].Unlock()
+	if deleted && stub != nil {
+		cache.dropStub(key, stub)
+	}
+	cache.unlockStub(segID)
 	return
 }
 
 // Get returns the value or not found error.
+// If the cache has a Loader, a missing key is loaded, stored and returned, see WithLoader.
 func (cache *Cache) Get(key []byte) (value []byte, err error) {
-	hashVal := hashFunc(key)
+	start := cache.now()
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	value, _, err = cache.segments[segID].get(key, nil, hashVal, false)
 	cache.locks[segID].Unlock()
+	if err == nil {
+		value, err = cache.unstub(key, value, false)
+	}
+	if (err == ErrNotFound || err == ErrExpired) && cache.loader != nil {
+		// the key is copied, so it doesn't escape to the heap when there is no loader.
+		loadKey := append([]byte(nil), key...)
+		value, err = cache.load(loadKey, func() ([]byte, int, error) {
+			return cache.loader.Load(loadKey)
+		})
+	}
+	cache.observe(OpGet, start, err)
 	return
 }
 
@@ -96,7 +508,12 @@ func (cache *Cache) Get(key []byte) (value []byte, err error) {
 // The method will return ErrNotFound is there's a miss, and the function will
 // not be called. Errors returned by the function will be propagated.
 func (cache *Cache) GetFn(key []byte, fn func([]byte) error) (err error) {
-	hashVal := hashFunc(key)
+	if cache.hasStubs() {
+		return cache.viewStubbed(key, false, func(_ *segment, value []byte, _ *entryHdr) error {
+			return fn(value)
+		})
+	}
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	err = cache.segments[segID].view(key, fn, hashVal, false)
@@ -104,17 +521,206 @@ func (cache *Cache) GetFn(key []byte, fn func([]byte) error) (err error) {
 	return
 }
 
+// GetUnsafe is like Get, but returns a view of the value in the ring buffer instead of a copy, or a copy
+// if the value wraps around it. The view is only valid until the next operation on the cache, which may
+// overwrite it, and must not be modified, so GetUnsafe is meant for a single goroutine reading a cache it
+// doesn't share. It doesn't call the loader nor handle the values of WithLargeValues and
+// WithOverflowStore. Returns ErrUnsafeGetDisabled unless the cache is created with WithUnsafeGet.
+func (cache *Cache) GetUnsafe(key []byte) (value []byte, err error) {
+	if !cache.unsafeGet {
+		return nil, ErrUnsafeGetDisabled
+	}
+	start := cache.now()
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	value, _, err = cache.segments[segID].viewEntry(key, hashVal, false)
+	cache.locks[segID].Unlock()
+	cache.observe(OpGet, start, err)
+	return
+}
+
+// GetFnWithExpiration is like GetFn, but also passes to fn the unix time in seconds the entry expires at,
+// 0 means no expire, so the freshness of the value is checked with a single lookup.
+func (cache *Cache) GetFnWithExpiration(key []byte, fn func(value []byte, expireAt uint32) error) (err error) {
+	if cache.hasStubs() {
+		return cache.viewStubbed(key, false, func(_ *segment, value []byte, hdr *entryHdr) error {
+			return fn(value, hdr.expireAtSeconds())
+		})
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	value, hdr, err := cache.segments[segID].viewEntry(key, hashVal, false)
+	if err == nil {
+		err = fn(value, hdr.expireAtSeconds())
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
+// GetFnWithTTL is like GetFn, but also passes to fn the seconds left before the entry expires, like TTL,
+// 0 means no expire.
+func (cache *Cache) GetFnWithTTL(key []byte, fn func(value []byte, ttl uint32) error) (err error) {
+	if cache.hasStubs() {
+		return cache.viewStubbed(key, false, func(seg *segment, value []byte, hdr *entryHdr) error {
+			return fn(value, seg.timeLeft(hdr))
+		})
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	value, hdr, err := seg.viewEntry(key, hashVal, false)
+	if err == nil {
+		err = fn(value, seg.timeLeft(&hdr))
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
 // GetOrSet returns existing value or if record doesn't exist
 // it sets a new key, value and expiration for a cache entry and stores it in the cache, returns nil in that case
 func (cache *Cache) GetOrSet(key, value []byte, expireSeconds int) (retValue []byte, err error) {
-	hashVal := hashFunc(key)
+	if err = cache.checkValue(value); err != nil {
+		return
+	}
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
+	ttl := time.Duration(expireSeconds) * time.Second
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
 	cache.locks[segID].Lock()
-	defer cache.locks[segID].Unlock()
-
 	retValue, _, err = cache.segments[segID].get(key, nil, hashVal, false)
+	if err == nil {
+		cache.locks[segID].Unlock()
+		if cache.stubbed(retValue) {
+			retValue, err = cache.unstub(key, retValue, false)
+		}
+		return
+	}
+	err = cache.segments[segID].setTTL(key, value, hashVal, ttl, 0)
+	cache.locks[segID].Unlock()
+	return nil, cache.setReplaced(key, value, ttl, 0, 1, nil, err)
+}
+
+// SetIfAbsent sets the key only if it doesn't exist, is expired or cached by SetNotFound, and reports
+// whether it was set, without copying the existing value like GetOrSet.
+func (cache *Cache) SetIfAbsent(key, value []byte, expireSeconds int) (stored bool, err error) {
+	return cache.setIf(key, value, expireSeconds, false)
+}
+
+// SetIfPresent replaces the value of the key only if it exists and is not expired, and reports
+// whether it was set. A key cached by SetNotFound is not present.
+func (cache *Cache) SetIfPresent(key, value []byte, expireSeconds int) (stored bool, err error) {
+	return cache.setIf(key, value, expireSeconds, true)
+}
+
+func (cache *Cache) setIf(key, value []byte, expireSeconds int, present bool) (stored bool, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	_, _, err = seg.locate(key, hashVal, true)
+	if (err == nil) != present {
+		cache.locks[segID].Unlock()
+		return false, nil
+	}
+	if err = cache.checkValue(value); err != nil {
+		cache.locks[segID].Unlock()
+		return false, err
+	}
+	stub := cache.stubOf(seg, key, hashVal)
+	err = seg.set(key, value, hashVal, expireSeconds)
+	cache.locks[segID].Unlock()
+	if err == nil && stub != nil {
+		cache.dropStub(key, stub)
+	}
+	return err == nil, err
+}
+
+// GetOrSetFn is like GetOrSet, but on a miss the value is written by compute into dst, a reused buffer of
+// maxLen bytes, instead of being passed in, so encoders that write into a buffer set a missing key without
+// allocating. compute returns the length of the value written to dst, ErrComputedLength is returned if it
+// is out of dst. compute is called with the segment lock held, so it must not call the cache.
+func (cache *Cache) GetOrSetFn(key []byte, expireSeconds int, compute func(dst []byte) (n int, err error), maxLen int) (retValue []byte, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	retValue, _, err = seg.get(key, nil, hashVal, false)
+	if err == nil {
+		cache.locks[segID].Unlock()
+		if cache.stubbed(retValue) {
+			retValue, err = cache.unstub(key, retValue, false)
+		}
+		return
+	}
+	retValue = nil
+	buf, _ := cache.computeBuf.Get().(*[]byte)
+	if buf == nil {
+		buf = new([]byte)
+	}
+	defer cache.computeBuf.Put(buf)
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	if cap(*buf) < maxLen {
+		*buf = make([]byte, maxLen)
+	}
+	dst := (*buf)[:maxLen]
+	n, err := compute(dst)
+	if err == nil && (n < 0 || n > maxLen) {
+		err = ErrComputedLength
+	}
+	if err == nil {
+		err = cache.checkValue(dst[:n])
+	}
 	if err != nil {
-		err = cache.segments[segID].set(key, value, hashVal, expireSeconds)
+		cache.locks[segID].Unlock()
+		return
+	}
+	ttl := time.Duration(expireSeconds) * time.Second
+	err = seg.setTTL(key, dst[:n], hashVal, ttl, 0)
+	cache.locks[segID].Unlock()
+	return nil, cache.setReplaced(key, dst[:n], ttl, 0, 1, nil, err)
+}
+
+// GetOrCompute returns the existing value, or calls loader to compute it and stores it with expireSeconds.
+// Concurrent callers missing the same key wait for a single loader call and get its result, so a
+// missing key never causes a thundering herd. Errors returned by loader are returned and not cached.
+// If the computed value can't be stored, e.g. it is too large, it is returned with the error of Set.
+// A key cached by SetNotFound returns ErrNegativeCached without calling loader.
+func (cache *Cache) GetOrCompute(key []byte, expireSeconds int, loader func() ([]byte, error)) (value []byte, err error) {
+	value, err = cache.Get(key)
+	if err == nil || err == ErrNegativeCached {
+		return
+	}
+	return cache.load(key, func() ([]byte, int, error) {
+		value, err := loader()
+		return value, expireSeconds, err
+	})
+}
+
+// load calls fn once for all concurrent misses of key and stores the value it returns.
+func (cache *Cache) load(key []byte, fn func() ([]byte, int, error)) (value []byte, err error) {
+	value, err, shared := cache.flights.do(key, func() ([]byte, error) {
+		// the value may have been stored by a load that completed after our miss.
+		if value, err := cache.Peek(key); err == nil || err == ErrNegativeCached {
+			return value, err
+		}
+		value, expireSeconds, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		return value, cache.Set(key, value, expireSeconds)
+	})
+	if shared && value != nil {
+		value = append([]byte(nil), value...)
 	}
 	return
 }
@@ -125,16 +731,56 @@ func (cache *Cache) GetOrSet(key, value []byte, expireSeconds int) (retValue []b
 // but it can be evicted when cache is full.  Returns existing value if record exists
 // with a bool value to indicate whether an existing record was found
 func (cache *Cache) SetAndGet(key, value []byte, expireSeconds int) (retValue []byte, found bool, err error) {
-	hashVal := hashFunc(key)
+	if err = cache.checkValue(value); err != nil {
+		return
+	}
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
+	ttl := time.Duration(expireSeconds) * time.Second
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
 	cache.locks[segID].Lock()
-	defer cache.locks[segID].Unlock()
-
 	retValue, _, err = cache.segments[segID].get(key, nil, hashVal, false)
 	if err == nil {
 		found = true
 	}
+	err = cache.segments[segID].setTTL(key, value, hashVal, ttl, 0)
+	cache.locks[segID].Unlock()
+	var stub []byte
+	if found && cache.stubbed(retValue) {
+		// the value stub stands for is read before it is replaced in the OverflowStore.
+		stub = retValue
+		retValue, _ = cache.unstub(key, stub, false)
+	}
+	err = cache.setReplaced(key, value, ttl, 0, 1, stub, err)
+	return
+}
+
+// GetSet sets a key, value and expiration like Set and returns the value it replaces in the same locked
+// operation, like the GETSET command of Redis. The old value is a copy owned by the caller. ErrNotFound is
+// returned if the key didn't exist, the value is set anyway. If the value can't be set, the error of the set
+// is returned and the entry is unchanged.
+func (cache *Cache) GetSet(key, value []byte, expireSeconds int) (old []byte, err error) {
+	if err = cache.checkValue(value); err != nil {
+		return
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
+	cache.locks[segID].Lock()
+	old, _, getErr := cache.segments[segID].get(key, nil, hashVal, false)
 	err = cache.segments[segID].set(key, value, hashVal, expireSeconds)
+	cache.locks[segID].Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if getErr != nil {
+		return nil, ErrNotFound
+	}
+	if old, getErr = cache.unstubReplaced(key, old); getErr != nil {
+		return nil, ErrNotFound
+	}
 	return
 }
 
@@ -145,8 +791,17 @@ func (cache *Cache) SetAndGet(key, value []byte, expireSeconds int) (retValue []
 // but it can be evicted when cache is full. Returns bool value to indicate if existing record was found along with bool
 // value indicating the value was replaced and error if any
 func (cache *Cache) Update(key []byte, updater Updater) (found bool, replaced bool, err error) {
-	hashVal := hashFunc(key)
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
+	var stub []byte
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
+	// the value stub stood for is dropped once the segment is unlocked.
+	defer func() {
+		if stub != nil {
+			cache.dropStub(key, stub)
+		}
+	}()
 	cache.locks[segID].Lock()
 	defer cache.locks[segID].Unlock()
 
@@ -160,17 +815,68 @@ func (cache *Cache) Update(key []byte, updater Updater) (found bool, replaced bo
 	if !replaced {
 		return
 	}
-	err = cache.segments[segID].set(key, value, hashVal, expireSeconds)
+	if err = cache.checkValue(value); err != nil {
+		return found, false, err
+	}
+	if err = cache.segments[segID].set(key, value, hashVal, expireSeconds); err == nil && found && cache.stubbed(retValue) {
+		stub = retValue
+	}
+	return
+}
+
+// UpdateE is like Update, but the updater can keep the value and only update its expiration with UpdateTouch,
+// which returns ErrNotFound if the entry wasn't found, or abort the update by returning an error, which is
+// returned as is with the entry left unchanged.
+func (cache *Cache) UpdateE(key []byte, updater UpdaterE) (found bool, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	var stub []byte
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
+	// the value stub stood for is dropped once the segment is unlocked.
+	defer func() {
+		if stub != nil {
+			cache.dropStub(key, stub)
+		}
+	}()
+	cache.locks[segID].Lock()
+	defer cache.locks[segID].Unlock()
+
+	retValue, _, getErr := cache.segments[segID].get(key, nil, hashVal, false)
+	found = getErr == nil
+	value, action, expireSeconds, err := updater(retValue, found)
+	if err != nil {
+		return
+	}
+	switch action {
+	case UpdateReplace:
+		if err = cache.checkValue(value); err != nil {
+			return
+		}
+		if err = cache.segments[segID].set(key, value, hashVal, expireSeconds); err == nil && found && cache.stubbed(retValue) {
+			stub = retValue
+		}
+	case UpdateTouch:
+		if !found {
+			return found, ErrNotFound
+		}
+		err = cache.segments[segID].touch(key, hashVal, expireSeconds)
+	}
 	return
 }
 
 // Peek returns the value or not found error, without updating access time or counters.
+// Expired entries are reported as ErrExpired but are not removed, so Peek never changes
+// the hit rate or the eviction order of the cache.
 func (cache *Cache) Peek(key []byte) (value []byte, err error) {
-	hashVal := hashFunc(key)
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	value, _, err = cache.segments[segID].get(key, nil, hashVal, true)
 	cache.locks[segID].Unlock()
+	if err == nil && cache.stubbed(value) {
+		value, err = cache.unstub(key, value, true)
+	}
 	return
 }
 
@@ -184,7 +890,12 @@ func (cache *Cache) Peek(key []byte) (value []byte, err error) {
 // The method will return ErrNotFound is there's a miss, and the function will
 // not be called. Errors returned by the function will be propagated.
 func (cache *Cache) PeekFn(key []byte, fn func([]byte) error) (err error) {
-	hashVal := hashFunc(key)
+	if cache.hasStubs() {
+		return cache.viewStubbed(key, true, func(_ *segment, value []byte, _ *entryHdr) error {
+			return fn(value)
+		})
+	}
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	err = cache.segments[segID].view(key, fn, hashVal, true)
@@ -192,30 +903,71 @@ func (cache *Cache) PeekFn(key []byte, fn func([]byte) error) (err error) {
 	return
 }
 
+// Has reports whether the key exists and is not expired, without copying the value.
+// Like Peek, it doesn't update access time or counters.
+func (cache *Cache) Has(key []byte) (found bool) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	_, _, err := cache.segments[segID].locate(key, hashVal, true)
+	cache.locks[segID].Unlock()
+	return err == nil
+}
+
 // GetWithBuf copies the value to the buf or returns not found error.
-// This method doesn't allocate memory when the capacity of buf is greater or equal to value.
+// This method doesn't allocate memory when the capacity of buf is greater or equal to value, unless the
+// value is split by WithLargeValues.
 func (cache *Cache) GetWithBuf(key, buf []byte) (value []byte, err error) {
-	hashVal := hashFunc(key)
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	value, _, err = cache.segments[segID].get(key, buf, hashVal, false)
 	cache.locks[segID].Unlock()
+	if err == nil && cache.stubbed(value) {
+		value, err = cache.unstub(key, value, false)
+	}
+	return
+}
+
+// GetIntoBuf copies the value into buf and returns its length, it never allocates, except to reassemble a
+// value split by WithLargeValues. If the value is longer than len(buf), nothing is copied and its length is
+// returned with ErrBufferTooSmall, so the value can be read again with a buffer of that length. Only the read
+// that copies the value is counted as a hit.
+func (cache *Cache) GetIntoBuf(key, buf []byte) (n int, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	n, err = cache.segments[segID].getInto(key, buf, hashVal)
+	cache.locks[segID].Unlock()
+	if err == nil && cache.stubbed(buf[:n]) {
+		var value []byte
+		if value, err = cache.unstub(key, buf[:n], false); err != nil {
+			return 0, err
+		}
+		if n = len(value); len(buf) < n {
+			return n, ErrBufferTooSmall
+		}
+		copy(buf, value)
+	}
 	return
 }
 
 // GetWithExpiration returns the value with expiration or not found error.
 func (cache *Cache) GetWithExpiration(key []byte) (value []byte, expireAt uint32, err error) {
-	hashVal := hashFunc(key)
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	value, expireAt, err = cache.segments[segID].get(key, nil, hashVal, false)
 	cache.locks[segID].Unlock()
+	if err == nil && cache.stubbed(value) {
+		value, err = cache.unstub(key, value, false)
+	}
 	return
 }
 
 // TTL returns the TTL time left for a given key or a not found error.
 func (cache *Cache) TTL(key []byte) (timeLeft uint32, err error) {
-	hashVal := hashFunc(key)
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
 	cache.locks[segID].Lock()
 	timeLeft, err = cache.segments[segID].ttl(key, hashVal)
@@ -223,13 +975,266 @@ func (cache *Cache) TTL(key []byte) (timeLeft uint32, err error) {
 	return
 }
 
+// Pop returns the value or not found error, and deletes the entry in the same locked operation.
+func (cache *Cache) Pop(key []byte) (value []byte, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	cache.locks[segID].Lock()
+	value, _, err = cache.segments[segID].get(key, nil, hashVal, false)
+	if err == nil {
+		cache.segments[segID].del(key, hashVal)
+	}
+	cache.locks[segID].Unlock()
+	if err == nil {
+		value, err = cache.unstubReplaced(key, value)
+	}
+	cache.unlockStub(segID)
+	return
+}
+
+// GetEx returns the value or not found error, and updates the expiration of the entry to expireSeconds in
+// the same locked operation, like the GETEX command of Redis. expireSeconds <= 0 removes the expiration,
+// like Persist.
+func (cache *Cache) GetEx(key []byte, expireSeconds int) (value []byte, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	if value, _, err = cache.segments[segID].get(key, nil, hashVal, false); err == nil {
+		if err = cache.segments[segID].touch(key, hashVal, expireSeconds); err != nil {
+			value = nil
+		}
+	}
+	cache.locks[segID].Unlock()
+	if err == nil && cache.stubbed(value) {
+		value, err = cache.unstub(key, value, false)
+	}
+	return
+}
+
+// GetDel is the same as Pop, named after the GETDEL command of Redis.
+func (cache *Cache) GetDel(key []byte) (value []byte, err error) {
+	return cache.Pop(key)
+}
+
+// Incr interprets the value of key as a decimal integer, adds delta to it and stores
+// the result, all under the segment lock. If the key doesn't exist, it is set to delta.
+// Returns ErrNotInteger if the existing value is not a valid 64-bit integer.
+// expireSeconds <= 0 means no expire, but it can be evicted when cache is full.
+func (cache *Cache) Incr(key []byte, delta int64, expireSeconds int) (value int64, err error) {
+	return cache.incr(key, delta, false, expireSeconds, false)
+}
+
+// IncrKeepTTL is like Incr, but an existing key keeps its expiration, like the INCR command of Redis,
+// and a missing key is set without expiration.
+func (cache *Cache) IncrKeepTTL(key []byte, delta int64) (value int64, err error) {
+	return cache.incr(key, delta, false, 0, true)
+}
+
+// Decr is like Incr, but subtracts delta from the value. Returns ErrNotInteger if the result overflows,
+// delta is not negated, so Decr with math.MinInt64 is a valid decrement of a negative value.
+func (cache *Cache) Decr(key []byte, delta int64, expireSeconds int) (value int64, err error) {
+	return cache.incr(key, delta, true, expireSeconds, false)
+}
+
+func (cache *Cache) incr(key []byte, delta int64, decr bool, expireSeconds int, keepTTL bool) (value int64, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	if cache.isStubbed(&cache.segments[segID], key, hashVal) {
+		// a value split or delegated is too large to be an integer.
+		err = ErrNotInteger
+	} else {
+		value, err = cache.segments[segID].incr(key, delta, decr, hashVal, expireSeconds, keepTTL)
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
+// Append appends suffix to the value of an existing key, keeping its expiration.
+// The value is extended in place when the entry has spare capacity, otherwise the entry is re-inserted.
+// Returns ErrNotFound if the key doesn't exist, and ErrStubbed if its value is split or delegated.
+func (cache *Cache) Append(key, suffix []byte) (err error) {
+	return cache.extend(key, suffix, false)
+}
+
+// Prepend is like Append, but inserts prefix before the existing value.
+func (cache *Cache) Prepend(key, prefix []byte) (err error) {
+	return cache.extend(key, prefix, true)
+}
+
+func (cache *Cache) extend(key, data []byte, prepend bool) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	if cache.isStubbed(&cache.segments[segID], key, hashVal) {
+		err = ErrStubbed
+	} else {
+		err = cache.segments[segID].extend(key, data, hashVal, prepend)
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
+// GetRange returns a copy of length bytes of the value of key from offset, fewer if the value ends before,
+// without copying the rest of the value. ErrOutOfRange is returned for a negative offset or length.
+func (cache *Cache) GetRange(key []byte, offset, length int) (value []byte, err error) {
+	if cache.hasStubs() && offset >= 0 && length >= 0 {
+		err = cache.viewStubbed(key, false, func(_ *segment, whole []byte, _ *entryHdr) error {
+			if offset > len(whole) {
+				offset = len(whole)
+			}
+			if length > len(whole)-offset {
+				length = len(whole) - offset
+			}
+			value = append([]byte{}, whole[offset:offset+length]...)
+			return nil
+		})
+		return
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	value, err = cache.segments[segID].getRange(key, hashVal, offset, length)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// SetRange overwrites the value of an existing key with data from offset, keeping its expiration, like the
+// SETRANGE command of Redis. The value is extended if data goes past its end, with zeros between its end and
+// offset. It is updated in place when the entry has the capacity, otherwise the entry is re-inserted.
+// Returns ErrNotFound if the key doesn't exist, ErrOutOfRange for a negative offset, and ErrStubbed if its
+// value is split or delegated.
+func (cache *Cache) SetRange(key []byte, offset int, data []byte) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	if cache.isStubbed(&cache.segments[segID], key, hashVal) {
+		err = ErrStubbed
+	} else {
+		err = cache.segments[segID].setRange(key, hashVal, offset, data)
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
+// UpdateInPlace calls fn with the value of an existing key under the segment lock, fn modifies the value in
+// place, e.g. a counter or a fixed size struct, so the entry isn't copied or moved and its expiration is kept.
+// The value must not be retained after fn returns. The changes made by fn are kept even if it returns an error,
+// which is returned. Returns ErrNotFound if the key doesn't exist, and ErrStubbed if its value is split or
+// delegated.
+func (cache *Cache) UpdateInPlace(key []byte, fn func(value []byte) error) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	if cache.isStubbed(&cache.segments[segID], key, hashVal) {
+		err = ErrStubbed
+	} else {
+		err = cache.segments[segID].mutate(key, hashVal, fn)
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
 // Del deletes an item in the cache by key and returns true or false if a delete occurred.
 func (cache *Cache) Del(key []byte) (affected bool) {
-	hashVal := hashFunc(key)
+	return cache.del(key, nil)
+}
+
+// DelWithValue is like Del, but also returns a copy of the value deleted, read with the same lock.
+// The value is nil if the entry was expired or cached by SetNotFound.
+func (cache *Cache) DelWithValue(key []byte) (value []byte, affected bool) {
+	affected = cache.del(key, func(deleted []byte) {
+		value = append([]byte(nil), deleted...)
+	})
+	return
+}
+
+// DelFn is like DelWithValue, but it calls fn with a zero-copy view of the value before it is deleted,
+// with the segment lock held, so fn must not call the cache. fn is not called if the entry was expired
+// or cached by SetNotFound. A value split or delegated is passed to fn once it is read, after the lock is
+// released.
+func (cache *Cache) DelFn(key []byte, fn func(value []byte)) (affected bool) {
+	return cache.del(key, fn)
+}
+
+// CompareAndDelete deletes an existing key only if its value equals expected, in the same locked operation,
+// e.g. to release a lock only if it still holds the token of its owner. It returns whether the entry was
+// deleted, and ErrNotFound if the key doesn't exist. The deletion is propagated to the stores and logs of the
+// cache only if the entry was deleted.
+func (cache *Cache) CompareAndDelete(key, expected []byte) (deleted bool, err error) {
+	if cache.isClosed() {
+		return false, ErrClosed
+	}
+	hashVal := cache.hash(key)
 	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	defer cache.unlockStub(segID)
 	cache.locks[segID].Lock()
-	affected = cache.segments[segID].del(key, hashVal)
+	seg := &cache.segments[segID]
+	value, _, err := seg.viewEntry(key, hashVal, true)
+	if err == nil && cache.stubbed(value) {
+		// the value stub stands for is compared without the lock, the entry is deleted if it still has stub.
+		stub := append([]byte(nil), value...)
+		cache.locks[segID].Unlock()
+		if value, err = cache.unstub(key, stub, true); err != nil || !bytes.Equal(value, expected) {
+			return false, err
+		}
+		cache.locks[segID].Lock()
+		if value, _, err = seg.viewEntry(key, hashVal, true); err == nil && bytes.Equal(value, stub) {
+			deleted = seg.del(key, hashVal)
+		}
+		cache.locks[segID].Unlock()
+		if deleted {
+			cache.dropStub(key, stub)
+		}
+	} else {
+		if err == nil && bytes.Equal(value, expected) {
+			deleted = seg.del(key, hashVal)
+		}
+		cache.locks[segID].Unlock()
+	}
+	if err == ErrExpired || err == ErrNegativeCached {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (cache *Cache) del(key []byte, fn func(value []byte)) (affected bool) {
+	start := cache.now()
+	if cache.isClosed() {
+		return false
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	stub := cache.stubOf(seg, key, hashVal)
+	if fn != nil && stub == nil {
+		if value, _, err := seg.viewEntry(key, hashVal, true); err == nil {
+			fn(value)
+		}
+	}
+	affected = seg.del(key, hashVal)
 	cache.locks[segID].Unlock()
+	if affected && stub != nil {
+		// fn is called with the value stub stood for before it is dropped.
+		if fn != nil {
+			if value, err := cache.unstub(key, stub, true); err == nil {
+				fn(value)
+			}
+		}
+		cache.dropStub(key, stub)
+	}
+	cache.unlockStub(segID)
+	if cache.observer != nil {
+		var err error
+		if !affected {
+			err = ErrNotFound
+		}
+		cache.observe(OpDel, start, err)
+	}
 	return
 }
 
@@ -261,6 +1266,118 @@ func (cache *Cache) DelInt(key int64) (affected bool) {
 	return cache.Del(bKey[:])
 }
 
+// GetOrSetInt is like GetOrSet, but takes an integer key.
+func (cache *Cache) GetOrSetInt(key int64, value []byte, expireSeconds int) (retValue []byte, err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], uint64(key))
+	return cache.GetOrSet(bKey[:], value, expireSeconds)
+}
+
+// SetAndGetInt is like SetAndGet, but takes an integer key.
+func (cache *Cache) SetAndGetInt(key int64, value []byte, expireSeconds int) (retValue []byte, found bool, err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], uint64(key))
+	return cache.SetAndGet(bKey[:], value, expireSeconds)
+}
+
+// TouchInt is like Touch, but takes an integer key.
+func (cache *Cache) TouchInt(key int64, expireSeconds int) (err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], uint64(key))
+	return cache.Touch(bKey[:], expireSeconds)
+}
+
+// TTLInt is like TTL, but takes an integer key.
+func (cache *Cache) TTLInt(key int64) (timeLeft uint32, err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], uint64(key))
+	return cache.TTL(bKey[:])
+}
+
+// UpdateInt is like Update, but takes an integer key.
+func (cache *Cache) UpdateInt(key int64, updater Updater) (found bool, replaced bool, err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], uint64(key))
+	return cache.Update(bKey[:], updater)
+}
+
+// GetIntFn is like GetFn, but takes an integer key.
+func (cache *Cache) GetIntFn(key int64, fn func([]byte) error) (err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], uint64(key))
+	return cache.GetFn(bKey[:], fn)
+}
+
+// SetUint64 stores a value for an unsigned integer key in the cache.
+// The key is encoded like SetInt, so SetUint64(uint64(k)) and SetInt(k) store the same entry.
+func (cache *Cache) SetUint64(key uint64, value []byte, expireSeconds int) (err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], key)
+	return cache.Set(bKey[:], value, expireSeconds)
+}
+
+// GetUint64 returns the value for an unsigned integer key or a not found error.
+func (cache *Cache) GetUint64(key uint64) (value []byte, err error) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], key)
+	return cache.Get(bKey[:])
+}
+
+// DelUint64 deletes an item in the cache by unsigned integer key and returns true or false if a delete occurred.
+func (cache *Cache) DelUint64(key uint64) (affected bool) {
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], key)
+	return cache.Del(bKey[:])
+}
+
+// SetInt2 stores a value for a composite key of two integers, e.g. a (tenantID, objectID) pair.
+func (cache *Cache) SetInt2(key1, key2 int64, value []byte, expireSeconds int) (err error) {
+	var bKey [16]byte
+	binary.LittleEndian.PutUint64(bKey[:8], uint64(key1))
+	binary.LittleEndian.PutUint64(bKey[8:], uint64(key2))
+	return cache.Set(bKey[:], value, expireSeconds)
+}
+
+// GetInt2 returns the value for a composite key of two integers or a not found error.
+func (cache *Cache) GetInt2(key1, key2 int64) (value []byte, err error) {
+	var bKey [16]byte
+	binary.LittleEndian.PutUint64(bKey[:8], uint64(key1))
+	binary.LittleEndian.PutUint64(bKey[8:], uint64(key2))
+	return cache.Get(bKey[:])
+}
+
+// DelInt2 deletes an item in the cache by a composite key of two integers and returns true or false if a delete occurred.
+func (cache *Cache) DelInt2(key1, key2 int64) (affected bool) {
+	var bKey [16]byte
+	binary.LittleEndian.PutUint64(bKey[:8], uint64(key1))
+	binary.LittleEndian.PutUint64(bKey[8:], uint64(key2))
+	return cache.Del(bKey[:])
+}
+
+// SetString is like Set, but takes a string key without converting it to a byte slice.
+func (cache *Cache) SetString(key string, value []byte, expireSeconds int) (err error) {
+	return cache.Set(stringToBytes(key), value, expireSeconds)
+}
+
+// GetString returns the value for a string key or a not found error.
+func (cache *Cache) GetString(key string) (value []byte, err error) {
+	return cache.Get(stringToBytes(key))
+}
+
+// DelString deletes an item in the cache by string key and returns true or false if a delete occurred.
+func (cache *Cache) DelString(key string) (affected bool) {
+	return cache.Del(stringToBytes(key))
+}
+
+// stringToBytes returns the bytes of s without copying, they must not be modified.
+// The cache only reads keys, the key stored in the ring buffer is a copy.
+func stringToBytes(s string) []byte {
+	return *(*[]byte)(unsafe.Pointer(&struct {
+		string
+		Cap int
+	}{s, len(s)}))
+}
+
 // EvacuateCount is a metric indicating the number of times an eviction occurred.
 func (cache *Cache) EvacuateCount() (count int64) {
 	for i := range cache.segments {
@@ -349,13 +1466,26 @@ func (cache *Cache) TouchedCount() (touchedCount int64) {
 	return
 }
 
-// Clear clears the cache.
-func (cache *Cache) Clear() {
+// TotalCost returns the total cost of the entries currently in the cache, see SetWithCost.
+func (cache *Cache) TotalCost() (cost int64) {
 	for i := range cache.segments {
-		cache.locks[i].Lock()
-		cache.segments[i].clear()
-		cache.locks[i].Unlock()
+		cost += atomic.LoadInt64(&cache.segments[i].totalCost)
 	}
+	return
+}
+
+// RejectedCount indicates the number of new entries not admitted by WithTinyLFU.
+func (cache *Cache) RejectedCount() (count int64) {
+	for i := range cache.segments {
+		count += atomic.LoadInt64(&cache.segments[i].rejected)
+	}
+	return
+}
+
+// Clear clears the cache, one segment at a time like ClearAsync, but it returns once all of them are cleared.
+func (cache *Cache) Clear() {
+	atomic.AddUint64(&cache.clearGen, 1)
+	cache.sweepClear(false)
 }
 
 // ResetStatistics refreshes the current state of the statistics.
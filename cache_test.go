@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	mrand "math/rand"
 	"strconv"
 	"strings"
@@ -14,6 +15,8 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/coocood/freecache/clocktest"
 )
 
 // mockTimer is a mock for Timer contract.
@@ -202,6 +205,238 @@ func TestGetOrSet(t *testing.T) {
 	}
 }
 
+func TestSetIfAbsentAndPresent(t *testing.T) {
+	now := uint32(1000)
+	cache := NewCacheCustomTimer(1024, &mockTimer{nowCallback: func() uint32 { return now }})
+	key := []byte("abcd")
+
+	if stored, err := cache.SetIfPresent(key, []byte("v0"), 0); stored || err != nil {
+		t.Fatalf("SetIfPresent of missing key = %v, %v", stored, err)
+	}
+	if cache.Has(key) {
+		t.Fatal("SetIfPresent set a missing key")
+	}
+	if stored, err := cache.SetIfAbsent(key, []byte("v1"), 10); !stored || err != nil {
+		t.Fatalf("SetIfAbsent of missing key = %v, %v", stored, err)
+	}
+	if stored, err := cache.SetIfAbsent(key, []byte("v2"), 10); stored || err != nil {
+		t.Fatalf("SetIfAbsent of existing key = %v, %v", stored, err)
+	}
+	if stored, err := cache.SetIfPresent(key, []byte("v3"), 10); !stored || err != nil {
+		t.Fatalf("SetIfPresent of existing key = %v, %v", stored, err)
+	}
+	if value, _ := cache.Get(key); string(value) != "v3" {
+		t.Fatalf("value = %s", value)
+	}
+
+	now += 11
+	if stored, _ := cache.SetIfPresent(key, []byte("v4"), 10); stored {
+		t.Fatal("SetIfPresent replaced an expired key")
+	}
+	if stored, _ := cache.SetIfAbsent(key, []byte("v5"), 10); !stored {
+		t.Fatal("SetIfAbsent didn't set an expired key")
+	}
+
+	cache.SetNotFound(key, 0)
+	if stored, _ := cache.SetIfPresent(key, []byte("v6"), 0); stored {
+		t.Fatal("SetIfPresent replaced a negative cached key")
+	}
+	if stored, _ := cache.SetIfAbsent(key, []byte("v7"), 0); !stored {
+		t.Fatal("SetIfAbsent didn't set a negative cached key")
+	}
+	if value, _ := cache.Get(key); string(value) != "v7" {
+		t.Fatalf("value = %s", value)
+	}
+
+	if stored, err := cache.SetIfAbsent(make([]byte, 65536), []byte("v"), 0); stored || err != ErrLargeKey {
+		t.Fatalf("SetIfAbsent of large key = %v, %v", stored, err)
+	}
+}
+
+func TestGetFnWithExpirationAndTTL(t *testing.T) {
+	now := uint32(1000)
+	cache := NewCacheCustomTimer(1024, &mockTimer{nowCallback: func() uint32 { return now }})
+	cache.Set([]byte("a"), []byte("va"), 10)
+	cache.Set([]byte("b"), []byte("vb"), 0)
+
+	var gotValue string
+	var gotExpireAt, gotTTL uint32
+	err := cache.GetFnWithExpiration([]byte("a"), func(value []byte, expireAt uint32) error {
+		gotValue, gotExpireAt = string(value), expireAt
+		return nil
+	})
+	if err != nil || gotValue != "va" || gotExpireAt != 1010 {
+		t.Fatalf("GetFnWithExpiration = %q, %d, %v", gotValue, gotExpireAt, err)
+	}
+	now += 4
+	err = cache.GetFnWithTTL([]byte("a"), func(value []byte, ttl uint32) error {
+		gotValue, gotTTL = string(value), ttl
+		return nil
+	})
+	if err != nil || gotValue != "va" || gotTTL != 6 {
+		t.Fatalf("GetFnWithTTL = %q, %d, %v", gotValue, gotTTL, err)
+	}
+	err = cache.GetFnWithTTL([]byte("b"), func(value []byte, ttl uint32) error {
+		gotValue, gotTTL = string(value), ttl
+		return nil
+	})
+	if err != nil || gotValue != "vb" || gotTTL != 0 {
+		t.Fatalf("GetFnWithTTL without expiration = %q, %d, %v", gotValue, gotTTL, err)
+	}
+	fnErr := errors.New("fn failed")
+	if err = cache.GetFnWithTTL([]byte("b"), func([]byte, uint32) error { return fnErr }); err != fnErr {
+		t.Fatalf("GetFnWithTTL err = %v", err)
+	}
+	now += 6
+	called := false
+	err = cache.GetFnWithExpiration([]byte("a"), func([]byte, uint32) error {
+		called = true
+		return nil
+	})
+	if err != ErrExpired || called {
+		t.Fatalf("GetFnWithExpiration of expired key = %v, called %v", err, called)
+	}
+	if cache.HitCount() != 4 || cache.MissCount() != 1 {
+		t.Fatalf("hits = %d, misses = %d", cache.HitCount(), cache.MissCount())
+	}
+	fn := func(value []byte, ttl uint32) error { return nil }
+	if allocs := testing.AllocsPerRun(100, func() { cache.GetFnWithTTL([]byte("b"), fn) }); allocs > 0 {
+		t.Fatalf("GetFnWithTTL allocs = %v", allocs)
+	}
+}
+
+func TestGetUnsafe(t *testing.T) {
+	key := []byte("key")
+	cache := NewCache(1024 * 1024)
+	cache.Set(key, []byte("value"), 0)
+	if _, err := cache.GetUnsafe(key); err != ErrUnsafeGetDisabled {
+		t.Fatalf("GetUnsafe without WithUnsafeGet err = %v", err)
+	}
+
+	cache = NewCacheWithOptions(1024*1024, WithUnsafeGet(), WithChecksums(true))
+	if _, err := cache.GetUnsafe(key); err != ErrNotFound {
+		t.Fatalf("GetUnsafe of a missing key err = %v", err)
+	}
+	cache.Set(key, []byte("value"), 0)
+	value, err := cache.GetUnsafe(key)
+	if err != nil || string(value) != "value" {
+		t.Fatalf("GetUnsafe = %q, %v", value, err)
+	}
+	// the view aliases the ring buffer, so an overwrite in place changes it.
+	cache.Set(key, []byte("VALUE"), 0)
+	if string(value) != "VALUE" {
+		t.Fatalf("view after overwrite = %q", value)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { cache.GetUnsafe(key) }); allocs > 0 {
+		t.Fatalf("GetUnsafe allocs = %v", allocs)
+	}
+	if cache.HitCount() == 0 {
+		t.Fatal("GetUnsafe doesn't count hits")
+	}
+}
+
+func TestGetOrSetFn(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("abcd")
+	calls := 0
+	compute := func(dst []byte) (int, error) {
+		calls++
+		return copy(dst, "efgh"), nil
+	}
+	value, err := cache.GetOrSetFn(key, 10, compute, 16)
+	if err != nil || value != nil || calls != 1 {
+		t.Fatalf("GetOrSetFn of missing key = %q, %v, %d calls", value, err, calls)
+	}
+	value, err = cache.GetOrSetFn(key, 10, compute, 16)
+	if err != nil || string(value) != "efgh" || calls != 1 {
+		t.Fatalf("GetOrSetFn of existing key = %q, %v, %d calls", value, err, calls)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 10 {
+		t.Fatalf("ttl = %d", ttl)
+	}
+
+	computeErr := errors.New("compute failed")
+	_, err = cache.GetOrSetFn([]byte("b"), 0, func([]byte) (int, error) { return 0, computeErr }, 16)
+	if err != computeErr || cache.Has([]byte("b")) {
+		t.Fatalf("GetOrSetFn with compute error = %v", err)
+	}
+	_, err = cache.GetOrSetFn([]byte("c"), 0, func([]byte) (int, error) { return 17, nil }, 16)
+	if err != ErrComputedLength || cache.Has([]byte("c")) {
+		t.Fatalf("GetOrSetFn with invalid length = %v", err)
+	}
+
+	miss := func() {
+		cache.Del(key)
+		cache.GetOrSetFn(key, 0, compute, 16)
+	}
+	miss()
+	if allocs := testing.AllocsPerRun(100, miss); allocs > 0 {
+		t.Fatalf("GetOrSetFn miss allocs = %v", allocs)
+	}
+}
+
+func TestDelWithValue(t *testing.T) {
+	now := uint32(1000)
+	cache := NewCacheCustomTimer(1024, &mockTimer{nowCallback: func() uint32 { return now }})
+	cache.Set([]byte("a"), []byte("va"), 0)
+	cache.Set([]byte("b"), []byte("vb"), 10)
+	cache.Set([]byte("c"), []byte("vc"), 0)
+
+	value, affected := cache.DelWithValue([]byte("a"))
+	if !affected || string(value) != "va" {
+		t.Fatalf("DelWithValue = %q, %v", value, affected)
+	}
+	if value, affected = cache.DelWithValue([]byte("a")); affected || value != nil {
+		t.Fatalf("DelWithValue of deleted key = %q, %v", value, affected)
+	}
+	now += 10
+	if value, affected = cache.DelWithValue([]byte("b")); !affected || value != nil {
+		t.Fatalf("DelWithValue of expired key = %q, %v", value, affected)
+	}
+	var got string
+	affected = cache.DelFn([]byte("c"), func(value []byte) {
+		got = string(value)
+	})
+	if !affected || got != "vc" || cache.EntryCount() != 0 {
+		t.Fatalf("DelFn = %q, %v, %d entries", got, affected, cache.EntryCount())
+	}
+	if cache.HitCount() != 0 || cache.MissCount() != 0 {
+		t.Fatalf("hits = %d, misses = %d", cache.HitCount(), cache.MissCount())
+	}
+}
+
+func TestGetIntoBuf(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("abcd")
+	if n, err := cache.GetIntoBuf(key, nil); n != 0 || err != ErrNotFound {
+		t.Fatalf("GetIntoBuf of missing key = %d, %v", n, err)
+	}
+	cache.Set(key, []byte("0123456789"), 0)
+
+	buf := make([]byte, 4)
+	n, err := cache.GetIntoBuf(key, buf)
+	if n != 10 || err != ErrBufferTooSmall {
+		t.Fatalf("GetIntoBuf with short buffer = %d, %v", n, err)
+	}
+	if string(buf) != "\x00\x00\x00\x00" {
+		t.Fatalf("short buffer was written: %q", buf)
+	}
+	if cache.HitCount() != 0 {
+		t.Fatalf("short read counted as hit")
+	}
+	buf = make([]byte, n+2)
+	n, err = cache.GetIntoBuf(key, buf)
+	if n != 10 || err != nil || string(buf[:n]) != "0123456789" {
+		t.Fatalf("GetIntoBuf = %d, %v, %q", n, err, buf[:n])
+	}
+	if cache.HitCount() != 1 {
+		t.Fatalf("HitCount = %d", cache.HitCount())
+	}
+	if allocs := testing.AllocsPerRun(100, func() { cache.GetIntoBuf(key, buf) }); allocs > 0 {
+		t.Fatalf("GetIntoBuf allocs = %v", allocs)
+	}
+}
+
 func TestGetWithExpiration(t *testing.T) {
 	cache := NewCache(1024)
 	key := []byte("abcd")
@@ -238,14 +473,15 @@ func TestGetWithExpiration(t *testing.T) {
 }
 
 func TestExpire(t *testing.T) {
-	cache := NewCache(1024)
+	clock := clocktest.New(time.Now())
+	cache := NewCacheWithOptions(1024, WithClock(clock))
 	key := []byte("abcd")
 	val := []byte("efgh")
 	err := cache.Set(key, val, 1)
 	if err != nil {
 		t.Error("err should be nil")
 	}
-	time.Sleep(time.Second)
+	clock.Advance(time.Second)
 	val, err = cache.Get(key)
 	if err == nil {
 		t.Fatal("key should be expired", string(val))
@@ -391,7 +627,8 @@ func testTTLWithNotFoundKey(t *testing.T) {
 }
 
 func TestTouch(t *testing.T) {
-	cache := NewCache(1024)
+	clock := clocktest.New(time.Now())
+	cache := NewCacheWithOptions(1024, WithClock(clock))
 	key1 := []byte("abcd")
 	val1 := []byte("efgh")
 	key2 := []byte("ijkl")
@@ -411,7 +648,7 @@ func TestTouch(t *testing.T) {
 	if err != nil {
 		t.Error("err should be nil", err.Error())
 	}
-	time.Sleep(time.Second)
+	clock.Advance(time.Second)
 	ttl, err := cache.TTL(key1)
 	if err != nil {
 		t.Error("err should be nil", err.Error())
@@ -432,7 +669,8 @@ func TestTouch(t *testing.T) {
 }
 
 func TestAverageAccessTimeWhenUpdateInplace(t *testing.T) {
-	cache := NewCache(1024)
+	clock := clocktest.New(time.Now())
+	cache := NewCacheWithOptions(1024, WithClock(clock))
 
 	key := []byte("test-key")
 	valueLong := []byte("very-long-de-value")
@@ -442,18 +680,18 @@ func TestAverageAccessTimeWhenUpdateInplace(t *testing.T) {
 	if err != nil {
 		t.Fatal("err should be nil")
 	}
-	now := time.Now().Unix()
+	now := clock.Now().Unix()
 	aat := cache.AverageAccessTime()
 	if (now - aat) > 1 {
 		t.Fatalf("track average access time error, now:%d, aat:%d", now, aat)
 	}
 
-	time.Sleep(time.Second * 4)
+	clock.Advance(4 * time.Second)
 	err = cache.Set(key, valueShort, 0)
 	if err != nil {
 		t.Fatal("err should be nil")
 	}
-	now = time.Now().Unix()
+	now = clock.Now().Unix()
 	aat = cache.AverageAccessTime()
 	if (now - aat) > 1 {
 		t.Fatalf("track average access time error, now:%d, aat:%d", now, aat)
@@ -461,7 +699,8 @@ func TestAverageAccessTimeWhenUpdateInplace(t *testing.T) {
 }
 
 func TestAverageAccessTimeWhenUpdateWithNewSpace(t *testing.T) {
-	cache := NewCache(1024)
+	clock := clocktest.New(time.Now())
+	cache := NewCacheWithOptions(1024, WithClock(clock))
 
 	key := []byte("test-key")
 	valueLong := []byte("very-long-de-value")
@@ -471,18 +710,18 @@ func TestAverageAccessTimeWhenUpdateWithNewSpace(t *testing.T) {
 	if err != nil {
 		t.Fatal("err should be nil")
 	}
-	now := time.Now().Unix()
+	now := clock.Now().Unix()
 	aat := cache.AverageAccessTime()
 	if (now - aat) > 1 {
 		t.Fatalf("track average access time error, now:%d, aat:%d", now, aat)
 	}
 
-	time.Sleep(time.Second * 4)
+	clock.Advance(4 * time.Second)
 	err = cache.Set(key, valueLong, 0)
 	if err != nil {
 		t.Fatal("err should be nil")
 	}
-	now = time.Now().Unix()
+	now = clock.Now().Unix()
 	aat = cache.AverageAccessTime()
 	if (now - aat) > 2 {
 		t.Fatalf("track average access time error, now:%d, aat:%d", now, aat)
@@ -540,7 +779,8 @@ func TestLargeEntry(t *testing.T) {
 }
 
 func TestInt64Key(t *testing.T) {
-	cache := NewCache(1024)
+	clock := clocktest.New(time.Now())
+	cache := NewCacheWithOptions(1024, WithClock(clock))
 	err := cache.SetInt(1, []byte("abc"), 3)
 	if err != nil {
 		t.Error("err should be nil")
@@ -556,7 +796,7 @@ func TestInt64Key(t *testing.T) {
 	if !bytes.Equal(val, []byte("abc")) {
 		t.Error("value not equal")
 	}
-	time.Sleep(2 * time.Second)
+	clock.Advance(2 * time.Second)
 	val, expiry, err := cache.GetIntWithExpiration(1)
 	if err != nil {
 		t.Error("err should be nil")
@@ -564,7 +804,7 @@ func TestInt64Key(t *testing.T) {
 	if !bytes.Equal(val, []byte("abc")) {
 		t.Error("value not equal")
 	}
-	now := time.Now()
+	now := clock.Now()
 	if expiry != uint32(now.Unix()+1) {
 		t.Errorf("Expiry should one second in the future but was %v", now)
 	}
@@ -579,8 +819,56 @@ func TestInt64Key(t *testing.T) {
 	}
 }
 
-func TestIterator(t *testing.T) {
+func TestInt64KeyParity(t *testing.T) {
 	cache := NewCache(1024)
+	if val, err := cache.GetOrSetInt(1, []byte("abc"), 0); val != nil || err != nil {
+		t.Fatalf("GetOrSetInt of missing key = %q, %v", val, err)
+	}
+	if val, err := cache.GetOrSetInt(1, []byte("xyz"), 0); string(val) != "abc" || err != nil {
+		t.Fatalf("GetOrSetInt of existing key = %q, %v", val, err)
+	}
+	if val, found, err := cache.SetAndGetInt(1, []byte("def"), 0); !found || string(val) != "abc" || err != nil {
+		t.Fatalf("SetAndGetInt = %q, %v, %v", val, found, err)
+	}
+	if err := cache.TouchInt(1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := cache.TTLInt(1); ttl != 10 || err != nil {
+		t.Fatalf("TTLInt = %d, %v", ttl, err)
+	}
+	found, replaced, err := cache.UpdateInt(1, func(value []byte, found bool) ([]byte, bool, int) {
+		return append(value, 'g'), true, 0
+	})
+	if !found || !replaced || err != nil {
+		t.Fatalf("UpdateInt = %v, %v, %v", found, replaced, err)
+	}
+	var got string
+	if err := cache.GetIntFn(1, func(value []byte) error {
+		got = string(value)
+		return nil
+	}); err != nil || got != "defg" {
+		t.Fatalf("GetIntFn = %q, %v", got, err)
+	}
+	// the int keys are the same entries as the byte keys they encode to.
+	var bKey [8]byte
+	binary.LittleEndian.PutUint64(bKey[:], 1)
+	if val, _ := cache.Get(bKey[:]); string(val) != "defg" {
+		t.Fatalf("Get of encoded key = %q", val)
+	}
+	fn := func([]byte) error { return nil }
+	if allocs := testing.AllocsPerRun(100, func() {
+		cache.TouchInt(1, 0)
+		cache.TTLInt(1)
+		cache.GetIntFn(1, fn)
+	}); allocs > 0 {
+		t.Fatalf("int key allocs = %v", allocs)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	// the entries only fit without eviction with the layout of unseeded hash values.
+	clock := clocktest.New(time.Now())
+	cache := NewCacheWithOptions(1024, WithHashSeed(0), WithClock(clock))
 	count := 10000
 	for i := 0; i < count; i++ {
 		err := cache.Set([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("val%d", i)), 0)
@@ -590,7 +878,7 @@ func TestIterator(t *testing.T) {
 	}
 	// Set some value that expires to make sure expired entry is not returned.
 	cache.Set([]byte("abc"), []byte("def"), 1)
-	time.Sleep(2 * time.Second)
+	clock.Advance(2 * time.Second)
 	it := cache.NewIterator()
 	for i := 0; i < count; i++ {
 		entry := it.Next()
@@ -607,6 +895,42 @@ func TestIterator(t *testing.T) {
 	}
 }
 
+func TestWillFit(t *testing.T) {
+	cacheSize := 512 * 1024
+	cache := NewCache(cacheSize)
+	key := []byte("abcd")
+	maxValLen := cacheSize/1024 - ENTRY_HDR_SIZE - len(key)
+	if n := cache.MaxValueLen(len(key)); n != maxValLen {
+		t.Fatalf("MaxValueLen = %d, want %d", n, maxValLen)
+	}
+	if !cache.WillFit(len(key), maxValLen) || cache.WillFit(len(key), maxValLen+1) {
+		t.Fatal("WillFit doesn't match the limit of Set")
+	}
+	if err := cache.Set(key, make([]byte, maxValLen), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(key, make([]byte, maxValLen+1), 0); err != ErrLargeEntry {
+		t.Fatalf("err = %v", err)
+	}
+	if cache.MaxValueLen(65536) != -1 || cache.WillFit(65536, 0) || cache.WillFit(-1, 0) {
+		t.Fatal("a large key fits")
+	}
+
+	sizes := make([]int, segmentCount)
+	for i := range sizes {
+		sizes[i] = 1024 * 1024
+	}
+	sizes[100] = 4096
+	cache = NewCacheWithOptions(0, WithSegmentSizes(sizes))
+	if n := cache.MaxValueLen(len(key)); n != 4096/4-ENTRY_HDR_SIZE-len(key) {
+		t.Fatalf("MaxValueLen = %d, want the limit of the smallest segment", n)
+	}
+	cache.Close()
+	if cache.WillFit(len(key), 0) {
+		t.Fatal("an entry fits in a closed cache")
+	}
+}
+
 func TestSetLargerEntryDeletesWrongEntry(t *testing.T) {
 	cachesize := 512 * 1024
 	cache := NewCache(cachesize)
@@ -1018,6 +1342,29 @@ func TestSetAndGet(t *testing.T) {
 	}
 }
 
+func TestGetSet(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	key := []byte("key")
+	if old, err := cache.GetSet(key, []byte("v1"), 0); old != nil || err != ErrNotFound {
+		t.Fatalf("GetSet of a missing key = %q, %v", old, err)
+	}
+	old, err := cache.GetSet(key, []byte("v2"), 0)
+	if err != nil || string(old) != "v1" {
+		t.Fatalf("GetSet = %q, %v", old, err)
+	}
+	// the old value is a copy, not a view of the ring buffer.
+	cache.Set(key, []byte("v3"), 0)
+	if string(old) != "v1" {
+		t.Fatalf("old value changed to %q", old)
+	}
+	if old, err := cache.GetSet(key, make([]byte, 1024*1024), 0); old != nil || err != ErrLargeEntry {
+		t.Fatalf("GetSet of a large value = %q, %v", old, err)
+	}
+	if v, err := cache.Get(key); err != nil || string(v) != "v3" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	testName := "Update"
 	cache := NewCache(1024)
@@ -1087,6 +1434,50 @@ func TestUpdate(t *testing.T) {
 	assertExpectations(4, true, false, val2, val2)
 }
 
+func TestUpdateE(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024, WithTimer(timer))
+	key := []byte("abcd")
+	errAbort := errors.New("abort")
+
+	found, err := cache.UpdateE(key, func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		return nil, UpdateTouch, 10, nil
+	})
+	if found || err != ErrNotFound {
+		t.Fatalf("touch of a missing key = %v, %v", found, err)
+	}
+	found, err = cache.UpdateE(key, func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		return []byte("v1"), UpdateReplace, 10, nil
+	})
+	if found || err != nil {
+		t.Fatalf("replace = %v, %v", found, err)
+	}
+	found, err = cache.UpdateE(key, func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		return []byte("v2"), UpdateReplace, 0, errAbort
+	})
+	if !found || err != errAbort {
+		t.Fatalf("abort = %v, %v", found, err)
+	}
+	found, err = cache.UpdateE(key, func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		if string(value) != "v1" {
+			t.Fatalf("value = %q after the abort", value)
+		}
+		return nil, UpdateTouch, 100, nil
+	})
+	if !found || err != nil {
+		t.Fatalf("touch = %v, %v", found, err)
+	}
+	if ttl, err := cache.TTL(key); err != nil || ttl != 100 {
+		t.Fatalf("ttl = %d, %v", ttl, err)
+	}
+	found, err = cache.UpdateE(key, func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		return []byte("v3"), UpdateKeep, 0, nil
+	})
+	if v, _ := cache.Get(key); !found || err != nil || string(v) != "v1" {
+		t.Fatalf("keep = %v, %v, value %q", found, err, v)
+	}
+}
+
 func TestBenchmarkCacheGetWithBuf(t *testing.T) {
 	alloc := testing.Benchmark(BenchmarkCacheGetWithBuf).AllocsPerOp()
 	if alloc > 0 {
@@ -1100,3 +1491,1601 @@ func TestBenchmarkCacheSet(t *testing.T) {
 		t.Errorf("current alloc count '%d' is higher than 0", alloc)
 	}
 }
+
+func TestIncrDecr(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("counter")
+
+	value, err := cache.Incr(key, 5, 0)
+	if err != nil || value != 5 {
+		t.Fatalf("Incr on missing key expected 5, got %d, err %v", value, err)
+	}
+	value, err = cache.Incr(key, 3, 0)
+	if err != nil || value != 8 {
+		t.Fatalf("Incr expected 8, got %d, err %v", value, err)
+	}
+	value, err = cache.Decr(key, 10, 0)
+	if err != nil || value != -2 {
+		t.Fatalf("Decr expected -2, got %d, err %v", value, err)
+	}
+	stored, err := cache.Get(key)
+	if err != nil || string(stored) != "-2" {
+		t.Fatalf("stored value expected -2, got %s, err %v", stored, err)
+	}
+
+	cache.Set(key, []byte("abc"), 0)
+	if _, err = cache.Incr(key, 1, 0); err != ErrNotInteger {
+		t.Fatalf("Incr on non-integer value expected ErrNotInteger, got %v", err)
+	}
+	cache.Set(key, []byte(strconv.FormatInt(math.MaxInt64, 10)), 0)
+	if _, err = cache.Incr(key, 1, 0); err != ErrNotInteger {
+		t.Fatalf("Incr overflow expected ErrNotInteger, got %v", err)
+	}
+
+	// MinInt64 can't be negated, Decr must not turn it into an increment.
+	cache.Set(key, []byte("0"), 0)
+	if value, err = cache.Decr(key, math.MinInt64, 0); err != ErrNotInteger {
+		t.Fatalf("Decr overflow expected ErrNotInteger, got %d, err %v", value, err)
+	}
+	cache.Set(key, []byte("-1"), 0)
+	if value, err = cache.Decr(key, math.MinInt64, 0); err != nil || value != math.MaxInt64 {
+		t.Fatalf("Decr expected %d, got %d, err %v", int64(math.MaxInt64), value, err)
+	}
+	cache.Set(key, []byte(strconv.FormatInt(math.MinInt64, 10)), 0)
+	if _, err = cache.Decr(key, 1, 0); err != ErrNotInteger {
+		t.Fatalf("Decr underflow expected ErrNotInteger, got %v", err)
+	}
+}
+
+func TestIncrKeepTTL(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("counter")
+	if value, err := cache.IncrKeepTTL(key, 2); err != nil || value != 2 {
+		t.Fatalf("IncrKeepTTL on missing key expected 2, got %d, err %v", value, err)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 0 {
+		t.Fatalf("missing key expected no expiration, got %d", ttl)
+	}
+	cache.Set(key, []byte("5"), 100)
+	if value, err := cache.IncrKeepTTL(key, 1); err != nil || value != 6 {
+		t.Fatalf("IncrKeepTTL expected 6, got %d, err %v", value, err)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 100 {
+		t.Fatalf("IncrKeepTTL expected the ttl to be kept, got %d", ttl)
+	}
+	cache.Set(key, []byte("abc"), 0)
+	if _, err := cache.IncrKeepTTL(key, 1); err != ErrNotInteger {
+		t.Fatalf("IncrKeepTTL on non-integer value expected ErrNotInteger, got %v", err)
+	}
+}
+
+func TestConcurrentIncr(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cache.Incr(key, 1, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	value, err := cache.Incr(key, 0, 0)
+	if err != nil || value != 8000 {
+		t.Fatalf("expected 8000, got %d, err %v", value, err)
+	}
+}
+
+func TestAppendPrepend(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("abcd")
+	if err := cache.Append(key, []byte("x")); err != ErrNotFound {
+		t.Fatalf("Append on missing key expected ErrNotFound, got %v", err)
+	}
+	cache.Set(key, []byte("efgh"), 100)
+	// grow the entry so it has spare capacity, then shrink it.
+	cache.Set(key, []byte("efghijklmn"), 100)
+	cache.Set(key, []byte("efgh"), 100)
+	if err := cache.Append(key, []byte("ij")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Prepend(key, []byte("cd")); err != nil {
+		t.Fatal(err)
+	}
+	value, expireAt, err := cache.GetWithExpiration(key)
+	if err != nil || string(value) != "cdefghij" {
+		t.Fatalf("expected cdefghij, got %s, err %v", value, err)
+	}
+	if expireAt == 0 {
+		t.Fatal("expiration should be kept")
+	}
+	// exceed the capacity so the entry is re-inserted.
+	if err = cache.Append(key, []byte("klmnopqrstuvwxyz")); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.Prepend(key, []byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	value, expireAt2, err := cache.GetWithExpiration(key)
+	if err != nil || string(value) != "abcdefghijklmnopqrstuvwxyz" {
+		t.Fatalf("expected alphabet, got %s, err %v", value, err)
+	}
+	if expireAt2 != expireAt {
+		t.Fatalf("expiration expected %d, got %d", expireAt, expireAt2)
+	}
+	if cache.EntryCount() != 1 {
+		t.Fatalf("entry count expected 1, got %d", cache.EntryCount())
+	}
+}
+
+func TestGetRangeSetRange(t *testing.T) {
+	for _, checksums := range []bool{false, true} {
+		timer := &mockMilliTimer{nowMs: 100000}
+		cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithChecksums(checksums))
+		key := []byte("bitmap")
+		if err := cache.SetRange(key, 0, []byte("x")); err != ErrNotFound {
+			t.Fatalf("SetRange of a missing key err = %v", err)
+		}
+		cache.Set(key, []byte("0123456789"), 10)
+		if v, err := cache.GetRange(key, 2, 3); err != nil || string(v) != "234" {
+			t.Fatalf("GetRange = %q, %v", v, err)
+		}
+		if v, err := cache.GetRange(key, 8, 10); err != nil || string(v) != "89" {
+			t.Fatalf("GetRange past the end = %q, %v", v, err)
+		}
+		if v, err := cache.GetRange(key, 20, 10); err != nil || len(v) != 0 {
+			t.Fatalf("GetRange after the end = %q, %v", v, err)
+		}
+		if _, err := cache.GetRange(key, -1, 1); err != ErrOutOfRange {
+			t.Fatalf("GetRange of a negative offset err = %v", err)
+		}
+		if err := cache.SetRange(key, 3, []byte("abc")); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := cache.Get(key); err != nil || string(v) != "012abc6789" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+		// the value is extended past its capacity with zeros.
+		if err := cache.SetRange(key, 12, []byte("z")); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := cache.Get(key); err != nil || string(v) != "012abc6789\x00\x00z" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+		if ttl, _ := cache.TTL(key); ttl != 10 {
+			t.Fatalf("ttl = %d", ttl)
+		}
+		// the value is extended in place within its capacity.
+		cache.Set(key, make([]byte, 100), 0)
+		cache.Set(key, []byte("ab"), 0)
+		if err := cache.SetRange(key, 4, []byte("cd")); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := cache.Get(key); err != nil || string(v) != "ab\x00\x00cd" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+		if errs := cache.Validate(); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+	}
+}
+
+func TestUpdateInPlace(t *testing.T) {
+	cache := NewCacheWithOptions(1024*1024, WithChecksums(true))
+	key := []byte("counter")
+	errAbort := errors.New("abort")
+	if err := cache.UpdateInPlace(key, func(value []byte) error { return nil }); err != ErrNotFound {
+		t.Fatalf("UpdateInPlace of a missing key err = %v", err)
+	}
+	cache.Set(key, make([]byte, 8), 10)
+	incr := func(value []byte) error {
+		binary.LittleEndian.PutUint64(value, binary.LittleEndian.Uint64(value)+1)
+		return nil
+	}
+	if n := testing.AllocsPerRun(100, func() { cache.UpdateInPlace(key, incr) }); n != 0 {
+		t.Fatalf("allocs = %v, want 0", n)
+	}
+	if err := cache.UpdateInPlace(key, func(value []byte) error {
+		incr(value)
+		return errAbort
+	}); err != errAbort {
+		t.Fatalf("UpdateInPlace err = %v", err)
+	}
+	if v, err := cache.Get(key); err != nil || binary.LittleEndian.Uint64(v) != 102 {
+		t.Fatalf("Get = %v, %v", v, err)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 10 {
+		t.Fatalf("ttl = %d", ttl)
+	}
+
+	// the values wrapping around the ring buffer are written back.
+	cache = NewCacheWithOptions(512*1024, WithChecksums(true))
+	var wrapped int
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprint(i))
+		cache.Set(key, make([]byte, 100), 0)
+		hashVal := cache.hash(key)
+		seg := &cache.segments[hashVal&segmentAndOpVal]
+		hdr, off, _ := seg.locate(key, hashVal, true)
+		if seg.rb.getDataOff(off+ENTRY_HDR_SIZE+int64(hdr.keyLen))+100 > len(seg.rb.data) {
+			wrapped++
+		}
+		if err := cache.UpdateInPlace(key, func(value []byte) error {
+			copy(value, key)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := cache.Get(key); err != nil || !bytes.HasPrefix(v, key) {
+			t.Fatalf("Get(%s) = %q, %v", key, v, err)
+		}
+	}
+	if wrapped == 0 {
+		t.Fatal("no value wrapped around")
+	}
+}
+
+func TestSetMultiGetMulti(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	var entries []Entry
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		entries = append(entries, Entry{Key: key, Value: []byte(fmt.Sprintf("val%d", i))})
+		keys = append(keys, key)
+	}
+	entries = append(entries, Entry{Key: make([]byte, 65536)})
+	errs := cache.SetMulti(entries)
+	for i := 0; i < 1000; i++ {
+		if errs[i] != nil {
+			t.Fatalf("SetMulti(%d) unexpected err %v", i, errs[i])
+		}
+	}
+	if errs[1000] != ErrLargeKey {
+		t.Fatalf("SetMulti expected ErrLargeKey, got %v", errs[1000])
+	}
+	keys = append(keys, []byte("missing"))
+	values, errs := cache.GetMulti(keys)
+	for i := 0; i < 1000; i++ {
+		if errs[i] != nil || string(values[i]) != fmt.Sprintf("val%d", i) {
+			t.Fatalf("GetMulti(%d) got %s, err %v", i, values[i], errs[i])
+		}
+	}
+	if values[1000] != nil || errs[1000] != ErrNotFound {
+		t.Fatalf("GetMulti expected ErrNotFound, got %v", errs[1000])
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(1024*1024, WithWriteThrough(store))
+	key := []byte("lock")
+	if deleted, err := cache.CompareAndDelete(key, []byte("token")); deleted || err != ErrNotFound {
+		t.Fatalf("CompareAndDelete of a missing key = %v, %v", deleted, err)
+	}
+	cache.Set(key, []byte("token"), 0)
+	if deleted, err := cache.CompareAndDelete(key, []byte("other")); deleted || err != nil {
+		t.Fatalf("CompareAndDelete of another value = %v, %v", deleted, err)
+	}
+	if _, ok := store.get("lock"); !ok {
+		t.Fatal("the key is deleted from the store")
+	}
+	if deleted, err := cache.CompareAndDelete(key, []byte("token")); !deleted || err != nil {
+		t.Fatalf("CompareAndDelete = %v, %v", deleted, err)
+	}
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Fatalf("Get err = %v", err)
+	}
+	if _, ok := store.get("lock"); ok {
+		t.Fatal("the key isn't deleted from the store")
+	}
+}
+
+func TestDelMulti(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		cache.Set(key, key, 0)
+		keys = append(keys, key)
+	}
+	keys = append(keys, []byte("missing"))
+	if affected := cache.DelMulti(keys[50:]); affected != 50 {
+		t.Fatalf("DelMulti expected 50 deletions, got %d", affected)
+	}
+	if cache.EntryCount() != 50 {
+		t.Fatalf("entry count expected 50, got %d", cache.EntryCount())
+	}
+	if _, err := cache.Get(keys[60]); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := cache.Get(keys[10]); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+}
+
+func TestTouchMultiTTLMulti(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		cache.Set(key, key, 10)
+		keys = append(keys, key)
+	}
+	keys = append(keys, []byte("missing"))
+	errs := cache.TouchMulti(keys[50:], 100)
+	for i, err := range errs[:50] {
+		if err != nil {
+			t.Fatalf("TouchMulti(%d) unexpected err %v", i, err)
+		}
+	}
+	if errs[50] != ErrNotFound {
+		t.Fatalf("TouchMulti expected ErrNotFound, got %v", errs[50])
+	}
+	timeLeft, errs := cache.TTLMulti(keys)
+	for i := 0; i < 100; i++ {
+		want := uint32(10)
+		if i >= 50 {
+			want = 100
+		}
+		if errs[i] != nil || timeLeft[i] != want {
+			t.Fatalf("TTLMulti(%d) got %d, err %v", i, timeLeft[i], errs[i])
+		}
+	}
+	if timeLeft[100] != 0 || errs[100] != ErrNotFound {
+		t.Fatalf("TTLMulti expected ErrNotFound, got %v", errs[100])
+	}
+}
+
+func TestUpdateMulti(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	var keys [][]byte
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("account%d", i))
+		cache.Set(key, []byte("100"), 0)
+		keys = append(keys, key)
+	}
+	// transfers between the accounts keep the total, which every UpdateMulti sees.
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				from, to := (g+n)%10, (g+2*n+1)%10
+				if from == to {
+					continue
+				}
+				pair := [][]byte{keys[from], keys[to]}
+				err := cache.UpdateMulti(pair, func(idx int, old []byte, found bool) ([]byte, bool, int) {
+					balance, _ := strconv.Atoi(string(old))
+					if idx == 0 {
+						return []byte(strconv.Itoa(balance - 1)), true, 0
+					}
+					return []byte(strconv.Itoa(balance + 1)), true, 0
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	var total int
+	check := func() {
+		total = 0
+		cache.UpdateMulti(keys, func(idx int, old []byte, found bool) ([]byte, bool, int) {
+			balance, _ := strconv.Atoi(string(old))
+			total += balance
+			return nil, false, 0
+		})
+	}
+	for i := 0; i < 100; i++ {
+		if check(); total != 1000 {
+			t.Fatalf("total = %d during the transfers", total)
+		}
+	}
+	wg.Wait()
+	if check(); total != 1000 {
+		t.Fatalf("total = %d", total)
+	}
+
+	err := cache.UpdateMulti([][]byte{[]byte("new"), make([]byte, 65536), []byte("skipped")},
+		func(idx int, old []byte, found bool) ([]byte, bool, int) {
+			if idx == 0 && found {
+				t.Fatal("new key found")
+			}
+			return []byte("value"), true, 0
+		})
+	if err != ErrLargeKey {
+		t.Fatalf("UpdateMulti err = %v", err)
+	}
+	if _, err := cache.Get([]byte("new")); err != ErrNotFound {
+		t.Fatalf("Get of the key rolled back err = %v", err)
+	}
+	if _, err := cache.Get([]byte("skipped")); err != ErrNotFound {
+		t.Fatalf("Get of the key after the error err = %v", err)
+	}
+
+	// the last value is too large, the updates before it are rolled back.
+	cache.Set([]byte("pinned"), []byte("old"), 100)
+	cache.Pin([]byte("pinned"))
+	_, expireAt, _ := cache.GetWithExpiration([]byte("pinned"))
+	err = cache.UpdateMulti([][]byte{keys[0], []byte("pinned"), []byte("large")},
+		func(idx int, old []byte, found bool) ([]byte, bool, int) {
+			if idx == 2 {
+				return make([]byte, 8192), true, 0
+			}
+			return []byte("value"), true, 0
+		})
+	if err != ErrLargeEntry {
+		t.Fatalf("UpdateMulti err = %v", err)
+	}
+	if v, err := cache.Get(keys[0]); err != nil || string(v) != "100" {
+		t.Fatalf("Get of %s = %q, %v", keys[0], v, err)
+	}
+	if v, exp, err := cache.GetWithExpiration([]byte("pinned")); err != nil || string(v) != "old" || exp != expireAt {
+		t.Fatalf("Get of pinned = %q, %d, %v", v, exp, err)
+	}
+	hashVal := cache.hash([]byte("pinned"))
+	if hdr, _, _ := cache.segments[hashVal&segmentAndOpVal].locate([]byte("pinned"), hashVal, true); hdr.flags&flagPinned == 0 {
+		t.Fatal("restored entry not pinned")
+	}
+}
+
+func TestPop(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("abcd")
+	if _, err := cache.Pop(key); err != ErrNotFound {
+		t.Fatalf("Pop on missing key expected ErrNotFound, got %v", err)
+	}
+	cache.Set(key, []byte("efgh"), 0)
+	value, err := cache.Pop(key)
+	if err != nil || string(value) != "efgh" {
+		t.Fatalf("Pop expected efgh, got %s, err %v", value, err)
+	}
+	if _, err = cache.Get(key); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after Pop, got %v", err)
+	}
+	if cache.EntryCount() != 0 {
+		t.Fatalf("entry count expected 0, got %d", cache.EntryCount())
+	}
+}
+
+func TestGetExGetDel(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	key := []byte("key")
+	if _, err := cache.GetEx(key, 10); err != ErrNotFound {
+		t.Fatalf("GetEx of a missing key err = %v", err)
+	}
+	cache.Set(key, []byte("value"), 10)
+	if v, err := cache.GetEx(key, 100); err != nil || string(v) != "value" {
+		t.Fatalf("GetEx = %q, %v", v, err)
+	}
+	if ttl, err := cache.TTL(key); err != nil || ttl != 100 {
+		t.Fatalf("ttl = %d, %v", ttl, err)
+	}
+	if v, err := cache.GetEx(key, 0); err != nil || string(v) != "value" {
+		t.Fatalf("GetEx = %q, %v", v, err)
+	}
+	if ttl, err := cache.TTL(key); err != nil || ttl != 0 {
+		t.Fatalf("ttl = %d, %v after GetEx without expiration", ttl, err)
+	}
+	if v, err := cache.GetDel(key); err != nil || string(v) != "value" {
+		t.Fatalf("GetDel = %q, %v", v, err)
+	}
+	if _, err := cache.GetDel(key); err != ErrNotFound {
+		t.Fatalf("GetDel of a deleted key err = %v", err)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	var now uint32 = 100
+	cache := NewCacheCustomTimer(1024, &mockTimer{nowCallback: func() uint32 { return now }})
+	key := []byte("abcd")
+	if _, err := cache.Peek(key); err != ErrNotFound {
+		t.Fatalf("Peek on missing key expected ErrNotFound, got %v", err)
+	}
+	cache.Set(key, []byte("efgh"), 10)
+	now = 105
+	value, err := cache.Peek(key)
+	if err != nil || string(value) != "efgh" {
+		t.Fatalf("Peek expected efgh, got %s, err %v", value, err)
+	}
+	err = cache.PeekFn(key, func(val []byte) error {
+		if string(val) != "efgh" {
+			t.Fatalf("PeekFn expected efgh, got %s", val)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.HitCount() != 0 || cache.MissCount() != 0 {
+		t.Fatalf("Peek should not change counters, got hit %d miss %d", cache.HitCount(), cache.MissCount())
+	}
+	if cache.AverageAccessTime() != 100 {
+		t.Fatalf("Peek should not change access time, got %d", cache.AverageAccessTime())
+	}
+	now = 110
+	if _, err = cache.Peek(key); err != ErrExpired {
+		t.Fatalf("Peek on expired key expected ErrExpired, got %v", err)
+	}
+	if cache.EntryCount() != 1 || cache.ExpiredCount() != 0 {
+		t.Fatal("Peek should not remove expired entry")
+	}
+}
+
+func TestHas(t *testing.T) {
+	var now uint32 = 100
+	cache := NewCacheCustomTimer(1024, &mockTimer{nowCallback: func() uint32 { return now }})
+	key := []byte("abcd")
+	if cache.Has(key) {
+		t.Fatal("Has on missing key should be false")
+	}
+	cache.Set(key, []byte("efgh"), 10)
+	if !cache.Has(key) {
+		t.Fatal("Has on existing key should be true")
+	}
+	now = 110
+	if cache.Has(key) {
+		t.Fatal("Has on expired key should be false")
+	}
+	if cache.HitCount() != 0 || cache.MissCount() != 0 {
+		t.Fatal("Has should not change counters")
+	}
+}
+
+func TestDelAt(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	now := func() time.Time { return time.UnixMilli(timer.NowMilli()) }
+	if err := cache.DelAt([]byte("missing"), now().Add(time.Second)); err != ErrNotFound {
+		t.Fatalf("DelAt of a missing key err = %v", err)
+	}
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Set([]byte("b"), []byte("2"), 1)
+	cache.Set([]byte("c"), []byte("3"), 100)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := cache.DelAt([]byte(key), now().Add(1500*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, ttl := range map[string]uint32{"a": 2, "b": 1, "c": 2} {
+		if v, _ := cache.TTL([]byte(key)); v != ttl {
+			t.Fatalf("TTL(%s) = %d, want %d", key, v, ttl)
+		}
+	}
+	timer.nowMs += 1499
+	if _, err := cache.Get([]byte("a")); err != nil {
+		t.Fatalf("Get before the deletion err = %v", err)
+	}
+	timer.nowMs++
+	if n := cache.DeleteExpired(); n != 3 {
+		t.Fatalf("DeleteExpired = %d", n)
+	}
+	if cache.EntryCount() != 0 {
+		t.Fatalf("entry count = %d", cache.EntryCount())
+	}
+
+	cache.Set([]byte("d"), []byte("4"), 0)
+	events := cache.Events(1)
+	if err := cache.DelAt([]byte("d"), now()); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-events; ev.Type != EventDel || string(ev.Key) != "d" {
+		t.Fatalf("event = %+v", ev)
+	}
+
+	// the times of the wall clock are converted to the clock of the cache.
+	cache = NewCacheWithOptions(1024*1024, WithClock(NewMonotonicClock()))
+	cache.Set([]byte("e"), []byte("5"), 0)
+	if err := cache.DelAt([]byte("e"), time.Now().Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := cache.TTL([]byte("e")); ttl != 10 {
+		t.Fatalf("TTL = %d", ttl)
+	}
+	if err := cache.DelAt([]byte("e"), time.Now()); err != nil || cache.EntryCount() != 0 {
+		t.Fatalf("DelAt now = %v, entry count = %d", err, cache.EntryCount())
+	}
+}
+
+func TestPersist(t *testing.T) {
+	var now uint32 = 100
+	cache := NewCacheCustomTimer(1024, &mockTimer{nowCallback: func() uint32 { return now }})
+	key := []byte("abcd")
+	if err := cache.Persist(key); err != ErrNotFound {
+		t.Fatalf("Persist on missing key expected ErrNotFound, got %v", err)
+	}
+	cache.Set(key, []byte("efgh"), 10)
+	if err := cache.Persist(key); err != nil {
+		t.Fatal(err)
+	}
+	now = 200
+	value, expireAt, err := cache.GetWithExpiration(key)
+	if err != nil || string(value) != "efgh" || expireAt != 0 {
+		t.Fatalf("expected persisted value, got %s, expireAt %d, err %v", value, expireAt, err)
+	}
+}
+
+func TestSetWithDuration(t *testing.T) {
+	var now uint32 = 100
+	cache := NewCacheCustomTimer(1024, &mockTimer{nowCallback: func() uint32 { return now }})
+	key := []byte("abcd")
+	cache.SetWithDuration(key, []byte("efgh"), 1500*time.Millisecond)
+	_, expireAt, err := cache.GetWithExpiration(key)
+	if err != nil || expireAt != 102 {
+		t.Fatalf("expected expireAt 102, got %d, err %v", expireAt, err)
+	}
+	cache.TouchWithDuration(key, time.Minute)
+	_, expireAt, _ = cache.GetWithExpiration(key)
+	if expireAt != 160 {
+		t.Fatalf("expected expireAt 160, got %d", expireAt)
+	}
+	cache.TouchWithDuration(key, 0)
+	_, expireAt, _ = cache.GetWithExpiration(key)
+	if expireAt != 0 {
+		t.Fatalf("expected no expire, got %d", expireAt)
+	}
+}
+
+// mockMilliTimer is a mock for MilliTimer contract.
+type mockMilliTimer struct {
+	nowMs int64
+}
+
+func (mock *mockMilliTimer) Now() uint32 {
+	return uint32(atomic.LoadInt64(&mock.nowMs) / 1000)
+}
+
+func (mock *mockMilliTimer) NowMilli() int64 {
+	return atomic.LoadInt64(&mock.nowMs)
+}
+
+func TestMillisecondExpiration(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheCustomTimer(1024, timer)
+	key := []byte("abcd")
+	cache.SetWithDuration(key, []byte("efgh"), 250*time.Millisecond)
+	timer.nowMs = 100249
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+	timer.nowMs = 100250
+	if _, err := cache.Get(key); err != ErrExpired {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+
+	// whole seconds keep the expiration aligned to the second, like Set.
+	timer.nowMs = 100600
+	cache.SetWithDuration(key, []byte("efgh"), time.Second)
+	timer.nowMs = 100999
+	if _, err := cache.Get(key); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+	timer.nowMs = 101000
+	if _, err := cache.Get(key); err != ErrExpired {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+
+	cache.Set(key, []byte("efgh"), 0)
+	cache.TouchWithDuration(key, 10*time.Millisecond)
+	timer.nowMs = 101010
+	if cache.Has(key) {
+		t.Fatal("key should be expired")
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheCustomTimer(1024*1024, timer)
+	for i := 0; i < 1000; i++ {
+		expireSeconds := 0
+		if i%2 == 0 {
+			expireSeconds = 10
+		}
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), expireSeconds)
+	}
+	if count := cache.DeleteExpired(); count != 0 {
+		t.Fatalf("expected no entry deleted, got %d", count)
+	}
+	atomic.StoreInt64(&timer.nowMs, 110000)
+	if count := cache.DeleteExpired(); count != 500 {
+		t.Fatalf("expected 500 entries deleted, got %d", count)
+	}
+	if cache.EntryCount() != 500 || cache.ExpiredCount() != 500 {
+		t.Fatalf("expected 500 entries and 500 expired, got %d and %d", cache.EntryCount(), cache.ExpiredCount())
+	}
+	for i := 1; i < 1000; i += 2 {
+		if _, err := cache.Get([]byte(fmt.Sprintf("key%d", i))); err != nil {
+			t.Fatalf("key%d unexpected err %v", i, err)
+		}
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024, WithTimer(timer), WithSlidingExpiration())
+	cache.Set([]byte("session"), []byte("v"), 10)
+	cache.Set([]byte("idle"), []byte("v"), 10)
+	cache.Set([]byte("forever"), []byte("v"), 0)
+	cache.SetNotFound([]byte("missing"), 10)
+
+	for i := 0; i < 5; i++ {
+		atomic.AddInt64(&timer.nowMs, 8000)
+		if _, err := cache.Get([]byte("session")); err != nil {
+			t.Fatalf("read %d of a session in use: %v", i, err)
+		}
+		if ttl, _ := cache.TTL([]byte("session")); ttl != 10 {
+			t.Fatalf("ttl after read %d = %d", i, ttl)
+		}
+		cache.Peek([]byte("idle"))
+		cache.Has([]byte("idle"))
+		cache.Get([]byte("missing"))
+	}
+	if _, err := cache.Get([]byte("idle")); err != ErrExpired {
+		t.Fatalf("idle session err = %v", err)
+	}
+	if _, err := cache.Get([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("negative cached key err = %v", err)
+	}
+	if ttl, err := cache.TTL([]byte("forever")); ttl != 0 || err != nil {
+		t.Fatalf("entry without expiration ttl = %d, %v", ttl, err)
+	}
+
+	cache.Touch([]byte("session"), 20)
+	atomic.AddInt64(&timer.nowMs, 15000)
+	cache.Get([]byte("session"))
+	if ttl, _ := cache.TTL([]byte("session")); ttl != 20 {
+		t.Fatalf("ttl after touch and read = %d", ttl)
+	}
+}
+
+func TestDefaultAndMaxTTL(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024, WithTimer(timer), WithDefaultTTL(time.Minute))
+	cache.Set([]byte("a"), []byte("v"), 0)
+	cache.Set([]byte("b"), []byte("v"), 10)
+	if ttl, _ := cache.TTL([]byte("a")); ttl != 60 {
+		t.Fatalf("default ttl = %d", ttl)
+	}
+	if ttl, _ := cache.TTL([]byte("b")); ttl != 10 {
+		t.Fatalf("explicit ttl = %d", ttl)
+	}
+
+	cache = NewCacheWithOptions(1024, WithTimer(timer), WithMaxTTL(time.Minute))
+	cache.Set([]byte("a"), []byte("v"), 0)
+	cache.Set([]byte("b"), []byte("v"), 3600)
+	cache.SetWithDuration([]byte("c"), []byte("v"), time.Second)
+	cache.SetWithCost([]byte("d"), []byte("v"), 1, 3600)
+	for _, tt := range []struct {
+		key string
+		ttl uint32
+	}{{"a", 60}, {"b", 60}, {"c", 1}, {"d", 60}} {
+		if ttl, _ := cache.TTL([]byte(tt.key)); ttl != tt.ttl {
+			t.Fatalf("%s ttl = %d, want %d", tt.key, ttl, tt.ttl)
+		}
+	}
+	cache.Touch([]byte("c"), 3600)
+	if ttl, _ := cache.TTL([]byte("c")); ttl != 60 {
+		t.Fatalf("touched ttl = %d", ttl)
+	}
+
+	cache = NewCacheWithOptions(1024, WithTimer(timer), WithDefaultTTL(time.Hour), WithMaxTTL(time.Minute))
+	cache.Set([]byte("a"), []byte("v"), 0)
+	if ttl, _ := cache.TTL([]byte("a")); ttl != 60 {
+		t.Fatalf("default ttl larger than max ttl = %d", ttl)
+	}
+}
+
+func TestActiveExpiration(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024, WithTimer(timer), WithActiveExpiration(time.Millisecond))
+	defer cache.Close()
+	cache.Set([]byte("abcd"), []byte("efgh"), 1)
+	atomic.StoreInt64(&timer.nowMs, 101000)
+	deadline := time.Now().Add(time.Second)
+	for cache.EntryCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired entry should be deleted by the janitor")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cache.Close()
+	cache.Close()
+}
+
+func TestClose(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(1024*1024, WithWriteThrough(store), WithActiveExpiration(time.Millisecond))
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte("value"), 0)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != ErrClosed {
+		t.Fatalf("second Close err = %v", err)
+	}
+	for i := range cache.segments {
+		if cache.segments[i].rb.data != nil {
+			t.Fatalf("segment %d isn't released", i)
+		}
+	}
+	key := []byte("1")
+	if err := cache.Set([]byte("new"), []byte("value"), 0); err != ErrClosed {
+		t.Fatalf("Set err = %v", err)
+	}
+	if _, ok := store.data["new"]; ok {
+		t.Fatal("Set after Close is written through")
+	}
+	if _, err := cache.Get(key); err != ErrClosed {
+		t.Fatalf("Get err = %v", err)
+	}
+	if _, err := cache.Peek(key); err != ErrClosed {
+		t.Fatalf("Peek err = %v", err)
+	}
+	if err := cache.GetFn(key, func([]byte) error { return nil }); err != ErrClosed {
+		t.Fatalf("GetFn err = %v", err)
+	}
+	if _, err := cache.Incr(key, 1, 0); err != ErrClosed {
+		t.Fatalf("Incr err = %v", err)
+	}
+	if err := cache.Resize(2 * 1024 * 1024); err != ErrClosed {
+		t.Fatalf("Resize err = %v", err)
+	}
+	if cache.Del(key) {
+		t.Fatal("Del after Close is affected")
+	}
+	if _, ok := store.data["1"]; !ok {
+		t.Fatal("Del after Close is written through")
+	}
+
+	// the other operations find no entry.
+	cache.Touch(key, 10)
+	cache.TTL(key)
+	cache.Append(key, []byte("suffix"))
+	cache.Pin(key)
+	cache.SetLarge(key, make([]byte, 10000), 0)
+	cache.GetOrSet(key, []byte("value"), 0)
+	cache.Update(key, func(value []byte, found bool) ([]byte, bool, int) { return []byte("v"), true, 0 })
+	cache.Scan(nil, func(key, value []byte) bool { return true })
+	for it := cache.NewIterator(); it.Next() != nil; {
+	}
+	cache.SetMulti([]Entry{{Key: key, Value: key}})
+	cache.GetMulti([][]byte{key})
+	if _, err := cache.AcquireView(key); err != ErrClosed {
+		t.Fatalf("AcquireView err = %v", err)
+	}
+	for it := cache.SegmentIterator(0); it.Next() != nil; {
+	}
+	cache.DeleteExpired()
+	cache.CompactAll()
+	cache.Shrink(0)
+	cache.MemoryUsage()
+	cache.Stats()
+	cache.Rebalance()
+	cache.ReleaseUnused()
+	cache.Clear()
+	cache.Save(&bytes.Buffer{})
+	cache.DebugDump(&bytes.Buffer{}, 0)
+	if errs := cache.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if n := cache.EntryCount(); n != 0 {
+		t.Fatalf("EntryCount = %d", n)
+	}
+}
+
+func TestOnExpired(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	var expiredKeys []string
+	cache := NewCacheWithOptions(1024, WithTimer(timer), WithOnExpired(func(key []byte) {
+		expiredKeys = append(expiredKeys, string(key))
+	}))
+	cache.Set([]byte("get"), []byte("value"), 1)
+	cache.Set([]byte("touch"), []byte("value"), 1)
+	cache.Set([]byte("janitor"), []byte("value"), 1)
+	cache.Set([]byte("deleted"), []byte("value"), 1)
+	cache.Set([]byte("persistent"), []byte("value"), 0)
+	cache.Del([]byte("deleted"))
+	timer.nowMs = 101000
+	cache.Get([]byte("get"))
+	cache.Touch([]byte("touch"), 1)
+	cache.DeleteExpired()
+	expected := []string{"get", "touch", "janitor"}
+	if fmt.Sprint(expiredKeys) != fmt.Sprint(expected) {
+		t.Fatalf("expected expired keys %v, got %v", expected, expiredKeys)
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	key := []byte("abcd")
+	if NewCache(1024).hashSeed == 0 {
+		t.Fatal("default hash seed should be random")
+	}
+	unseeded := NewCacheWithOptions(1024, WithHashSeed(0))
+	if unseeded.hash(key) != hashFunc(key) {
+		t.Fatal("seed 0 should hash without seed")
+	}
+	seeded1 := NewCacheWithOptions(1024, WithHashSeed(1))
+	seeded2 := NewCacheWithOptions(1024, WithHashSeed(2))
+	if seeded1.hash(key) == seeded2.hash(key) || seeded1.hash(key) == hashFunc(key) {
+		t.Fatal("different seeds should give different hash values")
+	}
+	if seeded1.hash(key) != NewCacheWithOptions(1024, WithHashSeed(1)).hash(key) {
+		t.Fatal("a fixed seed should give the same hash values")
+	}
+	seeded1.Set(key, []byte("efgh"), 0)
+	if value, err := seeded1.Get(key); err != nil || string(value) != "efgh" {
+		t.Fatalf("expected efgh, got %s, err %v", value, err)
+	}
+}
+
+func TestHashCollision(t *testing.T) {
+	cache := NewCache(1024)
+	seg := &cache.segments[0]
+	// both keys have the same segment, slot and hash16, and the same length.
+	var hashVal uint64 = 0x12345600
+	key1, key2 := []byte("abcd"), []byte("efgh")
+	if err := seg.set(key1, []byte("value1"), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := seg.get(key2, nil, hashVal, false); err != ErrNotFound {
+		t.Fatalf("colliding key expected ErrNotFound, got %v", err)
+	}
+	if err := seg.set(key2, []byte("value2"), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	value1, _, err := seg.get(key1, nil, hashVal, false)
+	if err != nil || string(value1) != "value1" {
+		t.Fatalf("expected value1, got %s, err %v", value1, err)
+	}
+	value2, _, err := seg.get(key2, nil, hashVal, false)
+	if err != nil || string(value2) != "value2" {
+		t.Fatalf("expected value2, got %s, err %v", value2, err)
+	}
+	if !seg.del(key1, hashVal) {
+		t.Fatal("del should return affected true")
+	}
+	if value2, _, err = seg.get(key2, nil, hashVal, false); err != nil || string(value2) != "value2" {
+		t.Fatalf("expected value2 after deleting colliding key, got %s, err %v", value2, err)
+	}
+}
+
+func TestStringKey(t *testing.T) {
+	cache := NewCache(1024)
+	if err := cache.SetString("abcd", []byte("efgh"), 0); err != nil {
+		t.Fatal(err)
+	}
+	value, err := cache.GetString("abcd")
+	if err != nil || string(value) != "efgh" {
+		t.Fatalf("expected efgh, got %s, err %v", value, err)
+	}
+	if value, err = cache.Get([]byte("abcd")); err != nil || string(value) != "efgh" {
+		t.Fatalf("string keys should be compatible with byte keys, got %s, err %v", value, err)
+	}
+	if !cache.DelString("abcd") {
+		t.Fatal("del should return affected true")
+	}
+	if _, err = cache.GetString("abcd"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	key := strings.Repeat("k", 100)
+	if allocs := testing.AllocsPerRun(100, func() { cache.DelString(key) }); allocs > 0 {
+		t.Fatalf("string key should not be converted, got %v allocs", allocs)
+	}
+}
+
+func TestUint64AndInt2Key(t *testing.T) {
+	cache := NewCache(1024)
+	cache.SetUint64(math.MaxUint64, []byte("max"), 0)
+	value, err := cache.GetUint64(math.MaxUint64)
+	if err != nil || string(value) != "max" {
+		t.Fatalf("expected max, got %s, err %v", value, err)
+	}
+	if value, err = cache.GetInt(-1); err != nil || string(value) != "max" {
+		t.Fatalf("uint64 keys should be compatible with int keys, got %s, err %v", value, err)
+	}
+	if !cache.DelUint64(math.MaxUint64) {
+		t.Fatal("del should return affected true")
+	}
+
+	cache.SetInt2(1, 2, []byte("12"), 0)
+	cache.SetInt2(2, 1, []byte("21"), 0)
+	if value, err = cache.GetInt2(1, 2); err != nil || string(value) != "12" {
+		t.Fatalf("expected 12, got %s, err %v", value, err)
+	}
+	if value, err = cache.GetInt2(2, 1); err != nil || string(value) != "21" {
+		t.Fatalf("expected 21, got %s, err %v", value, err)
+	}
+	if !cache.DelInt2(1, 2) {
+		t.Fatal("del should return affected true")
+	}
+	if _, err = cache.GetInt2(1, 2); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { cache.DelInt2(3, 4) }); allocs > 0 {
+		t.Fatalf("composite key should not allocate, got %v allocs", allocs)
+	}
+}
+
+func TestGetOrCompute(t *testing.T) {
+	cache := NewCache(1024)
+	key := []byte("abcd")
+	var loads int32
+	release := make(chan struct{})
+	loader := func() ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return []byte("efgh"), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrCompute(key, 0, loader)
+			if err != nil || string(value) != "efgh" {
+				t.Errorf("expected efgh, got %s, err %v", value, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("expected 1 load, got %d", loads)
+	}
+	if value, err := cache.Get(key); err != nil || string(value) != "efgh" {
+		t.Fatalf("computed value should be stored, got %s, err %v", value, err)
+	}
+
+	loadErr := errors.New("load failed")
+	_, err := cache.GetOrCompute([]byte("fail"), 0, func() ([]byte, error) {
+		return nil, loadErr
+	})
+	if err != loadErr {
+		t.Fatalf("expected load error, got %v", err)
+	}
+	if _, err = cache.Get([]byte("fail")); err != ErrNotFound {
+		t.Fatalf("load error should not be cached, got %v", err)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	var loads int32
+	release := make(chan struct{})
+	loaded := make(chan struct{}, 1)
+	cache := NewCacheWithOptions(1024, WithTimer(timer), WithRefreshAhead(0.2, func(key []byte) ([]byte, int, error) {
+		<-release
+		atomic.AddInt32(&loads, 1)
+		defer func() { loaded <- struct{}{} }()
+		return []byte("new"), 100, nil
+	}))
+	key := []byte("abcd")
+	cache.Set(key, []byte("old"), 100)
+	atomic.StoreInt64(&timer.nowMs, 179000)
+	if value, _ := cache.Get(key); string(value) != "old" {
+		t.Fatalf("expected old, got %s", value)
+	}
+	if atomic.LoadInt32(&loads) != 0 {
+		t.Fatal("entry should not be refreshed before the refresh window")
+	}
+	atomic.StoreInt64(&timer.nowMs, 181000)
+	for i := 0; i < 5; i++ {
+		if value, _ := cache.Get(key); string(value) != "old" {
+			t.Fatalf("expected old while reloading, got %s", value)
+		}
+	}
+	close(release)
+	<-loaded
+	deadline := time.Now().Add(time.Second)
+	for {
+		value, _ := cache.Peek(key)
+		if string(value) == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry should be refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 100 {
+		t.Fatalf("expected refreshed ttl 100, got %d", ttl)
+	}
+	if loads != 1 {
+		t.Fatalf("expected 1 load, got %d", loads)
+	}
+}
+
+func TestRefreshAheadClose(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := NewCacheWithOptions(1024, WithTimer(timer), WithRefreshAhead(0.2, func(key []byte) ([]byte, int, error) {
+		close(started)
+		<-release
+		return []byte("new"), 100, nil
+	}))
+	key := []byte("abcd")
+	cache.Set(key, []byte("old"), 100)
+	atomic.StoreInt64(&timer.nowMs, 181000)
+	cache.Get(key)
+	<-started
+	closed := make(chan struct{})
+	go func() {
+		cache.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the reload")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+}
+
+func TestSetNotFound(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheCustomTimer(1024, timer)
+	key := []byte("abcd")
+	if err := cache.SetNotFound(key, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(key); err != ErrNegativeCached {
+		t.Fatalf("expected ErrNegativeCached, got %v", err)
+	}
+	if _, err := cache.Peek(key); err != ErrNegativeCached {
+		t.Fatalf("expected ErrNegativeCached, got %v", err)
+	}
+	if cache.Has(key) {
+		t.Fatal("negative cached key should not exist")
+	}
+	_, err := cache.GetOrCompute(key, 0, func() ([]byte, error) {
+		t.Fatal("loader should not be called for negative cached key")
+		return nil, nil
+	})
+	if err != ErrNegativeCached {
+		t.Fatalf("expected ErrNegativeCached, got %v", err)
+	}
+	if it := cache.NewIterator(); it.Next() != nil {
+		t.Fatal("iterator should skip negative cached entries")
+	}
+	if cache.EntryCount() != 1 || cache.HitCount() != 2 {
+		t.Fatalf("expected 1 entry and 2 hits, got %d and %d", cache.EntryCount(), cache.HitCount())
+	}
+
+	cache.Set(key, []byte("efgh"), 0)
+	if value, err := cache.Get(key); err != nil || string(value) != "efgh" {
+		t.Fatalf("Set should overwrite negative entry, got %s, err %v", value, err)
+	}
+
+	cache.SetNotFound(key, 10)
+	atomic.StoreInt64(&timer.nowMs, 110000)
+	if _, err := cache.Get(key); err != ErrExpired {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	loadErr := errors.New("load failed")
+	cache := NewCacheWithOptions(1024, WithLoader(LoaderFunc(func(key []byte) ([]byte, int, error) {
+		atomic.AddInt32(&loads, 1)
+		if string(key) == "fail" {
+			return nil, 0, loadErr
+		}
+		<-release
+		return append([]byte("loaded "), key...), 100, nil
+	})))
+	key := []byte("abcd")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.Get(key)
+			if err != nil || string(value) != "loaded abcd" {
+				t.Errorf("expected loaded abcd, got %s, err %v", value, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("expected 1 load, got %d", loads)
+	}
+	if ttl, err := cache.TTL(key); err != nil || ttl != 100 {
+		t.Fatalf("loaded value should be stored with ttl 100, got %d, err %v", ttl, err)
+	}
+	if _, err := cache.Get([]byte("fail")); err != loadErr {
+		t.Fatalf("expected load error, got %v", err)
+	}
+	cache.SetNotFound([]byte("missing"), 0)
+	if _, err := cache.Get([]byte("missing")); err != ErrNegativeCached {
+		t.Fatalf("expected ErrNegativeCached, got %v", err)
+	}
+	if loads != 2 {
+		t.Fatalf("expected 2 loads, got %d", loads)
+	}
+	cache.Close()
+	if _, err := cache.Get([]byte("closed")); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if loads != 2 {
+		t.Fatalf("closed cache loaded, %d loads", loads)
+	}
+}
+
+func TestLoaderPanic(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := NewCacheWithOptions(1024, WithLoader(LoaderFunc(func(key []byte) ([]byte, int, error) {
+		close(started)
+		<-release
+		panic("load failed")
+	})))
+	key := []byte("abcd")
+	panicked := make(chan interface{})
+	go func() {
+		defer func() {
+			panicked <- recover()
+		}()
+		cache.Get(key)
+	}()
+	<-started
+	waited := make(chan error)
+	go func() {
+		_, err := cache.Get(key)
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if r := <-panicked; r != "load failed" {
+		t.Fatalf("recovered %v", r)
+	}
+	if err := <-waited; err != ErrLoaderPanicked {
+		t.Fatalf("waiting Get err = %v, want ErrLoaderPanicked", err)
+	}
+	if len(cache.flights.calls) != 0 {
+		t.Fatal("flight of the panicked load not removed")
+	}
+}
+
+func TestOnEvicted(t *testing.T) {
+	evicted := make(map[string]int)
+	// the clock doesn't move, so the entries are evicted with all their ttl left.
+	cache := NewCacheWithOptions(512*1024, WithClock(clocktest.New(time.Now())), WithOnEvicted(func(key, value []byte, expireSeconds int) {
+		if string(value) != "val"+string(key) {
+			t.Fatalf("evicted value %s doesn't match key %s", value, key)
+		}
+		evicted[string(key)] = expireSeconds
+	}))
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("%d", i)
+		cache.Set([]byte(key), []byte("val"+key), 1000)
+	}
+	if len(evicted) == 0 || int64(len(evicted))+cache.EntryCount() != 20000 {
+		t.Fatalf("expected evicted callback for every evicted entry, got %d", len(evicted))
+	}
+	for key, expireSeconds := range evicted {
+		if expireSeconds != 1000 {
+			t.Fatalf("expected expireSeconds 1000 for %s, got %d", key, expireSeconds)
+		}
+		if cache.Has([]byte(key)) {
+			t.Fatalf("evicted key %s should not be in the cache", key)
+		}
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	var evicted int64
+	cache := NewCacheWithOptions(1024*1024, WithMaxEntries(2560), WithOnEvicted(func(key, value []byte, expireSeconds int) {
+		evicted++
+	}))
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("v"), 0)
+		if cache.EntryCount() > 2560 {
+			t.Fatalf("entry count = %d after %d sets", cache.EntryCount(), i+1)
+		}
+	}
+	if evicted == 0 || cache.EntryCount()+evicted != 10000 {
+		t.Fatalf("entry count = %d, evicted = %d", cache.EntryCount(), evicted)
+	}
+	if v, err := cache.Get([]byte("key9999")); err != nil || string(v) != "v" {
+		t.Fatalf("key9999 = %q, %v", v, err)
+	}
+	// overwriting an entry doesn't evict.
+	evicted = 0
+	cache.Set([]byte("key9999"), []byte("value"), 0)
+	if evicted != 0 {
+		t.Fatalf("evicted = %d on overwrite", evicted)
+	}
+}
+
+func TestSetWithCost(t *testing.T) {
+	cache := NewCacheWithOptions(1024*1024, WithMaxCost(10*segmentCount))
+	key := []byte("key")
+	cache.SetWithCost(key, []byte("value"), 3, 0)
+	if cache.TotalCost() != 3 {
+		t.Fatalf("total cost = %d, want 3", cache.TotalCost())
+	}
+	cache.SetWithCost(key, []byte("v"), 5, 0)
+	if cache.TotalCost() != 5 {
+		t.Fatalf("total cost = %d, want 5", cache.TotalCost())
+	}
+	cache.Set(key, []byte("value"), 0)
+	if v, err := cache.Get(key); err != nil || string(v) != "value" || cache.TotalCost() != 1 {
+		t.Fatalf("key = %q, %v, total cost = %d", v, err, cache.TotalCost())
+	}
+	cache.Del(key)
+	if cache.TotalCost() != 0 {
+		t.Fatalf("total cost = %d, want 0", cache.TotalCost())
+	}
+	if err := cache.SetWithCost(key, []byte("value"), 11, 0); err != ErrLargeCost {
+		t.Fatalf("err = %v, want ErrLargeCost", err)
+	}
+
+	// the expensive entry outlives the cheap ones of its segment.
+	expensive := []byte("expensive")
+	segID := cache.hash(expensive) & segmentAndOpVal
+	cache.SetWithCost(expensive, []byte("value"), 5, 0)
+	var cheap int
+	for i := 0; cheap < 50; i++ {
+		k := []byte(fmt.Sprintf("cheap%d", i))
+		if cache.hash(k)&segmentAndOpVal != segID {
+			continue
+		}
+		cheap++
+		cache.Set(k, []byte("value"), 0)
+		if cost := cache.segments[segID].totalCost; cost > 10 {
+			t.Fatalf("segment cost = %d after %d sets", cost, cheap)
+		}
+	}
+	if v, err := cache.Get(expensive); err != nil || string(v) != "value" {
+		t.Fatalf("expensive = %q, %v", v, err)
+	}
+	if cache.EntryCount() != 6 {
+		t.Fatalf("entry count = %d, want 6", cache.EntryCount())
+	}
+	cache.Resize(2 * 1024 * 1024)
+	if cache.TotalCost() != 10 || cache.EntryCount() != 6 {
+		t.Fatalf("total cost = %d, entry count = %d after resize", cache.TotalCost(), cache.EntryCount())
+	}
+}
+
+func TestPin(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	key := []byte("config")
+	if err := cache.Pin(key); err != ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	cache.Set(key, []byte("value"), 0)
+	if err := cache.Pin(key); err != nil {
+		t.Fatal(err)
+	}
+	fill := func(prefix string) {
+		for i := 0; i < 20000; i++ {
+			cache.Set([]byte(fmt.Sprintf("%s%d", prefix, i)), make([]byte, 100), 0)
+		}
+	}
+	fill("a")
+	if v, err := cache.Get(key); err != nil || string(v) != "value" {
+		t.Fatalf("pinned = %q, %v", v, err)
+	}
+	// it stays pinned when it is set again, in place or not.
+	cache.Set(key, []byte("new value"), 0)
+	fill("b")
+	if v, err := cache.Get(key); err != nil || string(v) != "new value" {
+		t.Fatalf("pinned = %q, %v", v, err)
+	}
+	// the value capacity is doubled to 10 for the new value.
+	if cache.MemoryUsage().PinnedBytes != ENTRY_HDR_SIZE+int64(len(key))+10 {
+		t.Fatalf("pinned bytes = %d", cache.MemoryUsage().PinnedBytes)
+	}
+	if err := cache.Unpin(key); err != nil {
+		t.Fatal(err)
+	}
+	fill("c")
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if cache.MemoryUsage().PinnedBytes != 0 {
+		t.Fatalf("pinned bytes = %d", cache.MemoryUsage().PinnedBytes)
+	}
+
+	// the pinned entries of a segment are limited by the budget.
+	cache = NewCacheWithOptions(512*1024, WithMaxPinnedBytes(1000*segmentCount))
+	segID := cache.hash(key) & segmentAndOpVal
+	var err error
+	for i := 0; err == nil; i++ {
+		k := []byte(fmt.Sprintf("key%d", i))
+		if cache.hash(k)&segmentAndOpVal != segID {
+			continue
+		}
+		cache.Set(k, make([]byte, 100), 0)
+		err = cache.Pin(k)
+	}
+	if err != ErrPinnedBudget || cache.segments[segID].pinnedLen > 1000 {
+		t.Fatalf("err = %v, pinned bytes = %d", err, cache.segments[segID].pinnedLen)
+	}
+}
+
+func TestFilteredIterator(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("val%d", i)), i%2*100)
+	}
+	it := cache.NewFilteredIterator(func(key []byte, expireAt uint32) bool {
+		return expireAt != 0
+	})
+	var n int
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		i, _ := strconv.Atoi(string(entry.Key))
+		if i%2 != 1 || string(entry.Value) != "val"+string(entry.Key) {
+			t.Fatalf("unexpected entry %s %s", entry.Key, entry.Value)
+		}
+		n++
+	}
+	if n != 50 {
+		t.Fatalf("iterated %d entries, want 50", n)
+	}
+}
+
+func TestIteratorCursor(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	count := 1000
+	for i := 0; i < count; i++ {
+		cache.Set([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("val%d", i)), 0)
+	}
+	seen := make(map[string]bool)
+	var cursor uint64
+	for batches := 0; ; batches++ {
+		it := cache.NewIteratorAt(cursor)
+		var entry *Entry
+		for i := 0; i < 100; i++ {
+			if entry = it.Next(); entry == nil {
+				break
+			}
+			if seen[string(entry.Key)] {
+				t.Fatalf("entry %s returned twice", entry.Key)
+			}
+			seen[string(entry.Key)] = true
+		}
+		cursor = it.Cursor()
+		if entry == nil {
+			break
+		}
+		if batches > count {
+			t.Fatal("iteration doesn't end")
+		}
+	}
+	if len(seen) != count {
+		t.Fatalf("iterated %d entries, want %d", len(seen), count)
+	}
+	if cache.NewIteratorAt(cursor).Next() != nil {
+		t.Fatal("the cursor of a finished iterator should return no entry")
+	}
+}
+
+func TestSegmentIterator(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	count := 1000
+	for i := 0; i < count; i++ {
+		cache.Set([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("val%d", i)), 0)
+	}
+	var n int64
+	var wg sync.WaitGroup
+	for i := 0; i < 256; i++ {
+		wg.Add(1)
+		go func(segID int) {
+			defer wg.Done()
+			it := cache.SegmentIterator(segID)
+			for entry := it.Next(); entry != nil; entry = it.Next() {
+				if string(entry.Value) != "val"+string(entry.Key) {
+					t.Errorf("entry key value not match %s %s", entry.Key, entry.Value)
+				}
+				atomic.AddInt64(&n, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n != int64(count) {
+		t.Fatalf("iterated %d entries, want %d", n, count)
+	}
+	if cache.SegmentIterator(256).Next() != nil {
+		t.Fatal("invalid segment should have no entry")
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	cache := NewCacheWithOptions(1024*1024, WithChecksums(true))
+	cache.Set([]byte("a"), []byte("value"), 0)
+	cache.Set([]byte("a"), []byte("val"), 0)
+	cache.Append([]byte("a"), []byte("ue"))
+	cache.Prepend([]byte("a"), []byte("new "))
+	cache.SetWithCost([]byte("cost"), []byte("v"), 10, 0)
+	cache.Incr([]byte("n"), 2, 0)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	if err := cache.Resize(2 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"a": "new value", "cost": "v", "n": "2", "key999": "value999"} {
+		if v, err := cache.Get([]byte(key)); err != nil || string(v) != want {
+			t.Fatalf("%s = %q, %v", key, v, err)
+		}
+	}
+
+	key := []byte("key500")
+	hashVal := cache.hash(key)
+	seg := &cache.segments[hashVal&segmentAndOpVal]
+	hdr, off, _ := seg.locate(key, hashVal, true)
+	seg.rb.WriteAt([]byte("V"), off+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+	if _, err := cache.Get(key); err != ErrCorrupted {
+		t.Fatalf("Get err = %v", err)
+	}
+	if _, err := cache.Peek(key); err != ErrCorrupted {
+		t.Fatalf("Peek err = %v", err)
+	}
+	if err := cache.GetFn(key, func([]byte) error { return nil }); err != ErrCorrupted {
+		t.Fatalf("GetFn err = %v", err)
+	}
+	if _, err := cache.GetIntoBuf(key, make([]byte, 16)); err != ErrCorrupted {
+		t.Fatalf("GetIntoBuf err = %v", err)
+	}
+	if _, err := cache.AcquireView(key); err != ErrCorrupted {
+		t.Fatalf("AcquireView err = %v", err)
+	}
+	cache.Set(key, []byte("value500"), 0)
+	if v, err := cache.Get(key); err != nil || string(v) != "value500" {
+		t.Fatalf("%s after set = %q, %v", key, v, err)
+	}
+}
+
+func TestSegmentSizes(t *testing.T) {
+	sizes := make([]int, segmentCount)
+	for i := range sizes {
+		sizes[i] = 4096
+	}
+	sizes[7] = 1024 * 1024
+	sizes[8] = 100
+	cache := NewCacheWithOptions(0, WithSegmentSizes(sizes), WithHashSeed(0))
+	if usage := cache.MemoryUsage(); usage.BufferBytes != 254*4096+1024*1024+2048 || usage.Segments[7].BufferBytes != 1024*1024 {
+		t.Fatalf("memory usage = %+v", usage.SegmentMemStats)
+	}
+
+	value := make([]byte, 100*1024)
+	var large, small []byte
+	for i := 0; large == nil || small == nil; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if cache.hash(key)&segmentAndOpVal == 7 {
+			large = key
+		} else {
+			small = key
+		}
+	}
+	if err := cache.Set(large, value, 0); err != nil {
+		t.Fatalf("large value in the large segment: %v", err)
+	}
+	if err := cache.Set(small, value, 0); err != ErrLargeEntry {
+		t.Fatalf("large value in a small segment err = %v", err)
+	}
+
+	cache = NewCacheWithOptions(1024*1024, WithSegmentSizes(sizes[:10]))
+	if usage := cache.MemoryUsage(); usage.BufferBytes != 1024*1024 {
+		t.Fatalf("buffer bytes with invalid sizes = %d", usage.BufferBytes)
+	}
+}
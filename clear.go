@@ -0,0 +1,48 @@
+package freecache
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// ClearAsync clears the cache without waiting, the entries set before it are invalidated at once and the
+// segments are cleared one at a time in the background, or by their next operation, whichever comes first.
+// The entries set after ClearAsync are kept. The statistics of a segment, e.g. EntryCount, are reset when
+// it is cleared, so they include the invalidated entries until then. It does nothing once the cache is closed.
+func (cache *Cache) ClearAsync() {
+	if cache.isClosed() {
+		return
+	}
+	atomic.AddUint64(&cache.clearGen, 1)
+	cache.goBackground(func() {
+		cache.sweepClear(true)
+	})
+}
+
+// sweepClear clears the segments behind the generation of ClearAsync, locking one segment at a time,
+// yielding between the segments if yield is set. It stops when the cache is closed.
+func (cache *Cache) sweepClear(yield bool) {
+	for i := range cache.segments {
+		if cache.isClosed() {
+			return
+		}
+		cache.locks[i].Lock()
+		cache.segments[i].applyClear()
+		cache.locks[i].Unlock()
+		if yield {
+			runtime.Gosched()
+		}
+	}
+}
+
+// applyClear clears the segment if it is behind the generation of ClearAsync. It is called at the start of the
+// operations of the segment, so they don't see the entries set before the last ClearAsync.
+func (seg *segment) applyClear() {
+	if seg.clearGen == nil {
+		return
+	}
+	if gen := atomic.LoadUint64(seg.clearGen); gen != seg.gen {
+		seg.clear()
+		seg.gen = gen
+	}
+}
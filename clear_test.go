@@ -0,0 +1,73 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClearAsync(t *testing.T) {
+	cache := NewCacheWithOptions(1024 * 1024)
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte("value"), 0)
+	}
+	cache.ClearAsync()
+	cache.Set([]byte("new"), []byte("value"), 0)
+	for i := 0; i < 1000; i++ {
+		if _, err := cache.Get([]byte(fmt.Sprint(i))); err != ErrNotFound {
+			t.Fatalf("Get of %d after ClearAsync err = %v", i, err)
+		}
+	}
+	for it := cache.NewIterator(); ; {
+		entry := it.Next()
+		if entry == nil {
+			break
+		}
+		if string(entry.Key) != "new" {
+			t.Fatalf("iterator returned %q after ClearAsync", entry.Key)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for cache.EntryCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("EntryCount = %d after the sweep", cache.EntryCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, err := cache.Get([]byte("new")); err != nil || string(v) != "value" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if errs := cache.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestClearAsyncClose(t *testing.T) {
+	cache := NewCacheWithOptions(1024 * 1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			cache.ClearAsync()
+		}
+	}()
+	cache.Close()
+	<-done
+	if cache.goBackground(func() {}) {
+		t.Fatal("background goroutine started after Close")
+	}
+}
+
+func TestClearAllocs(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte("value"), 0)
+	}
+	if n := testing.AllocsPerRun(10, cache.Clear); n != 0 {
+		t.Fatalf("allocs = %v, want 0", n)
+	}
+	if n := cache.EntryCount(); n != 0 {
+		t.Fatalf("EntryCount = %d", n)
+	}
+}
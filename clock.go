@@ -0,0 +1,88 @@
+package freecache
+
+import (
+	"time"
+)
+
+// Clock is a source of time with nanosecond resolution, see WithClock. It replaces Timer, whose
+// uint32 seconds can't express sub-second expirations.
+type Clock interface {
+	// NowNano returns the current unix time in nanoseconds. The expirations and access times of the
+	// entries are measured from it, and stored in the entries as unix times. The default, NewWallClock,
+	// follows the changes of the wall clock, NewMonotonicClock doesn't.
+	NowNano() int64
+}
+
+// monotonicClock counts the time elapsed since start with the monotonic clock of the process,
+// so it doesn't jump when the wall clock is set.
+type monotonicClock struct {
+	start time.Time
+}
+
+// NewMonotonicClock returns a Clock of the unix time when it is created, advanced by the monotonic clock of
+// the process, so the expirations are not affected by changes of the wall clock afterwards. The times it
+// returns drift from the wall clock when it is set, e.g. by NTP, so the expirations returned by the cache,
+// e.g. by GetWithExpiration, and the times passed to it, e.g. by DelAt, drift from the unix times too.
+func NewMonotonicClock() Clock {
+	return monotonicClock{start: time.Now()}
+}
+
+func (c monotonicClock) NowNano() int64 {
+	return c.start.UnixNano() + int64(time.Since(c.start))
+}
+
+// timerMilli returns the time of timer in milliseconds at the time t of the wall clock, corrected for the drift
+// of the clocks from the wall clock, e.g. NewMonotonicClock, so a time passed to the cache, e.g. by DelAt, is
+// compared with the expirations of the entries.
+func timerMilli(timer MilliTimer, t time.Time) int64 {
+	if timer, ok := timer.(clockTimer); ok {
+		if clock, ok := timer.clock.(monotonicClock); ok {
+			return (t.UnixNano() + clock.NowNano() - time.Now().UnixNano()) / int64(time.Millisecond)
+		}
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// wallClock reads the unix time of the wall clock.
+type wallClock struct{}
+
+// NewWallClock returns the default Clock of the cache, reading the unix time of the wall clock, so the
+// expirations returned by the cache are unix times, but jump with the wall clock when it is set.
+func NewWallClock() Clock {
+	return wallClock{}
+}
+
+func (wallClock) NowNano() int64 {
+	return time.Now().UnixNano()
+}
+
+// clockTimer adapts a Clock to the MilliTimer used by the segments. The entries store their expiration
+// with millisecond resolution and their access time with second resolution.
+type clockTimer struct {
+	clock Clock
+}
+
+func (timer clockTimer) Now() uint32 {
+	return uint32(timer.clock.NowNano() / int64(time.Second))
+}
+
+func (timer clockTimer) NowMilli() int64 {
+	return timer.clock.NowNano() / int64(time.Millisecond)
+}
+
+// timerClock adapts a Timer to Clock, with the resolution of the Timer.
+type timerClock struct {
+	timer MilliTimer
+}
+
+// TimerClock returns a Clock reading timer, e.g. to pass an existing Timer where a Clock is expected.
+func TimerClock(timer Timer) Clock {
+	if timer, ok := timer.(clockTimer); ok {
+		return timer.clock
+	}
+	return timerClock{toMilliTimer(timer)}
+}
+
+func (c timerClock) NowNano() int64 {
+	return c.timer.NowMilli() * int64(time.Millisecond)
+}
@@ -0,0 +1,82 @@
+package freecache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	nowNano int64
+}
+
+func (c *fakeClock) NowNano() int64 {
+	return atomic.LoadInt64(&c.nowNano)
+}
+
+func TestWithClock(t *testing.T) {
+	clock := &fakeClock{nowNano: int64(100 * time.Second)}
+	// the clock takes precedence over the timer.
+	cache := NewCacheWithOptions(1024, WithTimer(&mockTimer{nowCallback: func() uint32 { return 0 }}), WithClock(clock))
+	cache.SetWithDuration([]byte("a"), []byte("v"), 1500*time.Millisecond)
+	cache.Set([]byte("b"), []byte("v"), 2)
+	if _, expireAt, _ := cache.GetWithExpiration([]byte("b")); expireAt != 102 {
+		t.Fatalf("expireAt = %d", expireAt)
+	}
+	atomic.AddInt64(&clock.nowNano, int64(1499*time.Millisecond))
+	if _, err := cache.Get([]byte("a")); err != nil {
+		t.Fatalf("a expired early: %v", err)
+	}
+	atomic.AddInt64(&clock.nowNano, int64(time.Millisecond))
+	if _, err := cache.Get([]byte("a")); err != ErrExpired {
+		t.Fatalf("a err = %v", err)
+	}
+	if _, err := cache.Get([]byte("b")); err != nil {
+		t.Fatalf("b expired early: %v", err)
+	}
+	atomic.AddInt64(&clock.nowNano, int64(500*time.Millisecond))
+	if _, err := cache.Get([]byte("b")); err != ErrExpired {
+		t.Fatalf("b err = %v", err)
+	}
+}
+
+func TestTimerClock(t *testing.T) {
+	now := uint32(1000)
+	clock := TimerClock(&mockTimer{nowCallback: func() uint32 { return now }})
+	if clock.NowNano() != int64(1000*time.Second) {
+		t.Fatalf("NowNano = %d", clock.NowNano())
+	}
+	milliClock := TimerClock(&mockMilliTimer{nowMs: 1500})
+	if milliClock.NowNano() != int64(1500*time.Millisecond) {
+		t.Fatalf("NowNano of MilliTimer = %d", milliClock.NowNano())
+	}
+	fake := &fakeClock{nowNano: 42}
+	if TimerClock(clockTimer{fake}) != Clock(fake) {
+		t.Fatal("TimerClock doesn't unwrap the clock of a clockTimer")
+	}
+}
+
+func TestMonotonicClock(t *testing.T) {
+	clock := NewMonotonicClock()
+	first := clock.NowNano()
+	if d := time.Duration(first - time.Now().UnixNano()); d < -time.Second || d > time.Second {
+		t.Fatalf("monotonic clock is %v from the wall clock", d)
+	}
+	time.Sleep(time.Millisecond)
+	if second := clock.NowNano(); second <= first {
+		t.Fatalf("monotonic clock went from %d to %d", first, second)
+	}
+}
+
+func TestWallClock(t *testing.T) {
+	if d := time.Duration(NewWallClock().NowNano() - time.Now().UnixNano()); d < -time.Second || d > 0 {
+		t.Fatalf("wall clock is %v from time.Now", d)
+	}
+	// the expirations of the default timer are unix times.
+	cache := NewCache(1024)
+	cache.Set([]byte("a"), []byte("v"), 100)
+	_, expireAt, _ := cache.GetWithExpiration([]byte("a"))
+	if d := int64(expireAt) - time.Now().Unix(); d < 99 || d > 100 {
+		t.Fatalf("expireAt is %d seconds from now", d)
+	}
+}
@@ -0,0 +1,43 @@
+// Package clocktest provides a fake freecache.Clock whose time only moves when told to, so the expiration
+// and eviction behavior of a cache can be tested deterministically and without sleeping:
+//
+//	clock := clocktest.New(time.Unix(1000, 0))
+//	cache := freecache.NewCacheWithOptions(size, freecache.WithClock(clock))
+//	cache.Set(key, value, 1)
+//	clock.Advance(time.Second) // key is expired now.
+package clocktest
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock is a freecache.Clock set by the test, it is safe for concurrent use.
+type Clock struct {
+	nowNano int64
+}
+
+// New returns a clock at now.
+func New(now time.Time) *Clock {
+	return &Clock{nowNano: now.UnixNano()}
+}
+
+// NowNano returns the time of the clock in unix nanoseconds.
+func (c *Clock) NowNano() int64 {
+	return atomic.LoadInt64(&c.nowNano)
+}
+
+// Now returns the time of the clock.
+func (c *Clock) Now() time.Time {
+	return time.Unix(0, c.NowNano())
+}
+
+// Advance moves the clock forward by d, or backward if d is negative.
+func (c *Clock) Advance(d time.Duration) {
+	atomic.AddInt64(&c.nowNano, int64(d))
+}
+
+// Set sets the time of the clock to now.
+func (c *Clock) Set(now time.Time) {
+	atomic.StoreInt64(&c.nowNano, now.UnixNano())
+}
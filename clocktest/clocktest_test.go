@@ -0,0 +1,65 @@
+package clocktest_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/coocood/freecache/clocktest"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := clocktest.New(start)
+	if !clock.Now().Equal(start) || clock.NowNano() != start.UnixNano() {
+		t.Fatalf("Now = %v", clock.Now())
+	}
+	clock.Advance(1500 * time.Millisecond)
+	if !clock.Now().Equal(start.Add(1500 * time.Millisecond)) {
+		t.Fatalf("Now after Advance = %v", clock.Now())
+	}
+	clock.Set(start)
+	if !clock.Now().Equal(start) {
+		t.Fatalf("Now after Set = %v", clock.Now())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clock.Advance(time.Second)
+		}()
+	}
+	wg.Wait()
+	if !clock.Now().Equal(start.Add(10 * time.Second)) {
+		t.Fatalf("Now after concurrent Advance = %v", clock.Now())
+	}
+}
+
+func TestClockExpiration(t *testing.T) {
+	clock := clocktest.New(time.Unix(1000, 0))
+	cache := freecache.NewCacheWithOptions(1024*1024, freecache.WithClock(clock))
+	cache.Set([]byte("a"), []byte("v"), 1)
+	cache.SetWithDuration([]byte("b"), []byte("v"), 100*time.Millisecond)
+
+	clock.Advance(99 * time.Millisecond)
+	if !cache.Has([]byte("b")) {
+		t.Fatal("b expired early")
+	}
+	clock.Advance(time.Millisecond)
+	if cache.Has([]byte("b")) {
+		t.Fatal("b should be expired")
+	}
+	if !cache.Has([]byte("a")) {
+		t.Fatal("a expired early")
+	}
+	clock.Advance(900 * time.Millisecond)
+	if _, err := cache.Get([]byte("a")); err != freecache.ErrExpired {
+		t.Fatalf("a err = %v", err)
+	}
+	if n := cache.DeleteExpired(); n != 1 {
+		t.Fatalf("DeleteExpired = %d", n)
+	}
+}
@@ -0,0 +1,140 @@
+package freecache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var ErrUnsupportedType = errors.New("The value type is not supported by the codec")
+
+// Codec marshals the values stored by SetObject and unmarshals the values read by GetObject.
+type Codec interface {
+	// Marshal appends the encoding of v to buf and returns the extended buffer.
+	Marshal(buf []byte, v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v, data is only valid during the call.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes values with encoding/json, it is the default codec of the cache.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(buf []byte, v interface{}) ([]byte, error) {
+	w := bytes.NewBuffer(buf)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return buf, err
+	}
+	// drop the newline appended by the encoder.
+	return w.Bytes()[:w.Len()-1], nil
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes values with encoding/gob. Every value is encoded with its type information,
+// so values can be decoded independently.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(buf []byte, v interface{}) ([]byte, error) {
+	w := bytes.NewBuffer(buf)
+	if err := gob.NewEncoder(w).Encode(v); err != nil {
+		return buf, err
+	}
+	return w.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec encodes protocol buffers messages generated with Marshal and Unmarshal methods,
+// like the ones of gogo/protobuf, without depending on a protobuf library.
+type ProtoCodec struct{}
+
+type protoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+func (ProtoCodec) Marshal(buf []byte, v interface{}) ([]byte, error) {
+	msg, ok := v.(protoMessage)
+	if !ok {
+		return buf, ErrUnsupportedType
+	}
+	data, err := msg.Marshal()
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, data...), nil
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(protoMessage)
+	if !ok {
+		return ErrUnsupportedType
+	}
+	return msg.Unmarshal(data)
+}
+
+// MsgpCodec encodes MessagePack values generated by tinylib/msgp, which append
+// directly to the pooled buffer, without depending on a msgpack library.
+type MsgpCodec struct{}
+
+type msgpMarshaler interface {
+	MarshalMsg(buf []byte) ([]byte, error)
+}
+
+type msgpUnmarshaler interface {
+	UnmarshalMsg(data []byte) ([]byte, error)
+}
+
+func (MsgpCodec) Marshal(buf []byte, v interface{}) ([]byte, error) {
+	msg, ok := v.(msgpMarshaler)
+	if !ok {
+		return buf, ErrUnsupportedType
+	}
+	return msg.MarshalMsg(buf)
+}
+
+func (MsgpCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(msgpUnmarshaler)
+	if !ok {
+		return ErrUnsupportedType
+	}
+	_, err := msg.UnmarshalMsg(data)
+	return err
+}
+
+var objectBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
+// SetObject marshals v with the codec of the cache and stores it, see Set.
+// The encoding buffer is pooled, so the value is only copied once into the cache.
+func (cache *Cache) SetObject(key []byte, v interface{}, expireSeconds int) (err error) {
+	bufPtr := objectBufPool.Get().(*[]byte)
+	buf, err := cache.codec.Marshal((*bufPtr)[:0], v)
+	if err == nil {
+		err = cache.Set(key, buf, expireSeconds)
+	}
+	// don't keep the memory of large values alive in the pool.
+	if cap(buf) <= 64*1024 {
+		*bufPtr = buf
+		objectBufPool.Put(bufPtr)
+	}
+	return
+}
+
+// GetObject unmarshals the value of key into v with the codec of the cache,
+// or returns not found error. The value is decoded without copying it out of the cache.
+func (cache *Cache) GetObject(key []byte, v interface{}) (err error) {
+	return cache.GetFn(key, func(data []byte) error {
+		return cache.codec.Unmarshal(data, v)
+	})
+}
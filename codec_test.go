@@ -0,0 +1,72 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+type codecTestObject struct {
+	Name  string
+	Count int
+}
+
+// Marshal and Unmarshal mimic the methods of generated protobuf messages.
+func (o *codecTestObject) Marshal() ([]byte, error) {
+	buf := make([]byte, 8, 8+len(o.Name))
+	binary.LittleEndian.PutUint64(buf, uint64(o.Count))
+	return append(buf, o.Name...), nil
+}
+
+func (o *codecTestObject) Unmarshal(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("short buffer")
+	}
+	o.Count = int(binary.LittleEndian.Uint64(data))
+	o.Name = string(data[8:])
+	return nil
+}
+
+// MarshalMsg and UnmarshalMsg mimic the methods generated by msgp.
+func (o *codecTestObject) MarshalMsg(buf []byte) ([]byte, error) {
+	data, _ := o.Marshal()
+	return append(buf, data...), nil
+}
+
+func (o *codecTestObject) UnmarshalMsg(data []byte) ([]byte, error) {
+	return nil, o.Unmarshal(data)
+}
+
+func TestObjectCodecs(t *testing.T) {
+	codecs := map[string]Codec{
+		"json":  JSONCodec{},
+		"gob":   GobCodec{},
+		"proto": ProtoCodec{},
+		"msgp":  MsgpCodec{},
+	}
+	for name, codec := range codecs {
+		cache := NewCacheWithOptions(1024, WithCodec(codec))
+		key := []byte("abcd")
+		in := &codecTestObject{Name: "efgh", Count: 3}
+		if err := cache.SetObject(key, in, 0); err != nil {
+			t.Fatalf("%s: SetObject unexpected err %v", name, err)
+		}
+		out := new(codecTestObject)
+		if err := cache.GetObject(key, out); err != nil {
+			t.Fatalf("%s: GetObject unexpected err %v", name, err)
+		}
+		if *out != *in {
+			t.Fatalf("%s: expected %v, got %v", name, in, out)
+		}
+		if err := cache.GetObject([]byte("missing"), out); err != ErrNotFound {
+			t.Fatalf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestObjectCodecUnsupportedType(t *testing.T) {
+	cache := NewCacheWithOptions(1024, WithCodec(ProtoCodec{}))
+	if err := cache.SetObject([]byte("abcd"), "efgh", 0); err != ErrUnsupportedType {
+		t.Fatalf("expected ErrUnsupportedType, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package freecache
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Compact reclaims the space of the deleted entries of the segment segID, 0 to 255, and returns the bytes
+// reclaimed. The space of a deleted entry is only reclaimed when the evacuation of a Set reaches it, so with
+// many deletes the ring buffer holds holes while live entries are evicted. Compact moves the live entries in
+// front of the last hole to the end of the ring buffer, like evacuate does, so the free space is coalesced
+// and the expired entries in front of it are removed. It copies up to the size of the segment with the
+// segment locked. Nothing is moved while an entry of the segment is held by a View.
+func (cache *Cache) Compact(segID int) (reclaimed int64) {
+	if segID < 0 || segID >= segmentCount {
+		return
+	}
+	cache.locks[segID].Lock()
+	reclaimed = cache.segments[segID].compact()
+	cache.locks[segID].Unlock()
+	return
+}
+
+// CompactAll compacts every segment, locking one segment at a time, and returns the bytes reclaimed.
+func (cache *Cache) CompactAll() (reclaimed int64) {
+	for i := range cache.segments {
+		reclaimed += cache.Compact(i)
+	}
+	return
+}
+
+func (seg *segment) compact() (reclaimed int64) {
+	seg.applyClear()
+	if seg.expireViews(seg.timer.NowMilli()); len(seg.views) > 0 {
+		// the viewed entries can't be moved.
+		return
+	}
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	nowMs := seg.timer.NowMilli()
+	// every entry is visited at most once, as the moved entries are behind the ones left.
+	for n := atomic.LoadInt64(&seg.totalCount); n > 0 && atomic.LoadInt64(&seg.deletedLen) > 0; n-- {
+		off := seg.rb.End() + seg.vacuumLen - seg.rb.Size()
+		seg.rb.ReadAt(hdrBuf[:], off)
+		entryLen := seg.entryLen(hdr)
+		if hdr.flags&flagDeleted == 0 {
+			if !isExpired(hdr.expireAtMilli(), nowMs) {
+				newOff := seg.rb.Evacuate(off, int(entryLen))
+				seg.updateEntryPtr(hdr.slotId, hdr.hash16, off, newOff)
+				continue
+			}
+			seg.expire(off, hdr.keyLen)
+			seg.delEntryPtrByOffset(hdr.slotId, hdr.hash16, off)
+		}
+		seg.reclaim(hdr, entryLen)
+		reclaimed += entryLen
+	}
+	return
+}
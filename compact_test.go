@@ -0,0 +1,61 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(512*1024, WithTimer(timer), WithHashSeed(0))
+	for i := 0; i < 4000; i++ {
+		expireSeconds := 0
+		if i%10 == 1 {
+			expireSeconds = 1
+		}
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), expireSeconds)
+	}
+	for i := 0; i < 4000; i += 3 {
+		cache.Del([]byte(fmt.Sprintf("key%d", i)))
+	}
+	timer.nowMs += 2000
+	deleted := cache.Stats().DeletedBytes
+	if deleted == 0 || cache.SegmentStats(3).DeletedBytes == 0 {
+		t.Fatalf("deleted bytes = %d", deleted)
+	}
+	used := cache.MemoryUsage().UsedBytes
+
+	if reclaimed := cache.Compact(3); reclaimed < cache.segments[3].memStats().DeletedBytes {
+		t.Fatalf("reclaimed = %d", reclaimed)
+	}
+	if stats := cache.SegmentStats(3); stats.DeletedBytes != 0 {
+		t.Fatalf("segment 3 deleted bytes after Compact = %d", stats.DeletedBytes)
+	}
+	reclaimed := cache.CompactAll()
+	if reclaimed < deleted-cache.MemoryUsage().Segments[3].DeletedBytes || cache.Stats().DeletedBytes != 0 {
+		t.Fatalf("reclaimed = %d, deleted bytes = %d", reclaimed, cache.Stats().DeletedBytes)
+	}
+	if usage := cache.MemoryUsage(); usage.UsedBytes > used-deleted {
+		t.Fatalf("used bytes = %d, want at most %d", usage.UsedBytes, used-deleted)
+	}
+	if reclaimed := cache.CompactAll(); reclaimed != 0 {
+		t.Fatalf("reclaimed from a compacted cache = %d", reclaimed)
+	}
+	if errs := cache.Validate(); errs != nil {
+		t.Fatal(errs)
+	}
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		value, err := cache.Get([]byte(key))
+		if i%3 == 0 || i%10 == 1 {
+			if err == nil {
+				t.Fatalf("%s is still in the cache", key)
+			}
+		} else if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("%s = %q, %v", key, value, err)
+		}
+	}
+	if reclaimed := cache.Compact(256); reclaimed != 0 {
+		t.Fatalf("reclaimed from segment 256 = %d", reclaimed)
+	}
+}
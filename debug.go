@@ -0,0 +1,120 @@
+package freecache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// debugDumpCells is the number of cells of the occupancy map printed by DebugDump.
+const debugDumpCells = 64
+
+// DebugDump writes a human readable layout of the segment segID, 0 to 255, to w, for debugging the
+// fragmentation and the evictions: the state of the ring buffer, an occupancy map of its bytes, the number of
+// entries of every slot and the holes left by the deleted entries, which are reclaimed when the evacuation
+// pointer, the offset of the oldest entry, reaches them. The segment is locked while it is walked.
+func (cache *Cache) DebugDump(w io.Writer, segID int) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	if segID < 0 || segID >= segmentCount {
+		fmt.Fprintf(bw, "segment %d out of range\n", segID)
+		return
+	}
+	cache.locks[segID].Lock()
+	defer cache.locks[segID].Unlock()
+	cache.segments[segID].debugDump(bw)
+}
+
+func (seg *segment) debugDump(w *bufio.Writer) {
+	if seg.rb.data == nil {
+		fmt.Fprintf(w, "segment %d released by Close\n", seg.segId)
+		return
+	}
+	size := seg.rb.Size()
+	end := seg.rb.End()
+	begin := end + seg.vacuumLen - size
+	fmt.Fprintf(w, "segment %d: size %d, used %d, vacuum %d, entries %d, in ring %d\n",
+		seg.segId, size, size-seg.vacuumLen, seg.vacuumLen, seg.entryCount, seg.totalCount)
+	fmt.Fprintf(w, "ring: begin %d, end %d, evacuation offset %d at index %d, write index %d\n",
+		seg.rb.Begin(), end, begin, seg.rb.getDataOff(begin), seg.rb.index)
+
+	// the bytes of the live and deleted entries in every cell of the ring buffer data.
+	cellSize := (size + debugDumpCells - 1) / debugDumpCells
+	var liveBytes, deletedBytes [debugDumpCells]int64
+	mark := func(off, n int64, cells *[debugDumpCells]int64) {
+		for pos := int64(seg.rb.getDataOff(off)); n > 0; {
+			cellEnd := (pos/cellSize + 1) * cellSize
+			if cellEnd > size {
+				cellEnd = size
+			}
+			k := cellEnd - pos
+			if k > n {
+				k = n
+			}
+			cells[pos/cellSize] += k
+			n -= k
+			if pos += k; pos == size {
+				pos = 0
+			}
+		}
+	}
+	type hole struct{ off, length, entries int64 }
+	var holes []hole
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for off := begin; off < end; {
+		seg.rb.ReadAt(hdrBuf[:], off)
+		entryLen := seg.entryLen(hdr)
+		if off+entryLen > end {
+			fmt.Fprintf(w, "entry at %d of length %d overruns the end\n", off, entryLen)
+			break
+		}
+		if hdr.flags&flagDeleted != 0 {
+			mark(off, entryLen, &deletedBytes)
+			if last := len(holes) - 1; last >= 0 && holes[last].off+holes[last].length == off {
+				holes[last].length += entryLen
+				holes[last].entries++
+			} else {
+				holes = append(holes, hole{off, entryLen, 1})
+			}
+		} else {
+			mark(off, entryLen, &liveBytes)
+		}
+		off += entryLen
+	}
+
+	fmt.Fprintf(w, "occupancy, %d bytes per cell, # live, x deleted, + both, . free, E evacuation, W write:\n", cellSize)
+	var cells, pointers [debugDumpCells]byte
+	for i := range cells {
+		switch {
+		case liveBytes[i] > 0 && deletedBytes[i] > 0:
+			cells[i] = '+'
+		case liveBytes[i] > 0:
+			cells[i] = '#'
+		case deletedBytes[i] > 0:
+			cells[i] = 'x'
+		default:
+			cells[i] = '.'
+		}
+		pointers[i] = ' '
+	}
+	pointers[int64(seg.rb.getDataOff(begin))/cellSize] = 'E'
+	pointers[int64(seg.rb.index)/cellSize] = 'W'
+	fmt.Fprintf(w, "  [%s]\n  [%s]\n", cells[:], pointers[:])
+
+	w.WriteString("entries per slot:\n")
+	for i := range seg.slotLens {
+		if i%16 == 0 {
+			fmt.Fprintf(w, "  %3d:", i)
+		}
+		fmt.Fprintf(w, " %3d", seg.slotLens[i])
+		if i%16 == 15 {
+			w.WriteByte('\n')
+		}
+	}
+	fmt.Fprintf(w, "holes: %d\n", len(holes))
+	for _, h := range holes {
+		fmt.Fprintf(w, "  offset %d, length %d, %d entries\n", h.off, h.length, h.entries)
+	}
+}
@@ -0,0 +1,41 @@
+package freecache
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDebugDump(t *testing.T) {
+	cache := NewCacheWithOptions(512*1024, WithHashSeed(0))
+	for i := 0; i < 5000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	for i := 0; i < 5000; i += 2 {
+		cache.Del([]byte(fmt.Sprintf("key%d", i)))
+	}
+	var buf bytes.Buffer
+	cache.DebugDump(&buf, 7)
+	dump := buf.String()
+	seg := &cache.segments[7]
+	for _, want := range []string{
+		fmt.Sprintf("segment 7: size %d, used %d", seg.rb.Size(), seg.rb.Size()-seg.vacuumLen),
+		fmt.Sprintf("entries %d", seg.entryCount),
+		"E", "W", "entries per slot:\n    0:",
+		"  240:",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Fatalf("dump misses %q", want)
+		}
+	}
+	if strings.Contains(dump, "holes: 0") || !strings.Contains(dump, "  offset ") {
+		t.Fatal("dump misses the deleted entries")
+	}
+
+	buf.Reset()
+	cache.DebugDump(&buf, 256)
+	if buf.String() != "segment 256 out of range\n" {
+		t.Fatalf("out of range dump = %q", buf.String())
+	}
+}
@@ -0,0 +1,34 @@
+package freecache
+
+import (
+	"sync/atomic"
+)
+
+// DefaultMaxEvacuationMoves is the number of recently used entries moved in a row by default, see
+// WithMaxEvacuationMoves.
+const DefaultMaxEvacuationMoves = 5
+
+// EvacuationPolicy selects the entries evicted when a segment is full, see WithEvacuationPolicy.
+type EvacuationPolicy uint8
+
+const (
+	// EvacuateLRU evicts the oldest entry of the ring buffer if it wasn't accessed after the average
+	// access time of the segment, otherwise the entry is moved to the end of the ring buffer, which
+	// approximates LRU.
+	EvacuateLRU EvacuationPolicy = iota
+	// EvacuateFIFO evicts the oldest entry of the ring buffer whatever its access time, so no entry is
+	// copied, which suits large values and uniform access. Expirations, pins, costs and
+	// WithRecentAccessProtection still apply.
+	EvacuateFIFO
+)
+
+// leastRecentUsed reports whether the entry of hdr can be evicted according to its access time.
+func (seg *segment) leastRecentUsed(hdr *entryHdr, nowMs int64) bool {
+	if seg.recentAccess > 0 && int64(hdr.accessTime)+seg.recentAccess > nowMs/1000 {
+		return false
+	}
+	if seg.evacuation == EvacuateFIFO {
+		return true
+	}
+	return int64(hdr.accessTime)*atomic.LoadInt64(&seg.totalCount) <= atomic.LoadInt64(&seg.totalTime)
+}
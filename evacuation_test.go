@@ -0,0 +1,58 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// evacuationRun sets 1000 keys, reads the even ones 2 minutes later, then sets 1000 new keys, about half of
+// the cache, and returns the statistics with the numbers of even and odd keys left.
+func evacuationRun(opts ...Option) (stats Stats, even, odd int) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(512*1024, append([]Option{WithTimer(timer), WithHashSeed(0)}, opts...)...)
+	value := make([]byte, 200)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), value, 0)
+	}
+	timer.nowMs += 120000
+	for i := 0; i < 1000; i += 2 {
+		cache.Get([]byte(fmt.Sprintf("key%d", i)))
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("new%d", i)), value, 0)
+	}
+	for i := 0; i < 1000; i++ {
+		if cache.Has([]byte(fmt.Sprintf("key%d", i))) {
+			if i%2 == 0 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	return cache.Stats(), even, odd
+}
+
+func TestEvacuationPolicy(t *testing.T) {
+	stats, even, odd := evacuationRun()
+	if stats.EvacuateCount == stats.EvictedCount || even <= odd {
+		t.Fatalf("LRU: stats %+v, %d even and %d odd keys left", stats, even, odd)
+	}
+
+	stats, even, odd = evacuationRun(WithEvacuationPolicy(EvacuateFIFO))
+	if stats.EvacuateCount != stats.EvictedCount || stats.EvictedCount == 0 {
+		t.Fatalf("FIFO: stats %+v, %d even and %d odd keys left", stats, even, odd)
+	}
+
+	stats, even, odd = evacuationRun(WithEvacuationPolicy(EvacuateFIFO), WithRecentAccessProtection(time.Minute))
+	if stats.EvacuateCount == stats.EvictedCount || even <= odd {
+		t.Fatalf("FIFO with protection: stats %+v, %d even and %d odd keys left", stats, even, odd)
+	}
+
+	_, fewMoves, _ := evacuationRun(WithMaxEvacuationMoves(1))
+	_, manyMoves, _ := evacuationRun(WithMaxEvacuationMoves(100))
+	if fewMoves >= manyMoves {
+		t.Fatalf("even keys left with 1 move: %d, with 100 moves: %d", fewMoves, manyMoves)
+	}
+}
@@ -0,0 +1,185 @@
+package freecache
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// EventType is the type of an Event.
+type EventType uint8
+
+const (
+	// EventSet is sent when an entry is set.
+	EventSet EventType = iota + 1
+	// EventDel is sent when an entry is deleted by Del.
+	EventDel
+	// EventExpire is sent when an expired entry is removed.
+	EventExpire
+	// EventEvict is sent when an entry is evicted to make room before it expires.
+	EventEvict
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDel:
+		return "del"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// Event is a change of an entry of the cache, see Events.
+type Event struct {
+	Type EventType
+	Key  []byte
+	// Value is the new value of an EventSet, nil for the other types.
+	Value []byte
+}
+
+type subscriber struct {
+	ch     chan Event
+	prefix []byte
+	exact  bool // only the key equal to prefix matches.
+}
+
+func (sub *subscriber) match(key []byte) bool {
+	if sub.exact {
+		return bytes.Equal(key, sub.prefix)
+	}
+	return bytes.HasPrefix(key, sub.prefix)
+}
+
+// eventHub sends the events of the segments of a cache to the subscribers.
+type eventHub struct {
+	mu      sync.RWMutex
+	subs    []*subscriber
+	active  int32 // number of subscribers, read without mu so no event is built without subscriber.
+	dropped int64
+}
+
+func (h *eventHub) enabled() bool {
+	return h != nil && atomic.LoadInt32(&h.active) > 0
+}
+
+// emit sends an event to the subscribers whose prefix matches key without blocking, the event is dropped
+// for the subscribers whose channel is full. key and value are copied once for all the subscribers.
+func (h *eventHub) emit(typ EventType, key, value []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var ev Event
+	built := false
+	for _, sub := range h.subs {
+		if !sub.match(key) {
+			continue
+		}
+		if !built {
+			ev = Event{Type: typ, Key: append([]byte(nil), key...)}
+			if typ == EventSet {
+				ev.Value = append([]byte{}, value...)
+			}
+			built = true
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			atomic.AddInt64(&h.dropped, 1)
+		}
+	}
+}
+
+func (h *eventHub) subscribe(prefix []byte, exact bool, buffer int) chan Event {
+	sub := &subscriber{ch: make(chan Event, buffer), prefix: append([]byte(nil), prefix...), exact: exact}
+	h.mu.Lock()
+	h.subs = append(h.subs, sub)
+	atomic.StoreInt32(&h.active, int32(len(h.subs)))
+	h.mu.Unlock()
+	return sub.ch
+}
+
+// unsubscribe removes the subscriber of ch and closes ch, it does nothing if ch isn't subscribed.
+func (h *eventHub) unsubscribe(ch <-chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, sub := range h.subs {
+		if sub.ch == ch {
+			close(sub.ch)
+			h.subs = append(h.subs[:i], h.subs[i+1:]...)
+			atomic.StoreInt32(&h.active, int32(len(h.subs)))
+			return
+		}
+	}
+}
+
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, sub := range h.subs {
+		close(sub.ch)
+	}
+	h.subs = nil
+	atomic.StoreInt32(&h.active, 0)
+}
+
+// Events returns a channel receiving the changes of the entries of the cache: sets, deletes by Del,
+// expirations and evictions. Clear doesn't send events for the entries it removes. The channel has a
+// buffer of buffer events, the events are dropped while it is full, see DroppedEvents. The events are
+// sent with the segment lock held, so a slow receiver doesn't slow the cache down, but the key and value
+// are copied for every event. The channel is closed by StopEvents or Close.
+func (cache *Cache) Events(buffer int) <-chan Event {
+	return cache.EventsWithPrefix(nil, buffer)
+}
+
+// EventsWithPrefix is like Events, but only receives the events of the keys starting with prefix.
+func (cache *Cache) EventsWithPrefix(prefix []byte, buffer int) <-chan Event {
+	return cache.events.subscribe(prefix, false, buffer)
+}
+
+// StopEvents stops sending events to a channel returned by Events and closes it.
+func (cache *Cache) StopEvents(ch <-chan Event) {
+	cache.events.unsubscribe(ch)
+}
+
+// DroppedEvents returns the number of events dropped because the channel of Events was full.
+func (cache *Cache) DroppedEvents() int64 {
+	return atomic.LoadInt64(&cache.events.dropped)
+}
+
+// watchBuffer is the number of events buffered for the callback of Watch.
+const watchBuffer = 16
+
+// Watch calls fn in a goroutine when the entry of key is set, deleted by Del, expires or is evicted, with
+// the type of the event and the new value for EventSet, nil for the others. Up to 16 events are buffered
+// while fn runs, the following ones are dropped. fn may call the cache. The returned cancel stops the
+// watch, fn may still be called for an event received before. The watch is stopped by Close too.
+func (cache *Cache) Watch(key []byte, fn func(EventType, []byte)) (cancel func()) {
+	ch := cache.events.subscribe(key, true, watchBuffer)
+	stop := make(chan struct{})
+	cache.goBackground(func() {
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				fn(ev.Type, ev.Value)
+			case <-stop:
+				return
+			case <-cache.done:
+				return
+			}
+		}
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			cache.events.unsubscribe(ch)
+		})
+	}
+}
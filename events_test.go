@@ -0,0 +1,116 @@
+package freecache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 1000000}
+	cache := NewCacheWithOptions(512*1024, WithTimer(timer))
+	all := cache.Events(10)
+	users := cache.EventsWithPrefix([]byte("user:"), 10)
+	cache.Set([]byte("user:1"), []byte("a"), 0)
+	cache.Set([]byte("other"), []byte("b"), 1)
+	cache.Del([]byte("user:1"))
+	cache.Del([]byte("user:1"))
+	timer.nowMs += 2000
+	cache.Get([]byte("other"))
+
+	want := []Event{
+		{Type: EventSet, Key: []byte("user:1"), Value: []byte("a")},
+		{Type: EventSet, Key: []byte("other"), Value: []byte("b")},
+		{Type: EventDel, Key: []byte("user:1")},
+		{Type: EventExpire, Key: []byte("other")},
+	}
+	for _, w := range want {
+		ev := <-all
+		if ev.Type != w.Type || string(ev.Key) != string(w.Key) || string(ev.Value) != string(w.Value) {
+			t.Fatalf("event = %v %s %s, want %v %s %s", ev.Type, ev.Key, ev.Value, w.Type, w.Key, w.Value)
+		}
+	}
+	if len(all) != 0 {
+		t.Fatalf("%d more events", len(all))
+	}
+	if ev := <-users; ev.Type != EventSet || string(ev.Key) != "user:1" {
+		t.Fatalf("event = %v %s", ev.Type, ev.Key)
+	}
+	if ev := <-users; ev.Type != EventDel || string(ev.Key) != "user:1" || len(users) != 0 {
+		t.Fatalf("event = %v %s", ev.Type, ev.Key)
+	}
+
+	// the events are dropped while the channel is full.
+	cache.StopEvents(users)
+	if _, ok := <-users; ok {
+		t.Fatal("channel should be closed")
+	}
+	value := make([]byte, 200)
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(strconv.Itoa(i)), value, 0)
+	}
+	if len(all) != 10 || cache.DroppedEvents() == 0 {
+		t.Fatalf("%d events, %d dropped", len(all), cache.DroppedEvents())
+	}
+	evictions := cache.Events(20000)
+	for i := 10000; i < 20000; i++ {
+		cache.Set([]byte(strconv.Itoa(i)), value, 0)
+	}
+	var evicted int
+	for len(evictions) > 0 {
+		if ev := <-evictions; ev.Type == EventEvict {
+			evicted++
+		}
+	}
+	if evicted == 0 {
+		t.Fatal("no eviction event")
+	}
+	cache.Close()
+	if _, ok := <-all; !ok {
+		t.Fatal("buffered events should be received after Close")
+	}
+	for range all {
+	}
+}
+
+func TestEventsAllocs(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	key, value := []byte("key"), []byte("value")
+	if allocs := testing.AllocsPerRun(100, func() { cache.Set(key, value, 0) }); allocs > 0 {
+		t.Fatalf("Set allocs = %v without subscriber", allocs)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	type call struct {
+		typ   EventType
+		value string
+	}
+	calls := make(chan call, 10)
+	cancel := cache.Watch([]byte("config"), func(typ EventType, value []byte) {
+		calls <- call{typ, string(value)}
+		// fn may call the cache.
+		cache.Get([]byte("config"))
+	})
+	cache.Set([]byte("config"), []byte("v1"), 0)
+	cache.Set([]byte("config2"), []byte("v1"), 0)
+	cache.Set([]byte("config"), []byte("v2"), 0)
+	cache.Del([]byte("config"))
+	for _, want := range []call{{EventSet, "v1"}, {EventSet, "v2"}, {EventDel, ""}} {
+		if c := <-calls; c != want {
+			t.Fatalf("call = %v, want %v", c, want)
+		}
+	}
+	cancel()
+	cancel()
+	cache.Set([]byte("config"), []byte("v3"), 0)
+	cache.Close()
+	if len(calls) != 0 {
+		t.Fatalf("fn called after cancel: %v", <-calls)
+	}
+
+	cache = NewCache(512 * 1024)
+	cache.Watch([]byte("config"), func(EventType, []byte) {})
+	// Close stops the watch.
+	cache.Close()
+}
@@ -0,0 +1,63 @@
+package freecache
+
+import (
+	"time"
+	"unsafe"
+)
+
+// ExpiringWithin returns the number of entries expiring within d from now, so a reload storm of the keys
+// expiring together can be predicted. The entries already expired aren't counted. It reads the headers of
+// all the entries, locking one segment at a time.
+func (cache *Cache) ExpiringWithin(d time.Duration) (count int64) {
+	withinMs := d.Milliseconds()
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		expiring, _ := cache.segments[i].expirations(nil, withinMs)
+		cache.locks[i].Unlock()
+		count += expiring
+	}
+	return
+}
+
+// TTLHistogram returns the distribution of the seconds left before the entries expire, rounded up like TTL,
+// and the number of entries without expiration, which aren't in the histogram. The entries already expired
+// aren't counted. Like ExpiringWithin, it reads the headers of all the entries.
+func (cache *Cache) TTLHistogram() (hist Histogram, persistent int64) {
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		_, n := cache.segments[i].expirations(&hist, -1)
+		cache.locks[i].Unlock()
+		persistent += n
+	}
+	return
+}
+
+// expirations returns the number of entries expiring within withinMs and the number of entries without
+// expiration, and adds the seconds left of the entries with expiration to hist if it isn't nil.
+func (seg *segment) expirations(hist *Histogram, withinMs int64) (expiring, persistent int64) {
+	seg.applyClear()
+	nowMs := seg.timer.NowMilli()
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for slotId := 0; slotId < 256; slotId++ {
+		for _, ptr := range seg.getSlot(uint8(slotId)) {
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			expireAtMs := hdr.expireAtMilli()
+			if expireAtMs == 0 {
+				persistent++
+				continue
+			}
+			left := expireAtMs - nowMs
+			if left <= 0 {
+				continue
+			}
+			if left <= withinMs {
+				expiring++
+			}
+			if hist != nil {
+				hist.add(uint32((left + 999) / 1000))
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExpiringWithin(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("a%d", i)), []byte("v"), 10)
+	}
+	for i := 0; i < 50; i++ {
+		cache.Set([]byte(fmt.Sprintf("b%d", i)), []byte("v"), 100)
+	}
+	for i := 0; i < 20; i++ {
+		cache.Set([]byte(fmt.Sprintf("c%d", i)), []byte("v"), 0)
+	}
+	cache.SetWithDuration([]byte("d"), []byte("v"), 1500*time.Millisecond)
+
+	for _, c := range []struct {
+		d    time.Duration
+		want int64
+	}{
+		{time.Second, 0},
+		{2 * time.Second, 1},
+		{10 * time.Second, 101},
+		{time.Hour, 151},
+	} {
+		if n := cache.ExpiringWithin(c.d); n != c.want {
+			t.Fatalf("ExpiringWithin(%v) = %d, want %d", c.d, n, c.want)
+		}
+	}
+
+	hist, persistent := cache.TTLHistogram()
+	if persistent != 20 || hist.Total() != 151 {
+		t.Fatalf("persistent = %d, total = %d", persistent, hist.Total())
+	}
+	// 2 seconds left, 10 seconds in [8, 15] and 100 seconds in [64, 127].
+	if hist.Counts[2] != 1 || hist.Counts[4] != 100 || hist.Counts[7] != 50 {
+		t.Fatalf("histogram = %v", hist.Counts)
+	}
+
+	timer.nowMs += 20000
+	if n := cache.ExpiringWithin(time.Hour); n != 50 {
+		t.Fatalf("ExpiringWithin counts the expired entries: %d", n)
+	}
+	if hist, _ = cache.TTLHistogram(); hist.Total() != 50 || hist.Counts[7] != 50 {
+		t.Fatalf("histogram after expiration = %v", hist.Counts)
+	}
+}
@@ -0,0 +1,73 @@
+package freecache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLoaderPanicked is returned to the callers waiting for the load of a key if the loader panicked.
+var ErrLoaderPanicked = errors.New("The loader panicked")
+
+// Loader loads the values of missing keys from a backing store, see WithLoader.
+type Loader interface {
+	// Load returns the value of key and the expireSeconds it should be stored with.
+	Load(key []byte) (value []byte, expireSeconds int, err error)
+}
+
+// LoaderFunc is an adapter to use an ordinary function as a Loader.
+type LoaderFunc func(key []byte) (value []byte, expireSeconds int, err error)
+
+// Load calls f(key).
+func (f LoaderFunc) Load(key []byte) ([]byte, int, error) {
+	return f(key)
+}
+
+// flightCall is an in-flight or completed load of a key.
+type flightCall struct {
+	wg    sync.WaitGroup
+	value []byte
+	err   error
+	dups  int
+}
+
+// flightGroup deduplicates concurrent loads of the same key, so only one caller runs the loader
+// and the others wait for its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do runs fn once for all concurrent callers with the same key. shared reports whether the
+// value was given to more than one caller, in that case it must not be modified. If fn panics,
+// the panic is propagated to the caller running it and the waiting callers get ErrLoaderPanicked.
+func (g *flightGroup) do(key []byte, fn func() ([]byte, error)) (value []byte, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[string(key)]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err, true
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[string(key)] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			c.err = ErrLoaderPanicked
+		}
+		g.mu.Lock()
+		delete(g.calls, string(key))
+		shared = c.dups > 0
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.value, c.err = fn()
+	returned = true
+	return c.value, c.err, false
+}
@@ -1,5 +1,5 @@
 module github.com/coocood/freecache
 
-go 1.13
+go 1.18
 
 require github.com/cespare/xxhash/v2 v2.1.2
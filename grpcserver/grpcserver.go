@@ -0,0 +1,146 @@
+// Package grpcserver exposes a freecache.Cache as the gRPC service Cache defined in freecachepb/freecache.proto:
+// Get, Set, Del, MGet, TTL and Stats, and Export, which streams the entries of the cache, e.g. to warm up
+// another cache.
+//
+//	s := grpc.NewServer()
+//	grpcserver.Register(s, cache)
+//	s.Serve(l)
+package grpcserver
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative freecachepb/freecache.proto
+
+import (
+	"context"
+	"errors"
+
+	"github.com/coocood/freecache"
+	pb "github.com/coocood/freecache/grpcserver/freecachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements freecachepb.CacheServer on a cache.
+type Server struct {
+	pb.UnimplementedCacheServer
+	cache *freecache.Cache
+}
+
+// New returns a server of cache.
+func New(cache *freecache.Cache) *Server {
+	return &Server{cache: cache}
+}
+
+// Register registers a server of cache with s.
+func Register(s grpc.ServiceRegistrar, cache *freecache.Cache) {
+	pb.RegisterCacheServer(s, New(cache))
+}
+
+// Get returns the value of the key, Found is false if the key is missing.
+func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	return s.get(req.Key)
+}
+
+func (s *Server) get(key []byte) (*pb.GetResponse, error) {
+	value, err := s.cache.Get(key)
+	if err == freecache.ErrNotFound {
+		return &pb.GetResponse{}, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.GetResponse{Value: value, Found: true}, nil
+}
+
+// Set sets the value of the key, ExpireSeconds <= 0 means no expire.
+func (s *Server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
+	if err := s.cache.Set(req.Key, req.Value, int(req.ExpireSeconds)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.SetResponse{}, nil
+}
+
+// Del deletes the key, Deleted is false if the key is missing.
+func (s *Server) Del(ctx context.Context, req *pb.DelRequest) (*pb.DelResponse, error) {
+	return &pb.DelResponse{Deleted: s.cache.Del(req.Key)}, nil
+}
+
+// MGet returns the values of the keys in the order of the keys.
+func (s *Server) MGet(ctx context.Context, req *pb.MGetRequest) (*pb.MGetResponse, error) {
+	resp := &pb.MGetResponse{Values: make([]*pb.GetResponse, len(req.Keys))}
+	for i, key := range req.Keys {
+		value, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+		resp.Values[i] = value
+	}
+	return resp, nil
+}
+
+// TTL returns the seconds left before the key expires, 0 if it doesn't expire.
+func (s *Server) TTL(ctx context.Context, req *pb.TTLRequest) (*pb.TTLResponse, error) {
+	ttl, err := s.cache.TTL(req.Key)
+	if err == freecache.ErrNotFound {
+		return &pb.TTLResponse{}, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.TTLResponse{TtlSeconds: ttl, Found: true}, nil
+}
+
+// Stats returns the statistics of the cache.
+func (s *Server) Stats(ctx context.Context, req *pb.StatsRequest) (*pb.StatsResponse, error) {
+	stats := s.cache.Stats()
+	return &pb.StatsResponse{
+		HitCount:       stats.HitCount,
+		MissCount:      stats.MissCount,
+		LookupCount:    stats.LookupCount,
+		EntryCount:     stats.EntryCount,
+		EvacuateCount:  stats.EvacuateCount,
+		ExpiredCount:   stats.ExpiredCount,
+		OverwriteCount: stats.OverwriteCount,
+		TouchedCount:   stats.TouchedCount,
+		HitRate:        stats.HitRate(),
+	}, nil
+}
+
+// Export streams the entries whose keys start with Prefix, with the seconds left before they expire.
+// It stops when the stream fails or its context is done. Entries that expire or are deleted while
+// the cache is exported are skipped.
+func (s *Server) Export(req *pb.ExportRequest, stream pb.Cache_ExportServer) error {
+	ctx := stream.Context()
+	var err error
+	s.cache.Scan(req.Prefix, func(key, value []byte) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		ttl, ttlErr := s.cache.TTL(key)
+		if ttlErr != nil {
+			return true
+		}
+		err = stream.Send(&pb.Entry{Key: key, Value: value, TtlSeconds: ttl})
+		return err == nil
+	})
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// toStatus converts an error of the cache to a gRPC status error.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, freecache.ErrLargeKey), errors.Is(err, freecache.ErrLargeEntry):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,130 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/coocood/freecache"
+	pb "github.com/coocood/freecache/grpcserver/freecachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T, cache *freecache.Cache) pb.CacheClient {
+	l := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	Register(s, cache)
+	go s.Serve(l)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Stop()
+	})
+	return pb.NewCacheClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	client := newTestClient(t, cache)
+	ctx := context.Background()
+
+	if _, err := client.Set(ctx, &pb.SetRequest{Key: []byte("a"), Value: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Set(ctx, &pb.SetRequest{Key: []byte("b"), Value: []byte("2"), ExpireSeconds: 100}); err != nil {
+		t.Fatal(err)
+	}
+	get, err := client.Get(ctx, &pb.GetRequest{Key: []byte("a")})
+	if err != nil || !get.Found || string(get.Value) != "1" {
+		t.Fatalf("Get a = %v, %v", get, err)
+	}
+	get, err = client.Get(ctx, &pb.GetRequest{Key: []byte("c")})
+	if err != nil || get.Found {
+		t.Fatalf("Get c = %v, %v", get, err)
+	}
+	mget, err := client.MGet(ctx, &pb.MGetRequest{Keys: [][]byte{[]byte("b"), []byte("c"), []byte("a")}})
+	if err != nil || len(mget.Values) != 3 {
+		t.Fatalf("MGet = %v, %v", mget, err)
+	}
+	if !mget.Values[0].Found || string(mget.Values[0].Value) != "2" || mget.Values[1].Found || string(mget.Values[2].Value) != "1" {
+		t.Fatalf("MGet = %v", mget.Values)
+	}
+	ttl, err := client.TTL(ctx, &pb.TTLRequest{Key: []byte("b")})
+	if err != nil || !ttl.Found || ttl.TtlSeconds == 0 || ttl.TtlSeconds > 100 {
+		t.Fatalf("TTL b = %v, %v", ttl, err)
+	}
+	ttl, err = client.TTL(ctx, &pb.TTLRequest{Key: []byte("a")})
+	if err != nil || !ttl.Found || ttl.TtlSeconds != 0 {
+		t.Fatalf("TTL a = %v, %v", ttl, err)
+	}
+	del, err := client.Del(ctx, &pb.DelRequest{Key: []byte("a")})
+	if err != nil || !del.Deleted {
+		t.Fatalf("Del a = %v, %v", del, err)
+	}
+	del, err = client.Del(ctx, &pb.DelRequest{Key: []byte("a")})
+	if err != nil || del.Deleted {
+		t.Fatalf("Del a again = %v, %v", del, err)
+	}
+	stats, err := client.Stats(ctx, &pb.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.EntryCount != 1 || stats.HitCount != 3 || stats.MissCount != 2 {
+		t.Fatalf("Stats = %v", stats)
+	}
+}
+
+func TestServerSetError(t *testing.T) {
+	client := newTestClient(t, freecache.NewCache(1024*1024))
+	_, err := client.Set(context.Background(), &pb.SetRequest{Key: make([]byte, 70000), Value: []byte("v")})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Set large key err = %v", err)
+	}
+}
+
+func TestServerExport(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("user:%d", i)), []byte(fmt.Sprint(i)), 100)
+		cache.Set([]byte(fmt.Sprintf("item:%d", i)), []byte(fmt.Sprint(i)), 0)
+	}
+	client := newTestClient(t, cache)
+	stream, err := client.Export(context.Background(), &pb.ExportRequest{Prefix: []byte("user:")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := freecache.NewCache(1024 * 1024)
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.TtlSeconds == 0 || entry.TtlSeconds > 100 {
+			t.Fatalf("entry %s ttl = %d", entry.Key, entry.TtlSeconds)
+		}
+		dst.Set(entry.Key, entry.Value, int(entry.TtlSeconds))
+	}
+	if dst.EntryCount() != 100 {
+		t.Fatalf("exported %d entries", dst.EntryCount())
+	}
+	for i := 0; i < 100; i++ {
+		value, err := dst.Get([]byte(fmt.Sprintf("user:%d", i)))
+		if err != nil || string(value) != fmt.Sprint(i) {
+			t.Fatalf("user:%d = %s, %v", i, value, err)
+		}
+	}
+}
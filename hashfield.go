@@ -0,0 +1,138 @@
+package freecache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrNotHash = errors.New("The value is not a hash of fields")
+
+// The value of a hash set by HSet is a sequence of fields, each the length of its name and the length of its
+// value as uvarints, followed by the name and the value.
+
+// findField returns the position of the field name in data and its value, or the end of data if it isn't found.
+// It returns ErrNotHash if the fields before it are malformed.
+func findField(data, name []byte) (start, end int, value []byte, found bool, err error) {
+	for off := 0; off < len(data); {
+		nameLen, n := binary.Uvarint(data[off:])
+		if n <= 0 {
+			return 0, 0, nil, false, ErrNotHash
+		}
+		valLen, m := binary.Uvarint(data[off+n:])
+		if m <= 0 {
+			return 0, 0, nil, false, ErrNotHash
+		}
+		nameOff := off + n + m
+		if nameLen > uint64(len(data)-nameOff) || valLen > uint64(len(data)-nameOff)-nameLen {
+			return 0, 0, nil, false, ErrNotHash
+		}
+		valOff := nameOff + int(nameLen)
+		next := valOff + int(valLen)
+		if bytes.Equal(data[nameOff:valOff], name) {
+			return off, next, data[valOff:next], true, nil
+		}
+		off = next
+	}
+	return len(data), len(data), nil, false, nil
+}
+
+// appendField appends the field name with value to data.
+func appendField(data, name, value []byte) []byte {
+	var lenBuf [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(name)))
+	n += binary.PutUvarint(lenBuf[n:], uint64(len(value)))
+	data = append(data, lenBuf[:n]...)
+	data = append(data, name...)
+	return append(data, value...)
+}
+
+// HSet sets the field of the hash stored as the value of key, like the HSET command of Redis, in one locked
+// operation that doesn't decode the other fields. A missing key is set to a hash of the field without
+// expiration, an existing key keeps its expiration. Returns ErrNotHash if the value of key isn't a hash.
+// The hash is limited like the values of Set.
+func (cache *Cache) HSet(key, field, value []byte) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	err = cache.segments[segID].hset(key, hashVal, field, value, false)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// HGet returns a copy of the value of the field of the hash stored as the value of key, see HSet.
+// Returns ErrNotFound if the key or the field doesn't exist, and ErrNotHash if the value isn't a hash.
+func (cache *Cache) HGet(key, field []byte) (value []byte, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	defer cache.locks[segID].Unlock()
+	data, _, err := cache.segments[segID].viewEntry(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	_, _, fieldValue, found, err := findField(data, field)
+	if err != nil {
+		return
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, fieldValue...), nil
+}
+
+// HDel deletes the field of the hash stored as the value of key, see HSet, and the key with its last field.
+// It returns whether the field was deleted, and ErrNotHash if the value isn't a hash.
+func (cache *Cache) HDel(key, field []byte) (affected bool, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	err = cache.segments[segID].hset(key, hashVal, field, nil, true)
+	cache.locks[segID].Unlock()
+	if err == nil {
+		affected = true
+	} else if err == ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+// hset sets the field of the hash of key to value, or deletes it if del is set, in which case ErrNotFound is
+// returned if the field doesn't exist. Like extend, the entry is re-inserted with the same expiration.
+func (seg *segment) hset(key []byte, hashVal uint64, field, value []byte, del bool) (err error) {
+	hdr, ptrOffset, err := seg.locate(key, hashVal, false)
+	if err == ErrNotFound || err == ErrExpired || err == ErrNegativeCached {
+		if del {
+			return ErrNotFound
+		}
+		return seg.set(key, appendField(nil, field, value), hashVal, 0)
+	}
+	if err != nil {
+		return
+	}
+	data, err := seg.rb.Slice(ptrOffset+ENTRY_HDR_SIZE+int64(hdr.keyLen), int64(hdr.valLen))
+	if err != nil {
+		return
+	}
+	if err = seg.verifyChecksum(&hdr, ptrOffset, key, data); err != nil {
+		return
+	}
+	start, end, _, found, err := findField(data, field)
+	if err != nil {
+		return
+	}
+	if del && !found {
+		return ErrNotFound
+	}
+	if del && start == 0 && end == len(data) {
+		seg.del(key, hashVal)
+		return nil
+	}
+	newData := make([]byte, 0, len(data)-(end-start)+len(field)+len(value)+2*binary.MaxVarintLen64)
+	newData = append(newData, data[:start]...)
+	if !del {
+		newData = appendField(newData, field, value)
+	}
+	newData = append(newData, data[end:]...)
+	return seg.setAt(key, newData, hashVal, seg.timer.NowMilli(), hdr.expireAtMilli(), seg.softExpireAt(&hdr, ptrOffset), hdr.flags&^(1<<nsShift-1), seg.entryCost(&hdr, ptrOffset))
+}
@@ -0,0 +1,76 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithChecksums(true))
+	key := []byte("user:1")
+	if _, err := cache.HGet(key, []byte("name")); err != ErrNotFound {
+		t.Fatalf("HGet of a missing key err = %v", err)
+	}
+	if err := cache.HSet(key, []byte("name"), []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	cache.Touch(key, 10)
+	for i := 0; i < 10; i++ {
+		if err := cache.HSet(key, []byte(fmt.Sprintf("f%d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.HSet(key, []byte("name"), []byte("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.HSet(key, []byte("empty"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.HGet(key, []byte("name")); err != nil || string(v) != "bob" {
+		t.Fatalf("HGet = %q, %v", v, err)
+	}
+	if v, err := cache.HGet(key, []byte("empty")); err != nil || len(v) != 0 {
+		t.Fatalf("HGet of an empty value = %q, %v", v, err)
+	}
+	for i := 0; i < 10; i++ {
+		if v, err := cache.HGet(key, []byte(fmt.Sprintf("f%d", i))); err != nil || string(v) != fmt.Sprintf("v%d", i) {
+			t.Fatalf("HGet(f%d) = %q, %v", i, v, err)
+		}
+	}
+	if _, err := cache.HGet(key, []byte("missing")); err != ErrNotFound {
+		t.Fatalf("HGet of a missing field err = %v", err)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 10 {
+		t.Fatalf("ttl = %d, HSet must keep the expiration", ttl)
+	}
+
+	if affected, err := cache.HDel(key, []byte("f5")); !affected || err != nil {
+		t.Fatalf("HDel = %v, %v", affected, err)
+	}
+	if affected, err := cache.HDel(key, []byte("f5")); affected || err != nil {
+		t.Fatalf("HDel of a deleted field = %v, %v", affected, err)
+	}
+	if _, err := cache.HGet(key, []byte("f5")); err != ErrNotFound {
+		t.Fatalf("HGet of a deleted field err = %v", err)
+	}
+	if v, err := cache.HGet(key, []byte("f6")); err != nil || string(v) != "v6" {
+		t.Fatalf("HGet = %q, %v", v, err)
+	}
+	for _, field := range []string{"name", "empty", "f0", "f1", "f2", "f3", "f4", "f6", "f7", "f8", "f9"} {
+		if affected, err := cache.HDel(key, []byte(field)); !affected || err != nil {
+			t.Fatalf("HDel(%s) = %v, %v", field, affected, err)
+		}
+	}
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Fatalf("the key isn't deleted with its last field: %v", err)
+	}
+
+	cache.Set([]byte("plain"), []byte("not a hash"), 0)
+	if _, err := cache.HGet([]byte("plain"), []byte("f")); err != ErrNotHash {
+		t.Fatalf("HGet of a plain value err = %v", err)
+	}
+	if err := cache.HSet([]byte("plain"), []byte("f"), []byte("v")); err != ErrNotHash {
+		t.Fatalf("HSet of a plain value err = %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package freecache
+
+import (
+	"math/bits"
+)
+
+// Histogram counts samples in power of 2 buckets: Counts[0] is the number of samples of 0 and
+// Counts[i] the number of samples from 2^(i-1) to 2^i-1.
+type Histogram struct {
+	Counts [33]int64
+}
+
+func (h *Histogram) add(v uint32) {
+	h.Counts[bits.Len32(v)]++
+}
+
+func (h *Histogram) merge(other *Histogram) {
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+}
+
+// Total returns the number of samples.
+func (h *Histogram) Total() (total int64) {
+	for _, c := range h.Counts {
+		total += c
+	}
+	return
+}
+
+// Quantile returns the upper bound of the bucket holding the sample at quantile q, from 0 to 1,
+// e.g. Quantile(0.99) is a value the 99th percentile is not larger than.
+func (h *Histogram) Quantile(q float64) int64 {
+	rank := int64(q * float64(h.Total()))
+	var count int64
+	for i, c := range h.Counts {
+		count += c
+		if count > rank {
+			return int64(1)<<i - 1
+		}
+	}
+	return 0
+}
+
+// Histograms are the distributions recorded by WithHistograms.
+type Histograms struct {
+	// ValueSizes are the lengths of the values set in the cache.
+	ValueSizes Histogram
+	// EvictionAges are the seconds since the last access of the entries evicted before they expired.
+	EvictionAges Histogram
+}
+
+// Histograms returns the distributions of value sizes and entry ages recorded by WithHistograms,
+// they are empty if it is not set. ResetStatistics clears them.
+func (cache *Cache) Histograms() (hist Histograms) {
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		if h := cache.segments[i].hist; h != nil {
+			hist.ValueSizes.merge(&h.ValueSizes)
+			hist.EvictionAges.merge(&h.EvictionAges)
+		}
+		cache.locks[i].Unlock()
+	}
+	return
+}
@@ -0,0 +1,36 @@
+package freecache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHistograms(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 1000000}
+	cache := NewCacheWithOptions(512*1024, WithTimer(timer), WithHistograms())
+	value := make([]byte, 300)
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(strconv.Itoa(i)), value[:i%2*100+100], 0)
+		if i == 5000 {
+			timer.nowMs += 10000
+		}
+	}
+	hist := cache.Histograms()
+	if hist.ValueSizes.Total() != 10000 || hist.ValueSizes.Counts[7] != 5000 || hist.ValueSizes.Counts[8] != 5000 {
+		t.Fatalf("value sizes = %v", hist.ValueSizes.Counts)
+	}
+	if hist.ValueSizes.Quantile(0.25) != 127 || hist.ValueSizes.Quantile(0.75) != 255 {
+		t.Fatalf("quantiles = %d %d", hist.ValueSizes.Quantile(0.25), hist.ValueSizes.Quantile(0.75))
+	}
+	evictions := hist.EvictionAges.Total()
+	if evictions == 0 || evictions > cache.EvacuateCount() {
+		t.Fatalf("evictions = %d, evacuate count = %d", evictions, cache.EvacuateCount())
+	}
+	if hist.EvictionAges.Counts[0]+hist.EvictionAges.Counts[4] != evictions || hist.EvictionAges.Counts[4] == 0 {
+		t.Fatalf("eviction ages = %v", hist.EvictionAges.Counts)
+	}
+	cache.ResetStatistics()
+	if hist := cache.Histograms(); hist.ValueSizes.Total() != 0 || hist.EvictionAges.Total() != 0 {
+		t.Fatal("histograms should be empty after reset")
+	}
+}
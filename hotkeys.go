@@ -0,0 +1,97 @@
+package freecache
+
+import (
+	"bytes"
+	"sort"
+)
+
+// HotKey is a key returned by HotKeys with its approximate number of hits.
+type HotKey struct {
+	Key  []byte
+	Hits int64
+}
+
+const hotKeysWidth = 256
+
+// hotKeys tracks the most hit keys of a segment. The hits of all keys are counted by a count-min sketch
+// of 4 rows of hotKeysWidth counters, the topN keys with the highest estimates are kept with their keys,
+// which are only copied when a key enters the top. It is guarded by the segment lock.
+type hotKeys struct {
+	table [4 * hotKeysWidth]uint32
+	top   []hotKey
+	topN  int
+}
+
+type hotKey struct {
+	key    []byte
+	hash32 uint32
+	hits   uint32
+}
+
+func newHotKeys(topN int) *hotKeys {
+	return &hotKeys{topN: topN, top: make([]hotKey, 0, topN)}
+}
+
+// record counts a hit of key and updates the top keys.
+func (h *hotKeys) record(key []byte, hashVal uint64) {
+	hash32 := uint32(hashVal >> 32)
+	hits := ^uint32(0)
+	for row := uint32(0); row < 4; row++ {
+		i := row*hotKeysWidth + (hash32*tinyLFUSeeds[row])>>24
+		if h.table[i] < ^uint32(0) {
+			h.table[i]++
+		}
+		if h.table[i] < hits {
+			hits = h.table[i]
+		}
+	}
+	minIdx := -1
+	for i := range h.top {
+		k := &h.top[i]
+		if k.hash32 == hash32 && bytes.Equal(k.key, key) {
+			k.hits = hits
+			return
+		}
+		if minIdx < 0 || k.hits < h.top[minIdx].hits {
+			minIdx = i
+		}
+	}
+	if len(h.top) < h.topN {
+		h.top = append(h.top, hotKey{key: append([]byte(nil), key...), hash32: hash32, hits: hits})
+	} else if minIdx >= 0 && hits > h.top[minIdx].hits {
+		k := &h.top[minIdx]
+		k.key = append(k.key[:0], key...)
+		k.hash32 = hash32
+		k.hits = hits
+	}
+}
+
+func (h *hotKeys) reset() {
+	h.table = [4 * hotKeysWidth]uint32{}
+	h.top = h.top[:0]
+}
+
+// HotKeys returns the most hit keys tracked by WithHotKeyTracking, up to topN, ordered by the number
+// of hits from the highest. The hits are approximate, they may be overestimated and the hits of a key
+// before it entered the top are included. It returns nil if hot key tracking is not enabled.
+func (cache *Cache) HotKeys() []HotKey {
+	var keys []HotKey
+	topN := 0
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		if hot := cache.segments[i].hot; hot != nil {
+			topN = hot.topN
+			for _, k := range hot.top {
+				keys = append(keys, HotKey{Key: append([]byte(nil), k.key...), Hits: int64(k.hits)})
+			}
+		}
+		cache.locks[i].Unlock()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Hits > keys[j].Hits
+	})
+	if len(keys) > topN {
+		keys = keys[:topN]
+	}
+	return keys
+}
@@ -0,0 +1,50 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHotKeys(t *testing.T) {
+	cache := NewCacheWithOptions(1024*1024, WithHotKeyTracking(3))
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Get([]byte(fmt.Sprintf("key%d", i)))
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 10*(j+1); k++ {
+				cache.Get([]byte(fmt.Sprintf("hot%d", j)))
+			}
+		}
+	}
+	// misses are not hits.
+	for j := 0; j < 3; j++ {
+		cache.Set([]byte(fmt.Sprintf("hot%d", j)), []byte("value"), 0)
+	}
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < j+1; k++ {
+				cache.Get([]byte(fmt.Sprintf("hot%d", j)))
+			}
+		}
+	}
+	keys := cache.HotKeys()
+	if len(keys) != 3 {
+		t.Fatalf("got %d hot keys, want 3", len(keys))
+	}
+	for i, k := range keys {
+		want := fmt.Sprintf("hot%d", 2-i)
+		if string(k.Key) != want || k.Hits < int64(100*(3-i)) {
+			t.Fatalf("hot key %d = %s %d, want %s", i, k.Key, k.Hits, want)
+		}
+	}
+
+	cache.ResetStatistics()
+	if keys := cache.HotKeys(); len(keys) != 0 {
+		t.Fatalf("got %d hot keys after reset", len(keys))
+	}
+	if keys := NewCache(1024 * 1024).HotKeys(); keys != nil {
+		t.Fatal("hot keys should be nil without tracking")
+	}
+}
@@ -0,0 +1,228 @@
+// Package httpapi exposes a freecache.Cache over HTTP, to inspect and modify it without writing a program:
+//
+//	GET    /keys/{key}             the value of key, 404 if it is missing.
+//	PUT    /keys/{key}?ttl=seconds sets the request body as the value of key, with an optional expiration,
+//	                               413 if it is too large, see WithMaxValueLen.
+//	DELETE /keys/{key}             deletes key, 404 if it is missing.
+//	GET    /ttl/{key}              {"ttl": seconds} left before key expires, -1 if it doesn't expire.
+//	GET    /stats                  the statistics of the cache in JSON.
+//	GET    /scan?prefix=p&limit=n  {"keys": [...]} the keys starting with prefix, up to limit, 1000 by default.
+//	POST   /clear                  clears the cache.
+//
+// The keys are the unescaped rest of the URL path and are returned as strings.
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coocood/freecache"
+)
+
+const defaultScanLimit = 1000
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithAuth rejects the requests for which authorized returns false with 401 Unauthorized.
+func WithAuth(authorized func(r *http.Request) bool) Option {
+	return func(h *Handler) {
+		h.authorized = authorized
+	}
+}
+
+// WithBearerToken only accepts the requests with the header "Authorization: Bearer token".
+// The header is compared in constant time, so the time of the comparison doesn't leak the token.
+func WithBearerToken(token string) Option {
+	expected := []byte("Bearer " + token)
+	return WithAuth(func(r *http.Request) bool {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+	})
+}
+
+// WithMaxValueLen limits the body of a PUT to n bytes, the longer bodies are rejected with 413 Request Entity
+// Too Large without being read in full. The default is the length of the largest value the cache accepts for
+// the key, see Cache.MaxValueLen, so n must be set for a cache with WithLargeValues or WithOverflowStore.
+func WithMaxValueLen(n int) Option {
+	return func(h *Handler) {
+		h.maxValueLen = n
+	}
+}
+
+// Handler is an http.Handler of the API of a cache.
+type Handler struct {
+	cache       *freecache.Cache
+	authorized  func(r *http.Request) bool
+	maxValueLen int // the limit of the body of a PUT, 0 for the limit of the cache.
+	mux         *http.ServeMux
+}
+
+// NewHandler returns a handler of the API of cache, its paths may be mounted under a prefix with http.StripPrefix.
+func NewHandler(cache *freecache.Cache, opts ...Option) *Handler {
+	h := &Handler{cache: cache, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("/keys/", h.handleKey)
+	h.mux.HandleFunc("/ttl/", h.handleTTL)
+	h.mux.HandleFunc("/stats", h.handleStats)
+	h.mux.HandleFunc("/scan", h.handleScan)
+	h.mux.HandleFunc("/clear", h.handleClear)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.authorized != nil && !h.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) handleKey(w http.ResponseWriter, r *http.Request) {
+	key := []byte(strings.TrimPrefix(r.URL.Path, "/keys/"))
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		value, err := h.cache.Get(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		w.Write(value)
+	case http.MethodPut:
+		ttl := 0
+		if s := r.URL.Query().Get("ttl"); s != "" {
+			var err error
+			if ttl, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+		}
+		limit := int64(h.maxValueLen)
+		if limit <= 0 {
+			if limit = int64(h.cache.MaxValueLen(len(key))); limit < 0 {
+				limit = 0
+			}
+		}
+		if r.ContentLength > limit {
+			http.Error(w, freecache.ErrLargeEntry.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			// MaxBytesReader fails once the body goes beyond the limit.
+			status := http.StatusBadRequest
+			if int64(len(value)) == limit {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if err = h.cache.Set(key, value, ttl); err != nil {
+			http.Error(w, err.Error(), setErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if !h.cache.Del(key) {
+			http.Error(w, freecache.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, HEAD, PUT, DELETE")
+	}
+}
+
+func (h *Handler) handleTTL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	timeLeft, err := h.cache.TTL([]byte(strings.TrimPrefix(r.URL.Path, "/ttl/")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	ttl := int64(timeLeft)
+	if ttl == 0 {
+		ttl = -1
+	}
+	writeJSON(w, struct {
+		TTL int64 `json:"ttl"`
+	}{ttl})
+}
+
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	stats := h.cache.Stats()
+	writeJSON(w, struct {
+		freecache.Stats
+		HitRate float64
+	}{stats, stats.HitRate()})
+}
+
+func (h *Handler) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, "GET")
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultScanLimit
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	keys := []string{}
+	h.cache.Scan([]byte(query.Get("prefix")), func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return len(keys) < limit
+	})
+	writeJSON(w, struct {
+		Keys []string `json:"keys"`
+	}{keys})
+}
+
+func (h *Handler) handleClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	h.cache.Clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setErrorStatus returns the status of a failed Set.
+func setErrorStatus(err error) int {
+	switch err {
+	case freecache.ErrLargeEntry:
+		return http.StatusRequestEntityTooLarge
+	case freecache.ErrLargeKey:
+		return http.StatusBadRequest
+	case freecache.ErrClosed:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
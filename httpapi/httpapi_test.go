@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coocood/freecache"
+)
+
+func TestHandler(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	srv := httptest.NewServer(NewHandler(cache, WithBearerToken("secret")))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	if resp, err := http.Get(srv.URL + "/stats"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("request without token = %v %v", resp, err)
+	}
+	for _, c := range []struct {
+		method, path, body string
+		status             int
+		reply              string
+	}{
+		{"PUT", "/keys/user/1", "alice", http.StatusNoContent, ""},
+		{"PUT", "/keys/user/2?ttl=100", "bob", http.StatusNoContent, ""},
+		{"PUT", "/keys/other", "x", http.StatusNoContent, ""},
+		{"PUT", "/keys/bad?ttl=x", "x", http.StatusBadRequest, "invalid ttl\n"},
+		{"GET", "/keys/user/1", "", http.StatusOK, "alice"},
+		{"GET", "/keys/missing", "", http.StatusNotFound, "Entry not found\n"},
+		{"GET", "/ttl/user/1", "", http.StatusOK, "{\"ttl\":-1}\n"},
+		{"GET", "/ttl/user/2", "", http.StatusOK, "{\"ttl\":100}\n"},
+		{"GET", "/scan?prefix=user/&limit=1", "", http.StatusOK, ""},
+		{"DELETE", "/keys/other", "", http.StatusNoContent, ""},
+		{"DELETE", "/keys/other", "", http.StatusNotFound, "Entry not found\n"},
+		{"POST", "/keys/other", "", http.StatusMethodNotAllowed, "method not allowed\n"},
+	} {
+		status, reply := do(c.method, c.path, c.body)
+		if status != c.status || (c.reply != "" && reply != c.reply) {
+			t.Fatalf("%s %s = %d %q, want %d %q", c.method, c.path, status, reply, c.status, c.reply)
+		}
+	}
+
+	_, reply := do("GET", "/scan?prefix=user/", "")
+	var scan struct{ Keys []string }
+	if err := json.Unmarshal([]byte(reply), &scan); err != nil || len(scan.Keys) != 2 {
+		t.Fatalf("scan = %s", reply)
+	}
+	_, reply = do("GET", "/stats", "")
+	var stats struct {
+		EntryCount int64
+		HitCount   int64
+		HitRate    float64
+	}
+	if err := json.Unmarshal([]byte(reply), &stats); err != nil || stats.EntryCount != 2 || stats.HitCount != 1 {
+		t.Fatalf("stats = %s", reply)
+	}
+	if status, _ := do("POST", "/clear", ""); status != http.StatusNoContent || cache.EntryCount() != 0 {
+		t.Fatalf("clear = %d, entry count = %d", status, cache.EntryCount())
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	srv := httptest.NewServer(NewHandler(cache, WithBearerToken("secret"), WithMaxValueLen(10)))
+	defer srv.Close()
+
+	put := func(key string, body io.Reader, token string) int {
+		req, err := http.NewRequest("PUT", srv.URL+"/keys/"+key, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put("k", strings.NewReader("v"), "secreT"); status != http.StatusUnauthorized {
+		t.Fatalf("PUT with a wrong token = %d", status)
+	}
+	if status := put("k", strings.NewReader("0123456789"), "secret"); status != http.StatusNoContent {
+		t.Fatalf("PUT of the max length = %d", status)
+	}
+	if status := put("k", strings.NewReader("0123456789a"), "secret"); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("PUT beyond the max length = %d", status)
+	}
+	// a body without Content-Length is only read up to the limit.
+	if status := put("k", io.MultiReader(strings.NewReader("0123456789a")), "secret"); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunked PUT beyond the max length = %d", status)
+	}
+	cache.Close()
+	if status := put("k", strings.NewReader("v"), "secret"); status != http.StatusServiceUnavailable {
+		t.Fatalf("PUT to a closed cache = %d", status)
+	}
+}
@@ -0,0 +1,9 @@
+package freecache
+
+import (
+	"syscall"
+)
+
+func adviseHugePages(data []byte) {
+	syscall.Madvise(data, syscall.MADV_HUGEPAGE)
+}
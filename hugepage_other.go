@@ -0,0 +1,5 @@
+//go:build !linux
+
+package freecache
+
+func adviseHugePages(data []byte) {}
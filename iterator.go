@@ -10,6 +10,9 @@ type Iterator struct {
 	segmentIdx int
 	slotIdx    int
 	entryIdx   int
+	endIdx     int
+	filter     func(key []byte, expireAt uint32) bool
+	keyBuf     []byte
 }
 
 // Entry represents a key/value pair.
@@ -22,9 +25,16 @@ type Entry struct {
 // The order of the entries is not guaranteed.
 // If there is no more entries to return, nil will be returned.
 func (it *Iterator) Next() *Entry {
-	for it.segmentIdx < 256 {
+	for it.segmentIdx < it.endIdx {
 		entry := it.nextForSegment(it.segmentIdx)
 		if entry != nil {
+			if it.cache.stubbed(entry.Value) {
+				var err error
+				// an entry whose value is lost is skipped.
+				if entry.Value, err = it.cache.unstub(entry.Key, entry.Value, true); err != nil {
+					continue
+				}
+			}
 			return entry
 		}
 		it.segmentIdx++
@@ -38,6 +48,7 @@ func (it *Iterator) nextForSegment(segIdx int) *Entry {
 	it.cache.locks[segIdx].Lock()
 	defer it.cache.locks[segIdx].Unlock()
 	seg := &it.cache.segments[segIdx]
+	seg.applyClear()
 	for it.slotIdx < 256 {
 		entry := it.nextForSlot(seg, it.slotIdx)
 		if entry != nil {
@@ -55,11 +66,25 @@ func (it *Iterator) nextForSlot(seg *segment, slotId int) *Entry {
 	for it.entryIdx < len(slot) {
 		ptr := slot[it.entryIdx]
 		it.entryIdx++
-		now := seg.timer.Now()
+		nowMs := seg.timer.NowMilli()
 		var hdrBuf [ENTRY_HDR_SIZE]byte
 		seg.rb.ReadAt(hdrBuf[:], ptr.offset)
 		hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
-		if hdr.expireAt == 0 || hdr.expireAt > now {
+		if !isExpired(hdr.expireAtMilli(), nowMs) && hdr.flags&flagNegative == 0 {
+			if it.filter != nil || it.cache.largeValues {
+				if cap(it.keyBuf) < int(hdr.keyLen) {
+					it.keyBuf = make([]byte, hdr.keyLen)
+				}
+				it.keyBuf = it.keyBuf[:hdr.keyLen]
+				seg.rb.ReadAt(it.keyBuf, ptr.offset+ENTRY_HDR_SIZE)
+				// the chunks of the values split by WithLargeValues are returned reassembled with their keys.
+				if it.cache.largeValues && isLargeChunk(it.keyBuf) {
+					continue
+				}
+				if it.filter != nil && !it.filter(it.keyBuf, hdr.expireAtSeconds()) {
+					continue
+				}
+			}
 			entry := new(Entry)
 			entry.Key = make([]byte, hdr.keyLen)
 			entry.Value = make([]byte, hdr.valLen)
@@ -71,9 +96,58 @@ func (it *Iterator) nextForSlot(seg *segment, slotId int) *Entry {
 	return nil
 }
 
+// Cursor returns the position of the iterator, which NewIteratorAt resumes from. The cursor of
+// an iterator that has returned all the entries makes NewIteratorAt return no entry.
+func (it *Iterator) Cursor() uint64 {
+	return uint64(it.segmentIdx)<<40 | uint64(it.slotIdx)<<32 | uint64(uint32(it.entryIdx))
+}
+
+// NewIteratorAt creates a new iterator for the cache, starting at a cursor returned by Iterator.Cursor,
+// so a large cache can be iterated in batches without keeping the iterator. Like Redis SCAN, the entries
+// set or deleted between the batches may be skipped or returned twice. A cursor of 0 starts from the beginning.
+func (cache *Cache) NewIteratorAt(cursor uint64) *Iterator {
+	it := &Iterator{
+		cache:      cache,
+		segmentIdx: int(cursor >> 40),
+		slotIdx:    int(cursor >> 32 & 0xff),
+		entryIdx:   int(uint32(cursor)),
+		endIdx:     segmentCount,
+	}
+	if it.segmentIdx > segmentCount {
+		it.segmentIdx = segmentCount
+	}
+	return it
+}
+
 // NewIterator creates a new iterator for the cache.
 func (cache *Cache) NewIterator() *Iterator {
 	return &Iterator{
-		cache: cache,
+		cache:  cache,
+		endIdx: segmentCount,
+	}
+}
+
+// SegmentIterator creates a new iterator for the entries of the segment segID, from 0 to 255,
+// so the segments can be iterated concurrently. It returns no entry for an invalid segID.
+func (cache *Cache) SegmentIterator(segID int) *Iterator {
+	if segID < 0 || segID >= segmentCount {
+		return &Iterator{cache: cache}
+	}
+	return &Iterator{
+		cache:      cache,
+		segmentIdx: segID,
+		endIdx:     segID + 1,
+	}
+}
+
+// NewFilteredIterator creates a new iterator for the cache, which only returns the entries
+// the filter returns true for. The filter is called with the key and the expiration time in seconds,
+// 0 for no expiration, before the value is copied, so the entries filtered out are cheap to skip.
+// The key is only valid until the filter returns, and the filter must not use the cache.
+func (cache *Cache) NewFilteredIterator(filter func(key []byte, expireAt uint32) bool) *Iterator {
+	return &Iterator{
+		cache:  cache,
+		endIdx: segmentCount,
+		filter: filter,
 	}
 }
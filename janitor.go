@@ -0,0 +1,31 @@
+package freecache
+
+import (
+	"time"
+)
+
+// runJanitor deletes the expired entries every interval until the cache is closed.
+// Segments are locked one at a time, so the scan doesn't block the whole cache.
+func (cache *Cache) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.done:
+			return
+		case <-ticker.C:
+			cache.DeleteExpired()
+		}
+	}
+}
+
+// DeleteExpired scans all segments and deletes the expired entries,
+// returns the number of entries deleted.
+func (cache *Cache) DeleteExpired() (count int) {
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		count += cache.segments[i].delExpired()
+		cache.locks[i].Unlock()
+	}
+	return
+}
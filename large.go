@@ -0,0 +1,260 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// largeMagic starts the manifest of a value stored in chunks by SetLarge, a value starting with it and
+// of the length of a manifest is taken for a manifest by GetLarge.
+const largeMagic = "\x00fclarge"
+
+// the manifest is largeMagic, the generation of the chunk keys, the length of the value as uint64, the
+// length of the chunks and the checksum of the value as uint32, little endian.
+const largeManifestLen = len(largeMagic) + 24
+
+// largeChunkSuffixLen is the length of the suffix appended to the key of a large value for the keys of
+// its chunks, largeMagic, the generation and the index of the chunk as uint32.
+const largeChunkSuffixLen = len(largeMagic) + 12
+
+type largeManifest struct {
+	gen      uint64 // distinguishes the chunks of the values set under the same key.
+	valueLen uint64
+	chunkLen uint32
+	sum      uint32
+}
+
+func parseLargeManifest(data []byte) (m largeManifest, ok bool) {
+	if len(data) != largeManifestLen || string(data[:len(largeMagic)]) != largeMagic {
+		return
+	}
+	data = data[len(largeMagic):]
+	m.gen = binary.LittleEndian.Uint64(data[0:8])
+	m.valueLen = binary.LittleEndian.Uint64(data[8:16])
+	m.chunkLen = binary.LittleEndian.Uint32(data[16:20])
+	m.sum = binary.LittleEndian.Uint32(data[20:24])
+	return m, m.chunkLen > 0
+}
+
+func (m *largeManifest) marshal() []byte {
+	data := make([]byte, largeManifestLen)
+	copy(data, largeMagic)
+	b := data[len(largeMagic):]
+	binary.LittleEndian.PutUint64(b[0:8], m.gen)
+	binary.LittleEndian.PutUint64(b[8:16], m.valueLen)
+	binary.LittleEndian.PutUint32(b[16:20], m.chunkLen)
+	binary.LittleEndian.PutUint32(b[20:24], m.sum)
+	return data
+}
+
+func (m *largeManifest) chunkCount() int {
+	return int((m.valueLen + uint64(m.chunkLen) - 1) / uint64(m.chunkLen))
+}
+
+// chunkKey appends the key of the chunk idx of the value of key to buf.
+func (m *largeManifest) chunkKey(buf, key []byte, idx int) []byte {
+	n := len(key) + len(largeMagic)
+	buf = append(append(buf[:0], key...), largeMagic...)
+	buf = append(buf, make([]byte, 12)...)
+	binary.LittleEndian.PutUint64(buf[n:], m.gen)
+	binary.LittleEndian.PutUint32(buf[n+8:], uint32(idx))
+	return buf
+}
+
+// isLargeChunk reports whether key is the key of a chunk of a value split by SetLarge, see chunkKey.
+func isLargeChunk(key []byte) bool {
+	n := len(key) - largeChunkSuffixLen
+	return n >= 0 && string(key[n:n+len(largeMagic)]) == largeMagic
+}
+
+// SetLarge is like Set, but a value too large for an entry, up to 1/16 of the cache size, is split in
+// chunks stored under keys derived from key, and a manifest of the chunks is stored under key. A value
+// fitting in an entry is set like Set. The chunks are entries evicted like the others, so a large value
+// is missing once one of its chunks is. Use GetLarge and DelLarge to get and delete the large values,
+// or WithLargeValues to handle them in Set, Get and Del. ErrReservedValue is returned for a value starting
+// like a manifest.
+func (cache *Cache) SetLarge(key, value []byte, expireSeconds int) (err error) {
+	if hasStubMagic(value) {
+		return ErrReservedValue
+	}
+	ttl := time.Duration(expireSeconds) * time.Second
+	if cache.hasStubs() {
+		return cache.setValue(key, value, ttl, 0, 1)
+	}
+	// the chunks of the value replaced are dropped under the stub lock, like with WithLargeValues.
+	segID := cache.hash(key) & segmentAndOpVal
+	cache.stubLocks[segID].Lock()
+	defer cache.stubLocks[segID].Unlock()
+	old, isLarge := cache.peekLargeManifest(key)
+	if err = cache.setValue(key, value, ttl, 0, 1); err == ErrLargeEntry {
+		err = cache.splitLarge(key, value, ttl, 0, 1)
+	}
+	if err == nil && isLarge {
+		cache.delLargeChunks(key, &old)
+	}
+	return
+}
+
+// splitLarge splits value, too large for an entry, in chunks and sets their manifest as the entry of key,
+// with the expiration ttl, flags and cost of setAt, see SetLarge.
+func (cache *Cache) splitLarge(key, value []byte, ttl time.Duration, flags uint8, cost int64) (err error) {
+	if len(key)+largeChunkSuffixLen > 65535 {
+		return ErrLargeKey
+	}
+	var size int64
+	maxEntryLen := int(^uint(0) >> 1)
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		size += cache.segments[i].rb.Size()
+		if n := len(cache.segments[i].rb.data)/4 - ENTRY_HDR_SIZE; n < maxEntryLen {
+			maxEntryLen = n
+		}
+		cache.locks[i].Unlock()
+	}
+	// the chunks are a quarter of an entry at most, so a segment holds a few more chunks than the
+	// average before it evicts them.
+	chunkLen := (maxEntryLen - len(key) - largeChunkSuffixLen) / 4
+	if int64(len(value)) > size/16 || chunkLen <= 0 {
+		return ErrLargeEntry
+	}
+	m := largeManifest{
+		gen:      atomic.AddUint64(&cache.largeGen, 1),
+		valueLen: uint64(len(value)),
+		chunkLen: uint32(chunkLen),
+		sum:      checksum(nil, value),
+	}
+	var chunkKey []byte
+	for i := 0; i < m.chunkCount(); i++ {
+		chunkKey = m.chunkKey(chunkKey, key, i)
+		chunk := value[i*chunkLen:]
+		if len(chunk) > chunkLen {
+			chunk = chunk[:chunkLen]
+		}
+		if err = cache.setStub(chunkKey, chunk, nil, ttl, flags, 1); err != nil {
+			m.valueLen = uint64(i * chunkLen)
+			cache.delLargeChunks(key, &m)
+			return
+		}
+	}
+	if err = cache.setStub(key, m.marshal(), value, ttl, flags, cost); err != nil {
+		cache.delLargeChunks(key, &m)
+	}
+	return
+}
+
+// GetLarge returns the value of key set by SetLarge, reassembled from its chunks if it was split.
+// It returns ErrNotFound if a chunk was evicted, and ErrCorrupted if the chunks don't match the manifest.
+func (cache *Cache) GetLarge(key []byte) (value []byte, err error) {
+	if value, err = cache.Get(key); err != nil || cache.largeValues {
+		return
+	}
+	return cache.getLargeChunks(key, value, false)
+}
+
+// getLargeChunks returns value if it isn't a manifest, otherwise the value reassembled from its chunks,
+// read like Peek if peek is true.
+func (cache *Cache) getLargeChunks(key, value []byte, peek bool) ([]byte, error) {
+	m, ok := parseLargeManifest(value)
+	if !ok {
+		return value, nil
+	}
+	value = make([]byte, m.valueLen)
+	var chunkKey []byte
+	for i := 0; i < m.chunkCount(); i++ {
+		chunkKey = m.chunkKey(chunkKey, key, i)
+		chunk := value[i*int(m.chunkLen):]
+		if len(chunk) > int(m.chunkLen) {
+			chunk = chunk[:m.chunkLen]
+		}
+		hashVal := cache.hash(chunkKey)
+		segID := hashVal & segmentAndOpVal
+		cache.locks[segID].Lock()
+		// the chunk is read in place, or into a new slice if it is longer than expected.
+		read, _, err := cache.segments[segID].get(chunkKey, chunk[:0:len(chunk)], hashVal, peek)
+		cache.locks[segID].Unlock()
+		if err == nil && len(read) != len(chunk) {
+			err = ErrCorrupted
+		}
+		if err == ErrExpired || err == ErrNegativeCached {
+			err = ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if checksum(nil, value) != m.sum {
+		return nil, ErrCorrupted
+	}
+	return value, nil
+}
+
+// DelLarge deletes key like Del, and the chunks of its value if it was split by SetLarge.
+func (cache *Cache) DelLarge(key []byte) (affected bool) {
+	if cache.hasStubs() {
+		return cache.del(key, nil)
+	}
+	segID := cache.hash(key) & segmentAndOpVal
+	cache.stubLocks[segID].Lock()
+	defer cache.stubLocks[segID].Unlock()
+	m, isLarge := cache.peekLargeManifest(key)
+	affected = cache.del(key, nil)
+	if isLarge {
+		cache.delLargeChunks(key, &m)
+	}
+	return
+}
+
+// peekLargeManifest returns the manifest stored under key, if its value was split by SetLarge.
+func (cache *Cache) peekLargeManifest(key []byte) (m largeManifest, ok bool) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	if value, _, err := cache.segments[segID].viewEntry(key, hashVal, true); err == nil {
+		m, ok = parseLargeManifest(value)
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
+// delLargeChunks deletes the chunks of the value of key, the deletions are not propagated to the writers.
+func (cache *Cache) delLargeChunks(key []byte, m *largeManifest) {
+	var chunkKey []byte
+	for i := 0; i < m.chunkCount(); i++ {
+		chunkKey = m.chunkKey(chunkKey, key, i)
+		hashVal := cache.hash(chunkKey)
+		segID := hashVal & segmentAndOpVal
+		cache.locks[segID].Lock()
+		seg := &cache.segments[segID]
+		seg.quiet(func() {
+			seg.del(chunkKey, hashVal)
+		})
+		cache.locks[segID].Unlock()
+	}
+}
+
+// setStub sets stored under key, e.g. the manifest of a value split by SetLarge, and propagates value to the
+// writers instead, in the same locked operation so they see the writes of key in order. A nil value isn't
+// propagated, e.g. for the chunks of a split value.
+func (cache *Cache) setStub(key, stored, value []byte, ttl time.Duration, flags uint8, cost int64) (err error) {
+	if cache.isClosed() {
+		return ErrClosed
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	nowMs := seg.timer.NowMilli()
+	expireAtMs := expireAtMilli(nowMs, seg.setPolicy(ttl))
+	// a set not admitted by WithTinyLFU returns no error, but is counted.
+	rejected := atomic.LoadInt64(&seg.rejected)
+	writes := seg.writes
+	seg.writes = nil
+	err = seg.setAt(key, stored, hashVal, nowMs, expireAtMs, 0, flags, cost)
+	seg.writes = writes
+	if err == nil && value != nil && atomic.LoadInt64(&seg.rejected) == rejected {
+		err = seg.logSet(key, value, hashVal, nowMs, expireAtMs, false)
+	}
+	cache.locks[segID].Unlock()
+	return
+}
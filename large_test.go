@@ -0,0 +1,235 @@
+package freecache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetLarge(t *testing.T) {
+	cache := NewCache(4 * 1024 * 1024)
+	large := bytes.Repeat([]byte("0123456789"), 20000)
+	if err := cache.Set([]byte("large"), large, 0); err != ErrLargeEntry {
+		t.Fatalf("Set err = %v", err)
+	}
+	if err := cache.SetLarge([]byte("large"), large, 100); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.GetLarge([]byte("large")); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("GetLarge = %d bytes, %v", len(v), err)
+	}
+	if ttl, err := cache.TTL([]byte("large")); err != nil || ttl != 100 {
+		t.Fatalf("ttl = %d, %v", ttl, err)
+	}
+	entries := cache.EntryCount()
+	if entries < 10 {
+		t.Fatalf("%d entries, want the manifest and the chunks", entries)
+	}
+
+	// a smaller value replaces the chunks.
+	if err := cache.SetLarge([]byte("large"), []byte("small"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.GetLarge([]byte("large")); err != nil || string(v) != "small" {
+		t.Fatalf("GetLarge = %q, %v", v, err)
+	}
+	if cache.EntryCount() != 1 {
+		t.Fatalf("%d entries after a small value", cache.EntryCount())
+	}
+
+	cache.SetLarge([]byte("large"), large, 0)
+	if !cache.DelLarge([]byte("large")) || cache.EntryCount() != 0 {
+		t.Fatalf("%d entries after DelLarge", cache.EntryCount())
+	}
+
+	cache.SetLarge([]byte("large"), large, 0)
+	m, _ := cache.peekLargeManifest([]byte("large"))
+	cache.del(m.chunkKey(nil, []byte("large"), 3), nil)
+	if _, err := cache.GetLarge([]byte("large")); err != ErrNotFound {
+		t.Fatalf("GetLarge with a missing chunk err = %v", err)
+	}
+
+	if err := cache.SetLarge([]byte("huge"), make([]byte, 1024*1024), 0); err != ErrLargeEntry {
+		t.Fatalf("SetLarge of 1/4 of the cache err = %v", err)
+	}
+}
+
+func TestWithLargeValues(t *testing.T) {
+	cache := NewCacheWithOptions(4*1024*1024, WithLargeValues())
+	large := bytes.Repeat([]byte("0123456789"), 20000)
+	if err := cache.Set([]byte("large"), large, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.Get([]byte("large")); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("Get = %d bytes, %v", len(v), err)
+	}
+	if v, err := cache.GetLarge([]byte("large")); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("GetLarge = %d bytes, %v", len(v), err)
+	}
+	cache.Set([]byte("small"), []byte("v"), 0)
+	if v, err := cache.Get([]byte("small")); err != nil || string(v) != "v" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if !cache.Del([]byte("large")) || cache.EntryCount() != 1 {
+		t.Fatalf("%d entries after Del", cache.EntryCount())
+	}
+
+	errs := cache.SetMulti([]Entry{{Key: []byte("large"), Value: large}, {Key: []byte("small"), Value: []byte("w")}})
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("SetMulti = %v", errs)
+	}
+	if v, err := cache.Get([]byte("large")); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("Get after SetMulti = %d bytes, %v", len(v), err)
+	}
+}
+
+func TestLargeValuesReads(t *testing.T) {
+	testStubReads(t, NewCacheWithOptions(4*1024*1024, WithLargeValues()))
+}
+
+// testStubReads checks that the reads of cache return a value too large for an entry, not its stub.
+func testStubReads(t *testing.T, cache *Cache) {
+	large := bytes.Repeat([]byte("0123456789"), 20000)
+	key := []byte("large")
+	if err := cache.Set(key, large, 100); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, value []byte, err error) {
+		t.Helper()
+		if err != nil || !bytes.Equal(value, large) {
+			t.Fatalf("%s = %d bytes, %v", name, len(value), err)
+		}
+	}
+	values, errs := cache.GetMulti([][]byte{key})
+	check("GetMulti", values[0], errs[0])
+	var viewed []byte
+	err := cache.GetFn(key, func(value []byte) error {
+		viewed = append(viewed[:0], value...)
+		return nil
+	})
+	check("GetFn", viewed, err)
+	err = cache.PeekFn(key, func(value []byte) error {
+		viewed = append(viewed[:0], value...)
+		return nil
+	})
+	check("PeekFn", viewed, err)
+	var ttl, expireAt uint32
+	err = cache.GetFnWithTTL(key, func(value []byte, t uint32) error {
+		viewed, ttl = append(viewed[:0], value...), t
+		return nil
+	})
+	check("GetFnWithTTL", viewed, err)
+	if ttl != 100 {
+		t.Fatalf("GetFnWithTTL ttl = %d", ttl)
+	}
+	err = cache.GetFnWithExpiration(key, func(value []byte, e uint32) error {
+		viewed, expireAt = append(viewed[:0], value...), e
+		return nil
+	})
+	check("GetFnWithExpiration", viewed, err)
+	if expireAt == 0 {
+		t.Fatal("GetFnWithExpiration expireAt = 0")
+	}
+	value, err := cache.Peek(key)
+	check("Peek", value, err)
+	value, err = cache.GetWithBuf(key, make([]byte, 64))
+	check("GetWithBuf", value, err)
+	value, _, err = cache.GetWithExpiration(key)
+	check("GetWithExpiration", value, err)
+	buf := make([]byte, len(large))
+	n, err := cache.GetIntoBuf(key, buf)
+	check("GetIntoBuf", buf[:n], err)
+	if n, err = cache.GetIntoBuf(key, make([]byte, 64)); n != len(large) || err != ErrBufferTooSmall {
+		t.Fatalf("GetIntoBuf with a small buffer = %d, %v", n, err)
+	}
+	typed := NewCacheOf[string, []byte](cache, StringCodec{}, BytesCodec{})
+	value, err = typed.Get("large")
+	check("CacheOf.Get", value, err)
+
+	if err = cache.SetObject([]byte("object"), string(large), 0); err != nil {
+		t.Fatal(err)
+	}
+	var object string
+	err = cache.GetObject([]byte("object"), &object)
+	check("GetObject", []byte(object), err)
+
+	it := cache.NewIterator()
+	count := 0
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		count++
+		if string(entry.Key) == "large" {
+			check("Iterator", entry.Value, nil)
+		} else if string(entry.Key) != "object" {
+			t.Fatalf("iterated key %q", entry.Key)
+		}
+	}
+	if count != 2 {
+		t.Fatalf("iterated %d entries", count)
+	}
+	count = 0
+	cache.Scan(nil, func(key, value []byte) bool {
+		count++
+		if string(key) == "large" {
+			check("Scan", value, nil)
+		} else if string(key) != "object" {
+			t.Fatalf("scanned key %q", key)
+		}
+		return true
+	})
+	if count != 2 {
+		t.Fatalf("scanned %d entries", count)
+	}
+
+	// the reads and the writes of the value in place resolve the stub or leave it as is.
+	value, err = cache.GetOrSet(key, []byte("small"), 0)
+	check("GetOrSet", value, err)
+	value, err = cache.GetOrSetFn(key, 0, func(dst []byte) (int, error) {
+		return copy(dst, "small"), nil
+	}, 8)
+	check("GetOrSetFn", value, err)
+	value, err = cache.GetEx(key, 200)
+	check("GetEx", value, err)
+	if ttl, _ := cache.TTL(key); ttl != 200 {
+		t.Fatalf("TTL after GetEx = %d", ttl)
+	}
+	value, err = cache.GetRange(key, 0, len(large)+10)
+	check("GetRange", value, err)
+	if value, err = cache.GetRange(key, 12, 5); err != nil || string(value) != "23456" {
+		t.Fatalf("GetRange = %q, %v", value, err)
+	}
+	if err = cache.Append(key, []byte("x")); err != ErrStubbed {
+		t.Fatalf("Append err = %v, want ErrStubbed", err)
+	}
+	if err = cache.Prepend(key, []byte("x")); err != ErrStubbed {
+		t.Fatalf("Prepend err = %v, want ErrStubbed", err)
+	}
+	if _, err = cache.Incr(key, 1, 0); err != ErrNotInteger {
+		t.Fatalf("Incr err = %v, want ErrNotInteger", err)
+	}
+	if err = cache.SetRange(key, 0, []byte("x")); err != ErrStubbed {
+		t.Fatalf("SetRange err = %v, want ErrStubbed", err)
+	}
+	err = cache.UpdateInPlace(key, func(value []byte) error {
+		value[0] = 'x'
+		return nil
+	})
+	if err != ErrStubbed {
+		t.Fatalf("UpdateInPlace err = %v, want ErrStubbed", err)
+	}
+	value, err = cache.Get(key)
+	check("Get after the writes in place", value, err)
+
+	if deleted, err := cache.CompareAndDelete(key, []byte("small")); deleted || err != nil {
+		t.Fatalf("CompareAndDelete of another value = %v, %v", deleted, err)
+	}
+	if deleted, err := cache.CompareAndDelete(key, large); !deleted || err != nil {
+		t.Fatalf("CompareAndDelete = %v, %v", deleted, err)
+	}
+	if err := cache.Set(key, large, 0); err != nil {
+		t.Fatal(err)
+	}
+	value, deleted := cache.DelWithValue(key)
+	check("DelWithValue", value, nil)
+	if !deleted {
+		t.Fatal("DelWithValue didn't delete the value")
+	}
+}
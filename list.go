@@ -0,0 +1,204 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrNotList = errors.New("The value is not a list")
+
+// The value of a list set by LPush and RPush is a sequence of items, each its length as uvarint followed by
+// the item, from the head to the tail of the list.
+
+// splitList returns the items of the list encoded in data, which are views of data.
+func splitList(data []byte) (items [][]byte, err error) {
+	for off := 0; off < len(data); {
+		itemLen, n := binary.Uvarint(data[off:])
+		if n <= 0 || itemLen > uint64(len(data)-off-n) {
+			return nil, ErrNotList
+		}
+		off += n
+		items = append(items, data[off:off+int(itemLen)])
+		off += int(itemLen)
+	}
+	return
+}
+
+// encodeList returns the encoding of items.
+func encodeList(items [][]byte) []byte {
+	size := 0
+	for _, item := range items {
+		size += binary.MaxVarintLen64 + len(item)
+	}
+	data := make([]byte, 0, size)
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, item := range items {
+		n := binary.PutUvarint(lenBuf[:], uint64(len(item)))
+		data = append(data, lenBuf[:n]...)
+		data = append(data, item...)
+	}
+	return data
+}
+
+// LPush inserts values at the head of the list stored as the value of key, like the LPUSH command of Redis,
+// so the last value becomes the head, and returns the length of the list. A missing key is set to a list of
+// values without expiration, an existing key keeps its expiration. With WithMaxListLen, the items beyond the
+// limit are removed from the tail. Returns ErrNotList if the value of key isn't a list.
+// The list is limited like the values of Set.
+func (cache *Cache) LPush(key []byte, values ...[]byte) (length int, err error) {
+	return cache.push(key, values, true)
+}
+
+// RPush is like LPush, but appends values at the tail of the list, and the items beyond the limit of
+// WithMaxListLen are removed from the head.
+func (cache *Cache) RPush(key []byte, values ...[]byte) (length int, err error) {
+	return cache.push(key, values, false)
+}
+
+func (cache *Cache) push(key []byte, values [][]byte, head bool) (length int, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	length, err = cache.segments[segID].push(key, hashVal, values, head, cache.maxListLen)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// LPop removes and returns the head of the list stored as the value of key, see LPush, and deletes the key
+// with its last item. Returns ErrNotFound if the key doesn't exist, and ErrNotList if the value isn't a list.
+func (cache *Cache) LPop(key []byte) (value []byte, err error) {
+	return cache.pop(key, true)
+}
+
+// RPop is like LPop, but removes and returns the tail of the list.
+func (cache *Cache) RPop(key []byte) (value []byte, err error) {
+	return cache.pop(key, false)
+}
+
+func (cache *Cache) pop(key []byte, head bool) (value []byte, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	value, err = cache.segments[segID].pop(key, hashVal, head)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// LRange returns copies of the items of the list stored as the value of key from start to stop included,
+// like the LRANGE command of Redis: negative indexes count from the tail, -1 being the last item, and the
+// indexes out of the list are clamped. Returns ErrNotFound if the key doesn't exist, and ErrNotList if the
+// value isn't a list.
+func (cache *Cache) LRange(key []byte, start, stop int) (values [][]byte, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	defer cache.locks[segID].Unlock()
+	data, _, err := cache.segments[segID].viewEntry(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	items, err := splitList(data)
+	if err != nil {
+		return
+	}
+	if start < 0 {
+		start += len(items)
+	}
+	if stop < 0 {
+		stop += len(items)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(items) {
+		stop = len(items) - 1
+	}
+	values = [][]byte{}
+	for i := start; i <= stop; i++ {
+		values = append(values, append([]byte{}, items[i]...))
+	}
+	return
+}
+
+// listData returns the value of the list of key after verifying its checksum, like hset.
+func (seg *segment) listData(key []byte, hashVal uint64) (data []byte, hdr entryHdr, ptrOffset int64, err error) {
+	hdr, ptrOffset, err = seg.locate(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	if data, err = seg.rb.Slice(ptrOffset+ENTRY_HDR_SIZE+int64(hdr.keyLen), int64(hdr.valLen)); err != nil {
+		return
+	}
+	err = seg.verifyChecksum(&hdr, ptrOffset, key, data)
+	return
+}
+
+// push inserts values at the head or the tail of the list of key, keeping at most maxLen items if maxLen > 0.
+// Like extend, the entry is re-inserted with the same expiration.
+func (seg *segment) push(key []byte, hashVal uint64, values [][]byte, head bool, maxLen int) (length int, err error) {
+	data, hdr, ptrOffset, err := seg.listData(key, hashVal)
+	missing := err == ErrNotFound || err == ErrExpired || err == ErrNegativeCached
+	if err != nil && !missing {
+		return
+	}
+	var items [][]byte
+	if !missing {
+		if items, err = splitList(data); err != nil {
+			return
+		}
+	}
+	if len(values) == 0 {
+		return len(items), nil
+	}
+	newItems := make([][]byte, 0, len(items)+len(values))
+	if head {
+		for i := len(values) - 1; i >= 0; i-- {
+			newItems = append(newItems, values[i])
+		}
+		newItems = append(newItems, items...)
+	} else {
+		newItems = append(append(newItems, items...), values...)
+	}
+	if maxLen > 0 && len(newItems) > maxLen {
+		if head {
+			newItems = newItems[:maxLen]
+		} else {
+			newItems = newItems[len(newItems)-maxLen:]
+		}
+	}
+	if missing {
+		return len(newItems), seg.set(key, encodeList(newItems), hashVal, 0)
+	}
+	err = seg.setAt(key, encodeList(newItems), hashVal, seg.timer.NowMilli(), hdr.expireAtMilli(), seg.softExpireAt(&hdr, ptrOffset), hdr.flags&^(1<<nsShift-1), seg.entryCost(&hdr, ptrOffset))
+	return len(newItems), err
+}
+
+// pop removes and returns the head or the tail of the list of key, deleting the key with its last item.
+func (seg *segment) pop(key []byte, hashVal uint64, head bool) (value []byte, err error) {
+	data, hdr, ptrOffset, err := seg.listData(key, hashVal)
+	if err == ErrExpired || err == ErrNegativeCached {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return
+	}
+	items, err := splitList(data)
+	if err != nil {
+		return
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+	if head {
+		value, items = items[0], items[1:]
+	} else {
+		value, items = items[len(items)-1], items[:len(items)-1]
+	}
+	value = append([]byte{}, value...)
+	if len(items) == 0 {
+		seg.del(key, hashVal)
+		return
+	}
+	err = seg.setAt(key, encodeList(items), hashVal, seg.timer.NowMilli(), hdr.expireAtMilli(), seg.softExpireAt(&hdr, ptrOffset), hdr.flags&^(1<<nsShift-1), seg.entryCost(&hdr, ptrOffset))
+	return
+}
@@ -0,0 +1,104 @@
+package freecache
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func listStrings(values [][]byte) []string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = string(v)
+	}
+	return strs
+}
+
+func TestList(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithChecksums(true))
+	key := []byte("events")
+	if _, err := cache.LRange(key, 0, -1); err != ErrNotFound {
+		t.Fatalf("LRange of a missing key err = %v", err)
+	}
+	if _, err := cache.LPop(key); err != ErrNotFound {
+		t.Fatalf("LPop of a missing key err = %v", err)
+	}
+	if n, err := cache.RPush(key, []byte("b"), []byte("c")); n != 2 || err != nil {
+		t.Fatalf("RPush = %d, %v", n, err)
+	}
+	cache.Touch(key, 10)
+	if n, err := cache.LPush(key, []byte("a"), []byte("")); n != 4 || err != nil {
+		t.Fatalf("LPush = %d, %v", n, err)
+	}
+	if n, err := cache.RPush(key); n != 4 || err != nil {
+		t.Fatalf("RPush of no values = %d, %v", n, err)
+	}
+	for _, c := range []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, []string{"", "a", "b", "c"}},
+		{1, 2, []string{"a", "b"}},
+		{-2, 100, []string{"b", "c"}},
+		{-100, 0, []string{""}},
+		{3, 1, []string{}},
+		{5, 10, []string{}},
+	} {
+		values, err := cache.LRange(key, c.start, c.stop)
+		if err != nil || !reflect.DeepEqual(listStrings(values), c.want) {
+			t.Fatalf("LRange(%d, %d) = %q, %v, want %q", c.start, c.stop, values, err, c.want)
+		}
+	}
+	if ttl, _ := cache.TTL(key); ttl != 10 {
+		t.Fatalf("ttl = %d, LPush must keep the expiration", ttl)
+	}
+	if v, err := cache.LPop(key); string(v) != "" || err != nil {
+		t.Fatalf("LPop = %q, %v", v, err)
+	}
+	if v, err := cache.RPop(key); string(v) != "c" || err != nil {
+		t.Fatalf("RPop = %q, %v", v, err)
+	}
+	if v, err := cache.RPop(key); string(v) != "b" || err != nil {
+		t.Fatalf("RPop = %q, %v", v, err)
+	}
+	if v, err := cache.LPop(key); string(v) != "a" || err != nil {
+		t.Fatalf("LPop = %q, %v", v, err)
+	}
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Fatalf("the key isn't deleted with its last item: %v", err)
+	}
+
+	cache.Set([]byte("plain"), []byte{0xff}, 0)
+	if _, err := cache.LPush([]byte("plain"), []byte("a")); err != ErrNotList {
+		t.Fatalf("LPush of a plain value err = %v", err)
+	}
+	if _, err := cache.LRange([]byte("plain"), 0, -1); err != ErrNotList {
+		t.Fatalf("LRange of a plain value err = %v", err)
+	}
+	if _, err := cache.RPop([]byte("plain")); err != ErrNotList {
+		t.Fatalf("RPop of a plain value err = %v", err)
+	}
+}
+
+func TestMaxListLen(t *testing.T) {
+	cache := NewCacheWithOptions(1024*1024, WithMaxListLen(3))
+	for i := 0; i < 10; i++ {
+		want := i + 1
+		if want > 3 {
+			want = 3
+		}
+		if n, err := cache.LPush([]byte("recent"), []byte(fmt.Sprint(i))); err != nil || n != want {
+			t.Fatalf("LPush = %d, %v", n, err)
+		}
+		cache.RPush([]byte("log"), []byte(fmt.Sprint(i)))
+	}
+	values, _ := cache.LRange([]byte("recent"), 0, -1)
+	if got := listStrings(values); !reflect.DeepEqual(got, []string{"9", "8", "7"}) {
+		t.Fatalf("LPush kept %q", got)
+	}
+	values, _ = cache.LRange([]byte("log"), 0, -1)
+	if got := listStrings(values); !reflect.DeepEqual(got, []string{"7", "8", "9"}) {
+		t.Fatalf("RPush kept %q", got)
+	}
+}
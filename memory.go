@@ -0,0 +1,50 @@
+package freecache
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// SegmentMemStats is the memory usage of a segment, or the total of all segments.
+type SegmentMemStats struct {
+	BufferBytes  int64 // size of the ring buffer.
+	UsedBytes    int64 // bytes of the ring buffer holding entries, including deleted entries.
+	DeletedBytes int64 // bytes of deleted entries, reclaimed when the ring buffer wraps.
+	SlotBytes    int64 // size of the entry index.
+	PinnedBytes  int64 // bytes of the pinned entries.
+}
+
+func (s *SegmentMemStats) add(other SegmentMemStats) {
+	s.BufferBytes += other.BufferBytes
+	s.UsedBytes += other.UsedBytes
+	s.DeletedBytes += other.DeletedBytes
+	s.SlotBytes += other.SlotBytes
+	s.PinnedBytes += other.PinnedBytes
+}
+
+// MemStats is the memory usage of the cache, the embedded SegmentMemStats is the total.
+type MemStats struct {
+	SegmentMemStats
+	Segments []SegmentMemStats
+}
+
+// MemoryUsage returns the memory usage of the cache and of every segment, locking one segment at a time.
+func (cache *Cache) MemoryUsage() (stats MemStats) {
+	stats.Segments = make([]SegmentMemStats, segmentCount)
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		stats.Segments[i] = cache.segments[i].memStats()
+		cache.locks[i].Unlock()
+		stats.add(stats.Segments[i])
+	}
+	return
+}
+
+func (seg *segment) memStats() (stats SegmentMemStats) {
+	stats.BufferBytes = seg.rb.Size()
+	stats.UsedBytes = seg.rb.Size() - seg.vacuumLen
+	stats.SlotBytes = int64(cap(seg.slotsData)) * int64(unsafe.Sizeof(entryPtr{}))
+	stats.PinnedBytes = seg.pinnedLen
+	stats.DeletedBytes = atomic.LoadInt64(&seg.deletedLen)
+	return
+}
@@ -0,0 +1,35 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	stats := cache.MemoryUsage()
+	if stats.BufferBytes != 1024*1024 || stats.UsedBytes != 0 || stats.DeletedBytes != 0 || len(stats.Segments) != segmentCount {
+		t.Fatalf("stats = %+v", stats.SegmentMemStats)
+	}
+	if stats.SlotBytes != segmentCount*256*16 {
+		t.Fatalf("slot bytes = %d", stats.SlotBytes)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value"), 0)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Del([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	entryLen := int64(ENTRY_HDR_SIZE + 6 + 5)
+	stats = cache.MemoryUsage()
+	if stats.UsedBytes != 1000*entryLen || stats.DeletedBytes != 100*entryLen {
+		t.Fatalf("stats = %+v", stats.SegmentMemStats)
+	}
+	var used int64
+	for _, s := range stats.Segments {
+		used += s.UsedBytes
+	}
+	if used != stats.UsedBytes {
+		t.Fatalf("used bytes of segments = %d, want %d", used, stats.UsedBytes)
+	}
+}
@@ -0,0 +1,88 @@
+// Package metrics exports the statistics of a freecache.Cache to Prometheus.
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/coocood/freecache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector of the statistics of a cache. The counters are reset by
+// Cache.ResetStatistics, which Prometheus handles as a counter reset.
+type Collector struct {
+	cache *freecache.Cache
+
+	hits, misses, evacuations, expirations, overwrites, touched, rejected *prometheus.Desc
+	hitRate, entries, bufferBytes, usedBytes, deletedBytes                *prometheus.Desc
+	segmentUsedBytes, segmentDeletedBytes                                 *prometheus.Desc
+}
+
+// NewCollector returns a collector of the statistics of cache, the names of the metrics start with
+// namespace, "freecache" if it is empty. The memory usage is collected by Cache.MemoryUsage, which
+// walks all the entries of the cache on every scrape.
+func NewCollector(namespace string, cache *freecache.Cache) *Collector {
+	if namespace == "" {
+		namespace = "freecache"
+	}
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	return &Collector{
+		cache:               cache,
+		hits:                desc("hits_total", "Number of lookups that found the key."),
+		misses:              desc("misses_total", "Number of lookups that didn't find the key."),
+		evacuations:         desc("evacuations_total", "Number of entries evicted to make room for new entries."),
+		expirations:         desc("expirations_total", "Number of entries removed because they expired."),
+		overwrites:          desc("overwrites_total", "Number of entries overwritten by a set."),
+		touched:             desc("touched_total", "Number of entries whose expiration was updated by Touch."),
+		rejected:            desc("rejected_total", "Number of new entries not admitted by the TinyLFU filter."),
+		hitRate:             desc("hit_rate", "Ratio of hits to lookups."),
+		entries:             desc("entries", "Number of entries in the cache."),
+		bufferBytes:         desc("buffer_bytes", "Size of the ring buffers."),
+		usedBytes:           desc("used_bytes", "Bytes of the ring buffers holding entries, including deleted entries."),
+		deletedBytes:        desc("deleted_bytes", "Bytes of deleted entries not reclaimed yet."),
+		segmentUsedBytes:    desc("segment_used_bytes", "Bytes of the ring buffer of a segment holding entries.", "segment"),
+		segmentDeletedBytes: desc("segment_deleted_bytes", "Bytes of deleted entries of a segment.", "segment"),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.hits, c.misses, c.evacuations, c.expirations, c.overwrites, c.touched, c.rejected,
+		c.hitRate, c.entries, c.bufferBytes, c.usedBytes, c.deletedBytes,
+		c.segmentUsedBytes, c.segmentDeletedBytes,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	counter := func(d *prometheus.Desc, v int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+	gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+	}
+	stats := c.cache.Stats()
+	counter(c.hits, stats.HitCount)
+	counter(c.misses, stats.MissCount)
+	counter(c.evacuations, stats.EvacuateCount)
+	counter(c.expirations, stats.ExpiredCount)
+	counter(c.overwrites, stats.OverwriteCount)
+	counter(c.touched, stats.TouchedCount)
+	counter(c.rejected, stats.RejectedCount)
+	gauge(c.hitRate, stats.HitRate())
+	gauge(c.entries, float64(stats.EntryCount))
+	mem := c.cache.MemoryUsage()
+	gauge(c.bufferBytes, float64(mem.BufferBytes))
+	gauge(c.usedBytes, float64(mem.UsedBytes))
+	gauge(c.deletedBytes, float64(mem.DeletedBytes))
+	for i, seg := range mem.Segments {
+		segment := strconv.Itoa(i)
+		gauge(c.segmentUsedBytes, float64(seg.UsedBytes), segment)
+		gauge(c.segmentDeletedBytes, float64(seg.DeletedBytes), segment)
+	}
+}
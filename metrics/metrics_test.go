@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coocood/freecache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Set([]byte("a"), []byte("2"), 0)
+	cache.Get([]byte("a"))
+	cache.Get([]byte("b"))
+	cache.Touch([]byte("a"), 10)
+
+	c := NewCollector("", cache)
+	expected := `
+# HELP freecache_entries Number of entries in the cache.
+# TYPE freecache_entries gauge
+freecache_entries 1
+# HELP freecache_hit_rate Ratio of hits to lookups.
+# TYPE freecache_hit_rate gauge
+freecache_hit_rate 0.5
+# HELP freecache_hits_total Number of lookups that found the key.
+# TYPE freecache_hits_total counter
+freecache_hits_total 1
+# HELP freecache_misses_total Number of lookups that didn't find the key.
+# TYPE freecache_misses_total counter
+freecache_misses_total 1
+# HELP freecache_overwrites_total Number of entries overwritten by a set.
+# TYPE freecache_overwrites_total counter
+freecache_overwrites_total 1
+# HELP freecache_touched_total Number of entries whose expiration was updated by Touch.
+# TYPE freecache_touched_total counter
+freecache_touched_total 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"freecache_entries", "freecache_hit_rate", "freecache_hits_total", "freecache_misses_total",
+		"freecache_overwrites_total", "freecache_touched_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 12 metrics plus 2 per segment.
+	if n := testutil.CollectAndCount(c); n != 12+2*256 {
+		t.Fatalf("collected %d metrics", n)
+	}
+	if err := prometheus.NewPedanticRegistry().Register(c); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,165 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+var ErrMmapUnsupported = errors.New("Memory mapped file is not supported on this system")
+
+const (
+	mmapMagic   = "FCMM"
+	mmapVersion = 1
+	// mmapStateOff is the offset of the ring buffer states, one per segment after the file header.
+	mmapStateOff  = 4096
+	mmapStateSize = 32
+	// mmapDataOff is the offset of the ring buffer data, a segment after another.
+	mmapDataOff = mmapStateOff + segmentCount*mmapStateSize
+)
+
+// mmapFile is the file of WithMmapFile. It starts with a header of magic, version uint32, segment size uint64,
+// hash seed uint64, a clean flag uint8, which is set by Close and cleared by OpenCache, so the entries of a
+// file not closed properly are dropped, and a checksums flag uint8 set by WithChecksums. Then come the states of the ring buffers, begin, end, index and the
+// vacuum length of the segment as int64, and the data of the ring buffers. All integers are little endian.
+type mmapFile struct {
+	file *os.File
+	data []byte
+}
+
+// openMmap maps the file at path and creates the segments on it. If the file has the entries of a cache
+// closed properly, the ring buffers are restored and the hash seed of the file is used.
+func (cache *Cache) openMmap(path string, segSize int, timer Timer, fixedHashSeed, checksums bool) (err error) {
+	fileSize := int64(mmapDataOff) + int64(segSize)*segmentCount
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return
+	}
+	valid := info.Size() == fileSize
+	if !valid {
+		if err = f.Truncate(fileSize); err != nil {
+			f.Close()
+			return
+		}
+	}
+	data, err := mmap(f, int(fileSize))
+	if err != nil {
+		f.Close()
+		return
+	}
+	cache.mapped = &mmapFile{file: f, data: data}
+
+	hdr := data[:mmapStateOff]
+	valid = valid && string(hdr[:4]) == mmapMagic &&
+		binary.LittleEndian.Uint32(hdr[4:8]) == mmapVersion &&
+		binary.LittleEndian.Uint64(hdr[8:16]) == uint64(segSize) &&
+		hdr[24] == 1 &&
+		(hdr[25] == 1) == checksums
+	if valid && fixedHashSeed {
+		valid = binary.LittleEndian.Uint64(hdr[16:24]) == cache.hashSeed
+	}
+	if valid {
+		cache.hashSeed = binary.LittleEndian.Uint64(hdr[16:24])
+	}
+	copy(hdr[:4], mmapMagic)
+	binary.LittleEndian.PutUint32(hdr[4:8], mmapVersion)
+	binary.LittleEndian.PutUint64(hdr[8:16], uint64(segSize))
+	binary.LittleEndian.PutUint64(hdr[16:24], cache.hashSeed)
+	hdr[24] = 0
+	hdr[25] = 0
+	if checksums {
+		hdr[25] = 1
+	}
+
+	for i := 0; i < segmentCount; i++ {
+		off := mmapDataOff + i*segSize
+		seg := &cache.segments[i]
+		*seg = newSegmentBuf(data[off:off+segSize:off+segSize], i, timer)
+		seg.checksums = checksums
+		if valid {
+			state := data[mmapStateOff+i*mmapStateSize:]
+			seg.restoreRing(
+				int64(binary.LittleEndian.Uint64(state[0:8])),
+				int64(binary.LittleEndian.Uint64(state[8:16])),
+				int64(binary.LittleEndian.Uint64(state[16:24])),
+				int64(binary.LittleEndian.Uint64(state[24:32])),
+			)
+		}
+	}
+	return
+}
+
+// closeMmap saves the states of the ring buffers, releases the segments and unmaps the file. The states are
+// saved and the segments released with all the segment locks held, so no operation reads or writes the
+// file while it is unmapped, the operations after it return ErrClosed.
+func (cache *Cache) closeMmap() {
+	data := cache.mapped.data
+	for i := range cache.locks {
+		cache.locks[i].Lock()
+	}
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		state := data[mmapStateOff+i*mmapStateSize:]
+		binary.LittleEndian.PutUint64(state[0:8], uint64(seg.rb.begin))
+		binary.LittleEndian.PutUint64(state[8:16], uint64(seg.rb.end))
+		binary.LittleEndian.PutUint64(state[16:24], uint64(seg.rb.index))
+		binary.LittleEndian.PutUint64(state[24:32], uint64(seg.vacuumLen))
+		seg.release()
+	}
+	data[24] = 1
+	for i := range cache.locks {
+		cache.locks[i].Unlock()
+	}
+	munmap(data)
+	cache.mapped.file.Sync()
+	cache.mapped.file.Close()
+	cache.mapped.data = nil
+}
+
+// restoreRing sets the state of the ring buffer saved by closeMmap and rebuilds the slots
+// from the entry headers in the ring buffer.
+func (seg *segment) restoreRing(begin, end, index, vacuumLen int64) {
+	size := seg.rb.Size()
+	if begin < 0 || end < begin || end-begin > size || index < 0 || index >= size ||
+		vacuumLen < 0 || vacuumLen > size || end+vacuumLen-size < begin {
+		return
+	}
+	seg.rb.begin, seg.rb.end, seg.rb.index = begin, end, int(index)
+	seg.vacuumLen = vacuumLen
+	nowMs := seg.timer.NowMilli()
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	var key []byte
+	for off := end + vacuumLen - size; off < end; {
+		seg.rb.ReadAt(hdrBuf[:], off)
+		hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+		entryLen := seg.entryLen(hdr)
+		atomic.AddInt64(&seg.totalTime, int64(hdr.accessTime))
+		atomic.AddInt64(&seg.totalCount, 1)
+		if hdr.flags&flagDeleted == 0 {
+			if cap(key) < int(hdr.keyLen) {
+				key = make([]byte, hdr.keyLen)
+			}
+			key = key[:hdr.keyLen]
+			seg.rb.ReadAt(key, off+ENTRY_HDR_SIZE)
+			slot := seg.getSlot(hdr.slotId)
+			idx, _ := seg.lookup(slot, hdr.hash16, key)
+			seg.insertEntryPtr(hdr.slotId, hdr.hash16, off, idx, hdr.keyLen, ttlSeconds(nowMs, hdr.expireAtMilli()))
+			atomic.AddInt64(&seg.totalCost, seg.entryCost(hdr, off))
+			if hdr.flags&flagPinned != 0 {
+				seg.pinnedLen += entryLen
+				seg.pinnedCount++
+			}
+			atomic.AddInt64(&seg.nsUsed[hdr.flags>>nsShift], entryLen)
+		} else {
+			atomic.AddInt64(&seg.deletedLen, entryLen)
+		}
+		off += entryLen
+	}
+}
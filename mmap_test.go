@@ -0,0 +1,170 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package freecache
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestMmapFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	cache, err := OpenCache(1024*1024, WithMmapFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	cache.Set([]byte("expire"), []byte("v"), 100)
+	cache.Del([]byte("key19999"))
+	count := cache.EntryCount()
+	cache.Close()
+
+	cache, err = OpenCache(1024*1024, WithMmapFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.EntryCount() != count {
+		t.Fatalf("entry count = %d, want %d", cache.EntryCount(), count)
+	}
+	if v, err := cache.Get([]byte("key19998")); err != nil || string(v) != "value19998" {
+		t.Fatalf("key19998 = %q, %v", v, err)
+	}
+	if _, err := cache.Get([]byte("key19999")); err != ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if ttl, err := cache.TTL([]byte("expire")); err != nil || ttl == 0 || ttl > 100 {
+		t.Fatalf("ttl = %d, %v", ttl, err)
+	}
+	for i := 0; i < 20000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("new%d", i)), 0)
+	}
+	if v, err := cache.Get([]byte("key19999")); err != nil || string(v) != "new19999" {
+		t.Fatalf("key19999 = %q, %v", v, err)
+	}
+
+	// the file is in use and wasn't closed, so its entries are dropped.
+	unclean, err := OpenCache(1024*1024, WithMmapFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unclean.EntryCount() != 0 {
+		t.Fatalf("entry count = %d, want 0", unclean.EntryCount())
+	}
+	unclean.Close()
+	cache.Close()
+
+	resized, err := OpenCache(2*1024*1024, WithMmapFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resized.Close()
+	if resized.EntryCount() != 0 {
+		t.Fatalf("entry count = %d, want 0", resized.EntryCount())
+	}
+}
+
+func TestMmapFileConcurrentClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	cache, err := OpenCache(1024*1024, WithMmapFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg, started sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		started.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			// Close waits for every goroutine to run 100 operations, or to stop on an error.
+			ready := false
+			defer func() {
+				if !ready {
+					started.Done()
+				}
+			}()
+			for i := 0; ; i++ {
+				key := []byte(fmt.Sprintf("key%d", (g*1000+i)%5000))
+				var err error
+				if g%2 == 0 {
+					err = cache.Set(key, []byte("value"), 0)
+				} else if _, err = cache.Get(key); err == ErrNotFound {
+					err = nil
+				}
+				if err == ErrClosed {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if i == 100 {
+					ready = true
+					started.Done()
+				}
+			}
+		}(g)
+	}
+	started.Wait()
+	cache.Close()
+	wg.Wait()
+	if err := cache.Set([]byte("key"), []byte("value"), 0); err != ErrClosed {
+		t.Fatalf("Set after Close err = %v", err)
+	}
+	if _, err := cache.Get([]byte("key0")); err != ErrClosed {
+		t.Fatalf("Get after Close err = %v", err)
+	}
+	if cache.EntryCount() != 0 {
+		t.Fatalf("entry count after Close = %d", cache.EntryCount())
+	}
+
+	cache, err = OpenCache(1024*1024, WithMmapFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if cache.EntryCount() == 0 {
+		t.Fatal("no entry kept by Close")
+	}
+	if errs := cache.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestMmapFileError(t *testing.T) {
+	if _, err := OpenCache(1024*1024, WithMmapFile(filepath.Join(t.TempDir(), "missing", "cache"))); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMmapFileChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	cache, err := OpenCache(1024*1024, WithMmapFile(path), WithChecksums(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set([]byte("a"), []byte("v"), 0)
+	cache.Close()
+
+	cache, err = OpenCache(1024*1024, WithMmapFile(path), WithChecksums(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.Get([]byte("a")); err != nil || string(v) != "v" {
+		t.Fatalf("a = %q, %v", v, err)
+	}
+	cache.Close()
+
+	// the entries of a file written with another checksums setting are dropped.
+	cache, err = OpenCache(1024*1024, WithMmapFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if cache.EntryCount() != 0 {
+		t.Fatalf("entry count = %d, want 0", cache.EntryCount())
+	}
+}
@@ -0,0 +1,229 @@
+package freecache
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var ErrTooManyQuotas = errors.New("The cache has too many namespaces with quota")
+
+// Namespace is a view of a cache whose keys are prefixed by the prefix of the namespace,
+// so modules sharing a cache can't collide and can be cleared separately. It has its own
+// hit and miss statistics.
+type Namespace struct {
+	cache     *Cache
+	prefix    []byte
+	id        uint8 // id of a namespace with quota, 0 means no quota.
+	quota     int64
+	hitCount  int64
+	missCount int64
+}
+
+// Namespace returns a view of the cache whose keys are prefixed by prefix. A namespace doesn't
+// own any memory, so namespaces with the same prefix share their entries but not their statistics.
+// A prefix should not be a prefix of another one, or Clear would delete the entries of both.
+func (cache *Cache) Namespace(prefix []byte) *Namespace {
+	return &Namespace{
+		cache:  cache,
+		prefix: append([]byte(nil), prefix...),
+	}
+}
+
+// NamespaceWithQuota is like Namespace, but the entries set by the namespace can only use quotaBytes
+// of the cache. When the namespace is full, its own oldest entries are evicted first, so it can't evict
+// the entries of others. The quota is enforced per segment as quotaBytes/256, at least 1, and entries
+// larger than that return ErrQuotaExceeded. A cache can have up to 15 namespaces with quota,
+// ErrTooManyQuotas is returned for more.
+func (cache *Cache) NamespaceWithQuota(prefix []byte, quotaBytes int) (*Namespace, error) {
+	cache.nsMu.Lock()
+	defer cache.nsMu.Unlock()
+	if cache.nsQuotas == maxQuotaNamespaces {
+		return nil, ErrTooManyQuotas
+	}
+	cache.nsQuotas++
+	ns := cache.Namespace(prefix)
+	ns.id = cache.nsQuotas
+	ns.quota = int64(quotaBytes)
+	segQuota := int64(quotaBytes / segmentCount)
+	if segQuota <= 0 {
+		segQuota = 1
+	}
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		cache.segments[i].nsQuota[ns.id] = segQuota
+		cache.locks[i].Unlock()
+	}
+	return ns, nil
+}
+
+// Quota returns the quota of the namespace in bytes, 0 means no quota.
+func (ns *Namespace) Quota() int64 {
+	return ns.quota
+}
+
+// UsedBytes returns the bytes used by the entries set by the namespace, it is only tracked
+// for a namespace with quota.
+func (ns *Namespace) UsedBytes() (used int64) {
+	if ns.id == 0 {
+		return 0
+	}
+	for i := range ns.cache.segments {
+		used += atomic.LoadInt64(&ns.cache.segments[i].nsUsed[ns.id])
+	}
+	return
+}
+
+// Prefix returns the prefix of the namespace.
+func (ns *Namespace) Prefix() []byte {
+	return ns.prefix
+}
+
+// key returns the prefixed key in buf, which avoids an allocation for short keys.
+func (ns *Namespace) key(buf []byte, key []byte) []byte {
+	return append(append(buf, ns.prefix...), key...)
+}
+
+func (ns *Namespace) count(err error) {
+	if err == nil {
+		atomic.AddInt64(&ns.hitCount, 1)
+	} else {
+		atomic.AddInt64(&ns.missCount, 1)
+	}
+}
+
+// Set is like Cache.Set in the namespace.
+func (ns *Namespace) Set(key, value []byte, expireSeconds int) (err error) {
+	var buf [128]byte
+	start := ns.cache.now()
+	err = ns.cache.setValue(ns.key(buf[:0], key), value, time.Duration(expireSeconds)*time.Second, ns.id<<nsShift, 1)
+	ns.cache.observe(OpSet, start, err)
+	return
+}
+
+// SetWithDuration is like Cache.SetWithDuration in the namespace.
+func (ns *Namespace) SetWithDuration(key, value []byte, ttl time.Duration) error {
+	var buf [128]byte
+	return ns.cache.setValue(ns.key(buf[:0], key), value, ttl, ns.id<<nsShift, 1)
+}
+
+// Get is like Cache.Get in the namespace.
+func (ns *Namespace) Get(key []byte) (value []byte, err error) {
+	var buf [128]byte
+	value, err = ns.cache.Get(ns.key(buf[:0], key))
+	ns.count(err)
+	return
+}
+
+// GetWithBuf is like Cache.GetWithBuf in the namespace.
+func (ns *Namespace) GetWithBuf(key, buf []byte) (value []byte, err error) {
+	var keyBuf [128]byte
+	value, err = ns.cache.GetWithBuf(ns.key(keyBuf[:0], key), buf)
+	ns.count(err)
+	return
+}
+
+// Peek is like Cache.Peek in the namespace, it doesn't update the statistics.
+func (ns *Namespace) Peek(key []byte) ([]byte, error) {
+	var buf [128]byte
+	return ns.cache.Peek(ns.key(buf[:0], key))
+}
+
+// Has is like Cache.Has in the namespace.
+func (ns *Namespace) Has(key []byte) bool {
+	var buf [128]byte
+	return ns.cache.Has(ns.key(buf[:0], key))
+}
+
+// TTL is like Cache.TTL in the namespace.
+func (ns *Namespace) TTL(key []byte) (uint32, error) {
+	var buf [128]byte
+	return ns.cache.TTL(ns.key(buf[:0], key))
+}
+
+// Touch is like Cache.Touch in the namespace.
+func (ns *Namespace) Touch(key []byte, expireSeconds int) error {
+	var buf [128]byte
+	return ns.cache.Touch(ns.key(buf[:0], key), expireSeconds)
+}
+
+// Del is like Cache.Del in the namespace.
+func (ns *Namespace) Del(key []byte) bool {
+	var buf [128]byte
+	return ns.cache.Del(ns.key(buf[:0], key))
+}
+
+// Clear deletes all entries of the namespace and returns the number of deleted entries.
+// It walks the index of every segment, locking one segment at a time. The deletes are not
+// written to the stores or the log of the cache.
+func (ns *Namespace) Clear() (count int) {
+	for i := range ns.cache.segments {
+		ns.cache.locks[i].Lock()
+		count += ns.cache.segments[i].delPrefix(ns.prefix)
+		ns.cache.locks[i].Unlock()
+	}
+	return
+}
+
+// HitCount is the number of successful lookups in the namespace.
+func (ns *Namespace) HitCount() int64 {
+	return atomic.LoadInt64(&ns.hitCount)
+}
+
+// MissCount is the number of failed lookups in the namespace.
+func (ns *Namespace) MissCount() int64 {
+	return atomic.LoadInt64(&ns.missCount)
+}
+
+// HitRate is the ratio of hits over lookups in the namespace.
+func (ns *Namespace) HitRate() float64 {
+	hitCount, missCount := ns.HitCount(), ns.MissCount()
+	if hitCount+missCount == 0 {
+		return 0
+	}
+	return float64(hitCount) / float64(hitCount+missCount)
+}
+
+// ResetStatistics resets the statistics of the namespace.
+func (ns *Namespace) ResetStatistics() {
+	atomic.StoreInt64(&ns.hitCount, 0)
+	atomic.StoreInt64(&ns.missCount, 0)
+}
+
+// delPrefix deletes the entries whose key starts with prefix.
+func (seg *segment) delPrefix(prefix []byte) (count int) {
+	seg.applyClear()
+	var keyBuf [128]byte
+	keyPrefix := keyBuf[:0]
+	if len(prefix) > len(keyBuf) {
+		keyPrefix = make([]byte, 0, len(prefix))
+	}
+	keyPrefix = keyPrefix[:len(prefix)]
+	for slotId := 0; slotId < 256; slotId++ {
+		slot := seg.getSlot(uint8(slotId))
+		for idx := len(slot) - 1; idx >= 0; idx-- {
+			ptr := &slot[idx]
+			if int(ptr.keyLen) < len(prefix) {
+				continue
+			}
+			seg.rb.ReadAt(keyPrefix, ptr.offset+ENTRY_HDR_SIZE)
+			if bytes.Equal(keyPrefix, prefix) {
+				if seg.events.enabled() || seg.writes.enabled() {
+					key := make([]byte, ptr.keyLen)
+					seg.rb.ReadAt(key, ptr.offset+ENTRY_HDR_SIZE)
+					if seg.events.enabled() {
+						seg.events.emit(EventDel, key, nil)
+					}
+					if seg.writes.enabled() {
+						seg.writes.del(key)
+					}
+				}
+				seg.delEntryPtr(uint8(slotId), slot, idx)
+				atomic.AddInt64(&seg.deleted, 1)
+				count++
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package freecache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	users := cache.Namespace([]byte("users:"))
+	orders := cache.Namespace([]byte("orders:"))
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%d", i))
+		users.Set(key, []byte("user"), 0)
+		orders.Set(key, []byte("order"), 0)
+	}
+	if cache.EntryCount() != 200 {
+		t.Fatalf("entry count = %d, want 200", cache.EntryCount())
+	}
+	if v, err := cache.Get([]byte("users:1")); err != nil || string(v) != "user" {
+		t.Fatalf("users:1 = %q, %v", v, err)
+	}
+	if v, err := orders.Get([]byte("1")); err != nil || string(v) != "order" {
+		t.Fatalf("orders 1 = %q, %v", v, err)
+	}
+	if _, err := orders.Get([]byte("100")); err != ErrNotFound {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if orders.HitCount() != 1 || orders.MissCount() != 1 || users.HitCount() != 0 || orders.HitRate() != 0.5 {
+		t.Fatalf("hits = %d, misses = %d, user hits = %d", orders.HitCount(), orders.MissCount(), users.HitCount())
+	}
+	if !orders.Del([]byte("1")) || orders.Has([]byte("1")) {
+		t.Fatal("orders 1 not deleted")
+	}
+
+	if n := users.Clear(); n != 100 {
+		t.Fatalf("cleared %d, want 100", n)
+	}
+	if cache.EntryCount() != 99 || users.Has([]byte("2")) || !orders.Has([]byte("2")) {
+		t.Fatalf("entry count = %d after clear", cache.EntryCount())
+	}
+
+	long := cache.Namespace(make([]byte, 200))
+	long.Set(make([]byte, 100), []byte("v"), 0)
+	if v, err := long.Get(make([]byte, 100)); err != nil || string(v) != "v" {
+		t.Fatalf("long = %q, %v", v, err)
+	}
+	if long.Clear() != 1 {
+		t.Fatal("long prefix not cleared")
+	}
+}
+
+func TestNamespaceAllocs(t *testing.T) {
+	ns := NewCache(1024 * 1024).Namespace([]byte("ns:"))
+	key, value, buf := []byte("key"), []byte("value"), make([]byte, 16)
+	allocs := testing.AllocsPerRun(100, func() {
+		ns.Set(key, value, 0)
+		ns.GetWithBuf(key, buf)
+		ns.Del(key)
+	})
+	if allocs != 0 {
+		t.Fatalf("allocs = %v, want 0", allocs)
+	}
+}
+
+func TestNamespaceSetLarge(t *testing.T) {
+	obs := &testObserver{ops: map[Op]int{}, errs: map[Op]int{}}
+	cache := NewCacheWithOptions(4*1024*1024, WithLargeValues(), WithObserver(obs))
+	users := cache.Namespace([]byte("users:"))
+	large := bytes.Repeat([]byte("0123456789"), 20000)
+	if err := users.Set([]byte("1"), large, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := users.Get([]byte("1")); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("users 1 = %d bytes, %v", len(v), err)
+	}
+	if obs.ops[OpSet] != 1 || obs.errs[OpSet] != 0 {
+		t.Fatalf("ops = %v, errs = %v", obs.ops, obs.errs)
+	}
+	// the chunks are in the namespace too, and are cleared with the manifest.
+	if n := users.Clear(); n < 2 || cache.EntryCount() != 0 {
+		t.Fatalf("cleared %d, %d entries left", n, cache.EntryCount())
+	}
+}
+
+func TestNamespaceWithQuota(t *testing.T) {
+	var evicted int
+	cache := NewCacheWithOptions(1024*1024, WithOnEvicted(func(key, value []byte, expireSeconds int) {
+		evicted++
+	}))
+	others := cache.Namespace([]byte("others:"))
+	for i := 0; i < 1000; i++ {
+		others.Set([]byte(fmt.Sprintf("%d", i)), make([]byte, 100), 0)
+	}
+	tenant, err := cache.NamespaceWithQuota([]byte("tenant:"), 100*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20000; i++ {
+		if err := tenant.Set([]byte(fmt.Sprintf("%d", i)), make([]byte, 100), 0); err != nil {
+			t.Fatal(err)
+		}
+		if used := tenant.UsedBytes(); used > tenant.Quota() {
+			t.Fatalf("used bytes = %d after %d sets", used, i+1)
+		}
+	}
+	if evicted == 0 {
+		t.Fatal("tenant entries were not evicted")
+	}
+	for i := 0; i < 1000; i++ {
+		if !others.Has([]byte(fmt.Sprintf("%d", i))) {
+			t.Fatalf("others %d evicted by the tenant", i)
+		}
+	}
+	if !tenant.Has([]byte("19999")) {
+		t.Fatal("newest tenant entry missing")
+	}
+	if err := tenant.Set([]byte("large"), make([]byte, 1000), 0); err != ErrQuotaExceeded {
+		t.Fatalf("err = %v, want ErrQuotaExceeded", err)
+	}
+	tenant.Clear()
+	if tenant.UsedBytes() != 0 {
+		t.Fatalf("used bytes = %d after clear", tenant.UsedBytes())
+	}
+	for i := 1; i < maxQuotaNamespaces; i++ {
+		if _, err := cache.NamespaceWithQuota([]byte(fmt.Sprintf("ns%d:", i)), 1024*1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cache.NamespaceWithQuota([]byte("more:"), 1024*1024); err != ErrTooManyQuotas {
+		t.Fatalf("err = %v, want ErrTooManyQuotas", err)
+	}
+}
@@ -0,0 +1,54 @@
+package freecache
+
+import (
+	"time"
+)
+
+// Op is an operation of the cache reported to an Observer.
+type Op uint8
+
+const (
+	OpGet Op = iota
+	OpSet
+	OpDel
+	OpTouch
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "get"
+	case OpSet:
+		return "set"
+	case OpDel:
+		return "del"
+	case OpTouch:
+		return "touch"
+	}
+	return "unknown"
+}
+
+// Observer is notified of the operations and evictions of a cache, see WithObserver.
+type Observer interface {
+	// ObserveOp is called after Get, Set, Del and Touch with the duration and the error of the operation.
+	// A Get that loads a missing key includes the load. Del reports ErrNotFound if the key is missing.
+	ObserveOp(op Op, d time.Duration, err error)
+	// ObserveEviction is called for every entry evicted before it expires, with the segment lock held,
+	// so it must not call the cache.
+	ObserveEviction()
+}
+
+// observe reports an operation started at start to the observer of the cache, if any.
+func (cache *Cache) observe(op Op, start time.Time, err error) {
+	if cache.observer != nil {
+		cache.observer.ObserveOp(op, time.Since(start), err)
+	}
+}
+
+// now returns the start time of an observed operation, or the zero time without observer.
+func (cache *Cache) now() time.Time {
+	if cache.observer != nil {
+		return time.Now()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,52 @@
+package freecache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testObserver struct {
+	ops       map[Op]int
+	errs      map[Op]int
+	evictions int
+}
+
+func (o *testObserver) ObserveOp(op Op, d time.Duration, err error) {
+	o.ops[op]++
+	if err != nil {
+		o.errs[op]++
+	}
+}
+
+func (o *testObserver) ObserveEviction() {
+	o.evictions++
+}
+
+func TestObserver(t *testing.T) {
+	obs := &testObserver{ops: map[Op]int{}, errs: map[Op]int{}}
+	cache := NewCacheWithOptions(512*1024, WithObserver(obs))
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Get([]byte("a"))
+	cache.Get([]byte("b"))
+	cache.Touch([]byte("a"), 10)
+	cache.Del([]byte("a"))
+	cache.Del([]byte("a"))
+	if obs.ops[OpSet] != 1 || obs.ops[OpGet] != 2 || obs.ops[OpTouch] != 1 || obs.ops[OpDel] != 2 {
+		t.Fatalf("ops = %v", obs.ops)
+	}
+	if obs.errs[OpGet] != 1 || obs.errs[OpDel] != 1 || obs.errs[OpSet] != 0 {
+		t.Fatalf("errs = %v", obs.errs)
+	}
+	value := make([]byte, 200)
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(strconv.Itoa(i)), value, 0)
+	}
+	// the evacuate count includes the entries moved to the end of the ring buffer.
+	if obs.evictions == 0 || int64(obs.evictions) > cache.EvacuateCount() {
+		t.Fatalf("evictions = %d, evacuate count = %d", obs.evictions, cache.EvacuateCount())
+	}
+	if OpTouch.String() != "touch" {
+		t.Fatal(OpTouch.String())
+	}
+}
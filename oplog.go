@@ -0,0 +1,198 @@
+package freecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrCorruptedLog = errors.New("Corrupted operation log")
+
+// SyncPolicy decides when the operations of WithOpLog are written and synced.
+type SyncPolicy int
+
+const (
+	// SyncNever writes the buffered operations every interval, without syncing.
+	SyncNever SyncPolicy = iota
+	// SyncInterval writes and syncs the buffered operations every interval.
+	SyncInterval
+	// SyncAlways writes and syncs every operation before Set or Del returns,
+	// Set returns the write error.
+	SyncAlways
+)
+
+const (
+	opSet byte = 1
+	opDel byte = 2
+	// opRecordHdrSize is the size of op, keyLen, valLen and expireAtMs of a record.
+	opRecordHdrSize = 17
+)
+
+type syncer interface {
+	Sync() error
+}
+
+// opLog appends the operations of the cache to a writer. A record is op uint8, keyLen uint32,
+// valLen uint32, expireAtMs int64 (0 means no expire), key, value and the CRC32 of all previous
+// bytes of the record. All integers are little endian.
+type opLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	bw     *bufio.Writer
+	policy SyncPolicy
+	err    error // the last write error, returned by flush.
+}
+
+func newOpLog(w io.Writer, policy SyncPolicy) *opLog {
+	return &opLog{
+		w:      w,
+		bw:     bufio.NewWriter(w),
+		policy: policy,
+	}
+}
+
+func (l *opLog) set(key, value []byte, nowMs, expireAtMs int64) error {
+	return l.append(opSet, key, value, expireAtMs)
+}
+
+func (l *opLog) del(key []byte) error {
+	return l.append(opDel, key, nil, 0)
+}
+
+func (l *opLog) append(op byte, key, value []byte, expireAtMs int64) (err error) {
+	var hdr [opRecordHdrSize]byte
+	hdr[0] = op
+	binary.LittleEndian.PutUint32(hdr[1:5], uint32(len(key)))
+	binary.LittleEndian.PutUint32(hdr[5:9], uint32(len(value)))
+	binary.LittleEndian.PutUint64(hdr[9:17], uint64(expireAtMs))
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[:])
+	crc.Write(key)
+	crc.Write(value)
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bw.Write(hdr[:])
+	l.bw.Write(key)
+	l.bw.Write(value)
+	l.bw.Write(sum[:])
+	if l.policy == SyncAlways {
+		return l.flushLocked()
+	}
+	return nil
+}
+
+func (l *opLog) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flushLocked()
+}
+
+func (l *opLog) flushLocked() (err error) {
+	if err = l.bw.Flush(); err == nil && l.policy != SyncNever {
+		if s, ok := l.w.(syncer); ok {
+			err = s.Sync()
+		}
+	}
+	if err != nil {
+		l.err = err
+		// a bufio.Writer keeps failing after an error, start over with the next operations.
+		l.bw.Reset(l.w)
+	}
+	return
+}
+
+func (l *opLog) run(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			l.flush()
+			return
+		case <-ticker.C:
+			l.flush()
+		}
+	}
+}
+
+// Replay applies the operations of a log written by WithOpLog to the cache, entries already expired
+// are skipped. The replayed operations are not written to the log of the cache, except the values too
+// large for an entry, which are set like Set to be split by WithLargeValues or delegated by WithOverflowStore. A truncated last record,
+// e.g. from a crash while writing, ends the replay without error, ErrCorruptedLog is returned if a
+// record is invalid.
+func (cache *Cache) Replay(r io.Reader) (err error) {
+	br := bufio.NewReader(r)
+	var hdr [opRecordHdrSize]byte
+	var data []byte
+	for {
+		if _, err = io.ReadFull(br, hdr[:]); err != nil {
+			return replayErr(err)
+		}
+		op := hdr[0]
+		keyLen := binary.LittleEndian.Uint32(hdr[1:5])
+		valLen := binary.LittleEndian.Uint32(hdr[5:9])
+		expireAtMs := int64(binary.LittleEndian.Uint64(hdr[9:17]))
+		if (op != opSet && op != opDel) || keyLen > 65535 || valLen > 1<<30 {
+			return ErrCorruptedLog
+		}
+		recordLen := int(keyLen+valLen) + 4
+		if cap(data) < recordLen {
+			data = make([]byte, recordLen)
+		}
+		data = data[:recordLen]
+		if _, err = io.ReadFull(br, data); err != nil {
+			return replayErr(err)
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(hdr[:])
+		crc.Write(data[:recordLen-4])
+		if crc.Sum32() != binary.LittleEndian.Uint32(data[recordLen-4:]) {
+			return ErrCorruptedLog
+		}
+		key, value := data[:keyLen], data[keyLen:keyLen+valLen]
+		if op == opDel {
+			cache.replayDel(key)
+			continue
+		}
+		ttl := time.Duration(0)
+		if expireAtMs != 0 {
+			nowMs := cache.segments[0].timer.NowMilli()
+			if isExpired(expireAtMs, nowMs) {
+				// the key may have been set earlier in the log.
+				cache.replayDel(key)
+				continue
+			}
+			ttl = time.Duration(expireAtMs-nowMs) * time.Millisecond
+		}
+		if cache.restore(key, value, ttl, 0, 1, 0) == ErrLargeEntry && (cache.largeValues || cache.overflow != nil) {
+			// the values split by WithLargeValues or delegated to the OverflowStore are logged whole.
+			cache.Set(key, value, int(ttlSeconds(0, int64(ttl/time.Millisecond))))
+		}
+	}
+}
+
+// replayDel deletes the key without writing it to the log like Del does.
+func (cache *Cache) replayDel(key []byte) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	seg.quiet(func() {
+		seg.del(key, hashVal)
+	})
+	cache.locks[segID].Unlock()
+}
+
+func replayErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
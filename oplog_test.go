@@ -0,0 +1,178 @@
+package freecache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (b *syncBuffer) Sync() error {
+	b.syncs++
+	return nil
+}
+
+func TestOpLogReplay(t *testing.T) {
+	buf := new(syncBuffer)
+	timer := &mockMilliTimer{nowMs: 1000000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithOpLog(buf, SyncAlways, time.Second))
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Set([]byte("b"), []byte("2"), 10)
+	cache.Set([]byte("c"), []byte("3"), 1)
+	cache.Set([]byte("a"), []byte("4"), 0)
+	cache.Del([]byte("b"))
+	cache.Set([]byte("d"), []byte("5"), 100)
+	cache.Close()
+	if buf.syncs != 6 {
+		t.Fatalf("syncs = %d, want 6", buf.syncs)
+	}
+
+	timer.nowMs += 5000
+	restored := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	if err := restored.Replay(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := restored.Get([]byte("a")); err != nil || string(v) != "4" {
+		t.Fatalf("a = %q, %v", v, err)
+	}
+	if _, err := restored.Get([]byte("b")); err != ErrNotFound {
+		t.Fatalf("b err = %v, want ErrNotFound", err)
+	}
+	if _, err := restored.Get([]byte("c")); err != ErrNotFound {
+		t.Fatalf("c err = %v, want ErrNotFound", err)
+	}
+	if ttl, err := restored.TTL([]byte("d")); err != nil || ttl != 95 {
+		t.Fatalf("d ttl = %d, %v, want 95", ttl, err)
+	}
+
+	// a truncated last record is ignored.
+	restored = NewCache(1024 * 1024)
+	if err := restored.Replay(bytes.NewReader(buf.Bytes()[:buf.Len()-3])); err != nil {
+		t.Fatal(err)
+	}
+	if restored.EntryCount() != 1 {
+		t.Fatalf("entry count = %d, want 1", restored.EntryCount())
+	}
+
+	data := append([]byte(nil), buf.Bytes()...)
+	data[opRecordHdrSize] ^= 0xff
+	if err := NewCache(1024 * 1024).Replay(bytes.NewReader(data)); err != ErrCorruptedLog {
+		t.Fatalf("err = %v, want ErrCorruptedLog", err)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestOpLogBuffered(t *testing.T) {
+	buf := new(syncBuffer)
+	cache := NewCacheWithOptions(1024*1024, WithOpLog(buf, SyncNever, time.Hour))
+	cache.Set([]byte("a"), []byte("1"), 0)
+	if buf.Len() != 0 {
+		t.Fatal("operation written before flush")
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() == 0 || buf.syncs != 0 {
+		t.Fatalf("len = %d, syncs = %d", buf.Len(), buf.syncs)
+	}
+	cache.Close()
+
+	cache = NewCacheWithOptions(1024*1024, WithOpLog(failWriter{}, SyncInterval, time.Hour))
+	defer cache.Close()
+	if err := cache.Set([]byte("a"), []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Flush(); err == nil {
+		t.Fatal("expected flush error")
+	}
+
+	if _, err := OpenCache(1024*1024, WithOpLog(buf, SyncInterval, 0)); err != ErrInvalidInterval {
+		t.Fatalf("err = %v, want ErrInvalidInterval", err)
+	}
+}
+
+// assertSameEntries fails if the live entries of got differ from the ones of want, values and TTLs.
+func assertSameEntries(t *testing.T, want, got *Cache) {
+	t.Helper()
+	n := 0
+	it := want.NewIterator()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		n++
+		value, err := got.Peek(entry.Key)
+		if err != nil || !bytes.Equal(value, entry.Value) {
+			t.Fatalf("%s = %q, %v, want %q", entry.Key, value, err, entry.Value)
+		}
+		wantTTL, _ := want.TTL(entry.Key)
+		if ttl, _ := got.TTL(entry.Key); ttl != wantTTL {
+			t.Fatalf("%s ttl = %d, want %d", entry.Key, ttl, wantTTL)
+		}
+	}
+	if count := got.EntryCount(); count != int64(n) {
+		t.Fatalf("entry count = %d, want %d", count, n)
+	}
+}
+
+// applyMutators modifies cache with every kind of mutating method, at nowMs.
+func applyMutators(cache *Cache, nowMs int64) {
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		cache.Set([]byte(key), []byte("1"), 0)
+	}
+	cache.GetSet([]byte("a"), []byte("2"), 10)
+	cache.UpdateE([]byte("b"), func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		return []byte("3"), UpdateReplace, 0, nil
+	})
+	cache.UpdateE([]byte("c"), func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		return nil, UpdateTouch, 20, nil
+	})
+	cache.Pop([]byte("d"))
+	cache.GetEx([]byte("e"), 30)
+	cache.Incr([]byte("f"), 5, 0)
+	cache.Touch([]byte("g"), 40)
+	cache.DelAt([]byte("h"), time.UnixMilli(nowMs+50000))
+	cache.Append([]byte("i"), []byte("x"))
+	cache.SetRange([]byte("j"), 1, []byte("yz"))
+	cache.SetIfAbsent([]byte("k"), []byte("4"), 0)
+	cache.DelMulti([][]byte{[]byte("i"), []byte("z")})
+	cache.TouchMulti([][]byte{[]byte("j")}, 60)
+	cache.UpdateMulti([][]byte{[]byte("l")}, func(idx int, old []byte, found bool) ([]byte, bool, int) {
+		return []byte("5"), true, 0
+	})
+	cache.UpdateInPlace([]byte("f"), func(value []byte) error {
+		value[0] = '7'
+		return nil
+	})
+	cache.SetMulti([]Entry{{Key: []byte("m"), Value: []byte("6")}, {Key: []byte("a"), Value: []byte("7")}})
+}
+
+func TestOpLogMutators(t *testing.T) {
+	buf := new(syncBuffer)
+	timer := &mockMilliTimer{nowMs: 1000000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithOpLog(buf, SyncAlways, time.Second))
+	defer cache.Close()
+	applyMutators(cache, timer.nowMs)
+
+	// a set rejected by the cache isn't logged.
+	n := buf.Len()
+	if err := cache.Set([]byte("large"), make([]byte, 8192), 0); err != ErrLargeEntry {
+		t.Fatalf("err = %v, want ErrLargeEntry", err)
+	}
+	if buf.Len() != n {
+		t.Fatal("rejected set logged")
+	}
+
+	restored := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	if err := restored.Replay(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	assertSameEntries(t, cache, restored)
+}
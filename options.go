@@ -0,0 +1,439 @@
+package freecache
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrInvalidInterval is returned by OpenCache if the interval of an option running in the background isn't positive.
+var ErrInvalidInterval = errors.New("The interval of the option must be positive")
+
+// Option configures a Cache created by NewCacheWithOptions.
+type Option func(*options)
+
+type options struct {
+	timer                    Timer
+	clock                    Clock
+	activeExpirationInterval time.Duration
+	onExpired                func(key []byte)
+	onEvicted                func(key, value []byte, expireSeconds int)
+	hashSeed                 uint64
+	fixedHashSeed            bool
+	codec                    Codec
+	refreshRatio             float64
+	refreshLoader            func(key []byte) (value []byte, expireSeconds int, err error)
+	loader                   Loader
+	writeThroughStore        Store
+	writeBehindStore         Store
+	writeBehindInterval      time.Duration
+	opLog                    io.Writer
+	opLogPolicy              SyncPolicy
+	opLogInterval            time.Duration
+	mmapPath                 string
+	allocator                Allocator
+	maxEntries               int64
+	tinyLFU                  bool
+	maxCost                  int64
+	maxPinned                int64
+	maxEvacuationMoves       int
+	recentAccess             time.Duration
+	evacuationPolicy         EvacuationPolicy
+	segmentSizes             []int
+	largeValues              bool
+	overflowStore            OverflowStore
+	maxListLen               int
+	unsafeGet                bool
+	rebalanceReserve         int64
+	rebalanceInterval        time.Duration
+	hotKeys                  int
+	observer                 Observer
+	hitRateWindow            time.Duration
+	histograms               bool
+	replicationTarget        Replicator
+	replicationQueueSize     int
+	replicationDropPolicy    DropPolicy
+	checksums                bool
+	slidingExpiration        bool
+	defaultTTL               time.Duration
+	maxTTL                   time.Duration
+	viewTimeout              time.Duration
+}
+
+// WithTimer sets the timer used by the cache to get the current time, see NewCacheCustomTimer.
+func WithTimer(timer Timer) Option {
+	return func(o *options) {
+		o.timer = timer
+	}
+}
+
+// WithClock sets the clock used by the cache to get the current time, for the expirations and access
+// times of the entries. It takes precedence over WithTimer. The default is NewWallClock.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithActiveExpiration starts a background goroutine that scans the segments every interval
+// and deletes the expired entries, so they don't linger in the cache until they are accessed
+// or reached by evacuation. The goroutine is stopped by Close.
+func WithActiveExpiration(interval time.Duration) Option {
+	return func(o *options) {
+		o.activeExpirationInterval = interval
+	}
+}
+
+// WithOnExpired sets a callback called with the key of every entry removed because its expiration
+// time elapsed, either lazily on access, by evacuation or by active expiration. It is not called for
+// entries deleted or evicted before they expire. The callback is called with the segment lock held,
+// so it must not call the cache.
+func WithOnExpired(fn func(key []byte)) Option {
+	return func(o *options) {
+		o.onExpired = fn
+	}
+}
+
+// WithOnEvicted sets a callback called with the key, value and remaining expireSeconds of every
+// entry evicted to make room for new entries before it expires, expireSeconds 0 means no expire.
+// The callback is called with the segment lock held, so it must not call the cache.
+func WithOnEvicted(fn func(key, value []byte, expireSeconds int)) Option {
+	return func(o *options) {
+		o.onEvicted = fn
+	}
+}
+
+// WithObserver sets an Observer notified of the duration and result of every Get, Set, Del and Touch,
+// and of every eviction, e.g. to export them as metrics. The operations are not timed without observer.
+func WithObserver(obs Observer) Option {
+	return func(o *options) {
+		o.observer = obs
+	}
+}
+
+// WithSlidingExpiration makes every read of an entry that expires, by Get and the other methods
+// counting hits, extend its expiration by the ttl it was last set or touched with, so the entries
+// only expire when they are not read for their ttl, e.g. sessions. The ttl is rounded up to seconds.
+// Peek, Has and TTL don't extend the expiration.
+func WithSlidingExpiration() Option {
+	return func(o *options) {
+		o.slidingExpiration = true
+	}
+}
+
+// WithChecksums stores a CRC32 of the key and value after every entry when enabled. The reads of a key,
+// e.g. Get, Peek and GetFn, verify it and return ErrCorrupted on a mismatch, e.g. when the memory or the file
+// of WithMmapFile was corrupted, iterators and Scan don't. It costs 4 bytes and a checksum per entry written.
+func WithChecksums(enabled bool) Option {
+	return func(o *options) {
+		o.checksums = enabled
+	}
+}
+
+// WithDefaultTTL sets the expiration of the entries set without one, i.e. with expireSeconds <= 0,
+// which never expire by default.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.defaultTTL = ttl
+	}
+}
+
+// WithMaxTTL clamps the expiration of every Set and Touch to ttl, including the ones without expiration,
+// so no entry is served longer than ttl after it was written or touched.
+func WithMaxTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.maxTTL = ttl
+	}
+}
+
+// WithHashSeed fixes the seed used to hash the keys, which is random by default so the slots of
+// attacker-controlled keys can't be predicted. A fixed seed makes the layout of the cache
+// reproducible, e.g. in tests, and seed 0 hashes the keys without seed.
+func WithHashSeed(seed uint64) Option {
+	return func(o *options) {
+		o.hashSeed = seed
+		o.fixedHashSeed = true
+	}
+}
+
+// WithCodec sets the codec used by SetObject and GetObject, the default is JSONCodec.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithRefreshAhead reloads an entry in the background when it is read while its remaining time
+// is below ratio of its ttl, e.g. 0.2 reloads an entry set with a ttl of 100 seconds when it is read
+// within its last 20 seconds. The value returned by loader is set with the returned expireSeconds,
+// on error the current value is kept until it expires. A key is only reloaded once at a time.
+func WithRefreshAhead(ratio float64, loader func(key []byte) (value []byte, expireSeconds int, err error)) Option {
+	return func(o *options) {
+		o.refreshRatio = ratio
+		o.refreshLoader = loader
+	}
+}
+
+// WithLoader makes Get read through loader: a missing or expired key is loaded, stored with the
+// returned expireSeconds and returned. Concurrent misses of the same key share a single Load call.
+// Errors returned by Load are returned by Get and not cached. The other errors of Get, e.g. ErrClosed or
+// ErrCorrupted, are returned without calling Load.
+func WithLoader(loader Loader) Option {
+	return func(o *options) {
+		o.loader = loader
+	}
+}
+
+// WithWriteThrough writes every change of the cache to store, see Store, once the cache is modified and with
+// the segment lock held, so the changes of a key reach store in order. If the store returns an error, the entry
+// is deleted from the cache and the error is returned, e.g. by Set. As the lock is held, the methods of store
+// block every key of the segment while they run, so they must not block, e.g. on a slow network call, and must
+// not call the cache, which deadlocks. A slow store should be used with WithWriteBehind instead.
+func WithWriteThrough(store Store) Option {
+	return func(o *options) {
+		o.writeThroughStore = store
+	}
+}
+
+// WithWriteBehind queues every change of the cache, see Store, and writes them to store in a background
+// goroutine every flushInterval, only the last write of a key is kept. Failed writes are retried on the next
+// flush, unless the key has been written again. Close flushes the pending writes. The flushInterval must be
+// positive, otherwise OpenCache returns ErrInvalidInterval.
+func WithWriteBehind(store Store, flushInterval time.Duration) Option {
+	return func(o *options) {
+		o.writeBehindStore = store
+		o.writeBehindInterval = flushInterval
+	}
+}
+
+// WithOpLog appends every change of the cache to w, like the writes of a Store, so the cache can be recovered
+// by replaying the log with Replay. The operations are buffered and written according to policy, every
+// interval unless the policy is SyncAlways. If w has a Sync method, like *os.File, it is called after writing
+// unless the policy is SyncNever. Close writes the buffered operations. The interval must be positive unless
+// the policy is SyncAlways, otherwise OpenCache returns ErrInvalidInterval.
+func WithOpLog(w io.Writer, policy SyncPolicy, interval time.Duration) Option {
+	return func(o *options) {
+		o.opLog = w
+		o.opLogPolicy = policy
+		o.opLogInterval = interval
+	}
+}
+
+// WithMmapFile backs the ring buffers with the memory mapped file at path, so they are outside the Go heap
+// and the entries survive a restart of the process. The file is created if it doesn't exist, its entries are
+// kept only if it was closed by Close with the same cache size and hash seed, otherwise the cache starts empty.
+// It is only supported on unix systems, ErrMmapUnsupported is returned by OpenCache on other systems.
+func WithMmapFile(path string) Option {
+	return func(o *options) {
+		o.mmapPath = path
+	}
+}
+
+// WithAllocator allocates the ring buffers with alloc instead of the Go heap, e.g. MmapAllocator or
+// HugePageAllocator, and frees them with it when the cache is resized or closed. OpenCache returns the error
+// of an allocation. It is ignored with WithMmapFile.
+func WithAllocator(alloc Allocator) Option {
+	return func(o *options) {
+		o.allocator = alloc
+	}
+}
+
+// WithMaxEntries limits the number of entries in addition to the cache size, the least recently used
+// entries are evicted to make room for new ones. The limit is enforced per segment as n/256, at least 1,
+// so the cache never holds more than n entries for n >= 256.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		if n <= 0 {
+			o.maxEntries = 0
+			return
+		}
+		o.maxEntries = int64(n / segmentCount)
+		if o.maxEntries == 0 {
+			o.maxEntries = 1
+		}
+	}
+}
+
+// WithTinyLFU enables an admission filter that estimates the access frequency of keys, so a new entry
+// is only set if it is accessed at least as often as the entry it would evict. It keeps frequently used
+// entries in the cache under scan-heavy workloads. Sets of new entries that are not admitted are
+// dropped silently and counted by RejectedCount.
+func WithTinyLFU() Option {
+	return func(o *options) {
+		o.tinyLFU = true
+	}
+}
+
+// WithMaxCost limits the total cost of the entries in addition to the cache size, entries are evicted
+// to stay within the budget. Entries set by SetWithCost weigh their cost, other entries weigh 1.
+// The budget is enforced per segment as budget/256, at least 1.
+func WithMaxCost(budget int64) Option {
+	return func(o *options) {
+		if budget <= 0 {
+			o.maxCost = 0
+			return
+		}
+		o.maxCost = budget / segmentCount
+		if o.maxCost == 0 {
+			o.maxCost = 1
+		}
+	}
+}
+
+// WithMaxPinnedBytes sets the budget of the entries pinned by Pin, n <= 0 keeps the default of 1/4 of
+// the cache size. The budget is enforced per segment as n/256, at most half of the segment.
+func WithMaxPinnedBytes(n int) Option {
+	return func(o *options) {
+		if n <= 0 {
+			o.maxPinned = 0
+			return
+		}
+		o.maxPinned = int64(n / segmentCount)
+		if o.maxPinned == 0 {
+			o.maxPinned = 1
+		}
+	}
+}
+
+// WithMaxEvacuationMoves sets the number of recently used entries a full segment moves to the end of its
+// ring buffer in a row, after which the next entry is evicted even if it was recently used, n <= 0 keeps
+// DefaultMaxEvacuationMoves. More moves keep more recently used entries, at the cost of copying them in Set.
+func WithMaxEvacuationMoves(n int) Option {
+	return func(o *options) {
+		o.maxEvacuationMoves = n
+	}
+}
+
+// WithRecentAccessProtection moves the entries accessed less than d ago instead of evicting them when a
+// segment is full, whatever the EvacuationPolicy, within the limit of WithMaxEvacuationMoves.
+// The access times are in seconds, so d is rounded up to seconds.
+func WithRecentAccessProtection(d time.Duration) Option {
+	return func(o *options) {
+		o.recentAccess = d
+	}
+}
+
+// WithEvacuationPolicy selects the entries evicted when a segment is full, EvacuateLRU by default.
+func WithEvacuationPolicy(policy EvacuationPolicy) Option {
+	return func(o *options) {
+		o.evacuationPolicy = policy
+	}
+}
+
+// WithSegmentSizes sets the size of the ring buffer of every segment, sizes[i] for the segment of id i,
+// instead of 1/256 of the cache size each, e.g. to give the segments known to be overloaded more room, see
+// OverloadedSegments, or a segment room for entries larger than 1/1024 of the cache size, the entries of a
+// segment being limited to 1/4 of it. sizes must have 256 elements, otherwise it is ignored, and a segment
+// is 2KB at minimum. It is ignored with WithMmapFile, and Resize makes the segments the same size again.
+func WithSegmentSizes(sizes []int) Option {
+	return func(o *options) {
+		o.segmentSizes = sizes
+	}
+}
+
+// WithLargeValues makes Set, Get and Del behave like SetLarge, GetLarge and DelLarge, so the values too
+// large for an entry are split in chunks instead of rejected with ErrLargeEntry, by the other sets too, e.g.
+// SetWithDuration or GetOrSet. The reads, e.g. GetMulti, GetFn, GetRange, the iterators and Scan, return a
+// split value reassembled, and skip the keys of its chunks, the writes replacing or deleting it, e.g. GetSet,
+// DelWithValue or DelMulti, delete its chunks, and Append and Prepend return ErrStubbed, while Update and
+// UpdateE see the manifest of a split value. The sets return ErrReservedValue for a value starting like a
+// manifest.
+func WithLargeValues() Option {
+	return func(o *options) {
+		o.largeValues = true
+	}
+}
+
+// WithOverflowStore delegates the values too large for an entry to store, a stub of the value is set in the
+// cache instead, so the expiration and the eviction of the key are still handled by the cache, and Get fetches
+// the value from store. Del deletes the value from store, but the values of the keys evicted or expired stay
+// there, so store should expire them with the expireSeconds it is given. The other sets, e.g. SetWithDuration
+// or GetOrSet, delegate the values too large too. The reads, e.g. GetMulti, GetFn, GetRange, the iterators and
+// Scan, return a delegated value fetched from store, the writes replacing or deleting it, e.g. GetSet,
+// DelWithValue or DelMulti, delete it from store, and Append and Prepend return ErrStubbed, while Update and
+// UpdateE see the stub of a delegated value. The sets return ErrReservedValue for a value starting like a
+// stub. It takes precedence over WithLargeValues.
+func WithOverflowStore(store OverflowStore) Option {
+	return func(o *options) {
+		o.overflowStore = store
+	}
+}
+
+// WithMaxListLen limits the lists of LPush and RPush to n items, the oldest items are removed from the other
+// end of the list when it grows beyond n, which keeps the last n items pushed. n <= 0 means no limit.
+func WithMaxListLen(n int) Option {
+	return func(o *options) {
+		o.maxListLen = n
+	}
+}
+
+// WithUnsafeGet enables GetUnsafe, which returns views of the ring buffers instead of copies.
+func WithUnsafeGet() Option {
+	return func(o *options) {
+		o.unsafeGet = true
+	}
+}
+
+// WithRebalancing keeps a reserve of reserve bytes, in addition to the cache size, lent to the segments
+// evicting much more than the others, e.g. when a hot prefix hashes into a few segments, see Rebalance,
+// which is called every interval, or only by the application if interval <= 0. It is ignored with
+// WithMmapFile, as the segments of a file can't be resized.
+func WithRebalancing(reserve int, interval time.Duration) Option {
+	return func(o *options) {
+		o.rebalanceReserve = int64(reserve)
+		o.rebalanceInterval = interval
+	}
+}
+
+// WithHotKeyTracking tracks the topN most hit keys, returned by HotKeys. The hits of the keys are
+// counted in the Get path by a count-min sketch of 4KB per segment, so the memory used doesn't depend
+// on the number of keys. ResetStatistics clears the tracked hits.
+func WithHotKeyTracking(topN int) Option {
+	return func(o *options) {
+		o.hotKeys = topN
+	}
+}
+
+// WithHitRateWindow counts the hits and misses of the last window in 60 buckets of window/60 per segment,
+// so HitRateWindow returns the recent hit rate, unlike HitRate which is the average since the cache was
+// created or its statistics were reset.
+func WithHitRateWindow(window time.Duration) Option {
+	return func(o *options) {
+		o.hitRateWindow = window
+	}
+}
+
+// WithHistograms records the distributions of the lengths of the values set and of the seconds since the
+// last access of the entries evicted, returned by Histograms, to tune the cache size and expirations.
+func WithHistograms() Option {
+	return func(o *options) {
+		o.histograms = true
+	}
+}
+
+// WithReplication replicates every change of the cache to target asynchronously, like the writes of a Store,
+// e.g. to keep the working set of a warm standby. The writes are queued and applied to target in order by a background goroutine, so a
+// slow target doesn't slow the cache down: when the queue is full, writes are dropped according to the
+// policy of WithReplicationQueue and counted by ReplicationStats. Close applies the queued writes.
+func WithReplication(target Replicator) Option {
+	return func(o *options) {
+		o.replicationTarget = target
+	}
+}
+
+// WithReplicationQueue sets the number of writes queued by WithReplication, DefaultReplicationQueueSize
+// by default, and the write dropped when it is full, DropNewest by default.
+func WithReplicationQueue(size int, policy DropPolicy) Option {
+	return func(o *options) {
+		o.replicationQueueSize = size
+		o.replicationDropPolicy = policy
+	}
+}
+
+// WithViewTimeout sets the time after which the entry of a View not released is unpinned, so it can be
+// evicted again, DefaultViewTimeout by default, d < 0 keeps the entries pinned until the views are released.
+func WithViewTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.viewTimeout = d
+	}
+}
@@ -0,0 +1,155 @@
+package freecache
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var ErrKeyExists = errors.New("The key already exists")
+var ErrWouldEvict = errors.New("The entry doesn't fit without evicting other entries")
+
+// Flags tune a single call of GetOpts or SetOpts, so the variants of Get and Set don't need a method for
+// every combination. Flags are combined with |, the flags meant for the other method are ignored.
+type Flags uint8
+
+const (
+	// NoStats doesn't count the get in the hit and miss statistics, the admission filter and the hot keys,
+	// and doesn't report it to the observer.
+	NoStats Flags = 1 << iota
+	// NoTouch doesn't update the access time of the entry, so it isn't kept from eviction, and doesn't
+	// extend its sliding expiration nor trigger its refresh-ahead.
+	NoTouch
+	// AllowStale returns the value of an expired entry not removed yet with ErrExpired, instead of removing it.
+	AllowStale
+	// NoEvictOthers fails the set with ErrWouldEvict rather than evicting entries to make room. The space of
+	// the deleted and expired entries is still reclaimed.
+	NoEvictOthers
+	// IfAbsent fails the set with ErrKeyExists if the key exists, like SetIfAbsent.
+	IfAbsent
+)
+
+// GetOpts is like Get, tuned by the NoStats, NoTouch and AllowStale flags. GetOpts(key, 0) gets the
+// entry like Get, but the loader isn't called and the values of SetLarge aren't joined. With AllowStale,
+// an expired value is returned along with ErrExpired.
+func (cache *Cache) GetOpts(key []byte, flags Flags) (value []byte, err error) {
+	start := cache.now()
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	value, err = cache.segments[segID].getFlags(key, hashVal, flags)
+	cache.locks[segID].Unlock()
+	if flags&NoStats == 0 {
+		cache.observe(OpGet, start, err)
+	}
+	return
+}
+
+// SetOpts is like Set, tuned by the NoEvictOthers and IfAbsent flags. With NoEvictOthers, a value too large
+// for an entry isn't split by WithLargeValues nor delegated by WithOverflowStore.
+func (cache *Cache) SetOpts(key, value []byte, expireSeconds int, flags Flags) (err error) {
+	if cache.isClosed() {
+		return ErrClosed
+	}
+	if err = cache.checkValue(value); err != nil {
+		return
+	}
+	start := cache.now()
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	stub := cache.stubOf(seg, key, hashVal)
+	if flags&IfAbsent != 0 {
+		if _, _, err = seg.locate(key, hashVal, true); err == nil {
+			err = ErrKeyExists
+		} else {
+			err = nil
+		}
+	}
+	if err == nil {
+		if flags&NoEvictOthers != 0 {
+			err = seg.setNoEvict(key, value, hashVal, expireSeconds)
+		} else {
+			err = seg.set(key, value, hashVal, expireSeconds)
+		}
+	}
+	cache.locks[segID].Unlock()
+	if flags&NoEvictOthers == 0 {
+		err = cache.setReplaced(key, value, time.Duration(expireSeconds)*time.Second, 0, 1, stub, err)
+	} else if err == nil && stub != nil {
+		cache.dropStub(key, stub)
+	}
+	cache.unlockStub(segID)
+	cache.observe(OpSet, start, err)
+	return
+}
+
+// getFlags is like get with the flags of GetOpts, the value of an expired entry is returned with ErrExpired
+// with AllowStale.
+func (seg *segment) getFlags(key []byte, hashVal uint64, flags Flags) (value []byte, err error) {
+	hdr, ptrOffset, err := seg.locateFlags(key, hashVal, flags)
+	if err != nil && (err != ErrExpired || flags&AllowStale == 0) {
+		return
+	}
+	value = make([]byte, hdr.valLen)
+	seg.rb.ReadAt(value, ptrOffset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+	if checkErr := seg.verifyChecksum(&hdr, ptrOffset, key, value); checkErr != nil {
+		return nil, checkErr
+	}
+	if err == nil && flags&NoStats == 0 {
+		seg.countHit()
+	}
+	return
+}
+
+// setNoEvict is like set, but returns ErrWouldEvict if the entry doesn't fit in the free space, the deleted
+// entries and the entry it replaces, so evacuate only has to reclaim them and move the live entries.
+func (seg *segment) setNoEvict(key, value []byte, hashVal uint64, expireSeconds int) (err error) {
+	if seg.rb.data == nil {
+		return ErrClosed
+	}
+	seg.applyClear()
+	maxKeyValLen := len(seg.rb.data)/4 - ENTRY_HDR_SIZE
+	if len(key)+len(value) > maxKeyValLen {
+		return ErrLargeEntry
+	}
+	free := seg.vacuumLen + atomic.LoadInt64(&seg.deletedLen)
+	entries := atomic.LoadInt64(&seg.entryCount)
+	totalCost := atomic.LoadInt64(&seg.totalCost)
+	newHdr := entryHdr{keyLen: uint16(len(key)), valCap: uint32(len(value))}
+	if newHdr.valCap == 0 {
+		newHdr.valCap = 1
+	}
+	slot := seg.getSlot(uint8(hashVal >> 8))
+	if idx, match := seg.lookup(slot, uint16(hashVal>>16), key); match {
+		var hdrBuf [ENTRY_HDR_SIZE]byte
+		seg.rb.ReadAt(hdrBuf[:], slot[idx].offset)
+		hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+		if hdr.valCap >= uint32(len(value)) && hdr.flags&flagCost == 0 && hdr.expireMs&expireMsSoft == 0 {
+			// overwritten in place.
+			return seg.set(key, value, hashVal, expireSeconds)
+		}
+		// the entry is relocated with a larger capacity like setAt.
+		newHdr.valCap = hdr.valCap
+		for newHdr.valCap < uint32(len(value)) {
+			newHdr.valCap *= 2
+		}
+		if newHdr.valCap > uint32(maxKeyValLen-len(key)) {
+			newHdr.valCap = uint32(maxKeyValLen - len(key))
+		}
+		free += seg.entryLen(hdr)
+		entries--
+		totalCost -= seg.entryCost(hdr, slot[idx].offset)
+	}
+	if seg.entryLen(&newHdr) > free || (seg.maxEntries > 0 && entries >= seg.maxEntries) ||
+		(seg.maxCost > 0 && totalCost+1 > seg.maxCost) {
+		return ErrWouldEvict
+	}
+	seg.noEvict = true
+	err = seg.setTTL(key, value, hashVal, time.Duration(expireSeconds)*time.Second, 0)
+	seg.noEvict = false
+	return
+}
@@ -0,0 +1,117 @@
+package freecache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestGetOpts(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithSlidingExpiration(), WithChecksums(true))
+	key := []byte("key")
+	cache.Set(key, []byte("abc"), 10)
+
+	v, err := cache.GetOpts(key, NoStats)
+	if err != nil || string(v) != "abc" {
+		t.Fatalf("GetOpts = %q, %v", v, err)
+	}
+	if _, err = cache.GetOpts([]byte("missing"), NoStats); err != ErrNotFound {
+		t.Fatalf("err = %v", err)
+	}
+	if cache.HitCount() != 0 || cache.MissCount() != 0 {
+		t.Fatalf("hits = %d, misses = %d", cache.HitCount(), cache.MissCount())
+	}
+	if v, err = cache.GetOpts(key, 0); err != nil || string(v) != "abc" || cache.HitCount() != 1 {
+		t.Fatalf("GetOpts = %q, %v, hits = %d", v, err, cache.HitCount())
+	}
+
+	// a get without touch doesn't extend the sliding expiration.
+	timer.nowMs += 5000
+	if _, err = cache.GetOpts(key, NoTouch); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 5 {
+		t.Fatalf("ttl = %d", ttl)
+	}
+	if _, err = cache.GetOpts(key, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := cache.TTL(key); ttl != 10 {
+		t.Fatalf("ttl = %d", ttl)
+	}
+
+	// a stale value is returned until it is got without AllowStale.
+	timer.nowMs += 10000
+	for i := 0; i < 2; i++ {
+		if v, err = cache.GetOpts(key, AllowStale|NoStats); err != ErrExpired || string(v) != "abc" {
+			t.Fatalf("GetOpts = %q, %v", v, err)
+		}
+	}
+	if v, err = cache.GetOpts(key, 0); err != ErrExpired || v != nil {
+		t.Fatalf("GetOpts = %q, %v", v, err)
+	}
+	if _, err = cache.GetOpts(key, AllowStale); err != ErrNotFound {
+		t.Fatalf("err = %v", err)
+	}
+	if errs := cache.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestSetOpts(t *testing.T) {
+	evicted := 0
+	cache := NewCacheWithOptions(512*1024, WithOnEvicted(func(key, value []byte, expireSeconds int) {
+		evicted++
+	}))
+	key := []byte("key")
+	if err := cache.SetOpts(key, []byte("abc"), 0, IfAbsent); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetOpts(key, []byte("def"), 0, IfAbsent); err != ErrKeyExists {
+		t.Fatalf("err = %v", err)
+	}
+	if v, _ := cache.Get(key); string(v) != "abc" {
+		t.Fatalf("value = %q", v)
+	}
+
+	// fill the cache until entries are evicted.
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; evicted == 0 || i < 10000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), value, 0)
+	}
+	evicted = 0
+	rejected := 0
+	for i := 0; i < 1000; i++ {
+		err := cache.SetOpts([]byte(fmt.Sprint("new", i)), value, 0, NoEvictOthers)
+		if err == ErrWouldEvict {
+			rejected++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rejected == 0 || evicted != 0 {
+		t.Fatalf("rejected = %d, evicted = %d", rejected, evicted)
+	}
+
+	// a live key is overwritten in place, and a deleted key leaves room for itself.
+	var live []byte
+	for i := 0; live == nil; i++ {
+		if _, err := cache.Get([]byte(fmt.Sprint(i))); err == nil {
+			live = []byte(fmt.Sprint(i))
+		}
+	}
+	if err := cache.SetOpts(live, bytes.Repeat([]byte("w"), 100), 0, NoEvictOthers); err != nil {
+		t.Fatal(err)
+	}
+	cache.Del(live)
+	if err := cache.SetOpts(live, value, 0, NoEvictOthers|IfAbsent); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cache.Get(live); !bytes.Equal(v, value) || evicted != 0 {
+		t.Fatalf("value = %q, evicted = %d", v, evicted)
+	}
+	if errs := cache.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+}
@@ -0,0 +1,97 @@
+// Package otelfreecache instruments a freecache.Cache with OpenTelemetry metrics and traces.
+package otelfreecache
+
+import (
+	"context"
+	"time"
+
+	"github.com/coocood/freecache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures the instrumentation of WithOTelMeter.
+type Option func(*observer)
+
+// WithSlowSetTracer records a span with a "slow set" event for every Set that takes threshold or longer,
+// so slow Sets show up in traces. The span has no parent, as Set doesn't take a context.
+func WithSlowSetTracer(tracer trace.Tracer, threshold time.Duration) Option {
+	return func(o *observer) {
+		o.tracer = tracer
+		o.slowSet = threshold
+	}
+}
+
+// observer is a freecache.Observer recording the operations as OpenTelemetry instruments.
+type observer struct {
+	duration  metric.Float64Histogram
+	hits      metric.Int64Counter
+	misses    metric.Int64Counter
+	evictions metric.Int64Counter
+	opAttrs   [freecache.OpTouch + 1]metric.MeasurementOption
+	tracer    trace.Tracer
+	slowSet   time.Duration
+}
+
+// WithOTelMeter returns a freecache.Option that records the operations of the cache with meter:
+// the duration of Get, Set, Del and Touch in the freecache.operation.duration histogram with an op
+// attribute, the hits and misses of Get in the freecache.hits and freecache.misses counters, and the
+// evictions in the freecache.evictions counter. Errors creating the instruments are sent to otel.Handle.
+func WithOTelMeter(meter metric.Meter, opts ...Option) freecache.Option {
+	o := &observer{}
+	var err error
+	if o.duration, err = meter.Float64Histogram("freecache.operation.duration",
+		metric.WithDescription("Duration of the cache operations."), metric.WithUnit("s")); err != nil {
+		otel.Handle(err)
+	}
+	if o.hits, err = meter.Int64Counter("freecache.hits",
+		metric.WithDescription("Number of Gets that found the key.")); err != nil {
+		otel.Handle(err)
+	}
+	if o.misses, err = meter.Int64Counter("freecache.misses",
+		metric.WithDescription("Number of Gets that didn't find the key.")); err != nil {
+		otel.Handle(err)
+	}
+	if o.evictions, err = meter.Int64Counter("freecache.evictions",
+		metric.WithDescription("Number of entries evicted before they expired.")); err != nil {
+		otel.Handle(err)
+	}
+	for op := range o.opAttrs {
+		o.opAttrs[op] = metric.WithAttributeSet(attribute.NewSet(attribute.String("op", freecache.Op(op).String())))
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return freecache.WithObserver(o)
+}
+
+func (o *observer) ObserveOp(op freecache.Op, d time.Duration, err error) {
+	ctx := context.Background()
+	if int(op) < len(o.opAttrs) {
+		o.duration.Record(ctx, d.Seconds(), o.opAttrs[op])
+	}
+	if op == freecache.OpGet {
+		// a negative cached key is a hit, the cache knows it is missing.
+		if err == nil || err == freecache.ErrNegativeCached {
+			o.hits.Add(ctx, 1)
+		} else {
+			o.misses.Add(ctx, 1)
+		}
+	}
+	if op == freecache.OpSet && o.tracer != nil && d >= o.slowSet {
+		end := time.Now()
+		_, span := o.tracer.Start(ctx, "freecache.Set", trace.WithTimestamp(end.Add(-d)))
+		span.AddEvent("slow set", trace.WithTimestamp(end),
+			trace.WithAttributes(attribute.Int64("freecache.duration_us", d.Microseconds())))
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End(trace.WithTimestamp(end))
+	}
+}
+
+func (o *observer) ObserveEviction() {
+	o.evictions.Add(context.Background(), 1)
+}
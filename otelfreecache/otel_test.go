@@ -0,0 +1,65 @@
+package otelfreecache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/coocood/freecache"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithOTelMeter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	cache := freecache.NewCacheWithOptions(512*1024,
+		WithOTelMeter(provider.Meter("test"), WithSlowSetTracer(tracer, 0)))
+
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Get([]byte("a"))
+	cache.Get([]byte("b"))
+	cache.Get([]byte("c"))
+	value := make([]byte, 200)
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(strconv.Itoa(i)), value, 0)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	sums := map[string]int64{}
+	var durations uint64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, p := range data.DataPoints {
+					sums[m.Name] += p.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, p := range data.DataPoints {
+					durations += p.Count
+				}
+			}
+		}
+	}
+	if sums["freecache.hits"] != 1 || sums["freecache.misses"] != 2 {
+		t.Fatalf("sums = %v", sums)
+	}
+	if sums["freecache.evictions"] == 0 {
+		t.Fatal("no eviction recorded")
+	}
+	if durations != 10004 {
+		t.Fatalf("recorded %d durations, want 10004", durations)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 10001 || spans[0].Name() != "freecache.Set" || len(spans[0].Events()) != 1 {
+		t.Fatalf("recorded %d spans", len(spans))
+	}
+}
@@ -0,0 +1,71 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// OverflowStore stores the values too large for an entry, see WithOverflowStore. Get returns ErrNotFound
+// for a missing key. The keys and values passed to it are copies it may retain.
+type OverflowStore interface {
+	Store
+	Get(key []byte) (value []byte, err error)
+}
+
+// overflowMagic starts the stub of a value delegated to the OverflowStore, followed by the length
+// of the value as uint64 and its checksum as uint32, little endian.
+const overflowMagic = "\x00fcovflw"
+
+const overflowStubLen = len(overflowMagic) + 12
+
+type overflowStub struct {
+	valueLen uint64
+	sum      uint32
+}
+
+func parseOverflowStub(data []byte) (stub overflowStub, ok bool) {
+	if len(data) != overflowStubLen || string(data[:len(overflowMagic)]) != overflowMagic {
+		return
+	}
+	data = data[len(overflowMagic):]
+	stub.valueLen = binary.LittleEndian.Uint64(data[0:8])
+	stub.sum = binary.LittleEndian.Uint32(data[8:12])
+	return stub, true
+}
+
+func (stub *overflowStub) marshal() []byte {
+	data := make([]byte, overflowStubLen)
+	copy(data, overflowMagic)
+	binary.LittleEndian.PutUint64(data[len(overflowMagic):], stub.valueLen)
+	binary.LittleEndian.PutUint32(data[len(overflowMagic)+8:], stub.sum)
+	return data
+}
+
+// setOverflow delegates value, too large for an entry, to the OverflowStore and sets a stub of it as the
+// entry of key, with the expiration ttl, flags and cost of setAt, see setReplaced.
+func (cache *Cache) setOverflow(key, value []byte, ttl time.Duration, flags uint8, cost int64) (err error) {
+	expireSeconds := int((ttl + time.Second - 1) / time.Second)
+	// key and value are copied, so they don't escape to the heap without an OverflowStore.
+	if err = cache.overflow.Set(append([]byte(nil), key...), append([]byte(nil), value...), expireSeconds); err != nil {
+		return
+	}
+	stub := overflowStub{valueLen: uint64(len(value)), sum: checksum(nil, value)}
+	return cache.setStub(key, stub.marshal(), value, ttl, flags, cost)
+}
+
+// getOverflow returns value if it isn't a stub, otherwise the value of key fetched from the OverflowStore.
+// It returns ErrNotFound if the store lost the value or has another value than the one of the stub.
+func (cache *Cache) getOverflow(key, value []byte) ([]byte, error) {
+	stub, ok := parseOverflowStub(value)
+	if !ok {
+		return value, nil
+	}
+	value, err := cache.overflow.Get(append([]byte(nil), key...))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(value)) != stub.valueLen || checksum(nil, value) != stub.sum {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
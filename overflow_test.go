@@ -0,0 +1,286 @@
+package freecache
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapOverflowStore is an OverflowStore keeping the values in a map.
+type mapOverflowStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	delay  time.Duration // of the writes, so they interleave with the writes of the cache.
+}
+
+func (s *mapOverflowStore) Set(key, value []byte, expireSeconds int) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *mapOverflowStore) Del(key []byte) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, string(key))
+	return nil
+}
+
+func (s *mapOverflowStore) Get(key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func TestOverflowStore(t *testing.T) {
+	store := &mapOverflowStore{values: map[string][]byte{}}
+	cache := NewCacheWithOptions(1024*1024, WithOverflowStore(store))
+	large := bytes.Repeat([]byte("v"), 10000)
+	if err := cache.Set([]byte("large"), large, 100); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.values) != 1 {
+		t.Fatalf("store has %d values", len(store.values))
+	}
+	if v, err := cache.Get([]byte("large")); err != nil || !bytes.Equal(v, large) {
+		t.Fatalf("Get = %d bytes, %v", len(v), err)
+	}
+	if ttl, err := cache.TTL([]byte("large")); err != nil || ttl != 100 {
+		t.Fatalf("ttl = %d, %v", ttl, err)
+	}
+
+	cache.Set([]byte("small"), []byte("v"), 0)
+	if v, err := cache.Get([]byte("small")); err != nil || string(v) != "v" || len(store.values) != 1 {
+		t.Fatalf("Get = %q, %v, store has %d values", v, err, len(store.values))
+	}
+
+	// the store value doesn't match the stub.
+	store.Set([]byte("large"), []byte("other"), 0)
+	if _, err := cache.Get([]byte("large")); err != ErrNotFound {
+		t.Fatalf("Get of a changed store value err = %v", err)
+	}
+
+	cache.Set([]byte("large"), large, 0)
+	if !cache.Del([]byte("large")) || len(store.values) != 0 {
+		t.Fatalf("store has %d values after Del", len(store.values))
+	}
+	cache.Set([]byte("large"), large, 0)
+	cache.Set([]byte("large"), []byte("small again"), 0)
+	if v, err := cache.Get([]byte("large")); err != nil || string(v) != "small again" || len(store.values) != 0 {
+		t.Fatalf("Get = %q, %v, store has %d values", v, err, len(store.values))
+	}
+}
+
+func TestOverflowStoreReads(t *testing.T) {
+	testStubReads(t, NewCacheWithOptions(4*1024*1024, WithOverflowStore(&mapOverflowStore{values: map[string][]byte{}})))
+}
+
+func TestOverflowStoreOverwrites(t *testing.T) {
+	// large is delegated to the store, or split in chunks with WithLargeValues.
+	large := bytes.Repeat([]byte("v"), 200000)
+	key := []byte("large")
+	replace := func(value []byte, found bool) ([]byte, bool, int) {
+		return []byte("small"), true, 0
+	}
+	writes := map[string]func(cache *Cache) (old []byte, err error){
+		"GetSet": func(cache *Cache) ([]byte, error) {
+			return cache.GetSet(key, []byte("small"), 0)
+		},
+		"SetAndGet": func(cache *Cache) ([]byte, error) {
+			old, _, err := cache.SetAndGet(key, []byte("small"), 0)
+			return old, err
+		},
+		"Pop": func(cache *Cache) ([]byte, error) {
+			return cache.Pop(key)
+		},
+		"Update": func(cache *Cache) ([]byte, error) {
+			_, _, err := cache.Update(key, replace)
+			return large, err
+		},
+		"UpdateE": func(cache *Cache) ([]byte, error) {
+			_, err := cache.UpdateE(key, func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+				return []byte("small"), UpdateReplace, 0, nil
+			})
+			return large, err
+		},
+		"SetIfPresent": func(cache *Cache) ([]byte, error) {
+			_, err := cache.SetIfPresent(key, []byte("small"), 0)
+			return large, err
+		},
+		"UpdateMulti": func(cache *Cache) ([]byte, error) {
+			return large, cache.UpdateMulti([][]byte{key}, func(idx int, old []byte, found bool) ([]byte, bool, int) {
+				return replace(old, found)
+			})
+		},
+		"DelMulti": func(cache *Cache) ([]byte, error) {
+			if cache.DelMulti([][]byte{key}) != 1 {
+				return nil, ErrNotFound
+			}
+			return large, nil
+		},
+		"DelWithValue": func(cache *Cache) ([]byte, error) {
+			old, _ := cache.DelWithValue(key)
+			return old, nil
+		},
+		"DelFn": func(cache *Cache) (old []byte, err error) {
+			cache.DelFn(key, func(value []byte) {
+				old = append(old, value...)
+			})
+			return
+		},
+		"CompareAndDelete": func(cache *Cache) ([]byte, error) {
+			_, err := cache.CompareAndDelete(key, large)
+			return large, err
+		},
+		"SetWithDuration": func(cache *Cache) ([]byte, error) {
+			return large, cache.SetWithDuration(key, []byte("small"), 0)
+		},
+		"SetWithCost": func(cache *Cache) ([]byte, error) {
+			return large, cache.SetWithCost(key, []byte("small"), 2, 0)
+		},
+		"SetNotFound": func(cache *Cache) ([]byte, error) {
+			return large, cache.SetNotFound(key, 0)
+		},
+		"SetOpts": func(cache *Cache) ([]byte, error) {
+			return large, cache.SetOpts(key, []byte("small"), 0, NoEvictOthers)
+		},
+		"DelAt": func(cache *Cache) ([]byte, error) {
+			return large, cache.DelAt(key, time.Now().Add(-time.Second))
+		},
+	}
+	for name, write := range writes {
+		store := &mapOverflowStore{values: map[string][]byte{}}
+		cache := NewCacheWithOptions(1024*1024, WithOverflowStore(store))
+		largeValues := NewCacheWithOptions(4*1024*1024, WithLargeValues())
+		for _, c := range []*Cache{cache, largeValues} {
+			if err := c.Set(key, large, 0); err != nil {
+				t.Fatal(err)
+			}
+			if old, err := write(c); err != nil || !bytes.Equal(old, large) {
+				t.Fatalf("%s = %d bytes, %v", name, len(old), err)
+			}
+			if n := c.EntryCount(); n > 1 {
+				t.Fatalf("%s left %d entries", name, n)
+			}
+		}
+		if len(store.values) != 0 {
+			t.Fatalf("%s left %d values in the store", name, len(store.values))
+		}
+	}
+}
+
+func TestOverflowStoreSets(t *testing.T) {
+	// large is delegated to the store, or split in chunks with WithLargeValues.
+	large := bytes.Repeat([]byte("v"), 200000)
+	key := []byte("large")
+	sets := map[string]func(cache *Cache, value []byte) error{
+		"SetWithDuration": func(cache *Cache, value []byte) error {
+			return cache.SetWithDuration(key, value, 0)
+		},
+		"SetWithCost": func(cache *Cache, value []byte) error {
+			return cache.SetWithCost(key, value, 2, 0)
+		},
+		"GetOrSet": func(cache *Cache, value []byte) error {
+			_, err := cache.GetOrSet(key, value, 0)
+			return err
+		},
+		"GetOrSetFn": func(cache *Cache, value []byte) error {
+			_, err := cache.GetOrSetFn(key, 0, func(dst []byte) (int, error) {
+				return copy(dst, value), nil
+			}, len(value))
+			return err
+		},
+		"SetAndGet": func(cache *Cache, value []byte) error {
+			_, _, err := cache.SetAndGet(key, value, 0)
+			return err
+		},
+	}
+	for name, set := range sets {
+		store := &mapOverflowStore{values: map[string][]byte{}}
+		cache := NewCacheWithOptions(1024*1024, WithOverflowStore(store))
+		largeValues := NewCacheWithOptions(4*1024*1024, WithLargeValues())
+		for _, c := range []*Cache{cache, largeValues} {
+			// the value of SetNotFound is replaced.
+			c.SetNotFound(key, 0)
+			if err := set(c, large); err != nil {
+				t.Fatalf("%s err = %v", name, err)
+			}
+			if value, err := c.Get(key); err != nil || !bytes.Equal(value, large) {
+				t.Fatalf("Get after %s = %d bytes, %v", name, len(value), err)
+			}
+			c.Del(key)
+			// a value read as a stub is rejected.
+			stub := (&overflowStub{valueLen: 5, sum: 1}).marshal()
+			manifest := (&largeManifest{valueLen: 5, chunkLen: 1}).marshal()
+			for _, value := range [][]byte{stub, manifest, append(stub, 'x')} {
+				if err := set(c, value); err != ErrReservedValue {
+					t.Fatalf("%s of %q err = %v, want ErrReservedValue", name, value, err)
+				}
+			}
+		}
+		if len(store.values) != 0 {
+			t.Fatalf("%s left %d values in the store", name, len(store.values))
+		}
+	}
+}
+
+func TestOverflowStoreConcurrentWrites(t *testing.T) {
+	key := []byte("large")
+	store := &mapOverflowStore{values: map[string][]byte{}, delay: 10 * time.Microsecond}
+	cache := NewCacheWithOptions(1024*1024, WithOverflowStore(store))
+	largeValues := NewCacheWithOptions(4*1024*1024, WithLargeValues())
+	for _, c := range []*Cache{cache, largeValues} {
+		// the stubs are checked after every round, a value leaked by a round is
+		// overwritten by the next.
+		for round := 0; round < 100; round++ {
+			var wg sync.WaitGroup
+			for g := 0; g < 4; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					large := bytes.Repeat([]byte(fmt.Sprint(g)), 100000)
+					for i := 0; i < 3; i++ {
+						switch (i + g + round) % 3 {
+						case 0:
+							c.Set(key, large, 0)
+						case 1:
+							c.Set(key, []byte("small"), 0)
+						default:
+							c.Del(key)
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			// the value left is the last one set, without the values it replaced.
+			value, err := c.Get(key)
+			switch {
+			case err == ErrNotFound && c.Has(key):
+				t.Fatal("the value of the stub left is missing")
+			case err == ErrNotFound || string(value) == "small":
+				if n := c.EntryCount(); n > 1 {
+					t.Fatalf("%d entries left with %q", n, value)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case c == largeValues:
+				m, _ := c.peekLargeManifest(key)
+				if n := c.EntryCount(); n != int64(m.chunkCount())+1 {
+					t.Fatalf("%d entries left for %d chunks", n, m.chunkCount())
+				}
+			}
+			if c == cache && (len(store.values) == 1) != (len(value) > 100) {
+				t.Fatalf("store has %d values with a value of %d bytes", len(store.values), len(value))
+			}
+		}
+	}
+}
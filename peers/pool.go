@@ -0,0 +1,152 @@
+// Package peers shares the loads of a fleet of caches: every key is owned by one peer chosen by consistent
+// hashing, and a cache missing a key fetches it from its owner instead of calling the loader, so a key is
+// loaded once by its owner however many caches miss it. A Pool is the Loader of its cache:
+//
+//	pool := peers.NewPool("http://10.0.0.1:8080", loader, &peers.HTTPTransport{})
+//	pool.SetPeers("http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8080")
+//	cache := freecache.NewCacheWithOptions(size, freecache.WithLoader(pool))
+//	http.Handle(peers.DefaultBasePath, pool.Handler(cache))
+//
+// The values fetched from peers are stored in the local cache with the expiration given by the owner.
+package peers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/coocood/freecache"
+)
+
+// ErrPeerLoad is wrapped by the errors of a Transport when the owner of a key failed to load it.
+var ErrPeerLoad = errors.New("The peer failed to load the key")
+
+// Transport fetches the values of keys from peers.
+type Transport interface {
+	// Fetch returns the value of key loaded by peer and the expireSeconds it should be stored with.
+	// It returns freecache.ErrNotFound if the loader of peer didn't find the key, and an error wrapping
+	// ErrPeerLoad if it failed. Other errors mean peer couldn't be reached and the key is loaded locally.
+	Fetch(peer string, key []byte) (value []byte, expireSeconds int, err error)
+}
+
+// Option configures a Pool.
+type Option func(*Pool)
+
+// WithReplicas sets the number of points of every peer on the hash ring, DefaultReplicas by default.
+// More points spread the keys more evenly between the peers.
+func WithReplicas(n int) Option {
+	return func(p *Pool) {
+		p.replicas = n
+	}
+}
+
+// Pool is a freecache.Loader that loads the keys owned by self with loader and fetches the other keys
+// from their owners with transport.
+type Pool struct {
+	self      string
+	loader    freecache.Loader
+	transport Transport
+	replicas  int
+
+	mu   sync.RWMutex
+	ring *Ring
+}
+
+// NewPool returns the pool of the peer self, which loads its keys with loader. The pool has no other peer
+// until SetPeers is called.
+func NewPool(self string, loader freecache.Loader, transport Transport, opts ...Option) *Pool {
+	p := &Pool{self: self, loader: loader, transport: transport}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.ring = NewRing(p.replicas, self)
+	return p
+}
+
+// SetPeers replaces the peers of the pool, they should include self. All the peers should be given
+// the same list, otherwise they disagree on the owners of some keys and load them more than once.
+func (p *Pool) SetPeers(peers ...string) {
+	ring := NewRing(p.replicas, peers...)
+	p.mu.Lock()
+	p.ring = ring
+	p.mu.Unlock()
+}
+
+// Owner returns the peer owning key.
+func (p *Pool) Owner(key []byte) string {
+	p.mu.RLock()
+	owner := p.ring.Get(key)
+	p.mu.RUnlock()
+	return owner
+}
+
+func (p *Pool) owns(key []byte) bool {
+	owner := p.Owner(key)
+	return owner == "" || owner == p.self
+}
+
+// Load loads key with the loader of the pool if self owns it, otherwise it fetches key from its owner.
+// If the owner can't be reached, key is loaded with the loader of the pool.
+func (p *Pool) Load(key []byte) (value []byte, expireSeconds int, err error) {
+	owner := p.Owner(key)
+	if owner == "" || owner == p.self {
+		return p.loader.Load(key)
+	}
+	value, expireSeconds, err = p.transport.Fetch(owner, key)
+	if err == nil || errors.Is(err, freecache.ErrNotFound) || errors.Is(err, ErrPeerLoad) {
+		return
+	}
+	return p.loader.Load(key)
+}
+
+// DefaultBasePath is the path under which the peers serve their keys by default.
+const DefaultBasePath = "/_freecache/"
+
+// expireHeader is the header of the expireSeconds of a fetched value.
+const expireHeader = "X-Freecache-Expire"
+
+// Handler returns the http.Handler serving the keys of cache, whose Loader is the pool, to the other peers
+// fetching them with an HTTPTransport. A key owned by self is got from cache, so it is loaded once and
+// stored. A key the requesting peer considers owned by self but self doesn't, e.g. while the list of peers
+// is changing, is returned from cache if present and loaded without being forwarded otherwise.
+func (p *Pool) Handler(cache *freecache.Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := []byte(r.URL.Query().Get("key"))
+		value, expireSeconds, err := p.serve(cache, key)
+		switch {
+		case errors.Is(err, freecache.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			w.Header().Set(expireHeader, strconv.Itoa(expireSeconds))
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(value)
+		}
+	})
+}
+
+func (p *Pool) serve(cache *freecache.Cache, key []byte) (value []byte, expireSeconds int, err error) {
+	owned := p.owns(key)
+	if owned {
+		value, err = cache.Get(key)
+	} else {
+		value, _, err = cache.GetWithExpiration(key)
+	}
+	if err == nil {
+		var ttl uint32
+		if ttl, err = cache.TTL(key); err == nil {
+			return value, int(ttl), nil
+		}
+	}
+	if owned {
+		return nil, 0, err
+	}
+	return p.loader.Load(key)
+}
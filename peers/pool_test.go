@@ -0,0 +1,139 @@
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coocood/freecache"
+)
+
+type testPeer struct {
+	pool  *Pool
+	cache *freecache.Cache
+	loads int64
+}
+
+// newTestFleet starts n peers, each loading "value-"+key with a ttl of 100 seconds, or failing for the
+// keys starting with "missing" or "fail".
+func newTestFleet(t *testing.T, n int) ([]*testPeer, []*httptest.Server) {
+	peers := make([]*testPeer, n)
+	servers := make([]*httptest.Server, n)
+	urls := make([]string, n)
+	for i := range peers {
+		peer := &testPeer{}
+		peers[i] = peer
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer.pool.Handler(peer.cache).ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		urls[i] = servers[i].URL
+	}
+	for i, peer := range peers {
+		peer := peer
+		loader := freecache.LoaderFunc(func(key []byte) ([]byte, int, error) {
+			atomic.AddInt64(&peer.loads, 1)
+			switch {
+			case string(key[:min(len(key), 7)]) == "missing":
+				return nil, 0, freecache.ErrNotFound
+			case string(key[:min(len(key), 4)]) == "fail":
+				return nil, 0, errors.New("backend down")
+			}
+			return []byte("value-" + string(key)), 100, nil
+		})
+		peer.pool = NewPool(urls[i], loader, &HTTPTransport{})
+		peer.pool.SetPeers(urls...)
+		peer.cache = freecache.NewCacheWithOptions(1024*1024, freecache.WithLoader(peer.pool))
+	}
+	return peers, servers
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func totalLoads(peers []*testPeer) (n int64) {
+	for _, peer := range peers {
+		n += atomic.LoadInt64(&peer.loads)
+	}
+	return
+}
+
+func TestPoolLoadsOncePerFleet(t *testing.T) {
+	peers, _ := newTestFleet(t, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, peer := range peers {
+			wg.Add(1)
+			go func(peer *testPeer, i int) {
+				defer wg.Done()
+				key := []byte(fmt.Sprintf("key%d", i))
+				value, err := peer.cache.Get(key)
+				if err != nil || string(value) != "value-"+string(key) {
+					t.Errorf("Get %s = %s, %v", key, value, err)
+				}
+				if ttl, err := peer.cache.TTL(key); err != nil || ttl == 0 || ttl > 100 {
+					t.Errorf("TTL %s = %d, %v", key, ttl, err)
+				}
+			}(peer, i)
+		}
+	}
+	wg.Wait()
+	if n := totalLoads(peers); n != 20 {
+		t.Fatalf("%d loads for 20 keys", n)
+	}
+	for _, peer := range peers {
+		if n := atomic.LoadInt64(&peer.loads); n == 0 || n == 20 {
+			t.Fatalf("a peer loaded %d keys", n)
+		}
+	}
+}
+
+func TestPoolErrors(t *testing.T) {
+	peers, _ := newTestFleet(t, 3)
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("missing%d", i))
+		for _, peer := range peers {
+			if _, err := peer.cache.Get(key); err != freecache.ErrNotFound {
+				t.Fatalf("Get %s err = %v", key, err)
+			}
+		}
+		key = []byte(fmt.Sprintf("fail%d", i))
+		owner := peers[0].pool.Owner(key)
+		for _, peer := range peers {
+			_, err := peer.cache.Get(key)
+			if err == nil {
+				t.Fatalf("Get %s succeeded", key)
+			}
+			if peer.pool.self != owner && !errors.Is(err, ErrPeerLoad) {
+				t.Fatalf("Get %s from %s err = %v", key, peer.pool.self, err)
+			}
+		}
+	}
+	// the errors are not cached, every Get is loaded once by the owner.
+	if n := totalLoads(peers); n != 60 {
+		t.Fatalf("%d loads for 3 Gets of 10 missing and 10 failing keys", n)
+	}
+}
+
+func TestPoolFallsBackWhenOwnerIsDown(t *testing.T) {
+	peers, servers := newTestFleet(t, 2)
+	servers[1].Close()
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		value, err := peers[0].cache.Get(key)
+		if err != nil || string(value) != "value-"+string(key) {
+			t.Fatalf("Get %s = %s, %v", key, value, err)
+		}
+	}
+	if n := atomic.LoadInt64(&peers[0].loads); n != 20 {
+		t.Fatalf("%d local loads", n)
+	}
+}
@@ -0,0 +1,63 @@
+package peers
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// DefaultReplicas is the number of points of every peer on a Ring created with replicas <= 0.
+const DefaultReplicas = 64
+
+// Ring maps keys to peers by consistent hashing: every peer is hashed to replicas points on a ring and
+// a key belongs to the first point after its hash, so adding or removing a peer only moves the keys of
+// its points. A Ring is not safe for concurrent modification.
+type Ring struct {
+	replicas int
+	points   []uint64
+	peers    map[uint64]string
+}
+
+// NewRing returns a ring of peers with replicas points per peer.
+func NewRing(replicas int, peers ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{replicas: replicas, peers: make(map[uint64]string)}
+	r.Add(peers...)
+	return r
+}
+
+// Add adds peers to the ring.
+func (r *Ring) Add(peers ...string) {
+	for _, peer := range peers {
+		for i := 0; i < r.replicas; i++ {
+			point := xxhash.Sum64String(strconv.Itoa(i) + peer)
+			if _, ok := r.peers[point]; ok {
+				continue
+			}
+			r.points = append(r.points, point)
+			r.peers[point] = peer
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Len returns the number of points on the ring.
+func (r *Ring) Len() int {
+	return len(r.points)
+}
+
+// Get returns the peer of key, or "" if the ring is empty.
+func (r *Ring) Get(key []byte) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := xxhash.Sum64(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.peers[r.points[i]]
+}
@@ -0,0 +1,46 @@
+package peers
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	if owner := NewRing(0).Get([]byte("a")); owner != "" {
+		t.Fatalf("empty ring owner = %q", owner)
+	}
+	r := NewRing(0, "a", "b", "c")
+	if r.Len() != 3*DefaultReplicas {
+		t.Fatalf("Len = %d", r.Len())
+	}
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner := r.Get([]byte(key))
+		if owner != r.Get([]byte(key)) {
+			t.Fatalf("the owner of %s changed", key)
+		}
+		counts[owner]++
+		owners[key] = owner
+	}
+	for _, peer := range []string{"a", "b", "c"} {
+		if counts[peer] < 500 {
+			t.Fatalf("unbalanced ring %v", counts)
+		}
+	}
+
+	r.Add("d")
+	moved := 0
+	for key, owner := range owners {
+		if newOwner := r.Get([]byte(key)); newOwner != owner {
+			if newOwner != "d" {
+				t.Fatalf("%s moved from %s to %s", key, owner, newOwner)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > 1500 {
+		t.Fatalf("%d keys moved to the new peer", moved)
+	}
+}
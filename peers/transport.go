@@ -0,0 +1,55 @@
+package peers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coocood/freecache"
+)
+
+// HTTPTransport fetches keys from peers served by Pool.Handler, the peers are base URLs like
+// "http://10.0.0.1:8080".
+type HTTPTransport struct {
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+	// BasePath is the path of Pool.Handler on the peers, DefaultBasePath if empty.
+	BasePath string
+}
+
+// Fetch gets key from peer.
+func (t *HTTPTransport) Fetch(peer string, key []byte) (value []byte, expireSeconds int, err error) {
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	basePath := t.BasePath
+	if basePath == "" {
+		basePath = DefaultBasePath
+	}
+	resp, err := client.Get(strings.TrimSuffix(peer, "/") + basePath + "?key=" + url.QueryEscape(string(key)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		expireSeconds, err = strconv.Atoi(resp.Header.Get(expireHeader))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid %s header from %s: %v", expireHeader, peer, err)
+		}
+		return body, expireSeconds, nil
+	case http.StatusNotFound:
+		return nil, 0, freecache.ErrNotFound
+	case http.StatusBadGateway:
+		return nil, 0, fmt.Errorf("%w %s: %s", ErrPeerLoad, peer, strings.TrimSpace(string(body)))
+	}
+	return nil, 0, fmt.Errorf("fetching from %s: %s", peer, resp.Status)
+}
@@ -0,0 +1,253 @@
+// Package ratelimit implements rate limiters keeping their state in a freecache.Cache, so the state of many
+// keys, e.g. clients or users, adds no GC overhead. Every decision is a single atomic operation of the cache,
+// so the limiters are safe for concurrent use:
+//
+//	limiter := ratelimit.NewSlidingWindow(cache, 100, time.Minute)
+//	if ok, _ := limiter.Allow(clientIP); !ok {
+//		// too many requests.
+//	}
+//
+// The state of a key is an entry of the cache, which expires once it doesn't matter anymore, e.g. when its
+// window ends, so the cache and the limiters should use the same clock, see WithClock. An entry evicted
+// when the cache is full forgets the events of its key, which are allowed again.
+package ratelimit
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+// ErrInvalidCount is returned by AllowN for a count of events n <= 0.
+var ErrInvalidCount = errors.New("The count of events must be positive")
+
+// Limiter is implemented by the rate limiters of the package.
+type Limiter interface {
+	// Allow is AllowN with n = 1.
+	Allow(key []byte) (allowed bool, err error)
+	// AllowN reports whether n events of key are allowed now, and counts them if they are.
+	// It returns ErrInvalidCount if n <= 0.
+	AllowN(key []byte, n int64) (allowed bool, err error)
+}
+
+// Option configures a limiter.
+type Option func(*config)
+
+type config struct {
+	prefix []byte
+	clock  freecache.Clock
+}
+
+// WithPrefix prepends prefix to the keys of the entries of the limiter, so that several limiters, or a limiter
+// and other entries, can share a cache.
+func WithPrefix(prefix []byte) Option {
+	return func(c *config) {
+		c.prefix = append([]byte(nil), prefix...)
+	}
+}
+
+// WithClock sets the clock of the limiter, which should be the clock of the cache, see freecache.WithClock.
+// The default is freecache.NewWallClock.
+func WithClock(clock freecache.Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+func newConfig(opts []Option) (c config) {
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.clock == nil {
+		c.clock = freecache.NewWallClock()
+	}
+	return
+}
+
+// windowKey returns the key of the counter of key in the window idx, the prefix, key and idx as uint64.
+func (c *config) windowKey(key []byte, idx int64) []byte {
+	buf := make([]byte, len(c.prefix)+len(key)+8)
+	n := copy(buf, c.prefix)
+	n += copy(buf[n:], key)
+	binary.BigEndian.PutUint64(buf[n:], uint64(idx))
+	return buf
+}
+
+// decodeCount returns the counter stored in value, 0 if it isn't a counter, and limit at most.
+func decodeCount(value []byte, limit int64) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+	if count := binary.LittleEndian.Uint64(value); count < uint64(limit) {
+		return int64(count)
+	}
+	return limit
+}
+
+func encodeCount(count int64) []byte {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(count))
+	return value
+}
+
+// expireSeconds returns the expiration of an entry which must live for d, rounded up to a second, 1 at least.
+func expireSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 1
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// FixedWindow allows limit events per key in every window of time, the windows being aligned on the unix
+// epoch, e.g. on the minutes for a window of a minute. Up to 2*limit events can happen within a window of
+// time across two windows, see SlidingWindow.
+type FixedWindow struct {
+	cache  *freecache.Cache
+	limit  int64
+	window time.Duration
+	config
+}
+
+// NewFixedWindow returns a limiter of limit events per key per window, which is a second at least.
+func NewFixedWindow(cache *freecache.Cache, limit int64, window time.Duration, opts ...Option) *FixedWindow {
+	if window < time.Second {
+		window = time.Second
+	}
+	return &FixedWindow{cache: cache, limit: limit, window: window, config: newConfig(opts)}
+}
+
+// Allow is AllowN with n = 1.
+func (l *FixedWindow) Allow(key []byte) (allowed bool, err error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n events of key are allowed in the current window, and counts them if they are.
+// It returns ErrInvalidCount if n <= 0.
+func (l *FixedWindow) AllowN(key []byte, n int64) (allowed bool, err error) {
+	if n <= 0 {
+		return false, ErrInvalidCount
+	}
+	now := l.clock.NowNano()
+	idx := now / int64(l.window)
+	end := (idx + 1) * int64(l.window)
+	_, err = l.cache.UpdateE(l.windowKey(key, idx), func(value []byte, found bool) ([]byte, freecache.UpdateAction, int, error) {
+		count := decodeCount(value, l.limit)
+		if n > l.limit-count {
+			return nil, freecache.UpdateKeep, 0, nil
+		}
+		allowed = true
+		return encodeCount(count + n), freecache.UpdateReplace, expireSeconds(time.Duration(end - now)), nil
+	})
+	return
+}
+
+// SlidingWindow allows limit events per key in any window of time, which is estimated from the counts of the
+// current fixed window and of the previous one, weighted by the part of it still in the sliding window,
+// assuming its events were evenly spread.
+type SlidingWindow struct {
+	cache  *freecache.Cache
+	limit  int64
+	window time.Duration
+	config
+}
+
+// NewSlidingWindow returns a limiter of limit events per key per window, which is a second at least.
+func NewSlidingWindow(cache *freecache.Cache, limit int64, window time.Duration, opts ...Option) *SlidingWindow {
+	if window < time.Second {
+		window = time.Second
+	}
+	return &SlidingWindow{cache: cache, limit: limit, window: window, config: newConfig(opts)}
+}
+
+// Allow is AllowN with n = 1.
+func (l *SlidingWindow) Allow(key []byte) (allowed bool, err error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n events of key are allowed in the window ending now, and counts them if they are.
+// The counters of the previous and current fixed windows are read and updated in one atomic operation.
+// It returns ErrInvalidCount if n <= 0.
+func (l *SlidingWindow) AllowN(key []byte, n int64) (allowed bool, err error) {
+	if n <= 0 {
+		return false, ErrInvalidCount
+	}
+	now := l.clock.NowNano()
+	idx := now / int64(l.window)
+	end := (idx + 1) * int64(l.window)
+	weight := float64(end-now) / float64(l.window)
+	var prev int64
+	keys := [][]byte{l.windowKey(key, idx-1), l.windowKey(key, idx)}
+	err = l.cache.UpdateMulti(keys, func(i int, value []byte, found bool) ([]byte, bool, int) {
+		count := decodeCount(value, l.limit)
+		if i == 0 {
+			prev = count
+			return nil, false, 0
+		}
+		if n > l.limit-count-int64(math.Ceil(float64(prev)*weight)) {
+			return nil, false, 0
+		}
+		allowed = true
+		// the counter is the previous window of the next one.
+		return encodeCount(count + n), true, expireSeconds(time.Duration(end + int64(l.window) - now))
+	})
+	return
+}
+
+// TokenBucket allows the events of a key while its bucket has tokens, an event takes a token and the bucket
+// is refilled at rate tokens per second, up to burst tokens. A key without events has a full bucket.
+type TokenBucket struct {
+	cache *freecache.Cache
+	rate  float64
+	burst int64
+	config
+}
+
+// NewTokenBucket returns a limiter refilling the bucket of every key at rate tokens per second up to burst.
+func NewTokenBucket(cache *freecache.Cache, rate float64, burst int64, opts ...Option) *TokenBucket {
+	return &TokenBucket{cache: cache, rate: rate, burst: burst, config: newConfig(opts)}
+}
+
+// Allow is AllowN with n = 1.
+func (l *TokenBucket) Allow(key []byte) (allowed bool, err error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether the bucket of key has n tokens, and takes them if it has. The state of the bucket,
+// its tokens as float64 and the time they were counted as int64, expires when the bucket would be full.
+// It returns ErrInvalidCount if n <= 0.
+func (l *TokenBucket) AllowN(key []byte, n int64) (allowed bool, err error) {
+	if n <= 0 {
+		return false, ErrInvalidCount
+	}
+	now := l.clock.NowNano()
+	_, err = l.cache.UpdateE(append(l.prefix[:len(l.prefix):len(l.prefix)], key...),
+		func(value []byte, found bool) ([]byte, freecache.UpdateAction, int, error) {
+			tokens := float64(l.burst)
+			if len(value) == 16 {
+				tokens = math.Float64frombits(binary.LittleEndian.Uint64(value[0:8]))
+				if elapsed := now - int64(binary.LittleEndian.Uint64(value[8:16])); elapsed > 0 {
+					tokens += float64(elapsed) / float64(time.Second) * l.rate
+				}
+				if tokens > float64(l.burst) || math.IsNaN(tokens) {
+					tokens = float64(l.burst)
+				}
+			}
+			if float64(n) > tokens {
+				return nil, freecache.UpdateKeep, 0, nil
+			}
+			allowed = true
+			tokens -= float64(n)
+			state := make([]byte, 16)
+			binary.LittleEndian.PutUint64(state[0:8], math.Float64bits(tokens))
+			binary.LittleEndian.PutUint64(state[8:16], uint64(now))
+			expire := 0
+			if l.rate > 0 {
+				expire = expireSeconds(time.Duration((float64(l.burst) - tokens) / l.rate * float64(time.Second)))
+			}
+			return state, freecache.UpdateReplace, expire, nil
+		})
+	return
+}
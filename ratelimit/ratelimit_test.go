@@ -0,0 +1,167 @@
+package ratelimit_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/coocood/freecache/clocktest"
+	"github.com/coocood/freecache/ratelimit"
+)
+
+func newCache(clock freecache.Clock) *freecache.Cache {
+	return freecache.NewCacheWithOptions(1024*1024, freecache.WithClock(clock))
+}
+
+// allowed returns the number of events of key allowed out of n.
+func allowed(t *testing.T, l ratelimit.Limiter, key string, n int) (count int) {
+	for i := 0; i < n; i++ {
+		ok, err := l.Allow([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			count++
+		}
+	}
+	return
+}
+
+func TestFixedWindow(t *testing.T) {
+	clock := clocktest.New(time.Unix(1000, 0))
+	cache := newCache(clock)
+	l := ratelimit.NewFixedWindow(cache, 10, time.Minute, ratelimit.WithClock(clock))
+	if n := allowed(t, l, "a", 20); n != 10 {
+		t.Fatalf("allowed = %d", n)
+	}
+	if n := allowed(t, l, "b", 5); n != 5 {
+		t.Fatalf("allowed = %d for another key", n)
+	}
+	if ok, _ := l.AllowN([]byte("b"), 6); ok {
+		t.Fatal("AllowN over the limit is allowed")
+	}
+	if ok, _ := l.AllowN([]byte("b"), 5); !ok {
+		t.Fatal("AllowN up to the limit isn't allowed")
+	}
+	// the windows are aligned on the minutes, 1000s is 40s into a minute.
+	clock.Advance(19 * time.Second)
+	if n := allowed(t, l, "a", 1); n != 0 {
+		t.Fatalf("allowed = %d before the end of the window", n)
+	}
+	clock.Advance(time.Second)
+	if n := allowed(t, l, "a", 20); n != 10 {
+		t.Fatalf("allowed = %d in the next window", n)
+	}
+	// the counters expire with their windows.
+	clock.Advance(2 * time.Minute)
+	if n := cache.EntryCount(); n != 0 {
+		cache.DeleteExpired()
+		if n = cache.EntryCount(); n != 0 {
+			t.Fatalf("EntryCount = %d after the windows", n)
+		}
+	}
+	// the counter can't overflow.
+	if ok, _ := l.AllowN([]byte("c"), 1<<62); ok {
+		t.Fatal("AllowN of a huge n is allowed")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := clocktest.New(time.Unix(960, 0))
+	cache := newCache(clock)
+	l := ratelimit.NewSlidingWindow(cache, 10, time.Minute, ratelimit.WithClock(clock))
+	if n := allowed(t, l, "a", 20); n != 10 {
+		t.Fatalf("allowed = %d", n)
+	}
+	// a quarter into the next window, 3/4 of the 10 events of the last window are in the sliding window.
+	clock.Advance(75 * time.Second)
+	if n := allowed(t, l, "a", 20); n != 2 {
+		t.Fatalf("allowed = %d", n)
+	}
+	// 3/4 into the window, 10/4 rounded up of the last window and the 2 events of the current one.
+	clock.Advance(30 * time.Second)
+	if n := allowed(t, l, "a", 20); n != 5 {
+		t.Fatalf("allowed = %d", n)
+	}
+	clock.Advance(2 * time.Minute)
+	if n := allowed(t, l, "a", 20); n != 10 {
+		t.Fatalf("allowed = %d after an idle window", n)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := clocktest.New(time.Unix(1000, 0))
+	cache := newCache(clock)
+	l := ratelimit.NewTokenBucket(cache, 2, 10, ratelimit.WithClock(clock), ratelimit.WithPrefix([]byte("tb:")))
+	if n := allowed(t, l, "a", 20); n != 10 {
+		t.Fatalf("allowed = %d", n)
+	}
+	clock.Advance(time.Second)
+	if n := allowed(t, l, "a", 20); n != 2 {
+		t.Fatalf("allowed = %d after a second", n)
+	}
+	clock.Advance(1500 * time.Millisecond)
+	if n := allowed(t, l, "a", 20); n != 3 {
+		t.Fatalf("allowed = %d after 1.5s", n)
+	}
+	if _, err := cache.Get([]byte("tb:a")); err != nil {
+		t.Fatalf("the bucket isn't under the prefix: %v", err)
+	}
+	// the bucket is full once its state expires.
+	clock.Advance(10 * time.Second)
+	if _, err := cache.Get([]byte("tb:a")); err != freecache.ErrExpired {
+		t.Fatalf("Get of an expired bucket err = %v", err)
+	}
+	if n := allowed(t, l, "a", 20); n != 10 {
+		t.Fatalf("allowed = %d with a full bucket", n)
+	}
+}
+
+func TestConcurrentLimiters(t *testing.T) {
+	clock := clocktest.New(time.Unix(1000, 0))
+	cache := newCache(clock)
+	for _, l := range []ratelimit.Limiter{
+		ratelimit.NewFixedWindow(cache, 100, time.Minute, ratelimit.WithClock(clock), ratelimit.WithPrefix([]byte("fw:"))),
+		ratelimit.NewSlidingWindow(cache, 100, time.Minute, ratelimit.WithClock(clock), ratelimit.WithPrefix([]byte("sw:"))),
+		ratelimit.NewTokenBucket(cache, 0, 100, ratelimit.WithClock(clock), ratelimit.WithPrefix([]byte("tb:"))),
+	} {
+		var count int64
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					if ok, _ := l.Allow([]byte("key")); ok {
+						atomic.AddInt64(&count, 1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if count != 100 {
+			t.Fatalf("%T allowed %d events", l, count)
+		}
+	}
+}
+
+func TestInvalidCount(t *testing.T) {
+	clock := clocktest.New(time.Unix(1000, 0))
+	cache := newCache(clock)
+	for _, l := range []ratelimit.Limiter{
+		ratelimit.NewFixedWindow(cache, 10, time.Minute, ratelimit.WithClock(clock)),
+		ratelimit.NewSlidingWindow(cache, 10, time.Minute, ratelimit.WithClock(clock), ratelimit.WithPrefix([]byte("s"))),
+		ratelimit.NewTokenBucket(cache, 1, 10, ratelimit.WithClock(clock), ratelimit.WithPrefix([]byte("t"))),
+	} {
+		for _, n := range []int64{0, -5} {
+			if ok, err := l.AllowN([]byte("a"), n); ok || err != ratelimit.ErrInvalidCount {
+				t.Fatalf("%T AllowN(%d) = %v, %v", l, n, ok, err)
+			}
+		}
+		if n := allowed(t, l, "a", 20); n != 10 {
+			t.Fatalf("%T allowed = %d after invalid counts", l, n)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package freecache
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// overloadFactor is the number of times the average evictions of the segments above which a segment is
+// overloaded.
+const overloadFactor = 2
+
+// OverloadedSegments returns the ids of the segments which evicted more than twice the average evictions
+// of the segments since the cache was created or its statistics reset, e.g. when the keys of a hot prefix
+// hash into the same segments, which evict their entries while the others have room.
+func (cache *Cache) OverloadedSegments() (segIDs []int) {
+	var evicted [segmentCount]int64
+	for i := range cache.segments {
+		evicted[i] = atomic.LoadInt64(&cache.segments[i].evicted)
+	}
+	return overloaded(&evicted)
+}
+
+// overloaded returns the ids of the segments whose evictions are above overloadFactor times the average,
+// the most evicting first.
+func overloaded(evicted *[segmentCount]int64) (segIDs []int) {
+	var total int64
+	for _, n := range evicted {
+		total += n
+	}
+	for i, n := range evicted {
+		if n > 0 && n*segmentCount > overloadFactor*total {
+			segIDs = append(segIDs, i)
+		}
+	}
+	sort.SliceStable(segIDs, func(a, b int) bool {
+		return evicted[segIDs[a]] > evicted[segIDs[b]]
+	})
+	return
+}
+
+// rebalancer lends the bytes of a reserve to the overloaded segments, see WithRebalancing.
+type rebalancer struct {
+	mu      sync.Mutex
+	total   int64               // size of the reserve.
+	reserve int64               // bytes of the reserve not lent.
+	step    int64               // bytes lent to or returned by a segment at a time.
+	lent    [segmentCount]int64 // bytes lent to every segment.
+	evicted [segmentCount]int64 // evictions of every segment at the last rebalance.
+}
+
+func newRebalancer(reserve int64, segSize int) *rebalancer {
+	r := &rebalancer{total: reserve}
+	r.reset(segSize)
+	return r
+}
+
+// reset returns the lent bytes to the reserve, the segments have segSize bytes each.
+func (r *rebalancer) reset(segSize int) {
+	r.reserve = r.total
+	r.step = int64(segSize / 4)
+	r.lent = [segmentCount]int64{}
+}
+
+// Rebalance lends the bytes of the reserve of WithRebalancing to the segments overloaded since the last
+// Rebalance, growing them by a quarter of their size, and takes the bytes lent back from the segments which
+// didn't evict since, shrinking them back. It returns the number of bytes lent and returned, 0 without
+// WithRebalancing. Only the segment resized is locked, see Resize.
+func (cache *Cache) Rebalance() (moved int64) {
+	r := cache.rebalancer
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var delta [segmentCount]int64
+	for i := range cache.segments {
+		evicted := atomic.LoadInt64(&cache.segments[i].evicted)
+		delta[i] = evicted - r.evicted[i]
+		if delta[i] < 0 {
+			// the statistics were reset.
+			delta[i] = evicted
+		}
+		r.evicted[i] = evicted
+	}
+	for i := range cache.segments {
+		if delta[i] == 0 && r.lent[i] > 0 && cache.resizeSegment(i, -r.step) == nil {
+			r.lent[i] -= r.step
+			r.reserve += r.step
+			moved += r.step
+		}
+	}
+	for _, i := range overloaded(&delta) {
+		if r.reserve < r.step || r.step == 0 {
+			break
+		}
+		if cache.resizeSegment(i, r.step) != nil {
+			continue
+		}
+		r.lent[i] += r.step
+		r.reserve -= r.step
+		moved += r.step
+	}
+	// the entries evicted by shrinking a segment don't count as evictions of the next period.
+	for i := range cache.segments {
+		r.evicted[i] = atomic.LoadInt64(&cache.segments[i].evicted)
+	}
+	return
+}
+
+// resizeSegment grows or shrinks the ring buffer of the segment segID by n bytes.
+func (cache *Cache) resizeSegment(segID int, n int64) (err error) {
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	err = seg.resize(int(seg.rb.Size() + n))
+	cache.locks[segID].Unlock()
+	return
+}
+
+func (cache *Cache) runRebalancer(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.done:
+			return
+		case <-ticker.C:
+			cache.Rebalance()
+		}
+	}
+}
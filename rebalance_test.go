@@ -0,0 +1,52 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRebalance(t *testing.T) {
+	cache := NewCacheWithOptions(512*1024, WithHashSeed(0), WithRebalancing(64*1024, 0))
+	segSize := cache.segments[5].rb.Size()
+	value := make([]byte, 100)
+	hot := 0
+	for i := 0; hot < 200; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if cache.hash(key)&segmentAndOpVal == 5 {
+			cache.Set(key, value, 0)
+			hot++
+		} else if i%100 == 0 {
+			cache.Set(key, value, 0)
+		}
+	}
+	if segIDs := cache.OverloadedSegments(); len(segIDs) == 0 || segIDs[0] != 5 {
+		t.Fatalf("overloaded segments = %v", segIDs)
+	}
+	if stats := cache.SegmentStats(5); stats.EvictedCount == 0 {
+		t.Fatalf("segment 5 stats = %+v", stats)
+	}
+
+	if moved := cache.Rebalance(); moved != segSize/4 {
+		t.Fatalf("moved = %d, want %d", moved, segSize/4)
+	}
+	if size := cache.SegmentStats(5).BufferBytes; size != segSize+segSize/4 {
+		t.Fatalf("segment 5 size = %d", size)
+	}
+	if errs := cache.Validate(); errs != nil {
+		t.Fatal(errs)
+	}
+	// segment 5 didn't evict since, so it returns the bytes lent.
+	if moved := cache.Rebalance(); moved != segSize/4 {
+		t.Fatalf("moved = %d, want %d", moved, segSize/4)
+	}
+	if size := cache.SegmentStats(5).BufferBytes; size != segSize {
+		t.Fatalf("segment 5 size = %d", size)
+	}
+	if moved := cache.Rebalance(); moved != 0 {
+		t.Fatalf("moved = %d, want 0", moved)
+	}
+
+	if moved := NewCache(512 * 1024).Rebalance(); moved != 0 {
+		t.Fatalf("moved without WithRebalancing = %d", moved)
+	}
+}
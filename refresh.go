@@ -0,0 +1,52 @@
+package freecache
+
+import (
+	"sync"
+)
+
+// refresher reloads the entries due for refresh-ahead in background goroutines.
+type refresher struct {
+	cache    *Cache
+	loader   func(key []byte) (value []byte, expireSeconds int, err error)
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+func newRefresher(cache *Cache, loader func(key []byte) ([]byte, int, error)) *refresher {
+	return &refresher{
+		cache:    cache,
+		loader:   loader,
+		inFlight: make(map[string]struct{}),
+	}
+}
+
+// refresh starts reloading key unless it is already being reloaded or the cache is closed. It is called
+// with the segment lock held, so it must not block. Close waits for the reloads in progress.
+func (r *refresher) refresh(key []byte) {
+	r.mu.Lock()
+	if _, ok := r.inFlight[string(key)]; ok {
+		r.mu.Unlock()
+		return
+	}
+	k := string(key)
+	r.inFlight[k] = struct{}{}
+	r.mu.Unlock()
+	if !r.cache.goBackground(func() { r.reload(k) }) {
+		r.mu.Lock()
+		delete(r.inFlight, k)
+		r.mu.Unlock()
+	}
+}
+
+func (r *refresher) reload(key string) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.inFlight, key)
+		r.mu.Unlock()
+	}()
+	value, expireSeconds, err := r.loader([]byte(key))
+	if err != nil || r.cache.isClosed() {
+		return
+	}
+	r.cache.SetString(key, value, expireSeconds)
+}
@@ -0,0 +1,140 @@
+package freecache
+
+import (
+	"sync/atomic"
+)
+
+// DefaultReplicationQueueSize is the number of writes queued for replication by default, see WithReplicationQueue.
+const DefaultReplicationQueueSize = 4096
+
+// Replicator receives the writes replicated by WithReplication, a *Cache satisfies it, a remote cache
+// can be replicated to by a client implementing it.
+type Replicator interface {
+	Set(key, value []byte, expireSeconds int) error
+	Del(key []byte) (affected bool)
+}
+
+// DropPolicy selects the write dropped when the replication queue is full.
+type DropPolicy uint8
+
+const (
+	// DropNewest drops the new write, the queued writes are replicated.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest queued write to make room for the new one.
+	DropOldest
+)
+
+// ReplicationStats are the counters of the writes queued by WithReplication.
+type ReplicationStats struct {
+	Replicated int64 // writes applied to the target.
+	Failed     int64 // writes the target returned an error for, they are not retried.
+	Dropped    int64 // writes dropped because the queue was full.
+	Queued     int64 // writes waiting in the queue.
+}
+
+// replicatedWrite is a Set or Del waiting to be replicated, the key and value are owned by the queue.
+type replicatedWrite struct {
+	key           []byte
+	value         []byte
+	expireSeconds int
+	deleted       bool
+}
+
+// replicator queues the writes of the cache and applies them to the target in a background goroutine,
+// in the order they were queued.
+type replicator struct {
+	target     Replicator
+	policy     DropPolicy
+	queue      chan replicatedWrite
+	replicated int64
+	failed     int64
+	dropped    int64
+}
+
+func newReplicator(target Replicator, size int, policy DropPolicy) *replicator {
+	if size <= 0 {
+		size = DefaultReplicationQueueSize
+	}
+	return &replicator{
+		target: target,
+		policy: policy,
+		queue:  make(chan replicatedWrite, size),
+	}
+}
+
+func (r *replicator) set(key, value []byte, nowMs, expireAtMs int64) error {
+	r.enqueue(replicatedWrite{key: key, value: value, expireSeconds: int(ttlSeconds(nowMs, expireAtMs))})
+	return nil
+}
+
+func (r *replicator) del(key []byte) error {
+	r.enqueue(replicatedWrite{key: key, deleted: true})
+	return nil
+}
+
+// flush returns immediately, the queue is applied by run as fast as the target allows.
+func (r *replicator) flush() error {
+	return nil
+}
+
+// enqueue never blocks, the write or the oldest queued write is dropped if the queue is full.
+func (r *replicator) enqueue(write replicatedWrite) {
+	for {
+		select {
+		case r.queue <- write:
+			return
+		default:
+		}
+		if r.policy != DropOldest {
+			atomic.AddInt64(&r.dropped, 1)
+			return
+		}
+		select {
+		case <-r.queue:
+			atomic.AddInt64(&r.dropped, 1)
+		default:
+		}
+	}
+}
+
+func (r *replicator) apply(write replicatedWrite) {
+	if write.deleted {
+		r.target.Del(write.key)
+	} else if err := r.target.Set(write.key, write.value, write.expireSeconds); err != nil {
+		atomic.AddInt64(&r.failed, 1)
+		return
+	}
+	atomic.AddInt64(&r.replicated, 1)
+}
+
+// run applies the queued writes until done is closed, then applies the writes left in the queue.
+func (r *replicator) run(done chan struct{}) {
+	for {
+		select {
+		case write := <-r.queue:
+			r.apply(write)
+		case <-done:
+			for {
+				select {
+				case write := <-r.queue:
+					r.apply(write)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// ReplicationStats returns the counters of WithReplication, all zero without replication.
+func (cache *Cache) ReplicationStats() (stats ReplicationStats) {
+	r := cache.replicator
+	if r == nil {
+		return
+	}
+	stats.Replicated = atomic.LoadInt64(&r.replicated)
+	stats.Failed = atomic.LoadInt64(&r.failed)
+	stats.Dropped = atomic.LoadInt64(&r.dropped)
+	stats.Queued = int64(len(r.queue))
+	return
+}
@@ -0,0 +1,100 @@
+package freecache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestReplication(t *testing.T) {
+	standby := NewCache(1024 * 1024)
+	cache := NewCacheWithOptions(1024*1024, WithReplication(standby))
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 100)
+	}
+	for i := 0; i < 10; i++ {
+		cache.Del([]byte(fmt.Sprintf("key%d", i)))
+	}
+	cache.Close()
+
+	if standby.EntryCount() != 90 {
+		t.Fatalf("standby has %d entries", standby.EntryCount())
+	}
+	for i := 10; i < 100; i++ {
+		value, err := standby.Get([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("key%d = %s, %v", i, value, err)
+		}
+		if ttl, _ := standby.TTL([]byte(fmt.Sprintf("key%d", i))); ttl == 0 || ttl > 100 {
+			t.Fatalf("key%d ttl = %d", i, ttl)
+		}
+	}
+	stats := cache.ReplicationStats()
+	if stats != (ReplicationStats{Replicated: 110}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats := NewCache(1024 * 1024).ReplicationStats(); stats != (ReplicationStats{}) {
+		t.Fatalf("stats without replication = %+v", stats)
+	}
+}
+
+func TestReplicationMutators(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 1000000}
+	standby := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithReplication(standby))
+	applyMutators(cache, timer.nowMs)
+	cache.Close()
+	want := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	applyMutators(want, timer.nowMs)
+	assertSameEntries(t, want, standby)
+}
+
+// blockingReplicator records the keys set, blocking every Set until release is closed.
+type blockingReplicator struct {
+	received chan struct{}
+	release  chan struct{}
+	mu       sync.Mutex
+	keys     []string
+}
+
+func (r *blockingReplicator) Set(key, value []byte, expireSeconds int) error {
+	r.received <- struct{}{}
+	<-r.release
+	r.mu.Lock()
+	r.keys = append(r.keys, string(key))
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *blockingReplicator) Del(key []byte) bool {
+	return false
+}
+
+func TestReplicationDropPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy DropPolicy
+		keys   string
+	}{
+		{DropNewest, "[k0 k1 k2]"},
+		{DropOldest, "[k0 k4 k5]"},
+	} {
+		target := &blockingReplicator{received: make(chan struct{}, 10), release: make(chan struct{})}
+		cache := NewCacheWithOptions(1024*1024, WithReplication(target), WithReplicationQueue(2, tt.policy))
+		cache.Set([]byte("k0"), []byte("v"), 0)
+		<-target.received
+		for i := 1; i < 6; i++ {
+			cache.Set([]byte(fmt.Sprintf("k%d", i)), []byte("v"), 0)
+		}
+		if stats := cache.ReplicationStats(); stats.Dropped != 3 || stats.Queued != 2 {
+			t.Fatalf("policy %d stats = %+v", tt.policy, stats)
+		}
+		close(target.release)
+		cache.Close()
+		if keys := fmt.Sprint(target.keys); keys != tt.keys {
+			t.Fatalf("policy %d replicated %s, want %s", tt.policy, keys, tt.keys)
+		}
+		if stats := cache.ReplicationStats(); stats.Replicated != 3 || stats.Queued != 0 {
+			t.Fatalf("policy %d stats after Close = %+v", tt.policy, stats)
+		}
+	}
+}
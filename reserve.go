@@ -0,0 +1,121 @@
+package freecache
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotAdmitted = errors.New("The entry is not admitted by the admission filter")
+
+// ReservedEntry is an entry set by Reserve whose value is written in place before it is committed.
+type ReservedEntry struct {
+	cache     *Cache
+	hashVal   uint64
+	key       []byte
+	hdr       entryHdr
+	ptrOffset int64
+	value     []byte
+	done      bool
+}
+
+// Reserve sets key to a value of valLen bytes expiring in ttl seconds and returns it as a ReservedEntry, so
+// an encoder writes the value in the cache memory instead of building it first. The value is filled through
+// Bytes and the entry is made visible with the value by Commit, or deleted by Abort. The previous value of
+// key is lost in both cases. The segment lock of key is held until Commit or Abort, so they must be called
+// promptly and the cache must not be called in between. Returns ErrOutOfRange for a negative valLen, the
+// errors of Set if the entry is too large, and ErrNotAdmitted if WithTinyLFU rejects it.
+func (cache *Cache) Reserve(key []byte, valLen, ttl int) (entry ReservedEntry, err error) {
+	if valLen < 0 {
+		return entry, ErrOutOfRange
+	}
+	if cache.isClosed() {
+		return entry, ErrClosed
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	if entry.value, entry.hdr, entry.ptrOffset, err = seg.reserve(key, hashVal, valLen, ttl); err != nil {
+		cache.locks[segID].Unlock()
+		return
+	}
+	entry.cache = cache
+	entry.hashVal = hashVal
+	entry.key = append([]byte(nil), key...)
+	return
+}
+
+// Bytes returns the value of the entry to be filled before Commit.
+func (entry *ReservedEntry) Bytes() []byte {
+	return entry.value
+}
+
+// Commit makes the entry visible with the value filled through Bytes and releases the segment lock.
+// It does nothing if the entry is already committed or aborted.
+func (entry *ReservedEntry) Commit() (err error) {
+	if entry.done || entry.cache == nil {
+		return
+	}
+	entry.done = true
+	cache := entry.cache
+	segID := entry.hashVal & segmentAndOpVal
+	seg := &cache.segments[segID]
+	valOff := entry.ptrOffset + ENTRY_HDR_SIZE + int64(entry.hdr.keyLen)
+	if seg.rb.getDataOff(valOff)+len(entry.value) > len(seg.rb.data) {
+		seg.rb.WriteAt(entry.value, valOff)
+	}
+	if seg.checksums {
+		seg.writeChecksum(&entry.hdr, entry.ptrOffset, entry.key, entry.value)
+	}
+	if seg.events.enabled() {
+		seg.events.emit(EventSet, entry.key, entry.value)
+	}
+	err = seg.logEntry(entry.key, entry.hashVal, &entry.hdr, entry.ptrOffset)
+	entry.value = nil
+	cache.locks[segID].Unlock()
+	return
+}
+
+// Abort deletes the entry and releases the segment lock. It does nothing if the entry is already committed
+// or aborted.
+func (entry *ReservedEntry) Abort() {
+	if entry.done || entry.cache == nil {
+		return
+	}
+	entry.done = true
+	segID := entry.hashVal & segmentAndOpVal
+	seg := &entry.cache.segments[segID]
+	// the subscribers haven't seen the set of the entry.
+	events := seg.events
+	seg.events = nil
+	seg.del(entry.key, entry.hashVal)
+	seg.events = events
+	entry.value = nil
+	entry.cache.locks[segID].Unlock()
+}
+
+// reserve sets an entry of key with a value of valLen bytes whose content is undefined, and returns the
+// value, a view of the ring buffer, or a copy to be written back by Commit if it wraps around.
+func (seg *segment) reserve(key []byte, hashVal uint64, valLen, ttl int) (value []byte, hdr entryHdr, ptrOffset int64, err error) {
+	if seg.rb.data == nil {
+		return nil, hdr, 0, ErrClosed
+	}
+	if valLen > len(seg.rb.data)/4 {
+		return nil, hdr, 0, ErrLargeEntry
+	}
+	nowMs := seg.timer.NowMilli()
+	// the content of the value is set by Commit, so any bytes of the right length are set, and the set
+	// isn't sent to the subscribers and the writers until then.
+	events, writes := seg.events, seg.writes
+	seg.events, seg.writes = nil, nil
+	err = seg.setAt(key, seg.rb.data[:valLen], hashVal, nowMs, expireAtMilli(nowMs, seg.setPolicy(time.Duration(ttl)*time.Second)), 0, 0, 1)
+	seg.events, seg.writes = events, writes
+	if err != nil {
+		return
+	}
+	if hdr, ptrOffset, err = seg.locate(key, hashVal, true); err != nil {
+		return nil, hdr, 0, ErrNotAdmitted
+	}
+	value, err = seg.rb.Slice(ptrOffset+ENTRY_HDR_SIZE+int64(hdr.keyLen), int64(hdr.valLen))
+	return
+}
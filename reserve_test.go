@@ -0,0 +1,83 @@
+package freecache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestReserve(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(512*1024, WithChecksums(true), WithWriteThrough(store))
+	events := cache.Events(10)
+	key := []byte("key")
+	cache.Set(key, []byte("old"), 0)
+	<-events
+	entry, err := cache.Reserve(key, 5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Bytes()) != 5 {
+		t.Fatalf("len(Bytes()) = %d", len(entry.Bytes()))
+	}
+	copy(entry.Bytes(), "hello")
+	if err = entry.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	entry.Commit()
+	entry.Abort()
+	if v, err := cache.Get(key); err != nil || string(v) != "hello" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if ttl, _ := cache.TTL(key); ttl == 0 || ttl > 10 {
+		t.Fatalf("ttl = %d", ttl)
+	}
+	if ev := <-events; ev.Type != EventSet || string(ev.Value) != "hello" {
+		t.Fatalf("event = %+v", ev)
+	}
+	if store.data["key"] != "hello" {
+		t.Fatalf("store = %q", store.data["key"])
+	}
+
+	entry, _ = cache.Reserve(key, 3, 0)
+	entry.Abort()
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Fatalf("Get after Abort err = %v", err)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("event of an aborted entry %+v", ev)
+	default:
+	}
+
+	// the values wrapping around the ring buffer are written back by Commit.
+	value := make([]byte, 200)
+	for i := 0; i < 10000; i++ {
+		k := []byte(fmt.Sprintf("key%d", i))
+		entry, err := cache.Reserve(k, len(value), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := range entry.Bytes() {
+			entry.Bytes()[j] = byte(i + j)
+		}
+		entry.Commit()
+		for j := range value {
+			value[j] = byte(i + j)
+		}
+		if v, err := cache.Get(k); err != nil || !bytes.Equal(v, value) {
+			t.Fatalf("Get(%s) = %v", k, err)
+		}
+	}
+
+	if _, err := cache.Reserve(key, cache.MaxValueLen(len(key))+1, 0); err != ErrLargeEntry {
+		t.Fatalf("Reserve of a large entry err = %v", err)
+	}
+	if _, err := cache.Reserve(key, -1, 0); err != ErrOutOfRange {
+		t.Fatalf("Reserve of a negative length err = %v", err)
+	}
+	cache.Close()
+	if _, err := cache.Reserve(key, 1, 0); err != ErrClosed {
+		t.Fatalf("Reserve after Close err = %v", err)
+	}
+}
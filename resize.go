@@ -0,0 +1,275 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"runtime/debug"
+	"sync/atomic"
+	"unsafe"
+)
+
+var ErrMmapResize = errors.New("Cache with a memory mapped file can't be resized")
+
+// Resize changes the size of the cache online, without clearing it. The live entries are copied to the new
+// ring buffers one segment at a time, so only one segment is locked at a time. When the cache shrinks, the
+// least recently used entries that don't fit anymore are evicted. The cache size is 512KB at minimum.
+// The bytes lent by WithRebalancing are returned to the reserve. The new ring buffers are allocated by the
+// allocator of WithAllocator, which frees the old ones, if a segment can't be allocated it keeps its size and
+// the error is returned. ErrViewed is returned likewise when a segment has a View not released.
+func (cache *Cache) Resize(newSize int) (err error) {
+	if cache.mapped != nil {
+		return ErrMmapResize
+	}
+	if newSize < minBufSize {
+		newSize = minBufSize
+	}
+	if r := cache.rebalancer; r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.reset(newSize / segmentCount)
+	}
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		err = cache.segments[i].resize(newSize / segmentCount)
+		cache.locks[i].Unlock()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Reset clears the cache and changes its size to newSize, like Clear followed by Resize, without copying the
+// entries. The ring buffers are reused if the size of the segments doesn't change. The entries set while
+// Reset is running may be dropped. The bytes lent by WithRebalancing are returned to the reserve. A segment
+// to reallocate with a View not released keeps its size, and ErrViewed is returned.
+func (cache *Cache) Reset(newSize int) (err error) {
+	if cache.mapped != nil {
+		return ErrMmapResize
+	}
+	if newSize < minBufSize {
+		newSize = minBufSize
+	}
+	if r := cache.rebalancer; r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.reset(newSize / segmentCount)
+	}
+	atomic.AddUint64(&cache.clearGen, 1)
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		err = cache.segments[i].reset(newSize / segmentCount)
+		cache.locks[i].Unlock()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Shrink resizes the cache down to targetSize like Resize and returns the freed memory to the OS.
+// It does nothing if the cache isn't larger than targetSize.
+func (cache *Cache) Shrink(targetSize int) error {
+	if cache.mapped != nil {
+		return ErrMmapResize
+	}
+	var size int64
+	for i := range cache.segments {
+		size += cache.segments[i].rb.Size()
+	}
+	if int64(targetSize) >= size {
+		return nil
+	}
+	if err := cache.Resize(targetSize); err != nil {
+		return err
+	}
+	debug.FreeOSMemory()
+	return nil
+}
+
+// ReleaseUnused deletes the expired entries and shrinks the entry indexes of the segments,
+// which only grow when entries are added, to fit the live entries. The freed memory is returned
+// to the OS. The ring buffers keep their size, use Shrink to make them smaller.
+func (cache *Cache) ReleaseUnused() {
+	cache.DeleteExpired()
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		cache.segments[i].shrinkSlots()
+		cache.locks[i].Unlock()
+	}
+	debug.FreeOSMemory()
+}
+
+// shrinkSlots halves the slot capacity while the longest slot still fits, the opposite of expand.
+func (seg *segment) shrinkSlots() {
+	var maxLen int32
+	for _, l := range seg.slotLens {
+		if l > maxLen {
+			maxLen = l
+		}
+	}
+	slotCap := seg.slotCap
+	for slotCap > 1 && slotCap/2 >= maxLen {
+		slotCap /= 2
+	}
+	if slotCap == seg.slotCap {
+		return
+	}
+	newSlotData := make([]entryPtr, slotCap*256)
+	for i := 0; i < 256; i++ {
+		copy(newSlotData[int32(i)*slotCap:], seg.slotsData[int32(i)*seg.slotCap:int32(i)*seg.slotCap+seg.slotLens[i]])
+	}
+	seg.slotCap = slotCap
+	seg.slotsData = newSlotData
+}
+
+// resize copies the live entries from the oldest to the newest to a new ring buffer of bufSize,
+// expired entries are dropped. The statistics and callbacks of the segment are kept. The old ring buffer
+// is freed, the segment is unchanged if the new one can't be allocated.
+func (seg *segment) resize(bufSize int) error {
+	if seg.rb.data == nil {
+		return ErrClosed
+	}
+	seg.applyClear()
+	if int(seg.rb.Size()) == bufSize {
+		return nil
+	}
+	// the viewed values are in the ring buffer to free.
+	if seg.expireViews(seg.timer.NowMilli()); len(seg.views) > 0 {
+		return ErrViewed
+	}
+	buf, err := seg.allocBuf(bufSize)
+	if err != nil {
+		return err
+	}
+	tmp := newSegmentBuf(buf, seg.segId, seg.timer)
+	tmp.onExpired = seg.onExpired
+	tmp.onEvicted = seg.onEvicted
+	tmp.observer = seg.observer
+	tmp.hist = seg.hist
+	tmp.events = seg.events
+	tmp.maxEntries = seg.maxEntries
+	tmp.maxCost = seg.maxCost
+	tmp.maxPinned = seg.maxPinned
+	tmp.maxMoves = seg.maxMoves
+	tmp.recentAccess = seg.recentAccess
+	tmp.evacuation = seg.evacuation
+	tmp.nsQuota = seg.nsQuota
+	tmp.checksums = seg.checksums
+	nowMs := seg.timer.NowMilli()
+	var entry []byte
+	end := seg.rb.End()
+	for off := end + seg.vacuumLen - seg.rb.Size(); off < end; {
+		var hdrBuf [ENTRY_HDR_SIZE]byte
+		seg.rb.ReadAt(hdrBuf[:], off)
+		hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+		entryLen := seg.entryLen(hdr)
+		if hdr.flags&flagDeleted == 0 {
+			if isExpired(hdr.expireAtMilli(), nowMs) {
+				seg.expire(off, hdr.keyLen)
+			} else {
+				n := ENTRY_HDR_SIZE + int(hdr.keyLen) + int(hdr.valLen)
+				if cap(entry) < n {
+					entry = make([]byte, n)
+				}
+				entry = entry[:n]
+				seg.rb.ReadAt(entry, off)
+				tmp.appendEntry(entry, seg.entryCost(hdr, off), seg.softExpireAt(hdr, off), nowMs)
+			}
+		}
+		off += entryLen
+	}
+	seg.freeBuf(seg.rb.data)
+	seg.rb = tmp.rb
+	seg.vacuumLen = tmp.vacuumLen
+	seg.slotLens = tmp.slotLens
+	seg.slotCap = tmp.slotCap
+	seg.slotsData = tmp.slotsData
+	atomic.StoreInt64(&seg.entryCount, tmp.entryCount)
+	atomic.StoreInt64(&seg.totalCount, tmp.totalCount)
+	atomic.StoreInt64(&seg.totalTime, tmp.totalTime)
+	atomic.StoreInt64(&seg.totalCost, tmp.totalCost)
+	seg.pinnedLen = tmp.pinnedLen
+	atomic.StoreInt64(&seg.deletedLen, tmp.deletedLen)
+	for i := range seg.nsUsed {
+		atomic.StoreInt64(&seg.nsUsed[i], tmp.nsUsed[i])
+	}
+	seg.pinnedCount = tmp.pinnedCount
+	atomic.AddInt64(&seg.totalEvacuate, tmp.totalEvacuate)
+	atomic.AddInt64(&seg.totalExpired, tmp.totalExpired)
+	atomic.AddInt64(&seg.evicted, tmp.evicted)
+	return nil
+}
+
+// reset clears the segment with a ring buffer of bufSize, the ring buffer is reused if it has this size.
+// The segment keeps its ring buffer if the new one can't be allocated, its entries are cleared by applyClear.
+func (seg *segment) reset(bufSize int) error {
+	if seg.rb.data == nil {
+		return ErrClosed
+	}
+	if len(seg.rb.data) != bufSize {
+		if seg.expireViews(seg.timer.NowMilli()); len(seg.views) > 0 {
+			return ErrViewed
+		}
+		buf, err := seg.allocBuf(bufSize)
+		if err != nil {
+			return err
+		}
+		seg.freeBuf(seg.rb.data)
+		seg.rb.data = buf
+	}
+	seg.clear()
+	seg.gen = atomic.LoadUint64(seg.clearGen)
+	return nil
+}
+
+// appendEntry writes an entry copied from another segment, header, key and value, keeping its
+// access time, expiration, soft expiration, cost and pin. The value capacity is trimmed to the value length.
+func (seg *segment) appendEntry(entry []byte, cost, softExpireAtMs int64, nowMs int64) {
+	hdr := (*entryHdr)(unsafe.Pointer(&entry[0]))
+	if int(hdr.keyLen)+int(hdr.valLen) > len(seg.rb.data)/4-ENTRY_HDR_SIZE {
+		return
+	}
+	if seg.maxCost > 0 && cost > seg.maxCost {
+		return
+	}
+	hdr.valCap = hdr.valLen
+	if hdr.valCap == 0 {
+		hdr.valCap = 1
+	}
+	key := entry[ENTRY_HDR_SIZE : ENTRY_HDR_SIZE+int(hdr.keyLen)]
+	entryLen := seg.entryLen(hdr)
+	if hdr.flags&flagPinned != 0 && seg.pinnedLen+entryLen > seg.pinnedBudget() {
+		hdr.flags &^= flagPinned
+	}
+	seg.evacuate(entryLen, cost, 0, hdr.slotId, nowMs)
+	slot := seg.getSlot(hdr.slotId)
+	idx, _ := seg.lookup(slot, hdr.hash16, key)
+	seg.insertEntryPtr(hdr.slotId, hdr.hash16, seg.rb.End(), idx, hdr.keyLen, ttlSeconds(nowMs, hdr.expireAtMilli()))
+	seg.rb.Write(entry)
+	seg.rb.Skip(int64(hdr.valCap - hdr.valLen))
+	if hdr.flags&flagCost != 0 {
+		var costBuf [8]byte
+		binary.LittleEndian.PutUint64(costBuf[:], uint64(cost))
+		seg.rb.Write(costBuf[:])
+	}
+	if hdr.expireMs&expireMsSoft != 0 {
+		var softBuf [8]byte
+		binary.LittleEndian.PutUint64(softBuf[:], uint64(softExpireAtMs))
+		seg.rb.Write(softBuf[:])
+	}
+	if seg.checksums {
+		var sumBuf [checksumLen]byte
+		binary.LittleEndian.PutUint32(sumBuf[:], checksum(key, entry[ENTRY_HDR_SIZE+int(hdr.keyLen):]))
+		seg.rb.Write(sumBuf[:])
+	}
+	atomic.AddInt64(&seg.totalCost, cost)
+	if hdr.flags&flagPinned != 0 {
+		seg.pinnedLen += entryLen
+		seg.pinnedCount++
+	}
+	atomic.AddInt64(&seg.nsUsed[hdr.flags>>nsShift], entryLen)
+	atomic.AddInt64(&seg.totalTime, int64(hdr.accessTime))
+	atomic.AddInt64(&seg.totalCount, 1)
+	seg.vacuumLen -= entryLen
+}
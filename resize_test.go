@@ -0,0 +1,128 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	var evicted int
+	timer := &mockMilliTimer{nowMs: 1000000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithOnEvicted(func(key, value []byte, expireSeconds int) {
+		evicted++
+	}))
+	for i := 0; i < 5000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	cache.Set([]byte("expire"), []byte("v"), 1)
+	cache.Set([]byte("ttl"), []byte("v"), 100)
+	cache.Del([]byte("key0"))
+	if cache.EntryCount() != 5001 || evicted != 0 {
+		t.Fatalf("entry count = %d, evicted = %d", cache.EntryCount(), evicted)
+	}
+	timer.nowMs += 2000
+
+	if err := cache.Resize(4 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if cache.EntryCount() != 5000 || cache.ExpiredCount() != 1 {
+		t.Fatalf("entry count = %d, expired = %d", cache.EntryCount(), cache.ExpiredCount())
+	}
+	for i := 1; i < 5000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if v, err := cache.Get([]byte(key)); err != nil || string(v) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("%s = %q, %v", key, v, err)
+		}
+	}
+	if ttl, err := cache.TTL([]byte("ttl")); err != nil || ttl != 98 {
+		t.Fatalf("ttl = %d, %v, want 98", ttl, err)
+	}
+	for i := 5000; i < 30000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	if evicted != 0 {
+		t.Fatalf("evicted = %d after growing", evicted)
+	}
+
+	count := cache.EntryCount()
+	if err := cache.Resize(512 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if evicted == 0 || cache.EntryCount()+int64(evicted) != count {
+		t.Fatalf("entry count = %d, evicted = %d, want %d in total", cache.EntryCount(), evicted, count)
+	}
+	if v, err := cache.Get([]byte("key29999")); err != nil || string(v) != "value29999" {
+		t.Fatalf("key29999 = %q, %v", v, err)
+	}
+}
+
+func TestShrinkAndReleaseUnused(t *testing.T) {
+	cache := NewCache(4 * 1024 * 1024)
+	for i := 0; i < 20000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	if err := cache.Shrink(8 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if cache.segments[0].rb.Size() != 4*1024*1024/segmentCount {
+		t.Fatalf("size = %d, shrink must not grow the cache", cache.segments[0].rb.Size())
+	}
+	if err := cache.Shrink(1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if cache.segments[0].rb.Size() != 1024*1024/segmentCount {
+		t.Fatalf("size = %d", cache.segments[0].rb.Size())
+	}
+	slotCap := cache.segments[0].slotCap
+	for i := 0; i < 20000; i++ {
+		cache.Del([]byte(fmt.Sprintf("key%d", i)))
+	}
+	cache.Set([]byte("key"), []byte("value"), 0)
+	cache.ReleaseUnused()
+	for i := range cache.segments {
+		if cache.segments[i].slotCap != 1 {
+			t.Fatalf("slot cap = %d, was %d", cache.segments[i].slotCap, slotCap)
+		}
+	}
+	if v, err := cache.Get([]byte("key")); err != nil || string(v) != "value" {
+		t.Fatalf("key = %q, %v", v, err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	alloc := &countingAllocator{}
+	cache := NewCacheWithOptions(1024*1024, WithAllocator(alloc))
+	defer cache.Close()
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte("value"), 0)
+	}
+	data := &cache.segments[0].rb.data[0]
+	if err := cache.Reset(1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if &cache.segments[0].rb.data[0] != data || alloc.allocs != segmentCount {
+		t.Fatalf("ring buffers not reused, allocs = %d", alloc.allocs)
+	}
+	if n := cache.EntryCount(); n != 0 {
+		t.Fatalf("EntryCount = %d", n)
+	}
+	if _, err := cache.Get([]byte("1")); err != ErrNotFound {
+		t.Fatalf("Get err = %v", err)
+	}
+
+	if err := cache.Reset(2 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if cache.segments[0].rb.Size() != 2*1024*1024/segmentCount || alloc.live != segmentCount {
+		t.Fatalf("size = %d, live = %d", cache.segments[0].rb.Size(), alloc.live)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprint(i)), []byte("value"), 0)
+	}
+	if v, err := cache.Get([]byte("999")); err != nil || string(v) != "value" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if errs := cache.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+}
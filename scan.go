@@ -0,0 +1,121 @@
+package freecache
+
+import (
+	"bytes"
+	"unsafe"
+)
+
+// Scan calls fn for every live entry whose key starts with prefix, until fn returns false.
+// The key and value passed to fn are only valid until fn returns. The matching entries of a segment
+// are copied while it is locked and fn is called after it is unlocked, so fn may use the cache.
+// The order of the entries is not guaranteed.
+func (cache *Cache) Scan(prefix []byte, fn func(key, value []byte) bool) {
+	cache.scan(prefix, nil, fn)
+}
+
+// ScanPattern is like Scan, but calls fn for the keys matching a glob pattern, where '*' matches
+// any sequence of bytes and '?' matches any single byte. The literal bytes of pattern before the
+// first wildcard are used as the prefix of the scan.
+func (cache *Cache) ScanPattern(pattern []byte, fn func(key, value []byte) bool) {
+	prefix := pattern
+	if i := bytes.IndexAny(pattern, "*?"); i >= 0 {
+		prefix = pattern[:i]
+	}
+	cache.scan(prefix, pattern, fn)
+}
+
+// scanEntry is the end of the key and the value of an entry copied to scanBuf.
+type scanEntry struct {
+	keyEnd, valEnd int
+}
+
+func (cache *Cache) scan(prefix, pattern []byte, fn func(key, value []byte) bool) {
+	var data []byte
+	var entries []scanEntry
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		data, entries = cache.segments[i].appendMatches(data[:0], entries[:0], prefix, pattern)
+		cache.locks[i].Unlock()
+		start := 0
+		for _, e := range entries {
+			key, value := data[start:e.keyEnd:e.keyEnd], data[e.keyEnd:e.valEnd:e.valEnd]
+			start = e.valEnd
+			// the chunks of the values split by WithLargeValues are scanned reassembled with their keys.
+			if cache.largeValues && isLargeChunk(key) {
+				continue
+			}
+			if cache.stubbed(value) {
+				var err error
+				if value, err = cache.unstub(key, value, true); err != nil {
+					continue
+				}
+			}
+			if !fn(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// appendMatches appends the keys and values of the live entries matching prefix and pattern to data.
+func (seg *segment) appendMatches(data []byte, entries []scanEntry, prefix, pattern []byte) ([]byte, []scanEntry) {
+	seg.applyClear()
+	nowMs := seg.timer.NowMilli()
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for slotId := 0; slotId < 256; slotId++ {
+		for _, ptr := range seg.getSlot(uint8(slotId)) {
+			if int(ptr.keyLen) < len(prefix) {
+				continue
+			}
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			if isExpired(hdr.expireAtMilli(), nowMs) || hdr.flags&flagNegative != 0 {
+				continue
+			}
+			start := len(data)
+			n := int(hdr.keyLen) + int(hdr.valLen)
+			if cap(data)-start < n {
+				newData := make([]byte, start, 2*cap(data)+n)
+				copy(newData, data)
+				data = newData
+			}
+			data = data[:start+n]
+			key := data[start : start+int(hdr.keyLen)]
+			seg.rb.ReadAt(key, ptr.offset+ENTRY_HDR_SIZE)
+			if !bytes.HasPrefix(key, prefix) || (pattern != nil && !globMatch(pattern, key)) {
+				data = data[:start]
+				continue
+			}
+			seg.rb.ReadAt(data[start+int(hdr.keyLen):], ptr.offset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+			entries = append(entries, scanEntry{keyEnd: start + int(hdr.keyLen), valEnd: start + n})
+		}
+	}
+	return data, entries
+}
+
+// globMatch reports whether key matches pattern, where '*' matches any sequence of bytes
+// and '?' matches any single byte.
+func globMatch(pattern, key []byte) bool {
+	p, k := 0, 0
+	// the positions to retry from after the last '*'.
+	starP, starK := -1, 0
+	for k < len(key) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == key[k]):
+			p++
+			k++
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starK = p, k
+			p++
+		case starP >= 0:
+			starK++
+			p, k = starP+1, starK
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,79 @@
+package freecache
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("user:%d", i)), []byte(fmt.Sprintf("u%d", i)), 0)
+		cache.Set([]byte(fmt.Sprintf("order:%d", i)), []byte(fmt.Sprintf("o%d", i)), 0)
+	}
+	cache.SetNotFound([]byte("user:missing"), 0)
+	var keys []string
+	cache.Scan([]byte("user:"), func(key, value []byte) bool {
+		if "u"+string(key[len("user:"):]) != string(value) {
+			t.Fatalf("%s = %s", key, value)
+		}
+		keys = append(keys, string(key))
+		return true
+	})
+	if len(keys) != 100 {
+		t.Fatalf("scanned %d keys, want 100", len(keys))
+	}
+
+	var n int
+	cache.Scan(nil, func(key, value []byte) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("scanned %d keys after stop, want 10", n)
+	}
+
+	// fn may use the cache.
+	cache.Scan([]byte("order:"), func(key, value []byte) bool {
+		cache.Del(key)
+		return true
+	})
+	if cache.EntryCount() != 101 {
+		t.Fatalf("entry count = %d, want 101", cache.EntryCount())
+	}
+
+	keys = keys[:0]
+	cache.ScanPattern([]byte("user:?5"), func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	sort.Strings(keys)
+	if fmt.Sprint(keys) != "[user:15 user:25 user:35 user:45 user:55 user:65 user:75 user:85 user:95]" {
+		t.Fatalf("keys = %v", keys)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"a?c", "abc", true},
+		{"a*", "a", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbd", false},
+		{"*b*", "abc", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"**", "x", true},
+		{"?", "", false},
+	} {
+		if globMatch([]byte(c.pattern), []byte(c.key)) != c.match {
+			t.Errorf("globMatch(%q, %q) = %v", c.pattern, c.key, !c.match)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package freecache
 
 import (
+	"encoding/binary"
 	"errors"
+	"hash/crc32"
+	"strconv"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -13,13 +17,40 @@ var ErrLargeKey = errors.New("The key is larger than 65535")
 var ErrLargeEntry = errors.New("The entry size is larger than 1/1024 of cache size")
 var ErrNotFound = errors.New("Entry not found")
 var ErrExpired = errors.New("Entry expired")
+var ErrNotInteger = errors.New("The value is not an integer or out of range")
+var ErrNegativeCached = errors.New("Entry cached as not found")
+var ErrLargeCost = errors.New("The entry cost is larger than 1/256 of the cost budget")
+var ErrPinnedBudget = errors.New("The pinned entries exceed the pinned bytes budget")
+var ErrQuotaExceeded = errors.New("The entry size is larger than 1/256 of the namespace quota")
+var ErrBufferTooSmall = errors.New("The buffer is smaller than the value")
+var ErrComputedLength = errors.New("The computed length is out of the buffer")
+var ErrCorrupted = errors.New("The entry checksum doesn't match")
+var ErrClosed = errors.New("The cache is closed")
+var ErrUnsafeGetDisabled = errors.New("GetUnsafe is not enabled by WithUnsafeGet")
+
+const (
+	flagDeleted  uint8 = 1 << iota // the entry has been deleted and is left for evacuation.
+	flagNegative                   // the entry caches a not found result, it has no value.
+	flagCost                       // the value capacity is followed by the cost of the entry as int64, otherwise it is 1.
+	flagPinned                     // the entry is moved instead of evicted by evacuate.
+)
+
+// the upper bits of the flags are the id of the quota namespace of the entry, 0 means none.
+const (
+	nsShift            = 4
+	maxQuotaNamespaces = 1<<(8-nsShift) - 1
+)
+
+// expireMsSoft is set in the expireMs of an entry followed by its soft expiration in milliseconds as int64,
+// after its cost if it has one, see SetWithSoftTTL. The milliseconds of expireMs are below 1000.
+const expireMsSoft uint16 = 1 << 15
 
 // entry pointer struct points to an entry in ring buffer
 type entryPtr struct {
-	offset   int64  // entry offset in ring buffer
-	hash16   uint16 // entries are ordered by hash16 in a slot.
-	keyLen   uint16 // used to compare a key
-	reserved uint32
+	offset int64  // entry offset in ring buffer
+	hash16 uint16 // entries are ordered by hash16 in a slot.
+	keyLen uint16 // used to compare a key
+	ttl    uint32 // seconds from the last set or touch to the expiration, used by refresh-ahead.
 }
 
 // entry header struct in ring buffer, followed by key and value.
@@ -30,9 +61,9 @@ type entryHdr struct {
 	hash16     uint16
 	valLen     uint32
 	valCap     uint32
-	deleted    bool
+	flags      uint8
 	slotId     uint8
-	reserved   uint16
+	expireMs   uint16 // millisecond part of expireAt, for sub-second expiration, and expireMsSoft.
 }
 
 // a segment contains 256 slots, a slot is an array of entry pointers ordered by hash16 value
@@ -46,21 +77,70 @@ type segment struct {
 	entryCount    int64
 	totalCount    int64      // number of entries in ring buffer, including deleted entries.
 	totalTime     int64      // used to calculate least recent used entry.
-	timer         Timer      // Timer giving current time
+	timer         MilliTimer // Timer giving current time
 	totalEvacuate int64      // used for debug
 	totalExpired  int64      // used for debug
 	overwrites    int64      // used for debug
 	touched       int64      // used for debug
+	rejected      int64      // used for debug
+	deleted       int64      // entries removed by Del.
+	evicted       int64      // entries evicted before they expired.
+	relocated     int64      // entries moved to the end of the ring buffer by an overwrite with a larger value.
+	accessExpired int64      // entries found expired by an access, counted by totalExpired too.
 	vacuumLen     int64      // up to vacuumLen, new data can be written without overwriting old data.
+	deletedLen    int64      // bytes of the deleted entries not reclaimed by evacuate yet.
 	slotLens      [256]int32 // The actual length for every slot.
 	slotCap       int32      // max number of entry pointers a slot can hold.
 	slotsData     []entryPtr // shared by all 256 slots
+	alloc         Allocator  // allocates the ring buffer, nil means the Go heap.
+	onExpired     func(key []byte)
+	onEvicted     func(key, value []byte, expireSeconds int)
+	observer      Observer
+	checksums     bool          // every entry is followed by the CRC32 of its key and value.
+	sliding       bool          // every access extends the expiration by the ttl of the entry.
+	defaultTTL    time.Duration // ttl of the sets without expiration, 0 means no expire.
+	maxTTL        time.Duration // ttls are clamped to maxTTL if it is not 0.
+	events        *eventHub
+	batched       bool                          // the sets don't emit their events, UpdateMulti emits them once all succeeded.
+	writes        *writeLog                     // propagates the writes to the stores and logs, may be nil.
+	onRefresh     func(key []byte)              // called on a hit when the entry is due for refresh-ahead.
+	refreshRatio  float64                       // remaining fraction of the ttl below which an entry is due.
+	maxEntries    int64                         // entries are evicted to stay below it, 0 means no limit.
+	lfu           *tinyLFU                      // admission filter of WithTinyLFU, may be nil.
+	hot           *hotKeys                      // hot key tracker of WithHotKeyTracking, may be nil.
+	window        *hitWindow                    // recent hits and misses of WithHitRateWindow, may be nil.
+	hist          *Histograms                   // distributions of WithHistograms, may be nil.
+	clearGen      *uint64                       // generation of Clear, the segment is cleared when gen is behind it.
+	gen           uint64                        // generation of Clear of the entries of the segment.
+	totalCost     int64                         // cost of the live entries.
+	maxCost       int64                         // entries are evicted to keep totalCost within it, 0 means no limit.
+	pinnedLen     int64                         // bytes of the pinned entries.
+	pinnedCount   int64                         // number of the pinned entries.
+	maxPinned     int64                         // budget of pinnedLen, 0 means 1/4 of the ring buffer, see pinnedBudget.
+	maxMoves      int                           // recently used entries moved in a row by evacuate before one is evicted.
+	recentAccess  int64                         // seconds after an access during which an entry is moved instead of evicted.
+	evacuation    EvacuationPolicy              // selects the entries evicted by evacuate.
+	noEvict       bool                          // evacuate moves the live entries instead of evicting them, see setNoEvict.
+	nsUsed        [maxQuotaNamespaces + 1]int64 // bytes of the entries of every quota namespace.
+	nsQuota       [maxQuotaNamespaces + 1]int64 // budget of nsUsed, 0 means no limit.
+	views         []viewPin                     // entries pinned by the views of AcquireView.
+	viewSeq       uint64                        // id of the last view pin.
+	viewTimeout   int64                         // milliseconds after which a view pin is dropped, 0 means never.
 }
 
 func newSegment(bufSize int, segId int, timer Timer) (seg segment) {
-	seg.rb = NewRingBuf(bufSize, 0)
+	return newSegmentBuf(make([]byte, bufSize), segId, timer)
+}
+
+// newSegmentBuf returns a segment whose ring buffer uses buf, which may be outside the Go heap.
+func newSegmentBuf(buf []byte, segId int, timer Timer) (seg segment) {
+	bufSize := len(buf)
+	seg.rb.data = buf
+	seg.rb.Reset(0)
 	seg.segId = segId
-	seg.timer = timer
+	seg.timer = toMilliTimer(timer)
+	seg.maxMoves = DefaultMaxEvacuationMoves
+	seg.viewTimeout = int64(DefaultViewTimeout / time.Millisecond)
 	seg.vacuumLen = int64(bufSize)
 	seg.slotCap = 1
 	seg.slotsData = make([]entryPtr, 256*seg.slotCap)
@@ -68,24 +148,72 @@ func newSegment(bufSize int, segId int, timer Timer) (seg segment) {
 }
 
 func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (err error) {
+	return seg.setTTL(key, value, hashVal, time.Duration(expireSeconds)*time.Second, 0)
+}
+
+func (seg *segment) setTTL(key, value []byte, hashVal uint64, ttl time.Duration, flags uint8) (err error) {
+	nowMs := seg.timer.NowMilli()
+	return seg.setAt(key, value, hashVal, nowMs, expireAtMilli(nowMs, seg.setPolicy(ttl)), 0, flags, 1)
+}
+
+// setPolicy returns the ttl of a set with ttl, the default ttl if ttl <= 0, clamped to the max ttl.
+func (seg *segment) setPolicy(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		ttl = seg.defaultTTL
+	}
+	return seg.maxPolicy(ttl)
+}
+
+// maxPolicy clamps ttl to the max ttl, ttl <= 0 means no expire, so it is clamped too.
+func (seg *segment) maxPolicy(ttl time.Duration) time.Duration {
+	if seg.maxTTL > 0 && (ttl <= 0 || ttl > seg.maxTTL) {
+		return seg.maxTTL
+	}
+	return ttl
+}
+
+// setAt is like set, but takes an absolute expireAtMs, so callers can keep the expiration of an existing entry,
+// the soft expiration of the entry, 0 means none, and its cost.
+func (seg *segment) setAt(key, value []byte, hashVal uint64, nowMs, expireAtMs, softExpireAtMs int64, flags uint8, cost int64) (err error) {
+	if seg.rb.data == nil {
+		return ErrClosed
+	}
+	seg.applyClear()
 	if len(key) > 65535 {
 		return ErrLargeKey
 	}
+	if seg.maxCost > 0 && cost > seg.maxCost {
+		return ErrLargeCost
+	}
+	nsID := flags >> nsShift
+	if quota := seg.nsQuota[nsID]; nsID != 0 && quota > 0 && int64(ENTRY_HDR_SIZE+len(key)+len(value)) > quota {
+		return ErrQuotaExceeded
+	}
+	if cost != 1 {
+		flags |= flagCost
+	}
+	var soft uint16
+	if softExpireAtMs != 0 {
+		soft = expireMsSoft
+	}
 	maxKeyValLen := len(seg.rb.data)/4 - ENTRY_HDR_SIZE
 	if len(key)+len(value) > maxKeyValLen {
 		// Do not accept large entry.
 		return ErrLargeEntry
 	}
-	now := seg.timer.Now()
-	expireAt := uint32(0)
-	if expireSeconds > 0 {
-		expireAt = now + uint32(expireSeconds)
+	now := uint32(nowMs / 1000)
+	if seg.hist != nil {
+		seg.hist.ValueSizes.add(uint32(len(value)))
 	}
 
 	slotId := uint8(hashVal >> 8)
 	hash16 := uint16(hashVal >> 16)
 	slot := seg.getSlot(slotId)
 	idx, match := seg.lookup(slot, hash16, key)
+	isNew := !match
+	if seg.lfu != nil {
+		seg.lfu.increment(uint32(hashVal))
+	}
 
 	var hdrBuf [ENTRY_HDR_SIZE]byte
 	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
@@ -96,19 +224,46 @@ func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (e
 		hdr.hash16 = hash16
 		hdr.keyLen = uint16(len(key))
 		originAccessTime := hdr.accessTime
+		originCost := seg.entryCost(hdr, matchedPtr.offset)
+		sameLayout := (hdr.flags^flags)&flagCost == 0 && (hdr.expireMs^soft)&expireMsSoft == 0
+		// a pinned entry stays pinned when it is set again.
+		flags |= hdr.flags & flagPinned
+		originFlags := hdr.flags
 		hdr.accessTime = now
-		hdr.expireAt = expireAt
+		hdr.expireMs = soft
+		hdr.setExpireAtMilli(expireAtMs)
+		hdr.flags = flags
 		hdr.valLen = uint32(len(value))
-		if hdr.valCap >= hdr.valLen {
+		if hdr.valCap >= hdr.valLen && sameLayout && (seg.maxCost == 0 || seg.totalCost-originCost+cost <= seg.maxCost) &&
+			!seg.viewed(matchedPtr.offset) {
 			// in place overwrite
 			atomic.AddInt64(&seg.totalTime, int64(hdr.accessTime)-int64(originAccessTime))
+			atomic.AddInt64(&seg.totalCost, cost-originCost)
+			if originNsID := originFlags >> nsShift; originNsID != nsID {
+				atomic.AddInt64(&seg.nsUsed[originNsID], -seg.entryLen(hdr))
+				atomic.AddInt64(&seg.nsUsed[nsID], seg.entryLen(hdr))
+			}
 			seg.rb.WriteAt(hdrBuf[:], matchedPtr.offset)
 			seg.rb.WriteAt(value, matchedPtr.offset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+			if flags&flagCost != 0 {
+				seg.writeCost(hdr, matchedPtr.offset, cost)
+			}
+			if soft != 0 {
+				seg.writeSoftExpireAt(hdr, matchedPtr.offset, softExpireAtMs)
+			}
+			if seg.checksums {
+				seg.writeChecksum(hdr, matchedPtr.offset, key, value)
+			}
+			matchedPtr.ttl = ttlSeconds(nowMs, expireAtMs)
 			atomic.AddInt64(&seg.overwrites, 1)
-			return
+			if seg.events.enabled() && !seg.batched {
+				seg.events.emit(EventSet, key, value)
+			}
+			return seg.logSet(key, value, hashVal, nowMs, expireAtMs, flags&flagNegative != 0)
 		}
 		// avoid unnecessary memory copy.
 		seg.delEntryPtr(slotId, slot, idx)
+		atomic.AddInt64(&seg.relocated, 1)
 		match = false
 		// increase capacity and limit entry len.
 		for hdr.valCap < hdr.valLen {
@@ -122,7 +277,9 @@ func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (e
 		hdr.hash16 = hash16
 		hdr.keyLen = uint16(len(key))
 		hdr.accessTime = now
-		hdr.expireAt = expireAt
+		hdr.expireMs = soft
+		hdr.setExpireAtMilli(expireAtMs)
+		hdr.flags = flags
 		hdr.valLen = uint32(len(value))
 		hdr.valCap = uint32(len(value))
 		if hdr.valCap == 0 { // avoid infinite loop when increasing capacity.
@@ -130,9 +287,24 @@ func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (e
 		}
 	}
 
-	entryLen := ENTRY_HDR_SIZE + int64(len(key)) + int64(hdr.valCap)
-	slotModified := seg.evacuate(entryLen, slotId, now)
-	if slotModified {
+	entryLen := seg.entryLen(hdr)
+	if hdr.flags&flagPinned != 0 && seg.pinnedLen+entryLen > seg.pinnedBudget() {
+		hdr.flags &^= flagPinned
+	}
+	if isNew && seg.lfu != nil && !seg.admit(uint32(hashVal), entryLen, cost, nowMs) {
+		atomic.AddInt64(&seg.rejected, 1)
+		return
+	}
+	slotModified := nsID != 0 && seg.nsQuota[nsID] > 0 && seg.evictNamespace(nsID, entryLen, slotId, nowMs)
+	evacuated, err := seg.evacuate(entryLen, cost, nsID, slotId, nowMs)
+	if err != nil {
+		if !isNew && seg.writes.enabled() {
+			// the previous entry of key is already deleted.
+			seg.writes.del(key)
+		}
+		return
+	}
+	if slotModified || evacuated {
 		// the slot has been modified during evacuation, we need to looked up for the 'idx' again.
 		// otherwise there would be index out of bound error.
 		slot = seg.getSlot(slotId)
@@ -140,18 +312,47 @@ func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (e
 		// assert(match == false)
 	}
 	newOff := seg.rb.End()
-	seg.insertEntryPtr(slotId, hash16, newOff, idx, hdr.keyLen)
+	seg.insertEntryPtr(slotId, hash16, newOff, idx, hdr.keyLen, ttlSeconds(nowMs, expireAtMs))
 	seg.rb.Write(hdrBuf[:])
 	seg.rb.Write(key)
 	seg.rb.Write(value)
 	seg.rb.Skip(int64(hdr.valCap - hdr.valLen))
+	if flags&flagCost != 0 {
+		var costBuf [8]byte
+		binary.LittleEndian.PutUint64(costBuf[:], uint64(cost))
+		seg.rb.Write(costBuf[:])
+	}
+	if soft != 0 {
+		var softBuf [8]byte
+		binary.LittleEndian.PutUint64(softBuf[:], uint64(softExpireAtMs))
+		seg.rb.Write(softBuf[:])
+	}
+	if seg.checksums {
+		var sumBuf [checksumLen]byte
+		binary.LittleEndian.PutUint32(sumBuf[:], checksum(key, value))
+		seg.rb.Write(sumBuf[:])
+	}
+	atomic.AddInt64(&seg.totalCost, cost)
+	if hdr.flags&flagPinned != 0 {
+		seg.pinnedLen += entryLen
+		seg.pinnedCount++
+	}
+	atomic.AddInt64(&seg.nsUsed[nsID], entryLen)
 	atomic.AddInt64(&seg.totalTime, int64(now))
 	atomic.AddInt64(&seg.totalCount, 1)
 	seg.vacuumLen -= entryLen
-	return
+	if seg.events.enabled() && !seg.batched {
+		seg.events.emit(EventSet, key, value)
+	}
+	return seg.logSet(key, value, hashVal, nowMs, expireAtMs, flags&flagNegative != 0)
 }
 
 func (seg *segment) touch(key []byte, hashVal uint64, expireSeconds int) (err error) {
+	return seg.touchTTL(key, hashVal, time.Duration(expireSeconds)*time.Second)
+}
+
+func (seg *segment) touchTTL(key []byte, hashVal uint64, ttl time.Duration) (err error) {
+	seg.applyClear()
 	if len(key) > 65535 {
 		return ErrLargeKey
 	}
@@ -170,78 +371,418 @@ func (seg *segment) touch(key []byte, hashVal uint64, expireSeconds int) (err er
 	seg.rb.ReadAt(hdrBuf[:], matchedPtr.offset)
 	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
 
-	now := seg.timer.Now()
-	if isExpired(hdr.expireAt, now) {
+	nowMs := seg.timer.NowMilli()
+	if isExpired(hdr.expireAtMilli(), nowMs) {
+		seg.expire(matchedPtr.offset, hdr.keyLen)
+		atomic.AddInt64(&seg.accessExpired, 1)
 		seg.delEntryPtr(slotId, slot, idx)
-		atomic.AddInt64(&seg.totalExpired, 1)
 		err = ErrNotFound
-		atomic.AddInt64(&seg.missCount, 1)
+		seg.countMiss()
 		return
 	}
 
-	expireAt := uint32(0)
-	if expireSeconds > 0 {
-		expireAt = now + uint32(expireSeconds)
-	}
-
 	originAccessTime := hdr.accessTime
-	hdr.accessTime = now
-	hdr.expireAt = expireAt
+	hdr.accessTime = uint32(nowMs / 1000)
+	hdr.setExpireAtMilli(expireAtMilli(nowMs, seg.maxPolicy(ttl)))
 	// in place overwrite
 	atomic.AddInt64(&seg.totalTime, int64(hdr.accessTime)-int64(originAccessTime))
 	seg.rb.WriteAt(hdrBuf[:], matchedPtr.offset)
+	matchedPtr.ttl = ttlSeconds(nowMs, hdr.expireAtMilli())
 	atomic.AddInt64(&seg.touched, 1)
+	return seg.logEntry(key, hashVal, hdr, matchedPtr.offset)
+}
+
+// expireBefore sets the expiration of an existing entry to expireAtMs unless it expires before, without
+// touching it, see DelAt. The entry is deleted if expireAtMs is already passed.
+func (seg *segment) expireBefore(key []byte, hashVal uint64, expireAtMs int64) (deleted bool, err error) {
+	hdr, ptrOffset, err := seg.locate(key, hashVal, true)
+	if err == ErrExpired || err == ErrNegativeCached {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return
+	}
+	nowMs := seg.timer.NowMilli()
+	if expireAtMs <= nowMs {
+		return seg.del(key, hashVal), nil
+	}
+	if cur := hdr.expireAtMilli(); cur != 0 && cur <= expireAtMs {
+		return
+	}
+	hdr.setExpireAtMilli(expireAtMs)
+	seg.rb.WriteAt((*[ENTRY_HDR_SIZE]byte)(unsafe.Pointer(&hdr))[:], ptrOffset)
+	slot := seg.getSlot(hdr.slotId)
+	if idx, match := seg.lookup(slot, hdr.hash16, key); match {
+		slot[idx].ttl = ttlSeconds(nowMs, expireAtMs)
+	}
+	return false, seg.logEntry(key, hashVal, &hdr, ptrOffset)
+}
+
+// incr adds delta to the integer value of key, or subtracts it if decr is set, so Decr doesn't negate delta,
+// which would overflow for math.MinInt64. With keepTTL, an existing key keeps its expiration.
+func (seg *segment) incr(key []byte, delta int64, decr bool, hashVal uint64, expireSeconds int, keepTTL bool) (value int64, err error) {
+	var buf [20]byte
+	nowMs := seg.timer.NowMilli()
+	expireAtMs := expireAtMilli(nowMs, seg.setPolicy(time.Duration(expireSeconds)*time.Second))
+	oldVal, _, err := seg.get(key, buf[:0], hashVal, false)
+	if err == nil {
+		value, err = strconv.ParseInt(string(oldVal), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		if keepTTL {
+			hdr, _, _ := seg.locate(key, hashVal, true)
+			expireAtMs = hdr.expireAtMilli()
+		}
+	} else if err != ErrNotFound && err != ErrExpired && err != ErrNegativeCached {
+		return
+	}
+	if decr {
+		if (delta > 0 && value < value-delta) || (delta < 0 && value > value-delta) {
+			return 0, ErrNotInteger
+		}
+		value -= delta
+	} else {
+		if (delta > 0 && value > value+delta) || (delta < 0 && value < value+delta) {
+			return 0, ErrNotInteger
+		}
+		value += delta
+	}
+	err = seg.setAt(key, strconv.AppendInt(buf[:0], value, 10), hashVal, nowMs, expireAtMs, 0, 0, 1)
+	return
+}
+
+// extend appends or prepends data to the value of an existing entry. The entry is updated in place
+// if its capacity allows, otherwise it is re-inserted with the same expiration.
+func (seg *segment) extend(key, data []byte, hashVal uint64, prepend bool) (err error) {
+	hdr, ptrOffset, err := seg.locate(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	valOff := ptrOffset + ENTRY_HDR_SIZE + int64(hdr.keyLen)
+	newLen := int(hdr.valLen) + len(data)
+	if uint64(hdr.valCap) < uint64(newLen) || seg.viewed(ptrOffset) {
+		value := make([]byte, newLen)
+		if prepend {
+			copy(value, data)
+			seg.rb.ReadAt(value[len(data):], valOff)
+		} else {
+			seg.rb.ReadAt(value[:hdr.valLen], valOff)
+			copy(value[hdr.valLen:], data)
+		}
+		return seg.setAt(key, value, hashVal, seg.timer.NowMilli(), hdr.expireAtMilli(), seg.softExpireAt(&hdr, ptrOffset), hdr.flags&^(1<<nsShift-1), seg.entryCost(&hdr, ptrOffset))
+	}
+	// in place overwrite
+	if prepend {
+		value := make([]byte, newLen)
+		copy(value, data)
+		seg.rb.ReadAt(value[len(data):], valOff)
+		seg.rb.WriteAt(value, valOff)
+	} else {
+		seg.rb.WriteAt(data, valOff+int64(hdr.valLen))
+	}
+	hdr.valLen = uint32(newLen)
+	seg.rb.WriteAt((*[ENTRY_HDR_SIZE]byte)(unsafe.Pointer(&hdr))[:], ptrOffset)
+	if seg.checksums {
+		value := make([]byte, newLen)
+		seg.rb.ReadAt(value, valOff)
+		seg.writeChecksum(&hdr, ptrOffset, key, value)
+	}
+	atomic.AddInt64(&seg.overwrites, 1)
+	return seg.logEntry(key, hashVal, &hdr, ptrOffset)
+}
+
+// mutate calls fn with the value of an existing entry, which fn modifies in place. The value is a view of the
+// ring buffer, or a copy written back if it wraps around, and its checksum is updated even if fn fails.
+func (seg *segment) mutate(key []byte, hashVal uint64, fn func(value []byte) error) (err error) {
+	hdr, ptrOffset, err := seg.locate(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	valOff := ptrOffset + ENTRY_HDR_SIZE + int64(hdr.keyLen)
+	value, err := seg.rb.Slice(valOff, int64(hdr.valLen))
+	if err != nil {
+		return
+	}
+	if err = seg.verifyChecksum(&hdr, ptrOffset, key, value); err != nil {
+		return
+	}
+	seg.countHit()
+	if seg.viewed(ptrOffset) {
+		// the viewed value is kept, the modified copy is stored in a new entry.
+		value = append([]byte(nil), value...)
+		if err = fn(value); err != nil {
+			return
+		}
+		return seg.setAt(key, value, hashVal, seg.timer.NowMilli(), hdr.expireAtMilli(), seg.softExpireAt(&hdr, ptrOffset), hdr.flags&^(1<<nsShift-1), seg.entryCost(&hdr, ptrOffset))
+	}
+	err = fn(value)
+	if seg.rb.getDataOff(valOff)+len(value) > len(seg.rb.data) {
+		seg.rb.WriteAt(value, valOff)
+	}
+	if seg.checksums {
+		seg.writeChecksum(&hdr, ptrOffset, key, value)
+	}
+	if err == nil {
+		atomic.AddInt64(&seg.overwrites, 1)
+	}
+	if logErr := seg.logEntry(key, hashVal, &hdr, ptrOffset); err == nil {
+		err = logErr
+	}
+	return
+}
+
+// getRange returns a copy of length bytes of the value of an existing entry from offset, fewer if the value
+// ends before. Only these bytes are read, unless the whole value is needed to verify its checksum.
+func (seg *segment) getRange(key []byte, hashVal uint64, offset, length int) (value []byte, err error) {
+	if offset < 0 || length < 0 {
+		return nil, ErrOutOfRange
+	}
+	hdr, ptrOffset, err := seg.locate(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	valOff := ptrOffset + ENTRY_HDR_SIZE + int64(hdr.keyLen)
+	if offset > int(hdr.valLen) {
+		offset = int(hdr.valLen)
+	}
+	if length > int(hdr.valLen)-offset {
+		length = int(hdr.valLen) - offset
+	}
+	if seg.checksums {
+		var whole []byte
+		if whole, err = seg.rb.Slice(valOff, int64(hdr.valLen)); err != nil {
+			return
+		}
+		if err = seg.verifyChecksum(&hdr, ptrOffset, key, whole); err != nil {
+			return
+		}
+	}
+	value = make([]byte, length)
+	seg.rb.ReadAt(value, valOff+int64(offset))
+	seg.countHit()
+	return
+}
+
+// setRange overwrites the value of an existing entry with data from offset, the value is extended if data
+// goes past its end, with zeros between its end and offset. Like extend, the entry is updated in place if
+// its capacity allows, otherwise it is re-inserted with the same expiration.
+func (seg *segment) setRange(key []byte, hashVal uint64, offset int, data []byte) (err error) {
+	if offset < 0 {
+		return ErrOutOfRange
+	}
+	if offset+len(data) > len(seg.rb.data) {
+		return ErrLargeEntry
+	}
+	hdr, ptrOffset, err := seg.locate(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	valOff := ptrOffset + ENTRY_HDR_SIZE + int64(hdr.keyLen)
+	newLen := int(hdr.valLen)
+	if offset+len(data) > newLen {
+		newLen = offset + len(data)
+	}
+	if uint64(hdr.valCap) < uint64(newLen) || seg.viewed(ptrOffset) {
+		value := make([]byte, newLen)
+		seg.rb.ReadAt(value[:hdr.valLen], valOff)
+		copy(value[offset:], data)
+		return seg.setAt(key, value, hashVal, seg.timer.NowMilli(), hdr.expireAtMilli(), seg.softExpireAt(&hdr, ptrOffset), hdr.flags&^(1<<nsShift-1), seg.entryCost(&hdr, ptrOffset))
+	}
+	// in place overwrite
+	if gap := offset - int(hdr.valLen); gap > 0 {
+		seg.rb.WriteAt(make([]byte, gap), valOff+int64(hdr.valLen))
+	}
+	seg.rb.WriteAt(data, valOff+int64(offset))
+	if newLen != int(hdr.valLen) {
+		hdr.valLen = uint32(newLen)
+		seg.rb.WriteAt((*[ENTRY_HDR_SIZE]byte)(unsafe.Pointer(&hdr))[:], ptrOffset)
+	}
+	if seg.checksums {
+		value := make([]byte, newLen)
+		seg.rb.ReadAt(value, valOff)
+		seg.writeChecksum(&hdr, ptrOffset, key, value)
+	}
+	atomic.AddInt64(&seg.overwrites, 1)
+	return seg.logEntry(key, hashVal, &hdr, ptrOffset)
+}
+
+// admit reports whether a new entry of hash h is accessed at least as often as the oldest live entry,
+// which would be the first to be evicted to make room for it.
+func (seg *segment) admit(h uint32, entryLen, cost int64, nowMs int64) bool {
+	if !seg.full(entryLen, cost) {
+		return true
+	}
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	end := seg.rb.End()
+	for off := end + seg.vacuumLen - seg.rb.Size(); off < end; off += seg.entryLen(hdr) {
+		seg.rb.ReadAt(hdrBuf[:], off)
+		if hdr.flags&flagDeleted != 0 {
+			continue
+		}
+		if isExpired(hdr.expireAtMilli(), nowMs) {
+			return true
+		}
+		victim := uint32(seg.segId) | uint32(hdr.slotId)<<8 | uint32(hdr.hash16)<<16
+		return seg.lfu.estimate(h) >= seg.lfu.estimate(victim)
+	}
+	return true
+}
+
+// pin pins or unpins an existing entry, a pinned entry is moved instead of evicted by evacuate.
+func (seg *segment) pin(key []byte, hashVal uint64, pin bool) (err error) {
+	seg.applyClear()
+	slotId := uint8(hashVal >> 8)
+	hash16 := uint16(hashVal >> 16)
+	slot := seg.getSlot(slotId)
+	idx, match := seg.lookup(slot, hash16, key)
+	if !match {
+		return ErrNotFound
+	}
+	offset := slot[idx].offset
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], offset)
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if isExpired(hdr.expireAtMilli(), seg.timer.NowMilli()) {
+		return ErrNotFound
+	}
+	if pin == (hdr.flags&flagPinned != 0) {
+		return nil
+	}
+	entryLen := seg.entryLen(hdr)
+	if pin {
+		if seg.pinnedLen+entryLen > seg.pinnedBudget() {
+			return ErrPinnedBudget
+		}
+		hdr.flags |= flagPinned
+		seg.pinnedLen += entryLen
+		seg.pinnedCount++
+	} else {
+		hdr.flags &^= flagPinned
+		seg.pinnedLen -= entryLen
+		seg.pinnedCount--
+	}
+	seg.rb.WriteAt(hdrBuf[:], offset)
+	return nil
+}
+
+// pinnedBudget returns the bytes that can be pinned, at most half of the ring buffer,
+// so evacuate can always make room for a new entry.
+func (seg *segment) pinnedBudget() int64 {
+	if seg.maxPinned == 0 {
+		return seg.rb.Size() / 4
+	}
+	if seg.maxPinned > seg.rb.Size()/2 {
+		return seg.rb.Size() / 2
+	}
+	return seg.maxPinned
+}
+
+// evictNamespace evicts the oldest entries of the quota namespace nsID until an entry of entryLen fits
+// in its quota, pinned entries are kept.
+func (seg *segment) evictNamespace(nsID uint8, entryLen int64, slotId uint8, nowMs int64) (slotModified bool) {
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	end := seg.rb.End()
+	for off := end + seg.vacuumLen - seg.rb.Size(); off < end && seg.nsUsed[nsID]+entryLen > seg.nsQuota[nsID]; off += seg.entryLen(hdr) {
+		seg.rb.ReadAt(hdrBuf[:], off)
+		if hdr.flags&(flagDeleted|flagPinned) != 0 || hdr.flags>>nsShift != nsID {
+			continue
+		}
+		if isExpired(hdr.expireAtMilli(), nowMs) {
+			seg.expire(off, hdr.keyLen)
+		} else {
+			seg.evict(off, hdr, nowMs)
+		}
+		seg.delEntryPtrByOffset(hdr.slotId, hdr.hash16, off)
+		if hdr.slotId == slotId {
+			slotModified = true
+		}
+	}
 	return
 }
 
-func (seg *segment) evacuate(entryLen int64, slotId uint8, now uint32) (slotModified bool) {
+// full reports whether entries must be evicted to add an entry of entryLen and cost,
+// for the ring buffer, the entry limit or the cost budget.
+func (seg *segment) full(entryLen, cost int64) bool {
+	return seg.vacuumLen < entryLen ||
+		(seg.maxEntries > 0 && atomic.LoadInt64(&seg.entryCount) >= seg.maxEntries) ||
+		(seg.maxCost > 0 && atomic.LoadInt64(&seg.totalCost)+cost > seg.maxCost)
+}
+
+// evacuate makes room for a new entry of entryLen and cost, set by the quota namespace nsID if it isn't 0.
+// It returns ErrViewed if the oldest entry is pinned by a view, as its space can't be reused.
+func (seg *segment) evacuate(entryLen, cost int64, nsID uint8, slotId uint8, nowMs int64) (slotModified bool, err error) {
 	var oldHdrBuf [ENTRY_HDR_SIZE]byte
 	consecutiveEvacuate := 0
-	for seg.vacuumLen < entryLen {
+	// pinned entries, and the entries of other namespaces when a quota namespace makes room, are moved
+	// instead of evicted, unless as many entries as the segment has were moved without reclaiming space.
+	keptMoves := int64(0)
+	for seg.full(entryLen, cost) {
 		oldOff := seg.rb.End() + seg.vacuumLen - seg.rb.Size()
+		if seg.viewed(oldOff) {
+			if seg.expireViews(nowMs); seg.viewed(oldOff) {
+				return slotModified, ErrViewed
+			}
+		}
 		seg.rb.ReadAt(oldHdrBuf[:], oldOff)
 		oldHdr := (*entryHdr)(unsafe.Pointer(&oldHdrBuf[0]))
-		oldEntryLen := ENTRY_HDR_SIZE + int64(oldHdr.keyLen) + int64(oldHdr.valCap)
-		if oldHdr.deleted {
+		oldEntryLen := seg.entryLen(oldHdr)
+		if oldHdr.flags&flagDeleted != 0 {
 			consecutiveEvacuate = 0
-			atomic.AddInt64(&seg.totalTime, -int64(oldHdr.accessTime))
-			atomic.AddInt64(&seg.totalCount, -1)
-			seg.vacuumLen += oldEntryLen
+			keptMoves = 0
+			seg.reclaim(oldHdr, oldEntryLen)
 			continue
 		}
-		expired := isExpired(oldHdr.expireAt, now)
-		leastRecentUsed := int64(oldHdr.accessTime)*atomic.LoadInt64(&seg.totalCount) <= atomic.LoadInt64(&seg.totalTime)
-		if expired || leastRecentUsed || consecutiveEvacuate > 5 {
+		expired := isExpired(oldHdr.expireAtMilli(), nowMs)
+		leastRecentUsed := seg.leastRecentUsed(oldHdr, nowMs)
+		// with a cost budget, entries costlier than the average are kept like recently used ones.
+		cheap := seg.maxCost == 0 || seg.entryCost(oldHdr, oldOff)*atomic.LoadInt64(&seg.entryCount) <= atomic.LoadInt64(&seg.totalCost)
+		kept := (oldHdr.flags&flagPinned != 0 || seg.noEvict || (nsID != 0 && oldHdr.flags>>nsShift != nsID && seg.nsQuota[nsID] > 0)) &&
+			keptMoves < atomic.LoadInt64(&seg.entryCount)
+		if expired || (!kept && ((leastRecentUsed && cheap) || consecutiveEvacuate > seg.maxMoves)) {
+			if expired {
+				seg.expire(oldOff, oldHdr.keyLen)
+			} else {
+				seg.evict(oldOff, oldHdr, nowMs)
+			}
 			seg.delEntryPtrByOffset(oldHdr.slotId, oldHdr.hash16, oldOff)
 			if oldHdr.slotId == slotId {
 				slotModified = true
 			}
 			consecutiveEvacuate = 0
-			atomic.AddInt64(&seg.totalTime, -int64(oldHdr.accessTime))
-			atomic.AddInt64(&seg.totalCount, -1)
-			seg.vacuumLen += oldEntryLen
-			if expired {
-				atomic.AddInt64(&seg.totalExpired, 1)
-			} else {
-				atomic.AddInt64(&seg.totalEvacuate, 1)
-			}
+			keptMoves = 0
+			seg.reclaim(oldHdr, oldEntryLen)
 		} else {
 			// evacuate an old entry that has been accessed recently for better cache hit rate.
 			newOff := seg.rb.Evacuate(oldOff, int(oldEntryLen))
 			seg.updateEntryPtr(oldHdr.slotId, oldHdr.hash16, oldOff, newOff)
-			consecutiveEvacuate++
+			if kept {
+				keptMoves++
+			} else {
+				consecutiveEvacuate++
+			}
 			atomic.AddInt64(&seg.totalEvacuate, 1)
 		}
 	}
 	return
 }
 
+// reclaim frees the space of the deleted entry at the beginning of the ring buffer.
+func (seg *segment) reclaim(hdr *entryHdr, entryLen int64) {
+	atomic.AddInt64(&seg.totalTime, -int64(hdr.accessTime))
+	atomic.AddInt64(&seg.totalCount, -1)
+	atomic.AddInt64(&seg.deletedLen, -entryLen)
+	seg.vacuumLen += entryLen
+}
+
 func (seg *segment) get(key, buf []byte, hashVal uint64, peek bool) (value []byte, expireAt uint32, err error) {
 	hdr, ptrOffset, err := seg.locate(key, hashVal, peek)
 	if err != nil {
 		return
 	}
-	expireAt = hdr.expireAt
+	expireAt = hdr.expireAtSeconds()
 	if cap(buf) >= int(hdr.valLen) {
 		value = buf[:hdr.valLen]
 	} else {
@@ -249,12 +790,33 @@ func (seg *segment) get(key, buf []byte, hashVal uint64, peek bool) (value []byt
 	}
 
 	seg.rb.ReadAt(value, ptrOffset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+	if err = seg.verifyChecksum(&hdr, ptrOffset, key, value); err != nil {
+		return nil, 0, err
+	}
 	if !peek {
-		atomic.AddInt64(&seg.hitCount, 1)
+		seg.countHit()
 	}
 	return
 }
 
+// getInto copies the value into buf if it fits, n is the length of the value either way.
+func (seg *segment) getInto(key, buf []byte, hashVal uint64) (n int, err error) {
+	hdr, ptrOffset, err := seg.locate(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	n = int(hdr.valLen)
+	if len(buf) < n {
+		return n, ErrBufferTooSmall
+	}
+	seg.rb.ReadAt(buf[:n], ptrOffset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+	if err = seg.verifyChecksum(&hdr, ptrOffset, key, buf[:n]); err != nil {
+		return 0, err
+	}
+	seg.countHit()
+	return
+}
+
 // view provides zero-copy access to the element's value, without copying to
 // an intermediate buffer.
 func (seg *segment) view(key []byte, fn func([]byte) error, hashVal uint64, peek bool) (err error) {
@@ -267,22 +829,84 @@ func (seg *segment) view(key []byte, fn func([]byte) error, hashVal uint64, peek
 	if err != nil {
 		return err
 	}
+	if err = seg.verifyChecksum(&hdr, ptrOffset, key, val); err != nil {
+		return err
+	}
 	err = fn(val)
 	if !peek {
-		atomic.AddInt64(&seg.hitCount, 1)
+		seg.countHit()
 	}
 	return
 }
 
+// viewEntry returns a zero-copy view of the value with the header of the entry, the view is only valid
+// while the segment is locked.
+func (seg *segment) viewEntry(key []byte, hashVal uint64, peek bool) (val []byte, hdr entryHdr, err error) {
+	val, hdr, _, err = seg.viewEntryAt(key, hashVal, peek)
+	return
+}
+
+// viewEntryAt is like viewEntry, but also returns the offset of the entry.
+func (seg *segment) viewEntryAt(key []byte, hashVal uint64, peek bool) (val []byte, hdr entryHdr, ptrOffset int64, err error) {
+	hdr, ptrOffset, err = seg.locate(key, hashVal, peek)
+	if err != nil {
+		return
+	}
+	start := ptrOffset + ENTRY_HDR_SIZE + int64(hdr.keyLen)
+	if val, err = seg.rb.Slice(start, int64(hdr.valLen)); err != nil {
+		return
+	}
+	if err = seg.verifyChecksum(&hdr, ptrOffset, key, val); err != nil {
+		return nil, hdr, ptrOffset, err
+	}
+	if !peek {
+		seg.countHit()
+	}
+	return
+}
+
+// timeLeft returns the seconds left before the entry of hdr expires, rounded up, 0 if it doesn't expire.
+func (seg *segment) timeLeft(hdr *entryHdr) uint32 {
+	if hdr.expireAt == 0 {
+		return 0
+	}
+	nowMs := seg.timer.NowMilli()
+	if isExpired(hdr.expireAtMilli(), nowMs) {
+		return 0
+	}
+	return uint32((hdr.expireAtMilli() - nowMs + 999) / 1000)
+}
+
+// peekFlags are the flags of a locate that must not modify the segment, see Peek.
+const peekFlags = NoStats | NoTouch | AllowStale
+
 func (seg *segment) locate(key []byte, hashVal uint64, peek bool) (hdrEntry entryHdr, ptrOffset int64, err error) {
+	if peek {
+		return seg.locateFlags(key, hashVal, peekFlags)
+	}
+	return seg.locateFlags(key, hashVal, 0)
+}
+
+// locateFlags is like locate with the flags of GetOpts. With AllowStale, an expired entry is left in the
+// segment and returned with ErrExpired.
+func (seg *segment) locateFlags(key []byte, hashVal uint64, flags Flags) (hdrEntry entryHdr, ptrOffset int64, err error) {
+	if seg.rb.data == nil {
+		err = ErrClosed
+		return
+	}
+	seg.applyClear()
 	slotId := uint8(hashVal >> 8)
 	hash16 := uint16(hashVal >> 16)
 	slot := seg.getSlot(slotId)
+	stats := flags&NoStats == 0
+	if seg.lfu != nil && stats {
+		seg.lfu.increment(uint32(hashVal))
+	}
 	idx, match := seg.lookup(slot, hash16, key)
 	if !match {
 		err = ErrNotFound
-		if !peek {
-			atomic.AddInt64(&seg.missCount, 1)
+		if stats {
+			seg.countMiss()
 		}
 		return
 	}
@@ -291,23 +915,55 @@ func (seg *segment) locate(key []byte, hashVal uint64, peek bool) (hdrEntry entr
 	var hdrBuf [ENTRY_HDR_SIZE]byte
 	seg.rb.ReadAt(hdrBuf[:], ptr.offset)
 	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
-	if !peek {
-		now := seg.timer.Now()
-		if isExpired(hdr.expireAt, now) {
-			seg.delEntryPtr(slotId, slot, idx)
-			atomic.AddInt64(&seg.totalExpired, 1)
-			err = ErrExpired
-			atomic.AddInt64(&seg.missCount, 1)
-			return
+	nowMs := seg.timer.NowMilli()
+	if isExpired(hdr.expireAtMilli(), nowMs) {
+		if stats {
+			seg.countMiss()
+		}
+		if flags&AllowStale != 0 {
+			// the expired entry is left for a later access or evacuation.
+			return *hdr, ptr.offset, ErrExpired
 		}
+		seg.expire(ptr.offset, hdr.keyLen)
+		atomic.AddInt64(&seg.accessExpired, 1)
+		seg.delEntryPtr(slotId, slot, idx)
+		err = ErrExpired
+		return
+	}
+	if stats && seg.hot != nil && hdr.flags&flagNegative == 0 {
+		seg.hot.record(key, hashVal)
+	}
+	if flags&NoTouch == 0 {
+		now := uint32(nowMs / 1000)
 		atomic.AddInt64(&seg.totalTime, int64(now-hdr.accessTime))
 		hdr.accessTime = now
+		if seg.sliding && ptr.ttl != 0 && hdr.expireAt != 0 && hdr.flags&flagNegative == 0 {
+			hdr.setExpireAtMilli(expireAtMilli(nowMs, time.Duration(ptr.ttl)*time.Second))
+		}
 		seg.rb.WriteAt(hdrBuf[:], ptr.offset)
+		if seg.onRefresh != nil && seg.refreshDue(hdr, ptr, nowMs) {
+			// the key is copied, so it doesn't escape to the heap when refresh-ahead is disabled.
+			seg.onRefresh(append([]byte(nil), key...))
+		}
+	}
+	if hdr.flags&flagNegative != 0 {
+		// the cache knows the key is missing, so it is a hit.
+		err = ErrNegativeCached
+		if stats {
+			seg.countHit()
+		}
+		return
 	}
 	return *hdr, ptr.offset, nil
 }
 
+// del deletes the entry of key. The deletion is propagated to the writers even if the key isn't found, as the
+// stores may have a key the cache has evicted.
 func (seg *segment) del(key []byte, hashVal uint64) (affected bool) {
+	seg.applyClear()
+	if seg.writes.enabled() {
+		seg.writes.del(key)
+	}
 	slotId := uint8(hashVal >> 8)
 	hash16 := uint16(hashVal >> 16)
 	slot := seg.getSlot(slotId)
@@ -316,10 +972,15 @@ func (seg *segment) del(key []byte, hashVal uint64) (affected bool) {
 		return false
 	}
 	seg.delEntryPtr(slotId, slot, idx)
+	atomic.AddInt64(&seg.deleted, 1)
+	if seg.events.enabled() {
+		seg.events.emit(EventDel, key, nil)
+	}
 	return true
 }
 
 func (seg *segment) ttl(key []byte, hashVal uint64) (timeLeft uint32, err error) {
+	seg.applyClear()
 	slotId := uint8(hashVal >> 8)
 	hash16 := uint16(hashVal >> 16)
 	slot := seg.getSlot(slotId)
@@ -337,9 +998,10 @@ func (seg *segment) ttl(key []byte, hashVal uint64) (timeLeft uint32, err error)
 	if hdr.expireAt == 0 {
 		return
 	} else {
-		now := seg.timer.Now()
-		if !isExpired(hdr.expireAt, now) {
-			timeLeft = hdr.expireAt - now
+		nowMs := seg.timer.NowMilli()
+		if !isExpired(hdr.expireAtMilli(), nowMs) {
+			// round up, so an entry that is not expired yet never has a zero TTL.
+			timeLeft = uint32((hdr.expireAtMilli() - nowMs + 999) / 1000)
 			return
 		}
 	}
@@ -347,6 +1009,88 @@ func (seg *segment) ttl(key []byte, hashVal uint64) (timeLeft uint32, err error)
 	return
 }
 
+// delExpired deletes all expired entries in the segment and returns the number of entries deleted.
+func (seg *segment) delExpired() (count int) {
+	seg.applyClear()
+	nowMs := seg.timer.NowMilli()
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for i := 0; i < 256; i++ {
+		slotId := uint8(i)
+		slot := seg.getSlot(slotId)
+		for idx := 0; idx < len(slot); {
+			seg.rb.ReadAt(hdrBuf[:], slot[idx].offset)
+			if !isExpired(hdr.expireAtMilli(), nowMs) {
+				idx++
+				continue
+			}
+			seg.expire(slot[idx].offset, hdr.keyLen)
+			seg.delEntryPtr(slotId, slot, idx)
+			slot = slot[:len(slot)-1]
+			count++
+		}
+	}
+	return
+}
+
+// expire counts the entry at offset as expired, sends its event and calls the expired callback with its key.
+// It must be called before the entry is overwritten.
+func (seg *segment) expire(offset int64, keyLen uint16) {
+	atomic.AddInt64(&seg.totalExpired, 1)
+	if seg.onExpired == nil && !seg.events.enabled() {
+		return
+	}
+	key := make([]byte, keyLen)
+	seg.rb.ReadAt(key, offset+ENTRY_HDR_SIZE)
+	if seg.events.enabled() {
+		seg.events.emit(EventExpire, key, nil)
+	}
+	if seg.onExpired != nil {
+		seg.onExpired(key)
+	}
+}
+
+// refreshDue reports whether the remaining time of an entry is below the refresh ratio of its ttl,
+// or its soft expiration is passed if it has one.
+func (seg *segment) refreshDue(hdr *entryHdr, ptr *entryPtr, nowMs int64) bool {
+	if hdr.expireMs&expireMsSoft != 0 {
+		return seg.softExpireAt(hdr, ptr.offset) <= nowMs
+	}
+	if hdr.expireAt == 0 || ptr.ttl == 0 {
+		return false
+	}
+	return float64(hdr.expireAtMilli()-nowMs) < seg.refreshRatio*float64(ptr.ttl)*1000
+}
+
+// evict counts the entry at offset as evicted, sends its event and calls the evicted callback with its
+// key, value and remaining expiration. It must be called before the entry is overwritten.
+func (seg *segment) evict(offset int64, hdr *entryHdr, nowMs int64) {
+	atomic.AddInt64(&seg.totalEvacuate, 1)
+	atomic.AddInt64(&seg.evicted, 1)
+	if seg.observer != nil {
+		seg.observer.ObserveEviction()
+	}
+	if seg.hist != nil {
+		if now := uint32(nowMs / 1000); now > hdr.accessTime {
+			seg.hist.EvictionAges.add(now - hdr.accessTime)
+		} else {
+			seg.hist.EvictionAges.add(0)
+		}
+	}
+	if seg.onEvicted == nil && !seg.events.enabled() {
+		return
+	}
+	data := make([]byte, int(hdr.keyLen)+int(hdr.valLen))
+	seg.rb.ReadAt(data, offset+ENTRY_HDR_SIZE)
+	if seg.events.enabled() {
+		seg.events.emit(EventEvict, data[:hdr.keyLen], nil)
+	}
+	if seg.onEvicted == nil {
+		return
+	}
+	seg.onEvicted(data[:hdr.keyLen:hdr.keyLen], data[hdr.keyLen:], int(ttlSeconds(nowMs, hdr.expireAtMilli())))
+}
+
 func (seg *segment) expand() {
 	newSlotData := make([]entryPtr, seg.slotCap*2*256)
 	for i := 0; i < 256; i++ {
@@ -367,7 +1111,7 @@ func (seg *segment) updateEntryPtr(slotId uint8, hash16 uint16, oldOff, newOff i
 	ptr.offset = newOff
 }
 
-func (seg *segment) insertEntryPtr(slotId uint8, hash16 uint16, offset int64, idx int, keyLen uint16) {
+func (seg *segment) insertEntryPtr(slotId uint8, hash16 uint16, offset int64, idx int, keyLen uint16, ttl uint32) {
 	if seg.slotLens[slotId] == seg.slotCap {
 		seg.expand()
 	}
@@ -378,6 +1122,7 @@ func (seg *segment) insertEntryPtr(slotId uint8, hash16 uint16, offset int64, id
 	slot[idx].offset = offset
 	slot[idx].hash16 = hash16
 	slot[idx].keyLen = keyLen
+	slot[idx].ttl = ttl
 }
 
 func (seg *segment) delEntryPtrByOffset(slotId uint8, hash16 uint16, offset int64) {
@@ -394,7 +1139,14 @@ func (seg *segment) delEntryPtr(slotId uint8, slot []entryPtr, idx int) {
 	var entryHdrBuf [ENTRY_HDR_SIZE]byte
 	seg.rb.ReadAt(entryHdrBuf[:], offset)
 	entryHdr := (*entryHdr)(unsafe.Pointer(&entryHdrBuf[0]))
-	entryHdr.deleted = true
+	atomic.AddInt64(&seg.totalCost, -seg.entryCost(entryHdr, offset))
+	if entryHdr.flags&flagPinned != 0 {
+		seg.pinnedLen -= seg.entryLen(entryHdr)
+		seg.pinnedCount--
+	}
+	atomic.AddInt64(&seg.nsUsed[entryHdr.flags>>nsShift], -seg.entryLen(entryHdr))
+	atomic.AddInt64(&seg.deletedLen, seg.entryLen(entryHdr))
+	entryHdr.flags |= flagDeleted
 	seg.rb.WriteAt(entryHdrBuf[:], offset)
 	copy(slot[idx:], slot[idx+1:])
 	seg.slotLens[slotId]--
@@ -415,6 +1167,8 @@ func entryPtrIdx(slot []entryPtr, hash16 uint16) (idx int) {
 	return
 }
 
+// lookup finds the entry of key in slot. Entries with the same hash16 are told apart by comparing
+// the full key stored in the ring buffer, so a hash collision never returns the value of another key.
 func (seg *segment) lookup(slot []entryPtr, hash16 uint16, key []byte) (idx int, match bool) {
 	idx = entryPtrIdx(slot, hash16)
 	for idx < len(slot) {
@@ -451,28 +1205,67 @@ func (seg *segment) resetStatistics() {
 	atomic.StoreInt64(&seg.totalEvacuate, 0)
 	atomic.StoreInt64(&seg.totalExpired, 0)
 	atomic.StoreInt64(&seg.overwrites, 0)
+	atomic.StoreInt64(&seg.deleted, 0)
+	atomic.StoreInt64(&seg.evicted, 0)
+	atomic.StoreInt64(&seg.relocated, 0)
+	atomic.StoreInt64(&seg.accessExpired, 0)
+	atomic.StoreInt64(&seg.rejected, 0)
 	atomic.StoreInt64(&seg.hitCount, 0)
 	atomic.StoreInt64(&seg.missCount, 0)
+	if seg.hot != nil {
+		seg.hot.reset()
+	}
+	if seg.window != nil {
+		*seg.window = hitWindow{interval: seg.window.interval}
+	}
+	if seg.hist != nil {
+		*seg.hist = Histograms{}
+	}
+}
+
+// release frees the ring buffer, the segment is left empty and its operations return ErrClosed.
+func (seg *segment) release() {
+	if seg.rb.data != nil {
+		seg.freeBuf(seg.rb.data)
+		seg.rb.data = nil
+	}
+	seg.clear()
+	seg.slotCap = 0
+	seg.slotsData = nil
 }
 
 func (seg *segment) clear() {
 	bufSize := len(seg.rb.data)
 	seg.rb.Reset(0)
 	seg.vacuumLen = int64(bufSize)
-	seg.slotCap = 1
-	seg.slotsData = make([]entryPtr, 256*seg.slotCap)
+	// the slots keep their capacity, so clearing doesn't allocate, ReleaseUnused shrinks them.
 	for i := 0; i < len(seg.slotLens); i++ {
 		seg.slotLens[i] = 0
 	}
+	// the offsets of the views are reused by the new entries, so their pins only keep the ring buffer.
+	for i := range seg.views {
+		seg.views[i].offset = -1
+	}
 
 	atomic.StoreInt64(&seg.hitCount, 0)
 	atomic.StoreInt64(&seg.missCount, 0)
 	atomic.StoreInt64(&seg.entryCount, 0)
 	atomic.StoreInt64(&seg.totalCount, 0)
+	atomic.StoreInt64(&seg.totalCost, 0)
+	seg.pinnedLen = 0
+	seg.pinnedCount = 0
+	atomic.StoreInt64(&seg.deletedLen, 0)
+	for i := range seg.nsUsed {
+		atomic.StoreInt64(&seg.nsUsed[i], 0)
+	}
 	atomic.StoreInt64(&seg.totalTime, 0)
 	atomic.StoreInt64(&seg.totalEvacuate, 0)
 	atomic.StoreInt64(&seg.totalExpired, 0)
 	atomic.StoreInt64(&seg.overwrites, 0)
+	atomic.StoreInt64(&seg.deleted, 0)
+	atomic.StoreInt64(&seg.evicted, 0)
+	atomic.StoreInt64(&seg.relocated, 0)
+	atomic.StoreInt64(&seg.accessExpired, 0)
 }
 
 func (seg *segment) getSlot(slotId uint8) []entryPtr {
@@ -480,7 +1273,150 @@ func (seg *segment) getSlot(slotId uint8) []entryPtr {
 	return seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
 }
 
+// entryLen returns the length of the entry in the ring buffer, including the cost and the soft expiration
+// if it has them.
+func (hdr *entryHdr) entryLen() int64 {
+	n := hdr.softOffset()
+	if hdr.expireMs&expireMsSoft != 0 {
+		n += 8
+	}
+	return n
+}
+
+// softOffset returns the offset of the soft expiration from the start of the entry, right after its cost.
+func (hdr *entryHdr) softOffset() int64 {
+	n := ENTRY_HDR_SIZE + int64(hdr.keyLen) + int64(hdr.valCap)
+	if hdr.flags&flagCost != 0 {
+		n += 8
+	}
+	return n
+}
+
+// checksumLen is the length of the CRC32 following every entry of a segment with checksums.
+const checksumLen = 4
+
+// entryLen returns the length of the entry in the ring buffer of the segment, including its checksum.
+func (seg *segment) entryLen(hdr *entryHdr) int64 {
+	if seg.checksums {
+		return hdr.entryLen() + checksumLen
+	}
+	return hdr.entryLen()
+}
+
+// crcTable is used to compute the checksums byte by byte, crc32.Update would make the key and
+// value escape to the heap, as it calls its implementations through function values.
+var crcTable = crc32.MakeTable(crc32.IEEE)
+
+// checksum returns the IEEE CRC32 of key followed by value.
+func checksum(key, value []byte) uint32 {
+	crc := ^uint32(0)
+	for _, b := range key {
+		crc = crcTable[byte(crc)^b] ^ crc>>8
+	}
+	for _, b := range value {
+		crc = crcTable[byte(crc)^b] ^ crc>>8
+	}
+	return ^crc
+}
+
+// writeChecksum writes the checksum of the entry at offset, right after its cost if it has one.
+func (seg *segment) writeChecksum(hdr *entryHdr, offset int64, key, value []byte) {
+	var buf [checksumLen]byte
+	binary.LittleEndian.PutUint32(buf[:], checksum(key, value))
+	seg.rb.WriteAt(buf[:], offset+hdr.entryLen())
+}
+
+// verifyChecksum returns ErrCorrupted if the segment has checksums and the checksum of the entry at offset
+// doesn't match key and value.
+func (seg *segment) verifyChecksum(hdr *entryHdr, offset int64, key, value []byte) error {
+	if !seg.checksums {
+		return nil
+	}
+	var buf [checksumLen]byte
+	seg.rb.ReadAt(buf[:], offset+hdr.entryLen())
+	if binary.LittleEndian.Uint32(buf[:]) != checksum(key, value) {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// entryCost returns the cost of the entry at offset.
+func (seg *segment) entryCost(hdr *entryHdr, offset int64) int64 {
+	if hdr.flags&flagCost == 0 {
+		return 1
+	}
+	var buf [8]byte
+	seg.rb.ReadAt(buf[:], offset+ENTRY_HDR_SIZE+int64(hdr.keyLen)+int64(hdr.valCap))
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+func (seg *segment) writeCost(hdr *entryHdr, offset int64, cost int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(cost))
+	seg.rb.WriteAt(buf[:], offset+ENTRY_HDR_SIZE+int64(hdr.keyLen)+int64(hdr.valCap))
+}
+
+// softExpireAt returns the soft expiration of the entry at offset in milliseconds, 0 if it has none.
+func (seg *segment) softExpireAt(hdr *entryHdr, offset int64) int64 {
+	if hdr.expireMs&expireMsSoft == 0 {
+		return 0
+	}
+	var buf [8]byte
+	seg.rb.ReadAt(buf[:], offset+hdr.softOffset())
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+func (seg *segment) writeSoftExpireAt(hdr *entryHdr, offset int64, softExpireAtMs int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(softExpireAtMs))
+	seg.rb.WriteAt(buf[:], offset+hdr.softOffset())
+}
+
+// expireAtSeconds returns the expiration of the entry as reported by GetWithExpiration, in seconds rounded up,
+// 0 means no expire.
+func (hdr *entryHdr) expireAtSeconds() uint32 {
+	if hdr.expireMs&^expireMsSoft != 0 {
+		return hdr.expireAt + 1
+	}
+	return hdr.expireAt
+}
+
+// expireAtMilli returns the expiration of the entry in milliseconds, 0 means no expire.
+func (hdr *entryHdr) expireAtMilli() int64 {
+	if hdr.expireAt == 0 {
+		return 0
+	}
+	return int64(hdr.expireAt)*1000 + int64(hdr.expireMs&^expireMsSoft)
+}
+
+// setExpireAtMilli sets the expiration of the entry in milliseconds, keeping expireMsSoft.
+func (hdr *entryHdr) setExpireAtMilli(expireAtMs int64) {
+	hdr.expireAt = uint32(expireAtMs / 1000)
+	hdr.expireMs = hdr.expireMs&expireMsSoft | uint16(expireAtMs%1000)
+}
+
+// expireAtMilli returns the absolute expiration in milliseconds for ttl, 0 means no expire.
+// A ttl of whole seconds expires at the beginning of a second, so it is the same as expireSeconds,
+// a sub-second ttl is rounded up to milliseconds.
+func expireAtMilli(nowMs int64, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl%time.Second == 0 {
+		return (nowMs/1000)*1000 + int64(ttl/time.Millisecond)
+	}
+	return nowMs + int64((ttl+time.Millisecond-1)/time.Millisecond)
+}
+
+// ttlSeconds returns the seconds from nowMs to expireAtMs rounded up, 0 means no expire.
+func ttlSeconds(nowMs, expireAtMs int64) uint32 {
+	if expireAtMs == 0 || expireAtMs <= nowMs {
+		return 0
+	}
+	return uint32((expireAtMs - nowMs + 999) / 1000)
+}
+
 // isExpired checks if a key is expired.
-func isExpired(keyExpireAt, now uint32) bool {
-	return keyExpireAt != 0 && keyExpireAt <= now
+func isExpired(keyExpireAtMs, nowMs int64) bool {
+	return keyExpireAtMs != 0 && keyExpireAtMs <= nowMs
 }
@@ -0,0 +1,23 @@
+// A basic freecache server supports redis protocol
+package main
+
+import (
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/coocood/freecache"
+	"github.com/coocood/freecache/server"
+)
+
+func main() {
+	runtime.GOMAXPROCS(runtime.NumCPU() - 1)
+	debug.SetGCPercent(10)
+	go func() {
+		log.Println(http.ListenAndServe("localhost:6060", nil))
+	}()
+	log.Println("Listening on port", ":7788")
+	log.Println(server.New(freecache.NewCache(256 * 1024 * 1024)).ListenAndServe(":7788"))
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coocood/freecache"
+)
+
+// The classes of the keyspace notifications, as the characters of the notify-keyspace-events config of Redis.
+const (
+	notifyKeyspace = 1 << iota // K, __keyspace@0__:<key> channels receive the event names.
+	notifyKeyevent             // E, __keyevent@0__:<event> channels receive the keys.
+	notifyGeneric              // g, del.
+	notifyString               // $, set.
+	notifyExpired              // x, expired.
+	notifyEvicted              // e, evicted.
+)
+
+const notifyAll = notifyGeneric | notifyString | notifyExpired | notifyEvicted
+
+// notifyBuffer is the number of cache events buffered for a subscribed connection, see freecache.Cache.Events.
+const notifyBuffer = 1024
+
+var errNotifyFlags = errors.New("Invalid notify-keyspace-events flags")
+
+// SetNotifyKeyspaceEvents sets the keyspace notifications published to the subscribed clients, like the
+// notify-keyspace-events config of Redis, which clients can also set with CONFIG SET. flags is a string of
+// K for the __keyspace@0__:<key> channels, E for the __keyevent@0__:<event> channels, and the classes of
+// events: g for del, $ for set, x for expired, e for evicted, A for all of them. The other classes of Redis
+// are accepted and ignored, as there are no such events. The empty string disables the notifications, which
+// is the default.
+func (s *Server) SetNotifyKeyspaceEvents(flags string) error {
+	notify, err := parseNotifyFlags(flags)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.notify, notify)
+	return nil
+}
+
+func parseNotifyFlags(flags string) (notify int32, err error) {
+	for _, c := range flags {
+		switch c {
+		case 'K':
+			notify |= notifyKeyspace
+		case 'E':
+			notify |= notifyKeyevent
+		case 'g':
+			notify |= notifyGeneric
+		case '$':
+			notify |= notifyString
+		case 'x':
+			notify |= notifyExpired
+		case 'e':
+			notify |= notifyEvicted
+		case 'A':
+			notify |= notifyAll
+		case 'l', 's', 'h', 'z', 't', 'm', 'n', 'd':
+		default:
+			return 0, errNotifyFlags
+		}
+	}
+	return
+}
+
+func formatNotifyFlags(notify int32) string {
+	var b strings.Builder
+	if notify&notifyAll == notifyAll {
+		b.WriteByte('A')
+	} else {
+		for _, f := range []struct {
+			flag int32
+			c    byte
+		}{{notifyGeneric, 'g'}, {notifyString, '$'}, {notifyExpired, 'x'}, {notifyEvicted, 'e'}} {
+			if notify&f.flag != 0 {
+				b.WriteByte(f.c)
+			}
+		}
+	}
+	if notify&notifyKeyspace != 0 {
+		b.WriteByte('K')
+	}
+	if notify&notifyKeyevent != 0 {
+		b.WriteByte('E')
+	}
+	return b.String()
+}
+
+// eventNotification returns the Redis name and the class of the event of typ.
+func eventNotification(typ freecache.EventType) (name string, class int32) {
+	switch typ {
+	case freecache.EventSet:
+		return "set", notifyString
+	case freecache.EventDel:
+		return "del", notifyGeneric
+	case freecache.EventExpire:
+		return "expired", notifyExpired
+	case freecache.EventEvict:
+		return "evicted", notifyEvicted
+	}
+	return "", 0
+}
+
+// client is a connection of the server, its replies and the messages of its subscriptions are written
+// to w with mu held.
+type client struct {
+	mu       sync.Mutex
+	w        *bufio.Writer
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	events   <-chan freecache.Event // the events of the cache once the client has subscribed, nil before.
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{
+		w:        bufio.NewWriter(conn),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+func (c *client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// execPubSub executes the pub/sub commands, and rejects the other commands of a subscribed client like
+// Redis, it returns false for the commands left to exec. It is called with c.mu held.
+func (s *Server) execPubSub(c *client, args [][]byte) bool {
+	cmd := strings.ToLower(string(args[0]))
+	w := c.w
+	switch cmd {
+	case "subscribe", "psubscribe":
+		if len(args) < 2 {
+			writeError(w, "ERR wrong number of arguments for '"+cmd+"' command")
+			return true
+		}
+		subs := c.channels
+		if cmd == "psubscribe" {
+			subs = c.patterns
+		}
+		for _, name := range args[1:] {
+			subs[string(name)] = struct{}{}
+			writeArrayLen(w, 3)
+			writeBulk(w, []byte(cmd))
+			writeBulk(w, name)
+			writeInt(w, int64(c.subscriptions()))
+		}
+		if c.events == nil {
+			events := s.cache.Events(notifyBuffer)
+			c.events = events
+			go s.publish(c, events)
+		}
+	case "unsubscribe", "punsubscribe":
+		subs := c.channels
+		if cmd == "punsubscribe" {
+			subs = c.patterns
+		}
+		names := args[1:]
+		if len(names) == 0 {
+			for name := range subs {
+				names = append(names, []byte(name))
+			}
+		}
+		if len(names) == 0 {
+			writeArrayLen(w, 3)
+			writeBulk(w, []byte(cmd))
+			writeNil(w)
+			writeInt(w, int64(c.subscriptions()))
+		}
+		for _, name := range names {
+			delete(subs, string(name))
+			writeArrayLen(w, 3)
+			writeBulk(w, []byte(cmd))
+			writeBulk(w, name)
+			writeInt(w, int64(c.subscriptions()))
+		}
+	case "ping":
+		if c.subscriptions() == 0 {
+			return false
+		}
+		writeArrayLen(w, 2)
+		writeBulk(w, []byte("pong"))
+		if len(args) > 1 {
+			writeBulk(w, args[1])
+		} else {
+			writeBulk(w, nil)
+		}
+	case "config":
+		s.config(w, args)
+	default:
+		if c.subscriptions() == 0 {
+			return false
+		}
+		writeError(w, "ERR Can't execute '"+cmd+"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+	}
+	return true
+}
+
+// config executes CONFIG GET and CONFIG SET of notify-keyspace-events, the only config of the server.
+func (s *Server) config(w *bufio.Writer, args [][]byte) {
+	sub := ""
+	if len(args) > 1 {
+		sub = strings.ToLower(string(args[1]))
+	}
+	switch {
+	case sub == "get" && len(args) == 3:
+		if !matchGlob(string(args[2]), "notify-keyspace-events") {
+			writeArrayLen(w, 0)
+			return
+		}
+		writeArrayLen(w, 2)
+		writeBulk(w, []byte("notify-keyspace-events"))
+		writeBulk(w, []byte(formatNotifyFlags(atomic.LoadInt32(&s.notify))))
+	case sub == "set" && len(args) == 4:
+		if !strings.EqualFold(string(args[2]), "notify-keyspace-events") {
+			writeError(w, "ERR Unknown option '"+string(args[2])+"'")
+			return
+		}
+		if err := s.SetNotifyKeyspaceEvents(string(args[3])); err != nil {
+			writeError(w, "ERR "+err.Error())
+			return
+		}
+		w.WriteString("+OK\r\n")
+	default:
+		writeError(w, "ERR wrong number of arguments for 'config' command")
+	}
+}
+
+// publish writes the notifications of the cache events to the subscribed client c until events is closed.
+func (s *Server) publish(c *client, events <-chan freecache.Event) {
+	for ev := range events {
+		notify := atomic.LoadInt32(&s.notify)
+		name, class := eventNotification(ev.Type)
+		if notify&class == 0 {
+			continue
+		}
+		c.mu.Lock()
+		if notify&notifyKeyspace != 0 {
+			c.message("__keyspace@0__:"+string(ev.Key), []byte(name))
+		}
+		if notify&notifyKeyevent != 0 {
+			c.message("__keyevent@0__:"+name, ev.Key)
+		}
+		c.w.Flush()
+		c.mu.Unlock()
+	}
+}
+
+// message writes payload to c if it is subscribed to channel, once for the channel and once for every
+// matching pattern like Redis.
+func (c *client) message(channel string, payload []byte) {
+	if _, ok := c.channels[channel]; ok {
+		writeArrayLen(c.w, 3)
+		writeBulk(c.w, []byte("message"))
+		writeBulk(c.w, []byte(channel))
+		writeBulk(c.w, payload)
+	}
+	for pattern := range c.patterns {
+		if matchGlob(pattern, channel) {
+			writeArrayLen(c.w, 4)
+			writeBulk(c.w, []byte("pmessage"))
+			writeBulk(c.w, []byte(pattern))
+			writeBulk(c.w, []byte(channel))
+			writeBulk(c.w, payload)
+		}
+	}
+}
+
+// matchGlob reports whether s matches the glob-style pattern of Redis: * matches any sequence, ? any
+// character, [...] a set of characters with ranges and ^ negation, and \ escapes the next character.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) > 1:
+					match = match || pattern[1] == s[0]
+					pattern = pattern[2:]
+				case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					match = match || (s[0] >= lo && s[0] <= hi)
+					pattern = pattern[3:]
+				default:
+					match = match || pattern[0] == s[0]
+					pattern = pattern[1:]
+				}
+			}
+			if match == not {
+				return false
+			}
+			if len(pattern) == 0 {
+				// an unterminated set ends the pattern.
+				return len(s) == 1
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
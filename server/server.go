@@ -0,0 +1,399 @@
+// Package server exposes a freecache.Cache over the Redis protocol, so redis-cli and Redis clients
+// can read and write an in-process cache. It supports a subset of the commands: PING, GET, SET with
+// EX and PX, SETEX, DEL, TTL, EXPIRE, INCR, MGET and DBSIZE. Pipelined commands are supported.
+// The changes of the entries are published as the keyspace notifications of Redis to the clients of
+// SUBSCRIBE and PSUBSCRIBE, once enabled by SetNotifyKeyspaceEvents or CONFIG SET notify-keyspace-events.
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+var (
+	ErrServerClosed = errors.New("Server closed")
+	errProtocol     = errors.New("Protocol error")
+)
+
+const (
+	maxArgs     = 1024 * 1024
+	maxArgLen   = 512 * 1024 * 1024
+	argChunkLen = 64 * 1024
+)
+
+// Server serves a cache over the Redis protocol.
+type Server struct {
+	cache  *freecache.Cache
+	notify int32 // the classes of keyspace notifications, see SetNotifyKeyspaceEvents.
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// New returns a server of cache.
+func New(cache *freecache.Cache) *Server {
+	return &Server{
+		cache:     cache,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections, see Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the connections of l and serves each of them in a goroutine until Close is called.
+// It always returns an error, ErrServerClosed after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close closes the listeners and the connections of the server, the cache is not closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	c := newClient(conn)
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		c.mu.Lock()
+		if c.events != nil {
+			s.cache.StopEvents(c.events)
+		}
+		c.mu.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+	var args [][]byte
+	for {
+		var err error
+		args, err = readCommand(r, args[:0])
+		c.mu.Lock()
+		if err != nil {
+			if err == errProtocol {
+				writeError(c.w, "ERR "+err.Error())
+				c.w.Flush()
+			}
+			c.mu.Unlock()
+			return
+		}
+		if len(args) > 0 && !s.execPubSub(c, args) {
+			s.exec(c.w, args)
+		}
+		// the replies of pipelined commands are written together.
+		if r.Buffered() == 0 {
+			err = c.w.Flush()
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) exec(w *bufio.Writer, args [][]byte) {
+	cmd := strings.ToLower(string(args[0]))
+	argc := len(args)
+	switch {
+	case cmd == "ping" && argc <= 2:
+		if argc == 2 {
+			writeBulk(w, args[1])
+		} else {
+			w.WriteString("+PONG\r\n")
+		}
+	case cmd == "get" && argc == 2:
+		value, err := s.cache.Get(args[1])
+		if err != nil {
+			writeNil(w)
+		} else {
+			writeBulk(w, value)
+		}
+	case cmd == "set" && (argc == 3 || argc == 5):
+		ttl := time.Duration(0)
+		if argc == 5 {
+			n, err := strconv.Atoi(string(args[4]))
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			switch strings.ToLower(string(args[3])) {
+			case "ex":
+				ttl = time.Duration(n) * time.Second
+			case "px":
+				ttl = time.Duration(n) * time.Millisecond
+			default:
+				writeError(w, "ERR syntax error")
+				return
+			}
+		}
+		s.writeSetReply(w, s.cache.SetWithDuration(args[1], args[2], ttl))
+	case cmd == "setex" && argc == 4:
+		n, err := strconv.Atoi(string(args[2]))
+		if err != nil || n <= 0 {
+			writeError(w, "ERR invalid expire time in 'setex' command")
+			return
+		}
+		s.writeSetReply(w, s.cache.Set(args[1], args[3], n))
+	case cmd == "del" && argc >= 2:
+		count := 0
+		for _, key := range args[1:] {
+			if s.cache.Del(key) {
+				count++
+			}
+		}
+		writeInt(w, int64(count))
+	case cmd == "ttl" && argc == 2:
+		timeLeft, err := s.cache.TTL(args[1])
+		switch {
+		case err != nil:
+			writeInt(w, -2)
+		case timeLeft == 0:
+			writeInt(w, -1)
+		default:
+			writeInt(w, int64(timeLeft))
+		}
+	case cmd == "expire" && argc == 3:
+		n, err := strconv.Atoi(string(args[2]))
+		if err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return
+		}
+		if n <= 0 {
+			// like Redis, a non positive expiration deletes the key.
+			if s.cache.Del(args[1]) {
+				writeInt(w, 1)
+			} else {
+				writeInt(w, 0)
+			}
+			return
+		}
+		if s.cache.Touch(args[1], n) != nil {
+			writeInt(w, 0)
+		} else {
+			writeInt(w, 1)
+		}
+	case cmd == "incr" && argc == 2:
+		s.incr(w, args[1])
+	case cmd == "mget" && argc >= 2:
+		writeArrayLen(w, argc-1)
+		for _, key := range args[1:] {
+			value, err := s.cache.Get(key)
+			if err != nil {
+				writeNil(w)
+			} else {
+				writeBulk(w, value)
+			}
+		}
+	case cmd == "dbsize" && argc == 1:
+		writeInt(w, s.cache.EntryCount())
+	case cmd == "ping" || cmd == "get" || cmd == "set" || cmd == "setex" || cmd == "del" || cmd == "ttl" ||
+		cmd == "expire" || cmd == "incr" || cmd == "mget" || cmd == "dbsize":
+		writeError(w, "ERR wrong number of arguments for '"+cmd+"' command")
+	default:
+		writeError(w, "ERR unknown command '"+cmd+"'")
+	}
+}
+
+func (s *Server) writeSetReply(w *bufio.Writer, err error) {
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	w.WriteString("+OK\r\n")
+}
+
+// incr increments the integer value of key, a missing key is set to 1, an existing key keeps its expiration.
+func (s *Server) incr(w *bufio.Writer, key []byte) {
+	result, err := s.cache.IncrKeepTTL(key, 1)
+	switch {
+	case err == freecache.ErrNotInteger:
+		writeError(w, "ERR value is not an integer or out of range")
+	case err != nil:
+		writeError(w, "ERR "+err.Error())
+	default:
+		writeInt(w, result)
+	}
+}
+
+// readCommand reads a command as an array of bulk strings, or as an inline command of arguments
+// separated by spaces, and appends its arguments to args.
+func readCommand(r *bufio.Reader, args [][]byte) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return args, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		for _, f := range bytes.Fields(line) {
+			args = append(args, append([]byte(nil), f...))
+		}
+		return args, nil
+	}
+	argc, err := strconv.Atoi(string(line[1:]))
+	if err != nil || argc > maxArgs {
+		return args, errProtocol
+	}
+	for i := 0; i < argc; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return args, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return args, errProtocol
+		}
+		argLen, err := strconv.Atoi(string(line[1:]))
+		if err != nil || argLen < 0 || argLen > maxArgLen {
+			return args, errProtocol
+		}
+		arg, err := readArg(r, argLen+2)
+		if err != nil {
+			return args, err
+		}
+		if arg[argLen] != '\r' || arg[argLen+1] != '\n' {
+			return args, errProtocol
+		}
+		args = append(args, arg[:argLen])
+	}
+	return args, nil
+}
+
+// readArg reads the n bytes of an argument in chunks, so its buffer only grows with the bytes received, not with
+// the length announced by the client.
+func readArg(r *bufio.Reader, n int) ([]byte, error) {
+	size := n
+	if size > argChunkLen {
+		size = argChunkLen
+	}
+	arg := make([]byte, 0, size)
+	for len(arg) < n {
+		chunk := n - len(arg)
+		if chunk > argChunkLen {
+			chunk = argChunkLen
+		}
+		if cap(arg)-len(arg) < chunk {
+			size = 2*cap(arg) + chunk
+			if size > n {
+				size = n
+			}
+			grown := make([]byte, len(arg), size)
+			copy(grown, arg)
+			arg = grown
+		}
+		if _, err := io.ReadFull(r, arg[len(arg):len(arg)+chunk]); err != nil {
+			return nil, err
+		}
+		arg = arg[:len(arg)+chunk]
+	}
+	return arg, nil
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	p, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errProtocol
+		}
+		return nil, err
+	}
+	i := len(p) - 2
+	if i < 0 || p[i] != '\r' {
+		return nil, errProtocol
+	}
+	return p[:i], nil
+}
+
+func writeBulk(w *bufio.Writer, value []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(value)))
+	w.WriteString("\r\n")
+	w.Write(value)
+	w.WriteString("\r\n")
+}
+
+func writeNil(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+func writeArrayLen(w *bufio.Writer, n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	w.WriteByte('-')
+	w.WriteString(msg)
+	w.WriteString("\r\n")
+}
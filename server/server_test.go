@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/coocood/freecache/clocktest"
+)
+
+func TestServer(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	s := New(cache)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for _, c := range []struct {
+		cmd, reply string
+	}{
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*3\r\n$3\r\nset\r\n$1\r\na\r\n$3\r\nabc\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$3\r\nabc\r\n"},
+		{"*2\r\n$3\r\nget\r\n$1\r\nb\r\n", "$-1\r\n"},
+		{"*2\r\n$3\r\nttl\r\n$1\r\na\r\n", ":-1\r\n"},
+		{"*2\r\n$3\r\nttl\r\n$1\r\nb\r\n", ":-2\r\n"},
+		{"*3\r\n$6\r\nexpire\r\n$1\r\na\r\n$3\r\n100\r\n", ":1\r\n"},
+		{"*3\r\n$6\r\nexpire\r\n$1\r\nb\r\n$3\r\n100\r\n", ":0\r\n"},
+		{"*2\r\n$3\r\nttl\r\n$1\r\na\r\n", ":100\r\n"},
+		{"*5\r\n$3\r\nset\r\n$1\r\nc\r\n$1\r\n1\r\n$2\r\nEX\r\n$2\r\n10\r\n", "+OK\r\n"},
+		{"*2\r\n$4\r\nincr\r\n$1\r\nc\r\n", ":2\r\n"},
+		{"*2\r\n$3\r\nttl\r\n$1\r\nc\r\n", ":10\r\n"},
+		{"*2\r\n$4\r\nincr\r\n$1\r\nd\r\n", ":1\r\n"},
+		{"*2\r\n$4\r\nincr\r\n$1\r\na\r\n", "-ERR value is not an integer or out of range\r\n"},
+		{"*4\r\n$4\r\nmget\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", "*3\r\n$3\r\nabc\r\n$-1\r\n$1\r\n2\r\n"},
+		{"*4\r\n$5\r\nsetex\r\n$1\r\ne\r\n$1\r\n5\r\n$1\r\nx\r\n", "+OK\r\n"},
+		{"*1\r\n$6\r\ndbsize\r\n", ":4\r\n"},
+		{"*4\r\n$3\r\ndel\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", ":2\r\n"},
+		{"*1\r\n$3\r\nget\r\n", "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"*1\r\n$4\r\nkeys\r\n", "-ERR unknown command 'keys'\r\n"},
+		{"get d\r\n", "$1\r\n1\r\n"},
+	} {
+		if _, err := conn.Write([]byte(c.cmd)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, len(c.reply))
+		if _, err := io.ReadFull(r, reply); err != nil {
+			t.Fatal(err)
+		}
+		if string(reply) != c.reply || r.Buffered() > 0 {
+			t.Fatalf("%q replied %q, want %q", c.cmd, reply, c.reply)
+		}
+	}
+
+	// pipelined commands.
+	if _, err := conn.Write([]byte(strings.Repeat("*2\r\n$4\r\nincr\r\n$1\r\nd\r\n", 100))); err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i <= 101; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := ":" + strconv.Itoa(i) + "\r\n"; line != want {
+			t.Fatalf("reply %q, want %q", line, want)
+		}
+	}
+
+	s.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("Serve returned %v", err)
+	}
+	if _, err := r.ReadByte(); err == nil {
+		t.Fatal("connection should be closed")
+	}
+}
+
+func expectReply(t *testing.T, r *bufio.Reader, want string) {
+	t.Helper()
+	reply := make([]byte, len(want))
+	if _, err := io.ReadFull(r, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != want {
+		t.Fatalf("replied %q, want %q", reply, want)
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	clock := clocktest.New(time.Unix(1000, 0))
+	cache := freecache.NewCacheWithOptions(1024*1024, freecache.WithClock(clock))
+	s := New(cache)
+	defer s.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+	conn, r := dial()
+	defer conn.Close()
+	sub, subR := dial()
+	defer sub.Close()
+
+	for _, c := range []struct {
+		cmd, reply string
+	}{
+		{"config get notify-keyspace-events\r\n", "*2\r\n$22\r\nnotify-keyspace-events\r\n$0\r\n\r\n"},
+		{"config set notify-keyspace-events Z\r\n", "-ERR Invalid notify-keyspace-events flags\r\n"},
+		{"config set notify-keyspace-events Ex$\r\n", "+OK\r\n"},
+		{"config get notify-*\r\n", "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\n$xE\r\n"},
+		{"config set notify-keyspace-events KEA\r\n", "+OK\r\n"},
+		{"config get notify-keyspace-events\r\n", "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nAKE\r\n"},
+		{"config get maxmemory\r\n", "*0\r\n"},
+	} {
+		conn.Write([]byte(c.cmd))
+		expectReply(t, r, c.reply)
+	}
+
+	for _, c := range []struct {
+		cmd, reply string
+	}{
+		{"subscribe __keyevent@0__:expired\r\n", "*3\r\n$9\r\nsubscribe\r\n$22\r\n__keyevent@0__:expired\r\n:1\r\n"},
+		{"psubscribe __keyspace@0__:user:*\r\n", "*3\r\n$10\r\npsubscribe\r\n$21\r\n__keyspace@0__:user:*\r\n:2\r\n"},
+		{"get a\r\n", "-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context\r\n"},
+		{"ping\r\n", "*2\r\n$4\r\npong\r\n$0\r\n\r\n"},
+	} {
+		sub.Write([]byte(c.cmd))
+		expectReply(t, subR, c.reply)
+	}
+
+	conn.Write([]byte("set user:1 a\r\nset other b ex 1\r\ndel user:1\r\n"))
+	expectReply(t, r, "+OK\r\n+OK\r\n:1\r\n")
+	clock.Advance(2 * time.Second)
+	conn.Write([]byte("get other\r\n"))
+	expectReply(t, r, "$-1\r\n")
+	expectReply(t, subR, "*4\r\n$8\r\npmessage\r\n$21\r\n__keyspace@0__:user:*\r\n$21\r\n__keyspace@0__:user:1\r\n$3\r\nset\r\n")
+	expectReply(t, subR, "*4\r\n$8\r\npmessage\r\n$21\r\n__keyspace@0__:user:*\r\n$21\r\n__keyspace@0__:user:1\r\n$3\r\ndel\r\n")
+	expectReply(t, subR, "*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:expired\r\n$5\r\nother\r\n")
+
+	sub.Write([]byte("unsubscribe\r\npunsubscribe\r\nunsubscribe\r\n"))
+	expectReply(t, subR, "*3\r\n$11\r\nunsubscribe\r\n$22\r\n__keyevent@0__:expired\r\n:1\r\n")
+	expectReply(t, subR, "*3\r\n$12\r\npunsubscribe\r\n$21\r\n__keyspace@0__:user:*\r\n:0\r\n")
+	expectReply(t, subR, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")
+	sub.Write([]byte("ping\r\n"))
+	expectReply(t, subR, "+PONG\r\n")
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"__keyspace@*__:user:*", "__keyspace@0__:user:1", true},
+		{"__keyspace@*__:user:*", "__keyspace@0__:item:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	} {
+		if got := matchGlob(c.pattern, c.s); got != c.match {
+			t.Errorf("matchGlob(%q, %q) = %v", c.pattern, c.s, got)
+		}
+	}
+}
+
+func TestReadCommandChunks(t *testing.T) {
+	value := strings.Repeat("v", 3*argChunkLen+5)
+	cmd := "*2\r\n$3\r\nget\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+	args, err := readCommand(bufio.NewReader(strings.NewReader(cmd)), nil)
+	if err != nil || len(args) != 2 || string(args[1]) != value {
+		t.Fatalf("readCommand = %d args, %v", len(args), err)
+	}
+	// the length announced isn't allocated before the bytes are received.
+	cmd = "*1\r\n$" + strconv.Itoa(maxArgLen) + "\r\nabc"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := readCommand(bufio.NewReader(strings.NewReader(cmd)), nil); err != io.ErrUnexpectedEOF {
+		t.Fatalf("readCommand of a truncated argument err = %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1024*1024 {
+		t.Fatalf("readCommand allocated %d bytes", n)
+	}
+}
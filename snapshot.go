@@ -0,0 +1,219 @@
+package freecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+	"unsafe"
+)
+
+var ErrInvalidSnapshot = errors.New("Invalid snapshot")
+
+const (
+	snapshotMagic   = "FCSN"
+	snapshotVersion = 2
+	// snapshotEndMark is written in place of the key length of a record after the last entry.
+	snapshotEndMark = ^uint32(0)
+	// snapshotRecordHdrSize is the size of keyLen, valLen, ttlMs, flags, cost and softTTLMs of a record.
+	snapshotRecordHdrSize = 33
+	// snapshotV1RecordHdrSize is the size of the records of version 1, without cost and softTTLMs.
+	snapshotV1RecordHdrSize = 17
+)
+
+// Save writes a snapshot of all live entries with their remaining TTL to w, it can be
+// loaded by LoadCache. The snapshot is versioned and ends with a CRC32 checksum.
+// Segments are copied one at a time, so the cache is never locked while writing to w,
+// and entries set during Save may or may not be included.
+//
+// The format is the magic "FCSN", the version, then a record for every entry and an end mark,
+// the entry count and the checksum of all previous bytes. All integers are little endian.
+// A record is keyLen uint32, valLen uint32, ttlMs int64 (0 means no expire), flags uint8, cost int64,
+// softTTLMs int64 (0 means no soft expiration, it is negative once passed), key and value. The flags keep
+// whether the entry is pinned or negative and the id of its namespace with quota, so the namespaces with
+// quota must be created in the same order after LoadCache for their used bytes to include the entries loaded.
+// The snapshots of version 1, without cost and softTTLMs, are still loaded.
+func (cache *Cache) Save(w io.Writer) (err error) {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	var hdr [8]byte
+	copy(hdr[:4], snapshotMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], snapshotVersion)
+	bw.Write(hdr[:])
+	var buf []byte
+	var count uint64
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		var n int
+		buf, n = cache.segments[i].appendSnapshot(buf[:0])
+		cache.locks[i].Unlock()
+		count += uint64(n)
+		if _, err = bw.Write(buf); err != nil {
+			return
+		}
+	}
+	var trailer [12]byte
+	binary.LittleEndian.PutUint32(trailer[:4], snapshotEndMark)
+	binary.LittleEndian.PutUint64(trailer[4:], count)
+	bw.Write(trailer[:])
+	if err = bw.Flush(); err != nil {
+		return
+	}
+	binary.LittleEndian.PutUint32(trailer[:4], crc.Sum32())
+	_, err = w.Write(trailer[:4])
+	return
+}
+
+// LoadCache returns a new cache of size configured by opts, with the entries of a snapshot written by Save.
+// The remaining TTL of the entries continues from the time they are loaded. Entries that don't fit in the
+// new cache are skipped. ErrInvalidSnapshot is returned if the snapshot is malformed or the checksum doesn't match.
+func LoadCache(r io.Reader, size int, opts ...Option) (cache *Cache, err error) {
+	if cache, err = OpenCache(size, opts...); err != nil {
+		return
+	}
+	if err = cache.loadSnapshot(r); err != nil {
+		cache.Close()
+		return nil, err
+	}
+	return
+}
+
+func (cache *Cache) loadSnapshot(r io.Reader) (err error) {
+	crc := crc32.NewIEEE()
+	tr := io.TeeReader(bufio.NewReader(r), crc)
+	var hdr [8]byte
+	if _, err = io.ReadFull(tr, hdr[:]); err != nil {
+		return snapshotErr(err)
+	}
+	version := binary.LittleEndian.Uint32(hdr[4:])
+	if string(hdr[:4]) != snapshotMagic || version < 1 || version > snapshotVersion {
+		return ErrInvalidSnapshot
+	}
+	hdrSize := snapshotRecordHdrSize
+	if version == 1 {
+		hdrSize = snapshotV1RecordHdrSize
+	}
+	var recordHdr [snapshotRecordHdrSize]byte
+	var data []byte
+	var count uint64
+	for {
+		if _, err = io.ReadFull(tr, recordHdr[:4]); err != nil {
+			return snapshotErr(err)
+		}
+		keyLen := binary.LittleEndian.Uint32(recordHdr[:4])
+		if keyLen == snapshotEndMark {
+			break
+		}
+		if _, err = io.ReadFull(tr, recordHdr[4:hdrSize]); err != nil {
+			return snapshotErr(err)
+		}
+		valLen := binary.LittleEndian.Uint32(recordHdr[4:8])
+		ttlMs := int64(binary.LittleEndian.Uint64(recordHdr[8:16]))
+		flags := recordHdr[16] & flagNegative
+		cost, softTTLMs := int64(1), int64(0)
+		if version >= 2 {
+			flags = recordHdr[16] &^ (flagDeleted | flagCost)
+			cost = int64(binary.LittleEndian.Uint64(recordHdr[17:25]))
+			softTTLMs = int64(binary.LittleEndian.Uint64(recordHdr[25:33]))
+		}
+		if keyLen > 65535 || valLen > 1<<30 {
+			return ErrInvalidSnapshot
+		}
+		if cap(data) < int(keyLen+valLen) {
+			data = make([]byte, keyLen+valLen)
+		}
+		data = data[:keyLen+valLen]
+		if _, err = io.ReadFull(tr, data); err != nil {
+			return snapshotErr(err)
+		}
+		cache.restore(data[:keyLen], data[keyLen:], time.Duration(ttlMs)*time.Millisecond, flags, cost, softTTLMs)
+		count++
+	}
+	var trailer [8]byte
+	if _, err = io.ReadFull(tr, trailer[:]); err != nil {
+		return snapshotErr(err)
+	}
+	sum := crc.Sum32()
+	var sumBuf [4]byte
+	if _, err = io.ReadFull(tr, sumBuf[:]); err != nil {
+		return snapshotErr(err)
+	}
+	if binary.LittleEndian.Uint64(trailer[:]) != count || binary.LittleEndian.Uint32(sumBuf[:]) != sum {
+		return ErrInvalidSnapshot
+	}
+	return nil
+}
+
+// restore sets an entry read from a snapshot or a log, without propagating it to the writers. softTTLMs is
+// the time left before the soft expiration, 0 means none.
+func (cache *Cache) restore(key, value []byte, ttl time.Duration, flags uint8, cost, softTTLMs int64) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	nowMs := seg.timer.NowMilli()
+	expireAtMs := int64(0)
+	if ttl > 0 {
+		expireAtMs = nowMs + int64(ttl/time.Millisecond)
+	}
+	softExpireAtMs := int64(0)
+	if softTTLMs != 0 {
+		softExpireAtMs = nowMs + softTTLMs
+	}
+	seg.quiet(func() {
+		err = seg.setAt(key, value, hashVal, nowMs, expireAtMs, softExpireAtMs, flags, cost)
+	})
+	cache.locks[segID].Unlock()
+	return
+}
+
+func snapshotErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidSnapshot
+	}
+	return err
+}
+
+// appendSnapshot appends a snapshot record for every live entry of the segment to buf.
+func (seg *segment) appendSnapshot(buf []byte) (_ []byte, count int) {
+	seg.applyClear()
+	nowMs := seg.timer.NowMilli()
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	var recordHdr [snapshotRecordHdrSize]byte
+	for i := 0; i < 256; i++ {
+		for _, ptr := range seg.getSlot(uint8(i)) {
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			expireAtMs := hdr.expireAtMilli()
+			if isExpired(expireAtMs, nowMs) {
+				continue
+			}
+			ttlMs := int64(0)
+			if expireAtMs != 0 {
+				ttlMs = expireAtMs - nowMs
+			}
+			binary.LittleEndian.PutUint32(recordHdr[:4], uint32(hdr.keyLen))
+			binary.LittleEndian.PutUint32(recordHdr[4:8], hdr.valLen)
+			binary.LittleEndian.PutUint64(recordHdr[8:16], uint64(ttlMs))
+			recordHdr[16] = hdr.flags
+			binary.LittleEndian.PutUint64(recordHdr[17:25], uint64(seg.entryCost(hdr, ptr.offset)))
+			softTTLMs := int64(0)
+			if softExpireAtMs := seg.softExpireAt(hdr, ptr.offset); softExpireAtMs != 0 {
+				// a soft expiration reached now is written as passed, as 0 means none.
+				if softTTLMs = softExpireAtMs - nowMs; softTTLMs == 0 {
+					softTTLMs = -1
+				}
+			}
+			binary.LittleEndian.PutUint64(recordHdr[25:33], uint64(softTTLMs))
+			buf = append(buf, recordHdr[:]...)
+			n := len(buf)
+			dataLen := int(hdr.keyLen) + int(hdr.valLen)
+			buf = append(buf, make([]byte, dataLen)...)
+			seg.rb.ReadAt(buf[n:], ptr.offset+ENTRY_HDR_SIZE)
+			count++
+		}
+	}
+	return buf, count
+}
@@ -0,0 +1,108 @@
+package freecache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("val%d", i)), i%3*10)
+	}
+	cache.SetNotFound([]byte("missing"), 0)
+	cache.Set([]byte("expired"), []byte("value"), 1)
+	timer.nowMs = 102000
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCache(bytes.NewReader(buf.Bytes()), 1024*1024, WithTimer(&mockMilliTimer{nowMs: 500000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.EntryCount() != 1001 {
+		t.Fatalf("expected 1001 entries, got %d", loaded.EntryCount())
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		value, err := loaded.Get(key)
+		if err != nil || string(value) != fmt.Sprintf("val%d", i) {
+			t.Fatalf("key%d expected val%d, got %s, err %v", i, i, value, err)
+		}
+		// 2 seconds passed before the snapshot was saved.
+		expected := uint32(i % 3 * 10)
+		if expected > 0 {
+			expected -= 2
+		}
+		if ttl, _ := loaded.TTL(key); ttl != expected {
+			t.Fatalf("key%d expected ttl %d, got %d", i, expected, ttl)
+		}
+	}
+	if _, err = loaded.Get([]byte("missing")); err != ErrNegativeCached {
+		t.Fatalf("expected ErrNegativeCached, got %v", err)
+	}
+
+	data := buf.Bytes()
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 1
+	if _, err = LoadCache(bytes.NewReader(corrupted), 1024*1024); err != ErrInvalidSnapshot {
+		t.Fatalf("expected ErrInvalidSnapshot for corrupted snapshot, got %v", err)
+	}
+	if _, err = LoadCache(bytes.NewReader(data[:len(data)-10]), 1024*1024); err != ErrInvalidSnapshot {
+		t.Fatalf("expected ErrInvalidSnapshot for truncated snapshot, got %v", err)
+	}
+	if _, err = LoadCache(bytes.NewReader([]byte("invalid!")), 1024*1024); err != ErrInvalidSnapshot {
+		t.Fatalf("expected ErrInvalidSnapshot for invalid snapshot, got %v", err)
+	}
+}
+
+func TestSnapshotEntryMetadata(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer))
+	ns, _ := cache.NamespaceWithQuota([]byte("ns:"), 64*1024)
+	cache.Set([]byte("pinned"), []byte("value"), 0)
+	cache.Pin([]byte("pinned"))
+	cache.SetWithCost([]byte("cost"), []byte("value"), 10, 0)
+	cache.SetWithSoftTTL([]byte("stale"), []byte("value"), time.Second, time.Minute)
+	ns.Set([]byte("key"), []byte("value"), 0)
+	timer.nowMs = 101000
+	cache.SetWithSoftTTL([]byte("soft"), []byte("value"), time.Second, time.Minute)
+	timer.nowMs = 101500
+
+	var buf bytes.Buffer
+	if err := cache.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	timer.nowMs = 100000
+	loaded, err := LoadCache(bytes.NewReader(buf.Bytes()), 1024*1024, WithTimer(timer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedNS, _ := loaded.NamespaceWithQuota([]byte("ns:"), 64*1024)
+	if used := loadedNS.UsedBytes(); used == 0 || used != ns.UsedBytes() {
+		t.Fatalf("namespace used bytes = %d, want %d", used, ns.UsedBytes())
+	}
+	hashVal := loaded.hash([]byte("pinned"))
+	if hdr, _, _ := loaded.segments[hashVal&segmentAndOpVal].locate([]byte("pinned"), hashVal, true); hdr.flags&flagPinned == 0 {
+		t.Fatal("loaded entry not pinned")
+	}
+	if cost := loaded.TotalCost(); cost != cache.TotalCost() {
+		t.Fatalf("total cost = %d, want %d", cost, cache.TotalCost())
+	}
+	// the soft ttl left was 0.5s when saved, and "stale" was past its soft ttl.
+	if _, stale, err := loaded.GetWithSoftTTL([]byte("soft")); err != nil || stale {
+		t.Fatalf("soft = stale %v, err %v", stale, err)
+	}
+	if _, stale, err := loaded.GetWithSoftTTL([]byte("stale")); err != nil || !stale {
+		t.Fatalf("stale = stale %v, err %v", stale, err)
+	}
+	timer.nowMs = 100500
+	if _, stale, _ := loaded.GetWithSoftTTL([]byte("soft")); !stale {
+		t.Fatal("soft ttl not restored")
+	}
+}
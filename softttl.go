@@ -0,0 +1,51 @@
+package freecache
+
+import (
+	"time"
+)
+
+// SetWithSoftTTL is like SetWithDuration with hardTTL, but the entry also has a soft expiration in softTTL,
+// after which GetWithSoftTTL still returns the value but reports it stale, so it can be served while it is
+// refreshed. The entry is removed at its hard expiration only. With WithRefreshAhead, the entry is reloaded
+// when it is read past its soft expiration instead of the ratio of its ttl. softTTL <= 0 means no soft
+// expiration. The soft expiration is kept by Touch and by the updates of the value in place, and replaced
+// by the other sets of the key.
+func (cache *Cache) SetWithSoftTTL(key, value []byte, softTTL, hardTTL time.Duration) (err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	nowMs := seg.timer.NowMilli()
+	err = seg.setAt(key, value, hashVal, nowMs, expireAtMilli(nowMs, seg.setPolicy(hardTTL)), expireAtMilli(nowMs, softTTL), 0, 1)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// GetWithSoftTTL is like Get without loader, but also returns whether the soft expiration of the entry set
+// by SetWithSoftTTL is passed. An entry without soft expiration is never stale.
+func (cache *Cache) GetWithSoftTTL(key []byte) (value []byte, stale bool, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	value, stale, err = cache.segments[segID].getSoft(key, hashVal)
+	cache.locks[segID].Unlock()
+	return
+}
+
+// getSoft is like get, but returns whether the entry is past its soft expiration.
+func (seg *segment) getSoft(key []byte, hashVal uint64) (value []byte, stale bool, err error) {
+	hdr, ptrOffset, err := seg.locate(key, hashVal, false)
+	if err != nil {
+		return
+	}
+	value = make([]byte, hdr.valLen)
+	seg.rb.ReadAt(value, ptrOffset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+	if err = seg.verifyChecksum(&hdr, ptrOffset, key, value); err != nil {
+		return nil, false, err
+	}
+	seg.countHit()
+	if softExpireAtMs := seg.softExpireAt(&hdr, ptrOffset); softExpireAtMs != 0 {
+		stale = softExpireAtMs <= seg.timer.NowMilli()
+	}
+	return
+}
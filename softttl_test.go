@@ -0,0 +1,89 @@
+package freecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSoftTTL(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithChecksums(true))
+	key := []byte("key")
+	expect := func(value string, stale bool) {
+		t.Helper()
+		v, s, err := cache.GetWithSoftTTL(key)
+		if err != nil || string(v) != value || s != stale {
+			t.Fatalf("GetWithSoftTTL = %q, %v, %v, want %q, %v", v, s, err, value, stale)
+		}
+		if errs := cache.Validate(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	if err := cache.SetWithSoftTTL(key, []byte("abc"), time.Second, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expect("abc", false)
+	timer.nowMs += 1000
+	expect("abc", true)
+	if ttl, _ := cache.TTL(key); ttl != 9 {
+		t.Fatalf("ttl = %d", ttl)
+	}
+
+	// the soft expiration is kept by Touch and the updates in place.
+	cache.Touch(key, 20)
+	cache.Append(key, []byte("defghijklmnop"))
+	cache.SetRange(key, 0, []byte("ABC"))
+	expect("ABCdefghijklmnop", true)
+	if ttl, _ := cache.TTL(key); ttl != 20 {
+		t.Fatalf("ttl = %d", ttl)
+	}
+	if err := cache.Resize(2 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	expect("ABCdefghijklmnop", true)
+
+	// the other sets replace it, in place or not.
+	cache.SetWithSoftTTL(key, []byte("x"), time.Second, 0)
+	expect("x", false)
+	cache.Set(key, []byte("y"), 0)
+	expect("y", false)
+	timer.nowMs += 2000
+	expect("y", false)
+	cache.SetWithSoftTTL(key, []byte("z"), time.Second, 0)
+	timer.nowMs += 1000
+	expect("z", true)
+	cache.SetWithSoftTTL(key, []byte("w"), 0, 0)
+	expect("w", false)
+	if v, err := cache.Get(key); err != nil || string(v) != "w" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+
+	cache.SetWithSoftTTL(key, []byte("v"), time.Second, 2*time.Second)
+	timer.nowMs += 2000
+	if _, _, err := cache.GetWithSoftTTL(key); err != ErrExpired {
+		t.Fatalf("GetWithSoftTTL after the hard expiration err = %v", err)
+	}
+}
+
+func TestSoftTTLRefreshAhead(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	loaded := make(chan string, 1)
+	cache := NewCacheWithOptions(1024*1024, WithTimer(timer), WithRefreshAhead(0.01, func(key []byte) ([]byte, int, error) {
+		loaded <- string(key)
+		return []byte("new"), 0, nil
+	}))
+	cache.SetWithSoftTTL([]byte("key"), []byte("old"), time.Second, time.Hour)
+	cache.Get([]byte("key"))
+	select {
+	case key := <-loaded:
+		t.Fatalf("%s reloaded before its soft expiration", key)
+	case <-time.After(10 * time.Millisecond):
+	}
+	timer.nowMs += 1000
+	if v, _ := cache.Get([]byte("key")); string(v) != "old" {
+		t.Fatalf("Get = %q, want the stale value", v)
+	}
+	if key := <-loaded; key != "key" {
+		t.Fatalf("reloaded %s", key)
+	}
+}
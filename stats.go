@@ -0,0 +1,119 @@
+package freecache
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the statistics of a cache, see the methods of Cache with the same names.
+// The entries removed from the cache are also counted by the reason of their removal.
+type Stats struct {
+	HitCount          int64
+	MissCount         int64
+	LookupCount       int64
+	EntryCount        int64
+	EvacuateCount     int64
+	ExpiredCount      int64
+	OverwriteCount    int64
+	TouchedCount      int64
+	RejectedCount     int64
+	AverageAccessTime int64
+
+	// DeletedCount is the number of entries removed by Del.
+	DeletedCount int64
+	// EvictedCount is the number of entries evicted to make room before they expired, unlike
+	// EvacuateCount, which also counts the recently used entries moved to the end of the ring buffer.
+	EvictedCount int64
+	// RelocatedCount is the number of entries moved to the end of the ring buffer by a Set
+	// of a value larger than the space of the old value.
+	RelocatedCount int64
+	// AccessExpiredCount is the number of entries found expired when accessed, part of ExpiredCount.
+	AccessExpiredCount int64
+	// DeletedBytes is the size of the deleted entries whose space isn't reclaimed yet, see Compact.
+	DeletedBytes int64
+}
+
+// HitRate is the ratio of hits over lookups of the snapshot.
+func (s Stats) HitRate() float64 {
+	if s.LookupCount == 0 {
+		return 0
+	}
+	return float64(s.HitCount) / float64(s.LookupCount)
+}
+
+// Stats returns all the statistics of the cache in one pass. The counters of a segment are read with
+// its lock held, so they are consistent with each other, unlike the results of the separate methods
+// called one after another while the cache is in use.
+func (cache *Cache) Stats() (stats Stats) {
+	var totalTime, totalCount int64
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		cache.locks[i].Lock()
+		stats.HitCount += atomic.LoadInt64(&seg.hitCount)
+		stats.MissCount += atomic.LoadInt64(&seg.missCount)
+		stats.EntryCount += atomic.LoadInt64(&seg.entryCount)
+		stats.EvacuateCount += atomic.LoadInt64(&seg.totalEvacuate)
+		stats.ExpiredCount += atomic.LoadInt64(&seg.totalExpired)
+		stats.OverwriteCount += atomic.LoadInt64(&seg.overwrites)
+		stats.TouchedCount += atomic.LoadInt64(&seg.touched)
+		stats.RejectedCount += atomic.LoadInt64(&seg.rejected)
+		stats.DeletedCount += atomic.LoadInt64(&seg.deleted)
+		stats.EvictedCount += atomic.LoadInt64(&seg.evicted)
+		stats.RelocatedCount += atomic.LoadInt64(&seg.relocated)
+		stats.AccessExpiredCount += atomic.LoadInt64(&seg.accessExpired)
+		stats.DeletedBytes += atomic.LoadInt64(&seg.deletedLen)
+		totalTime += atomic.LoadInt64(&seg.totalTime)
+		totalCount += atomic.LoadInt64(&seg.totalCount)
+		cache.locks[i].Unlock()
+	}
+	stats.LookupCount = stats.HitCount + stats.MissCount
+	if totalCount > 0 {
+		stats.AverageAccessTime = totalTime / totalCount
+	}
+	return
+}
+
+// SegmentStats is a snapshot of the statistics of a segment, to detect skew between segments.
+type SegmentStats struct {
+	EntryCount    int64
+	UsedBytes     int64 // bytes of the ring buffer holding entries, including deleted entries.
+	DeletedBytes  int64 // bytes of the deleted entries, reclaimed when the evacuation reaches them or by Compact.
+	BufferBytes   int64 // size of the ring buffer.
+	EvacuateCount int64
+	ExpiredCount  int64
+	EvictedCount  int64
+	HitCount      int64
+	MissCount     int64
+	UsedSlots     int // number of the 256 slots holding at least one entry.
+	MaxSlotLen    int // number of entries of the longest slot.
+	SlotCap       int // capacity of every slot, doubled when a slot is full.
+}
+
+// SegmentStats returns the statistics of the segment segID, from 0 to 255.
+// It returns zero statistics for an invalid segID.
+func (cache *Cache) SegmentStats(segID int) (stats SegmentStats) {
+	if segID < 0 || segID >= segmentCount {
+		return
+	}
+	seg := &cache.segments[segID]
+	cache.locks[segID].Lock()
+	defer cache.locks[segID].Unlock()
+	stats.EntryCount = atomic.LoadInt64(&seg.entryCount)
+	stats.BufferBytes = seg.rb.Size()
+	stats.UsedBytes = stats.BufferBytes - seg.vacuumLen
+	stats.DeletedBytes = atomic.LoadInt64(&seg.deletedLen)
+	stats.EvacuateCount = atomic.LoadInt64(&seg.totalEvacuate)
+	stats.ExpiredCount = atomic.LoadInt64(&seg.totalExpired)
+	stats.EvictedCount = atomic.LoadInt64(&seg.evicted)
+	stats.HitCount = atomic.LoadInt64(&seg.hitCount)
+	stats.MissCount = atomic.LoadInt64(&seg.missCount)
+	stats.SlotCap = int(seg.slotCap)
+	for _, l := range seg.slotLens {
+		if l > 0 {
+			stats.UsedSlots++
+		}
+		if int(l) > stats.MaxSlotLen {
+			stats.MaxSlotLen = int(l)
+		}
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package freecache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Set([]byte("a"), []byte("2"), 0)
+	cache.Set([]byte("b"), []byte("1"), 0)
+	cache.Get([]byte("a"))
+	cache.Get([]byte("c"))
+	cache.Touch([]byte("b"), 10)
+	stats := cache.Stats()
+	want := Stats{
+		HitCount:          cache.HitCount(),
+		MissCount:         cache.MissCount(),
+		LookupCount:       cache.LookupCount(),
+		EntryCount:        cache.EntryCount(),
+		EvacuateCount:     cache.EvacuateCount(),
+		ExpiredCount:      cache.ExpiredCount(),
+		OverwriteCount:    cache.OverwriteCount(),
+		TouchedCount:      cache.TouchedCount(),
+		RejectedCount:     cache.RejectedCount(),
+		AverageAccessTime: cache.AverageAccessTime(),
+	}
+	if stats != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+	if stats.HitCount != 1 || stats.LookupCount != 2 || stats.EntryCount != 2 || stats.OverwriteCount != 1 ||
+		stats.TouchedCount != 1 || stats.HitRate() != 0.5 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestSegmentStats(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(strconv.Itoa(i)), []byte("value"), 0)
+	}
+	var entries, used int64
+	for i := 0; i < 256; i++ {
+		stats := cache.SegmentStats(i)
+		if stats.BufferBytes != 2048 || stats.UsedBytes > stats.BufferBytes || stats.UsedSlots > 256 ||
+			stats.MaxSlotLen > stats.SlotCap || int64(stats.MaxSlotLen) > stats.EntryCount {
+			t.Fatalf("segment %d stats = %+v", i, stats)
+		}
+		entries += stats.EntryCount
+		used += stats.UsedBytes
+	}
+	if entries != cache.EntryCount() || used != cache.MemoryUsage().UsedBytes {
+		t.Fatalf("entries = %d, used = %d", entries, used)
+	}
+	if stats := cache.SegmentStats(256); stats != (SegmentStats{}) {
+		t.Fatalf("invalid segment stats = %+v", stats)
+	}
+}
+
+func TestRemovalCounters(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 1000000}
+	cache := NewCacheWithOptions(512*1024, WithTimer(timer))
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Set([]byte("a"), []byte("12345"), 0)
+	cache.Del([]byte("a"))
+	cache.Del([]byte("a"))
+	cache.Set([]byte("b"), []byte("1"), 1)
+	timer.nowMs += 2000
+	cache.Get([]byte("b"))
+	value := make([]byte, 200)
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(strconv.Itoa(i)), value, 0)
+	}
+	stats := cache.Stats()
+	if stats.DeletedCount != 1 || stats.RelocatedCount != 1 || stats.AccessExpiredCount != 1 || stats.ExpiredCount != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats.EvictedCount == 0 || stats.EvictedCount > stats.EvacuateCount ||
+		stats.EvictedCount != 10000-stats.EntryCount {
+		t.Fatalf("evicted = %d, evacuated = %d, entries = %d", stats.EvictedCount, stats.EvacuateCount, stats.EntryCount)
+	}
+	cache.ResetStatistics()
+	if stats := cache.Stats(); stats.DeletedCount != 0 || stats.EvictedCount != 0 || stats.RelocatedCount != 0 {
+		t.Fatalf("stats after reset = %+v", stats)
+	}
+}
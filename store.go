@@ -0,0 +1,405 @@
+package freecache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReservedValue is returned by the sets of a cache with WithLargeValues or WithOverflowStore for a value
+// starting like the manifest of a split value or the stub of a delegated value, which the reads would take
+// for one.
+var ErrReservedValue = errors.New("The value starts like the stub of a large value")
+
+// ErrStubbed is returned by the methods modifying a value in place, e.g. Append, for a value split by
+// WithLargeValues or delegated to the OverflowStore, which isn't in its entry.
+var ErrStubbed = errors.New("The value is split or delegated, it can't be modified in place")
+
+// Store is a durable backend the writes of the cache are propagated to,
+// see WithWriteThrough and WithWriteBehind.
+//
+// Every method modifying an entry propagates it once the cache accepted the change, as a Set of the new
+// value and expiration of the entry, e.g. for Touch, Incr or UpdateInPlace, or as a Del, e.g. for Pop,
+// DelMulti or SetNotFound. The evictions, expirations, Clear and Reset are not propagated. The values split
+// by SetLarge or delegated to an OverflowStore are propagated whole, but not the changes of their expiration.
+// With WithWriteThrough, the methods are called with a segment lock held, so they must not block nor call the
+// cache.
+type Store interface {
+	Set(key, value []byte, expireSeconds int) error
+	Del(key []byte) error
+}
+
+// storeWriter propagates the writes of the cache to a Store, the key and value are owned by the writer.
+// The expiration is expireAtMs, 0 means no expire, set at nowMs.
+type storeWriter interface {
+	set(key, value []byte, nowMs, expireAtMs int64) error
+	del(key []byte) error
+	flush() error
+}
+
+// writeLog propagates the writes applied by the segments to the writers of the cache. The segments call it
+// with their lock held once a write is applied, so the writers only see the writes the cache accepted, and
+// the writes of a key in the order they were applied.
+type writeLog struct {
+	writers []storeWriter
+}
+
+func (l *writeLog) enabled() bool {
+	return l != nil && len(l.writers) > 0
+}
+
+// set propagates a set of key to the writers. If a writer fails, the writers before it are sent a del of
+// key, as the entry is deleted by the segment, and the error is returned.
+func (l *writeLog) set(key, value []byte, nowMs, expireAtMs int64) (err error) {
+	k, v := append([]byte(nil), key...), append([]byte(nil), value...)
+	for i, w := range l.writers {
+		if err = w.set(k, v, nowMs, expireAtMs); err != nil {
+			for _, prev := range l.writers[:i] {
+				prev.del(k)
+			}
+			return
+		}
+	}
+	return
+}
+
+func (l *writeLog) del(key []byte) {
+	k := append([]byte(nil), key...)
+	for _, w := range l.writers {
+		w.del(k)
+	}
+}
+
+// logSet propagates the set of key applied at nowMs, the value of an entry cached by SetNotFound is
+// propagated as a del. If a writer fails, the entry is deleted, so the cache doesn't serve a value the
+// writers don't have, and the error is returned.
+func (seg *segment) logSet(key, value []byte, hashVal uint64, nowMs, expireAtMs int64, negative bool) (err error) {
+	if !seg.writes.enabled() {
+		return
+	}
+	if negative {
+		seg.writes.del(key)
+		return
+	}
+	if err = seg.writes.set(key, value, nowMs, expireAtMs); err != nil {
+		writes := seg.writes
+		seg.writes = nil
+		seg.del(key, hashVal)
+		seg.writes = writes
+	}
+	return
+}
+
+// logEntry propagates the entry of key at offset after it was modified in place, e.g. by Touch or Append.
+// The manifests of SetLarge and the stubs of WithOverflowStore are not propagated, see setStub.
+func (seg *segment) logEntry(key []byte, hashVal uint64, hdr *entryHdr, offset int64) (err error) {
+	if !seg.writes.enabled() {
+		return
+	}
+	value := make([]byte, hdr.valLen)
+	seg.rb.ReadAt(value, offset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+	if isStub(value) {
+		return
+	}
+	return seg.logSet(key, value, hashVal, seg.timer.NowMilli(), hdr.expireAtMilli(), hdr.flags&flagNegative != 0)
+}
+
+// isStub reports whether value is the manifest of a value split by SetLarge or the stub of a value delegated
+// to the OverflowStore, which stand for the value in the cache.
+func isStub(value []byte) bool {
+	if _, ok := parseLargeManifest(value); ok {
+		return true
+	}
+	_, ok := parseOverflowStub(value)
+	return ok
+}
+
+// hasStubMagic reports whether value starts like the manifest of a split value or the stub of a delegated
+// value, see ErrReservedValue.
+func hasStubMagic(value []byte) bool {
+	return len(value) >= len(largeMagic) &&
+		(string(value[:len(largeMagic)]) == largeMagic || string(value[:len(overflowMagic)]) == overflowMagic)
+}
+
+// checkValue returns ErrReservedValue if the reads of the cache would take value for a stub.
+func (cache *Cache) checkValue(value []byte) error {
+	if cache.hasStubs() && hasStubMagic(value) {
+		return ErrReservedValue
+	}
+	return nil
+}
+
+// hasStubs reports whether the values of the cache may be stubs its reads resolve, see stubbed.
+func (cache *Cache) hasStubs() bool {
+	return cache.overflow != nil || cache.largeValues
+}
+
+// stubbed reports whether value stands for another value the reads of the cache return instead, the stub of
+// a value delegated to the OverflowStore, or the manifest of a value split by SetLarge with WithLargeValues.
+func (cache *Cache) stubbed(value []byte) (ok bool) {
+	switch {
+	case cache.overflow != nil:
+		_, ok = parseOverflowStub(value)
+	case cache.largeValues:
+		_, ok = parseLargeManifest(value)
+	}
+	return
+}
+
+// stubOf returns a copy of the value of key if it is stubbed, so the write replacing or deleting it can drop
+// the value it stands for, see dropStub. It is called with the segment lock held.
+func (cache *Cache) stubOf(seg *segment, key []byte, hashVal uint64) []byte {
+	if !cache.hasStubs() {
+		return nil
+	}
+	value, _, err := seg.viewEntry(key, hashVal, true)
+	if err != nil || !cache.stubbed(value) {
+		return nil
+	}
+	return append([]byte(nil), value...)
+}
+
+// lockStub locks the writes of the keys of the segment segID that may replace or delete a stubbed value, so
+// reading the stub replaced, writing the entry and dropping the value the stub stood for don't interleave
+// with another write of the key, e.g. a set delegating a new value to the OverflowStore under the same key.
+// It is taken before the segment lock, and does nothing without stubs.
+func (cache *Cache) lockStub(segID uint64) {
+	if cache.hasStubs() {
+		cache.stubLocks[segID].Lock()
+	}
+}
+
+func (cache *Cache) unlockStub(segID uint64) {
+	if cache.hasStubs() {
+		cache.stubLocks[segID].Unlock()
+	}
+}
+
+// isStubbed reports whether the value of key is stubbed, so it can't be modified in place, see ErrStubbed.
+// It is called with the segment lock held.
+func (cache *Cache) isStubbed(seg *segment, key []byte, hashVal uint64) bool {
+	if !cache.hasStubs() {
+		return false
+	}
+	value, _, err := seg.viewEntry(key, hashVal, true)
+	return err == nil && cache.stubbed(value)
+}
+
+// setValue sets key like setAt, with the expiration of ttl applied by setPolicy. It is the set of Set and the
+// other methods setting a value of the caller: with WithLargeValues or WithOverflowStore, the value is
+// checked by checkValue, a value too large for an entry is split or delegated, and the value the set
+// replaces is dropped if it was stubbed, see setReplaced.
+func (cache *Cache) setValue(key, value []byte, ttl time.Duration, flags uint8, cost int64) (err error) {
+	if cache.isClosed() {
+		return ErrClosed
+	}
+	if err = cache.checkValue(value); err != nil {
+		return
+	}
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.lockStub(segID)
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	stub := cache.stubOf(seg, key, hashVal)
+	nowMs := seg.timer.NowMilli()
+	err = seg.setAt(key, value, hashVal, nowMs, expireAtMilli(nowMs, seg.setPolicy(ttl)), 0, flags, cost)
+	cache.locks[segID].Unlock()
+	err = cache.setReplaced(key, value, ttl, flags, cost, stub, err)
+	cache.unlockStub(segID)
+	return
+}
+
+// setReplaced completes a set of key once the segment lock is released, with the stub lock still held, err is
+// the error of the set in the segment: a value too large for an entry is split with WithLargeValues or delegated to the
+// OverflowStore, and stub, the stubbed value the set replaced, is dropped if the set succeeded.
+func (cache *Cache) setReplaced(key, value []byte, ttl time.Duration, flags uint8, cost int64, stub []byte, err error) error {
+	if err == ErrLargeEntry {
+		switch {
+		case cache.overflow != nil:
+			// the value stub stood for is replaced in the OverflowStore.
+			err, stub = cache.setOverflow(key, value, ttl, flags, cost), nil
+		case cache.largeValues:
+			err = cache.splitLarge(key, value, ttl, flags, cost)
+		}
+	}
+	if err == nil && stub != nil {
+		cache.dropStub(key, stub)
+	}
+	return err
+}
+
+// dropStub deletes the value stub stood for once a write replaced or deleted stub, the stubbed value of key:
+// the value delegated to the OverflowStore, or the chunks of a split value. It locks the segments, so it is
+// called without a segment lock, but with the stub lock of key, see lockStub.
+func (cache *Cache) dropStub(key, stub []byte) {
+	switch {
+	case cache.overflow != nil:
+		cache.overflow.Del(append([]byte(nil), key...))
+	case cache.largeValues:
+		if m, ok := parseLargeManifest(stub); ok {
+			cache.delLargeChunks(key, &m)
+		}
+	}
+}
+
+// unstub returns value, or the value it stands for if it is stubbed, read like Peek if peek is true.
+// It locks the segments, so it is called without a segment lock.
+func (cache *Cache) unstub(key, value []byte, peek bool) ([]byte, error) {
+	switch {
+	case cache.overflow != nil:
+		return cache.getOverflow(key, value)
+	case cache.largeValues:
+		return cache.getLargeChunks(key, value, peek)
+	}
+	return value, nil
+}
+
+// viewStubbed is the zero-copy read of a cache with stubs, fn is called with a view of the value of key and
+// the header of its entry under the segment lock, or, if the value is stubbed, with the value it stands for
+// once the lock is released.
+func (cache *Cache) viewStubbed(key []byte, peek bool, fn func(seg *segment, value []byte, hdr *entryHdr) error) error {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	seg := &cache.segments[segID]
+	cache.locks[segID].Lock()
+	value, hdr, err := seg.viewEntry(key, hashVal, peek)
+	if err != nil || !cache.stubbed(value) {
+		if err == nil {
+			err = fn(seg, value, &hdr)
+		}
+		cache.locks[segID].Unlock()
+		return err
+	}
+	value = append([]byte(nil), value...)
+	cache.locks[segID].Unlock()
+	if value, err = cache.unstub(key, value, peek); err != nil {
+		return err
+	}
+	return fn(seg, value, &hdr)
+}
+
+// unstubReplaced is called without the segment lock, but with the stub lock, once a write replaced or deleted
+// old, the value of key the write returns: if old is stubbed, it returns the value old stood for and drops it,
+// see dropStub.
+func (cache *Cache) unstubReplaced(key, old []byte) ([]byte, error) {
+	if !cache.stubbed(old) {
+		return old, nil
+	}
+	value, err := cache.unstub(key, old, false)
+	cache.dropStub(key, old)
+	return value, err
+}
+
+// quiet calls fn without propagating the writes of the segment, e.g. to restore a snapshot.
+func (seg *segment) quiet(fn func()) {
+	writes := seg.writes
+	seg.writes = nil
+	fn()
+	seg.writes = writes
+}
+
+type writeThrough struct {
+	store Store
+}
+
+func (w writeThrough) set(key, value []byte, nowMs, expireAtMs int64) error {
+	return w.store.Set(key, value, int(ttlSeconds(nowMs, expireAtMs)))
+}
+
+func (w writeThrough) del(key []byte) error {
+	return w.store.Del(key)
+}
+
+func (w writeThrough) flush() error {
+	return nil
+}
+
+// pendingWrite is the last write of a key not written to the store yet.
+type pendingWrite struct {
+	value         []byte
+	expireSeconds int
+	deleted       bool
+}
+
+type writeBehind struct {
+	store   Store
+	mu      sync.Mutex
+	pending map[string]pendingWrite
+	// flushMu serializes flushes, so a key is never written by two flushes at the same time.
+	flushMu sync.Mutex
+}
+
+func newWriteBehind(store Store) *writeBehind {
+	return &writeBehind{
+		store:   store,
+		pending: make(map[string]pendingWrite),
+	}
+}
+
+func (w *writeBehind) set(key, value []byte, nowMs, expireAtMs int64) error {
+	w.mu.Lock()
+	w.pending[string(key)] = pendingWrite{value: value, expireSeconds: int(ttlSeconds(nowMs, expireAtMs))}
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *writeBehind) del(key []byte) error {
+	w.mu.Lock()
+	w.pending[string(key)] = pendingWrite{deleted: true}
+	w.mu.Unlock()
+	return nil
+}
+
+// flush writes the pending writes to the store and returns the last error. The failed writes are
+// queued again, unless the key has been written in the meantime.
+func (w *writeBehind) flush() (err error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = make(map[string]pendingWrite, len(batch))
+	w.mu.Unlock()
+	for key, write := range batch {
+		var writeErr error
+		if write.deleted {
+			writeErr = w.store.Del([]byte(key))
+		} else {
+			writeErr = w.store.Set([]byte(key), write.value, write.expireSeconds)
+		}
+		if writeErr == nil {
+			continue
+		}
+		err = writeErr
+		w.mu.Lock()
+		if _, ok := w.pending[key]; !ok {
+			w.pending[key] = write
+		}
+		w.mu.Unlock()
+	}
+	return
+}
+
+func (w *writeBehind) run(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			w.flush()
+			return
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+// Flush writes the pending writes of WithWriteBehind and WithOpLog now, and returns the last error.
+func (cache *Cache) Flush() (err error) {
+	for _, w := range cache.writes.writers {
+		if flushErr := w.flush(); flushErr != nil {
+			err = flushErr
+		}
+	}
+	return
+}
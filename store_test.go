@@ -0,0 +1,196 @@
+package freecache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockStore struct {
+	mu     sync.Mutex
+	data   map[string]string
+	writes int
+	err    error
+}
+
+func newMockStore() *mockStore {
+	return &mockStore{data: make(map[string]string)}
+}
+
+func (s *mockStore) Set(key, value []byte, expireSeconds int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.writes++
+	s.data[string(key)] = string(value)
+	return nil
+}
+
+func (s *mockStore) Del(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.writes++
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *mockStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+func TestWriteThrough(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(1024, WithWriteThrough(store))
+	defer cache.Close()
+	cache.Set([]byte("abcd"), []byte("efgh"), 0)
+	if value, _ := store.get("abcd"); value != "efgh" {
+		t.Fatalf("expected efgh in store, got %s", value)
+	}
+	cache.Del([]byte("abcd"))
+	if _, ok := store.get("abcd"); ok {
+		t.Fatal("key should be deleted from store")
+	}
+	store.err = errors.New("store failed")
+	if err := cache.Set([]byte("ijkl"), []byte("mnop"), 0); err != store.err {
+		t.Fatalf("expected store error, got %v", err)
+	}
+	if _, err := cache.Get([]byte("ijkl")); err != ErrNotFound {
+		t.Fatalf("failed write should not be cached, got %v", err)
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(1024, WithWriteBehind(store, time.Hour))
+	cache.Set([]byte("abcd"), []byte("1"), 0)
+	cache.Set([]byte("abcd"), []byte("2"), 0)
+	cache.Set([]byte("efgh"), []byte("3"), 0)
+	cache.Del([]byte("efgh"))
+	if _, ok := store.get("abcd"); ok {
+		t.Fatal("write should be queued")
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := store.get("abcd"); value != "2" || store.writes != 2 {
+		t.Fatalf("expected only the last writes, got %s and %d writes", value, store.writes)
+	}
+
+	store.err = errors.New("store failed")
+	cache.Set([]byte("ijkl"), []byte("4"), 0)
+	if err := cache.Flush(); err != store.err {
+		t.Fatalf("expected store error, got %v", err)
+	}
+	store.mu.Lock()
+	store.err = nil
+	store.mu.Unlock()
+	cache.Close()
+	if value, _ := store.get("ijkl"); value != "4" {
+		t.Fatalf("failed write should be retried on close, got %s", value)
+	}
+}
+
+func TestWriteBehindInterval(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(1024, WithWriteBehind(store, time.Millisecond))
+	defer cache.Close()
+	cache.Set([]byte("abcd"), []byte("efgh"), 0)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if value, _ := store.get("abcd"); value == "efgh" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("write should be flushed in background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := OpenCache(1024, WithWriteBehind(store, 0)); err != ErrInvalidInterval {
+		t.Fatalf("err = %v, want ErrInvalidInterval", err)
+	}
+}
+
+func TestWriteThroughMutators(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(1024*1024, WithWriteThrough(store))
+	defer cache.Close()
+	cache.Set([]byte("a"), []byte("1"), 0)
+	cache.Set([]byte("b"), []byte("1"), 0)
+	cache.Set([]byte("c"), []byte("1"), 0)
+	cache.GetSet([]byte("a"), []byte("2"), 0)
+	cache.Incr([]byte("b"), 5, 0)
+	cache.Pop([]byte("c"))
+	cache.UpdateE([]byte("d"), func(value []byte, found bool) ([]byte, UpdateAction, int, error) {
+		return []byte("3"), UpdateReplace, 0, nil
+	})
+	for key, want := range map[string]string{"a": "2", "b": "6", "d": "3"} {
+		if value, _ := store.get(key); value != want {
+			t.Fatalf("%s = %q in store, want %q", key, value, want)
+		}
+	}
+	if _, ok := store.get("c"); ok {
+		t.Fatal("popped key should be deleted from store")
+	}
+
+	// a set rejected by the cache doesn't reach the store.
+	writes := store.writes
+	if err := cache.Set([]byte("e"), make([]byte, 8192), 0); err != ErrLargeEntry {
+		t.Fatalf("err = %v, want ErrLargeEntry", err)
+	}
+	if store.writes != writes {
+		t.Fatal("rejected set written to store")
+	}
+}
+
+func TestWriteThroughUpdateMulti(t *testing.T) {
+	store := newMockStore()
+	cache := NewCacheWithOptions(1024*1024, WithWriteThrough(store))
+	defer cache.Close()
+	events := cache.Events(10)
+	cache.Set([]byte("a"), []byte("1"), 0)
+	<-events
+	writes := store.writes
+
+	// the rolled back updates reach neither the store nor the events.
+	err := cache.UpdateMulti([][]byte{[]byte("a"), []byte("b"), []byte("large")},
+		func(idx int, old []byte, found bool) ([]byte, bool, int) {
+			if idx == 2 {
+				return make([]byte, 8192), true, 0
+			}
+			return []byte("2"), true, 0
+		})
+	if err != ErrLargeEntry {
+		t.Fatalf("UpdateMulti err = %v", err)
+	}
+	if store.writes != writes || len(events) != 0 {
+		t.Fatalf("%d writes, %d events of the rolled back updates", store.writes-writes, len(events))
+	}
+	if value, _ := store.get("a"); value != "1" {
+		t.Fatalf("a = %q in store", value)
+	}
+
+	err = cache.UpdateMulti([][]byte{[]byte("a"), []byte("b")}, func(idx int, old []byte, found bool) ([]byte, bool, int) {
+		return []byte("2"), true, 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if value, _ := store.get(key); value != "2" {
+			t.Fatalf("%s = %q in store", key, value)
+		}
+		if ev := <-events; ev.Type != EventSet || string(ev.Key) != key || string(ev.Value) != "2" {
+			t.Fatalf("event = %+v", ev)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package freecache
+
+import (
+	"io"
+)
+
+// GetInto writes the value of key to w and returns the number of bytes written, so a value is served
+// without copying it to an intermediate buffer, except when it wraps around the ring buffer like GetFn.
+// The value is written with the segment lock held, so a slow w blocks the keys of the segment, and w must
+// not call the cache. Returns the errors of Get, or the error of w.
+func (cache *Cache) GetInto(key []byte, w io.Writer) (n int, err error) {
+	err = cache.GetFn(key, func(value []byte) (err error) {
+		n, err = w.Write(value)
+		return
+	})
+	return
+}
+
+// SetFrom sets key to the length bytes read from r, expiring in ttl seconds, which are read into the
+// cache memory by Reserve instead of being read into a buffer first. The segment lock is held while r
+// is read, so a slow r blocks the keys of the segment, and r must not call the cache. If r fails, or ends
+// before length bytes with io.ErrUnexpectedEOF, the error is returned and key is deleted.
+func (cache *Cache) SetFrom(key []byte, r io.Reader, length, ttl int) (err error) {
+	entry, err := cache.Reserve(key, length, ttl)
+	if err != nil {
+		return
+	}
+	if _, err = io.ReadFull(r, entry.Bytes()); err != nil {
+		entry.Abort()
+		if err == io.EOF && length > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	return entry.Commit()
+}
@@ -0,0 +1,57 @@
+package freecache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestGetIntoSetFrom(t *testing.T) {
+	cache := NewCacheWithOptions(1024*1024, WithChecksums(true))
+	key := []byte("body")
+	var buf bytes.Buffer
+	if _, err := cache.GetInto(key, &buf); err != ErrNotFound {
+		t.Fatalf("GetInto of a missing key err = %v", err)
+	}
+	body := strings.Repeat("0123456789", 50)
+	if err := cache.SetFrom(key, strings.NewReader(body), len(body), 0); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := cache.GetInto(key, &buf); err != nil || n != len(body) || buf.String() != body {
+		t.Fatalf("GetInto = %d, %v, %q", n, err, buf.String())
+	}
+	if _, err := cache.GetInto(key, failingWriter{}); err == nil || err.Error() != "write failed" {
+		t.Fatalf("GetInto with a failing writer err = %v", err)
+	}
+
+	// only length bytes are read.
+	if err := cache.SetFrom(key, strings.NewReader("hello world"), 5, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.Get(key); err != nil || string(v) != "hello" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if err := cache.SetFrom(key, strings.NewReader("short"), 10, 0); err != io.ErrUnexpectedEOF {
+		t.Fatalf("SetFrom of a short reader err = %v", err)
+	}
+	if err := cache.SetFrom(key, strings.NewReader(""), 10, 0); err != io.ErrUnexpectedEOF {
+		t.Fatalf("SetFrom of an empty reader err = %v", err)
+	}
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Fatalf("Get after a failed SetFrom err = %v", err)
+	}
+	if err := cache.SetFrom(key, strings.NewReader(""), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cache.Get(key); err != nil || len(v) != 0 {
+		t.Fatalf("Get of an empty value = %q, %v", v, err)
+	}
+}
@@ -0,0 +1,74 @@
+package freecache
+
+// SecondaryCache is the second tier of a TieredCache, a *Cache satisfies it.
+type SecondaryCache interface {
+	Get(key []byte) (value []byte, err error)
+	TTL(key []byte) (timeLeft uint32, err error)
+	Set(key, value []byte, expireSeconds int) error
+	Del(key []byte) (affected bool)
+}
+
+// TieredCache is a small and fast L1 Cache in front of a larger L2 SecondaryCache.
+// Entries evicted from L1 are demoted to L2, and L2 hits are promoted back to L1,
+// so an entry is stored in one tier at a time.
+type TieredCache struct {
+	l1 *Cache
+	l2 SecondaryCache
+}
+
+// NewTieredCache returns a TieredCache with a new L1 Cache of l1Size configured by opts, in front of l2.
+// Demoted entries are written to l2 with the L1 segment lock held, so l2 should be fast, e.g. a bigger Cache.
+func NewTieredCache(l1Size int, l2 SecondaryCache, opts ...Option) *TieredCache {
+	t := &TieredCache{l2: l2}
+	opts = append(opts, WithOnEvicted(t.demote))
+	t.l1 = NewCacheWithOptions(l1Size, opts...)
+	return t
+}
+
+// L1 returns the first tier cache.
+func (t *TieredCache) L1() *Cache {
+	return t.l1
+}
+
+// Set stores the entry in L1, and deletes the key from L2 so a stale value is never read from it.
+func (t *TieredCache) Set(key, value []byte, expireSeconds int) (err error) {
+	if err = t.l1.Set(key, value, expireSeconds); err != nil {
+		return
+	}
+	t.l2.Del(key)
+	return
+}
+
+// Get returns the value from L1, or from L2 and then promotes the entry to L1, or a not found error.
+func (t *TieredCache) Get(key []byte) (value []byte, err error) {
+	if value, err = t.l1.Get(key); err == nil {
+		return
+	}
+	if value, err = t.l2.Get(key); err != nil {
+		return
+	}
+	timeLeft, err := t.l2.TTL(key)
+	if err != nil {
+		// expired in between.
+		return nil, err
+	}
+	if t.l1.Set(key, value, int(timeLeft)) == nil {
+		t.l2.Del(key)
+	}
+	return
+}
+
+// Del deletes the key from both tiers and returns true if a delete occurred in any of them.
+func (t *TieredCache) Del(key []byte) (affected bool) {
+	affected = t.l1.Del(key)
+	return t.l2.Del(key) || affected
+}
+
+// Close closes L1, see Cache.Close.
+func (t *TieredCache) Close() {
+	t.l1.Close()
+}
+
+func (t *TieredCache) demote(key, value []byte, expireSeconds int) {
+	t.l2.Set(key, value, expireSeconds)
+}
@@ -0,0 +1,45 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTieredCache(t *testing.T) {
+	l2 := NewCache(16 * 1024 * 1024)
+	tiered := NewTieredCache(512*1024, l2)
+	defer tiered.Close()
+	count := 20000
+	value := make([]byte, 64)
+	for i := 0; i < count; i++ {
+		if err := tiered.Set([]byte(fmt.Sprintf("key%d", i)), value, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tiered.L1().EvacuateCount() == 0 || l2.EntryCount() == 0 {
+		t.Fatal("entries should be evicted from L1 and demoted to L2")
+	}
+	for i := 0; i < count; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		inL1 := tiered.L1().Has(key)
+		if _, err := tiered.Get(key); err != nil {
+			t.Fatalf("key%d should be found in a tier, got %v", i, err)
+		}
+		if !inL1 && !tiered.L1().Has(key) {
+			t.Fatalf("key%d should be promoted to L1", i)
+		}
+	}
+
+	key := []byte("abcd")
+	l2.Set(key, []byte("old"), 0)
+	tiered.Set(key, []byte("new"), 0)
+	if l2.Has(key) {
+		t.Fatal("Set should delete the stale L2 entry")
+	}
+	if !tiered.Del(key) {
+		t.Fatal("del should return affected true")
+	}
+	if _, err := tiered.Get(key); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -6,11 +6,21 @@ import (
 )
 
 // Timer holds representation of current time.
+// New code should use Clock, which has nanosecond resolution, see WithClock.
 type Timer interface {
 	// Give current time (in seconds)
 	Now() uint32
 }
 
+// MilliTimer is a Timer with millisecond resolution, required for sub-second expiration.
+// A Timer that doesn't implement it is used with whole seconds.
+type MilliTimer interface {
+	Timer
+
+	// Give current time (in milliseconds)
+	NowMilli() int64
+}
+
 // Timer that must be stopped.
 type StoppableTimer interface {
 	Timer
@@ -32,6 +42,26 @@ func (timer defaultTimer) Now() uint32 {
 	return getUnixTime()
 }
 
+func (timer defaultTimer) NowMilli() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Second timer adapts a Timer without millisecond resolution to MilliTimer
+type secondTimer struct {
+	Timer
+}
+
+func (timer secondTimer) NowMilli() int64 {
+	return int64(timer.Now()) * 1000
+}
+
+func toMilliTimer(timer Timer) MilliTimer {
+	if milliTimer, ok := timer.(MilliTimer); ok {
+		return milliTimer
+	}
+	return secondTimer{timer}
+}
+
 // Cached timer stores Unix time every second and returns the cached value
 type cachedTimer struct {
 	now    uint32
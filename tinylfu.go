@@ -0,0 +1,83 @@
+package freecache
+
+// tinyLFU estimates the access frequency of keys by their hash, to admit a new entry only if it
+// is accessed at least as often as the entry it evicts. The first access of a key only sets a bit
+// in the doorkeeper, the following accesses increment a count-min sketch of 4 rows of 4 bit counters.
+// All counters are halved and the doorkeeper is cleared after every sampleSize increments, so old
+// accesses fade out. It is guarded by the segment lock.
+type tinyLFU struct {
+	table      []uint64 // the counters, 16 per word, width counters per row.
+	door       []uint64 // the doorkeeper bits, 8 per counter of a row.
+	shift      uint32   // shifts a 32 bit hash to an index of a row.
+	width      uint32
+	additions  int
+	sampleSize int
+}
+
+var tinyLFUSeeds = [4]uint32{0x9e3779b1, 0x85ebca77, 0xc2b2ae3d, 0x27d4eb2f}
+
+// newTinyLFU returns a sketch for about the number of entries, rounded up to a power of 2.
+func newTinyLFU(entries int) *tinyLFU {
+	width, bits := uint32(16), uint32(4)
+	for int(width) < entries {
+		width *= 2
+		bits++
+	}
+	return &tinyLFU{
+		table:      make([]uint64, width/4),
+		door:       make([]uint64, width/8),
+		shift:      32 - bits,
+		width:      width,
+		sampleSize: 10 * int(width),
+	}
+}
+
+func (f *tinyLFU) index(h uint32, row int) uint32 {
+	return uint32(row)*f.width + (h*tinyLFUSeeds[row])>>f.shift
+}
+
+func (f *tinyLFU) doorBit(h uint32) (word, bit uint32) {
+	i := (h * 0x165667b1) >> (f.shift - 3)
+	return i / 64, i % 64
+}
+
+func (f *tinyLFU) increment(h uint32) {
+	word, bit := f.doorBit(h)
+	if f.door[word]&(1<<bit) == 0 {
+		f.door[word] |= 1 << bit
+	} else {
+		for row := 0; row < 4; row++ {
+			i := f.index(h, row)
+			shift := (i % 16) * 4
+			if (f.table[i/16]>>shift)&0xf < 0xf {
+				f.table[i/16] += 1 << shift
+			}
+		}
+	}
+	f.additions++
+	if f.additions >= f.sampleSize {
+		f.reset()
+	}
+}
+
+func (f *tinyLFU) estimate(h uint32) int {
+	min := uint64(0xf)
+	for row := 0; row < 4; row++ {
+		i := f.index(h, row)
+		if c := (f.table[i/16] >> ((i % 16) * 4)) & 0xf; c < min {
+			min = c
+		}
+	}
+	word, bit := f.doorBit(h)
+	return int(min) + int(f.door[word]>>bit&1)
+}
+
+func (f *tinyLFU) reset() {
+	for i := range f.table {
+		f.table[i] = (f.table[i] >> 1) & 0x7777777777777777
+	}
+	for i := range f.door {
+		f.door[i] = 0
+	}
+	f.additions /= 2
+}
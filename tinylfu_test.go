@@ -0,0 +1,63 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTinyLFUSketch(t *testing.T) {
+	f := newTinyLFU(100)
+	if f.estimate(1) != 0 {
+		t.Fatalf("estimate = %d, want 0", f.estimate(1))
+	}
+	for i := 0; i < 5; i++ {
+		f.increment(1)
+	}
+	if f.estimate(1) != 5 {
+		t.Fatalf("estimate = %d, want 5", f.estimate(1))
+	}
+	f.increment(2)
+	if f.estimate(2) != 1 {
+		t.Fatalf("estimate = %d, want 1", f.estimate(2))
+	}
+	f.reset()
+	if f.estimate(1) != 2 || f.estimate(2) != 0 {
+		t.Fatalf("estimates after reset = %d, %d", f.estimate(1), f.estimate(2))
+	}
+}
+
+func scanHitRate(opts ...Option) float64 {
+	cache := NewCacheWithOptions(512*1024, opts...)
+	value := make([]byte, 100)
+	hot := func(i int) []byte { return []byte(fmt.Sprintf("hot%d", i)) }
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			if _, err := cache.Get(hot(i)); err != nil {
+				cache.Set(hot(i), value, 0)
+			}
+		}
+	}
+	for i := 0; i < 20000; i++ {
+		cache.Set([]byte(fmt.Sprintf("scan%d", i)), value, 0)
+	}
+	var hits int
+	for i := 0; i < 1000; i++ {
+		if _, err := cache.Get(hot(i)); err == nil {
+			hits++
+		}
+	}
+	return float64(hits) / 1000
+}
+
+func TestTinyLFU(t *testing.T) {
+	lru := scanHitRate()
+	lfu := scanHitRate(WithTinyLFU())
+	if lfu < 0.9 || lfu <= lru {
+		t.Fatalf("hit rate of hot keys after a scan = %v with TinyLFU, %v without", lfu, lru)
+	}
+	cache := NewCacheWithOptions(512*1024, WithTinyLFU())
+	cache.Set([]byte("key"), []byte("value"), 0)
+	if v, err := cache.Get([]byte("key")); err != nil || string(v) != "value" {
+		t.Fatalf("key = %q, %v", v, err)
+	}
+}
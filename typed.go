@@ -0,0 +1,164 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var ErrInvalidEncoding = errors.New("The value can't be decoded")
+
+// TypedCodec converts values of type T to bytes stored in the cache and back.
+type TypedCodec[T any] interface {
+	// Encode appends the encoding of v to buf and returns the extended buffer.
+	Encode(buf []byte, v T) ([]byte, error)
+	// Decode decodes data, which is only valid during the call, so it must be copied if retained.
+	Decode(data []byte) (T, error)
+}
+
+// CacheOf is a typed view over a Cache, keys and values are converted by codecs.
+// Keys and values are encoded into pooled buffers, so no memory is allocated for them
+// when the codecs don't allocate.
+type CacheOf[K comparable, V any] struct {
+	cache      *Cache
+	keyCodec   TypedCodec[K]
+	valueCodec TypedCodec[V]
+	bufPool    sync.Pool
+}
+
+// NewCacheOf returns a typed view over cache that converts keys and values with the given codecs.
+func NewCacheOf[K comparable, V any](cache *Cache, keyCodec TypedCodec[K], valueCodec TypedCodec[V]) *CacheOf[K, V] {
+	c := &CacheOf[K, V]{
+		cache:      cache,
+		keyCodec:   keyCodec,
+		valueCodec: valueCodec,
+	}
+	c.bufPool.New = func() interface{} {
+		buf := make([]byte, 0, 64)
+		return &buf
+	}
+	return c
+}
+
+// Cache returns the underlying byte-level cache.
+func (c *CacheOf[K, V]) Cache() *Cache {
+	return c.cache
+}
+
+// Set encodes key and value and stores them in the cache, see Cache.Set.
+func (c *CacheOf[K, V]) Set(key K, value V, expireSeconds int) (err error) {
+	bufPtr := c.bufPool.Get().(*[]byte)
+	defer c.putBuf(bufPtr)
+	buf, err := c.keyCodec.Encode((*bufPtr)[:0], key)
+	if err != nil {
+		return
+	}
+	keyLen := len(buf)
+	buf, err = c.valueCodec.Encode(buf, value)
+	*bufPtr = buf
+	if err != nil {
+		return
+	}
+	return c.cache.Set(buf[:keyLen], buf[keyLen:], expireSeconds)
+}
+
+// Get returns the decoded value or not found error, see Cache.Get.
+func (c *CacheOf[K, V]) Get(key K) (value V, err error) {
+	err = c.withKey(key, func(bKey []byte) error {
+		return c.cache.GetFn(bKey, func(data []byte) (err error) {
+			value, err = c.valueCodec.Decode(data)
+			return
+		})
+	})
+	return
+}
+
+// Del deletes the key and returns true or false if a delete occurred, see Cache.Del.
+func (c *CacheOf[K, V]) Del(key K) (affected bool) {
+	c.withKey(key, func(bKey []byte) error {
+		affected = c.cache.Del(bKey)
+		return nil
+	})
+	return
+}
+
+// TTL returns the TTL time left for a given key or a not found error, see Cache.TTL.
+func (c *CacheOf[K, V]) TTL(key K) (timeLeft uint32, err error) {
+	err = c.withKey(key, func(bKey []byte) (err error) {
+		timeLeft, err = c.cache.TTL(bKey)
+		return
+	})
+	return
+}
+
+func (c *CacheOf[K, V]) withKey(key K, fn func(bKey []byte) error) error {
+	bufPtr := c.bufPool.Get().(*[]byte)
+	defer c.putBuf(bufPtr)
+	buf, err := c.keyCodec.Encode((*bufPtr)[:0], key)
+	*bufPtr = buf
+	if err != nil {
+		return err
+	}
+	return fn(buf)
+}
+
+func (c *CacheOf[K, V]) putBuf(bufPtr *[]byte) {
+	// don't keep the memory of large values alive in the pool.
+	if cap(*bufPtr) <= 64*1024 {
+		c.bufPool.Put(bufPtr)
+	}
+}
+
+// StringCodec is a TypedCodec for strings.
+type StringCodec struct{}
+
+func (StringCodec) Encode(buf []byte, v string) ([]byte, error) {
+	return append(buf, v...), nil
+}
+
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// BytesCodec is a TypedCodec for byte slices, the decoded value is a copy.
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(buf []byte, v []byte) ([]byte, error) {
+	return append(buf, v...), nil
+}
+
+func (BytesCodec) Decode(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// Int64Codec is a TypedCodec for int64, encoded like the keys of SetInt.
+type Int64Codec struct{}
+
+func (Int64Codec) Encode(buf []byte, v int64) ([]byte, error) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	return append(buf, b[:]...), nil
+}
+
+func (Int64Codec) Decode(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, ErrInvalidEncoding
+	}
+	return int64(binary.LittleEndian.Uint64(data)), nil
+}
+
+// Uint64Codec is a TypedCodec for uint64.
+type Uint64Codec struct{}
+
+func (Uint64Codec) Encode(buf []byte, v uint64) ([]byte, error) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...), nil
+}
+
+func (Uint64Codec) Decode(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, ErrInvalidEncoding
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
@@ -0,0 +1,58 @@
+package freecache
+
+import (
+	"testing"
+)
+
+func TestCacheOf(t *testing.T) {
+	cache := NewCacheOf[string, int64](NewCache(1024), StringCodec{}, Int64Codec{})
+	if _, err := cache.Get("abcd"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := cache.Set("abcd", 42, 10); err != nil {
+		t.Fatal(err)
+	}
+	value, err := cache.Get("abcd")
+	if err != nil || value != 42 {
+		t.Fatalf("expected 42, got %d, err %v", value, err)
+	}
+	if ttl, err := cache.TTL("abcd"); err != nil || ttl == 0 {
+		t.Fatalf("expected ttl, got %d, err %v", ttl, err)
+	}
+	if raw, err := cache.Cache().Get([]byte("abcd")); err != nil || len(raw) != 8 {
+		t.Fatalf("expected 8 bytes value, got %v, err %v", raw, err)
+	}
+	if !cache.Del("abcd") {
+		t.Fatal("del should return affected true")
+	}
+	if _, err = cache.Get("abcd"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	cache.Cache().Set([]byte("bad"), []byte("abc"), 0)
+	if _, err = cache.Get("bad"); err != ErrInvalidEncoding {
+		t.Fatalf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestCacheOfIntKey(t *testing.T) {
+	raw := NewCache(1024)
+	cache := NewCacheOf[int64, []byte](raw, Int64Codec{}, BytesCodec{})
+	cache.Set(7, []byte("efgh"), 0)
+	value, err := raw.GetInt(7)
+	if err != nil || string(value) != "efgh" {
+		t.Fatalf("int64 keys should be compatible with GetInt, got %s, err %v", value, err)
+	}
+}
+
+func BenchmarkCacheOfGet(b *testing.B) {
+	b.ReportAllocs()
+	cache := NewCacheOf[uint64, uint64](NewCache(256*1024*1024), Uint64Codec{}, Uint64Codec{})
+	for i := 0; i < b.N; i++ {
+		cache.Set(uint64(i), uint64(i), 0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Get(uint64(i))
+	}
+}
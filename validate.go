@@ -0,0 +1,111 @@
+package freecache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Validate checks the invariants of every segment, locking one segment at a time: the entry headers
+// in the ring buffer, the entry pointers of the slots, which must be sorted and point to the live entries
+// of their keys, and the counters kept for the entries, e.g. EntryCount. It returns the violations found,
+// nil if the cache is consistent. It walks all entries, so it is meant for debugging and tests.
+func (cache *Cache) Validate() (errs []error) {
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		errs = append(errs, cache.segments[i].validate()...)
+		cache.locks[i].Unlock()
+	}
+	return
+}
+
+func (seg *segment) validate() (errs []error) {
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("segment %d: "+format, append([]interface{}{seg.segId}, args...)...))
+	}
+	size := seg.rb.Size()
+	end := seg.rb.End()
+	begin := end + seg.vacuumLen - size
+	if seg.vacuumLen < 0 || seg.vacuumLen > size || begin < seg.rb.Begin() {
+		fail("vacuum length %d out of the ring buffer [%d, %d)", seg.vacuumLen, seg.rb.Begin(), end)
+		return
+	}
+
+	var live, total, totalTime, totalCost, pinnedLen, pinnedCount, deletedLen int64
+	var nsUsed [maxQuotaNamespaces + 1]int64
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	maxKeyValLen := uint64(len(seg.rb.data)/4 - ENTRY_HDR_SIZE)
+	off := begin
+	for off < end {
+		seg.rb.ReadAt(hdrBuf[:], off)
+		entryLen := seg.entryLen(hdr)
+		if hdr.valLen > hdr.valCap || hdr.valCap == 0 || uint64(hdr.keyLen)+uint64(hdr.valCap) > maxKeyValLen {
+			fail("entry at %d has key length %d, value length %d and capacity %d", off, hdr.keyLen, hdr.valLen, hdr.valCap)
+			return
+		}
+		if off+entryLen > end {
+			fail("entry at %d of length %d overruns the end %d", off, entryLen, end)
+			return
+		}
+		total++
+		totalTime += int64(hdr.accessTime)
+		if hdr.flags&flagDeleted != 0 {
+			deletedLen += entryLen
+		} else {
+			live++
+			totalCost += seg.entryCost(hdr, off)
+			nsUsed[hdr.flags>>nsShift] += entryLen
+			if hdr.flags&flagPinned != 0 {
+				pinnedLen += entryLen
+				pinnedCount++
+			}
+			if _, match := seg.lookupByOff(seg.getSlot(hdr.slotId), hdr.hash16, off); !match {
+				fail("live entry at %d is not in slot %d", off, hdr.slotId)
+			}
+		}
+		off += entryLen
+	}
+
+	var ptrs int64
+	for i := range seg.slotLens {
+		if seg.slotLens[i] < 0 || seg.slotLens[i] > seg.slotCap {
+			fail("slot %d length %d out of capacity %d", i, seg.slotLens[i], seg.slotCap)
+			continue
+		}
+		slot := seg.getSlot(uint8(i))
+		ptrs += int64(len(slot))
+		for idx := range slot {
+			ptr := &slot[idx]
+			if idx > 0 && slot[idx-1].hash16 > ptr.hash16 {
+				fail("slot %d is not sorted at %d", i, idx)
+			}
+			if ptr.offset < begin || ptr.offset+ENTRY_HDR_SIZE > end {
+				fail("slot %d points to %d out of the entries [%d, %d)", i, ptr.offset, begin, end)
+				continue
+			}
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			if hdr.flags&flagDeleted != 0 || hdr.slotId != uint8(i) || hdr.hash16 != ptr.hash16 || hdr.keyLen != ptr.keyLen {
+				fail("slot %d points to %d, which isn't the live entry of its key", i, ptr.offset)
+			}
+		}
+	}
+
+	check := func(name string, got, want int64) {
+		if got != want {
+			fail("%s is %d, the entries have %d", name, got, want)
+		}
+	}
+	check("entry count", atomic.LoadInt64(&seg.entryCount), live)
+	check("entry pointer count", ptrs, live)
+	check("total count", atomic.LoadInt64(&seg.totalCount), total)
+	check("total access time", atomic.LoadInt64(&seg.totalTime), totalTime)
+	check("total cost", atomic.LoadInt64(&seg.totalCost), totalCost)
+	check("pinned bytes", seg.pinnedLen, pinnedLen)
+	check("pinned count", seg.pinnedCount, pinnedCount)
+	check("deleted bytes", atomic.LoadInt64(&seg.deletedLen), deletedLen)
+	for i := range nsUsed {
+		check(fmt.Sprintf("namespace %d bytes", i), atomic.LoadInt64(&seg.nsUsed[i]), nsUsed[i])
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package freecache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cache := NewCacheWithOptions(512*1024, WithChecksums(true))
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("key%d", i%3000))
+		switch i % 7 {
+		case 0:
+			cache.Del(key)
+		case 1:
+			cache.SetWithCost(key, []byte(strings.Repeat("v", i%100)), int64(i%5+2), 0)
+		case 2:
+			cache.Append(key, []byte("suffix"))
+		case 3:
+			cache.SetNotFound(key, 10)
+		case 4:
+			cache.Pin(key)
+		default:
+			cache.Set(key, []byte(strings.Repeat("v", i%200)), 0)
+		}
+		cache.Get(key)
+	}
+	if errs := cache.Validate(); errs != nil {
+		t.Fatalf("valid cache: %v", errs)
+	}
+	if err := cache.Resize(1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if errs := cache.Validate(); errs != nil {
+		t.Fatalf("resized cache: %v", errs)
+	}
+
+	seg := &cache.segments[0]
+	seg.entryCount++
+	for i := 0; i < 256; i++ {
+		if slot := seg.getSlot(uint8(i)); len(slot) > 0 {
+			slot[0].hash16 = 0xffff
+			break
+		}
+	}
+	if errs := cache.Validate(); len(errs) < 2 {
+		t.Fatalf("corrupted segment errs = %v", errs)
+	}
+}
@@ -0,0 +1,121 @@
+package freecache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrViewed is returned by a write needing the space of an entry held by a View, see AcquireView, and by
+// Resize and Reset while a segment to reallocate is viewed.
+var ErrViewed = errors.New("The entry to evict is held by a view")
+
+// DefaultViewTimeout is the time after which the pin of a View not released is dropped, see WithViewTimeout.
+const DefaultViewTimeout = 10 * time.Second
+
+// View is a read-only zero-copy view of a value, returned by AcquireView. The entry is pinned in its ring
+// buffer until Release, so the value can't be overwritten, moved or evicted while it is viewed.
+type View struct {
+	value []byte
+	seg   *segment
+	lock  *sync.Mutex
+	id    uint64 // id of the pin of the entry in the segment.
+}
+
+// viewPin pins the entry at offset for the view id, see segment.viewed. The offset is -1 once the entries
+// are cleared, the pin then only keeps the ring buffer from being freed.
+type viewPin struct {
+	id       uint64
+	offset   int64
+	pinnedAt int64 // time of the pin in milliseconds, see segment.expireViews.
+}
+
+// Bytes returns the value, it must not be modified or used after Release.
+func (v *View) Bytes() []byte {
+	return v.value
+}
+
+// Len returns the length of the value.
+func (v *View) Len() int {
+	return len(v.value)
+}
+
+// Release unpins the entry of the value. It is safe to call Release more than once, and on the copies of
+// a view, only the first call unpins the entry.
+func (v *View) Release() {
+	if v.seg == nil {
+		return
+	}
+	v.lock.Lock()
+	v.seg.unpinView(v.id)
+	v.lock.Unlock()
+	v.value = nil
+	v.seg = nil
+}
+
+// AcquireView returns a zero-copy view of the value of key, like GetFn, but without a callback, so the
+// value can be returned to the caller. The entry is pinned until the view is released: it is kept at its
+// place in the ring buffer, the writes of the key store the new value in a new entry, and the writes needing
+// the space of the entry when it is the oldest of its segment, 1/256 of the cache, fail with ErrViewed, so
+// Release must be called as soon as possible. A view not released within the timeout of WithViewTimeout
+// loses its pin, and its value may change once its entry is evicted. Clear doesn't wait for the views, the
+// values viewed may change after it, Resize and Reset fail with ErrViewed while a segment to reallocate is
+// viewed, and the views must be released before Close. On error, nothing has to be released.
+func (cache *Cache) AcquireView(key []byte) (view View, err error) {
+	hashVal := cache.hash(key)
+	segID := hashVal & segmentAndOpVal
+	cache.locks[segID].Lock()
+	seg := &cache.segments[segID]
+	value, _, offset, err := seg.viewEntryAt(key, hashVal, false)
+	if err == nil {
+		view = View{value: value, seg: seg, lock: &cache.locks[segID], id: seg.pinView(offset)}
+	}
+	cache.locks[segID].Unlock()
+	return
+}
+
+// pinView pins the entry at offset and returns the id of the pin.
+func (seg *segment) pinView(offset int64) uint64 {
+	seg.viewSeq++
+	seg.views = append(seg.views, viewPin{id: seg.viewSeq, offset: offset, pinnedAt: seg.timer.NowMilli()})
+	return seg.viewSeq
+}
+
+// unpinView removes the pin id, if the segment still has it.
+func (seg *segment) unpinView(id uint64) {
+	for i := range seg.views {
+		if seg.views[i].id == id {
+			last := len(seg.views) - 1
+			seg.views[i] = seg.views[last]
+			seg.views = seg.views[:last]
+			return
+		}
+	}
+}
+
+// viewed reports whether the entry at offset is pinned by a view.
+func (seg *segment) viewed(offset int64) bool {
+	for i := range seg.views {
+		if seg.views[i].offset == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// expireViews drops the pins older than the view timeout of the segment, so a view never released doesn't
+// make the writes fail forever.
+func (seg *segment) expireViews(nowMs int64) {
+	if seg.viewTimeout <= 0 {
+		return
+	}
+	for i := 0; i < len(seg.views); {
+		if nowMs-seg.views[i].pinnedAt < seg.viewTimeout {
+			i++
+			continue
+		}
+		last := len(seg.views) - 1
+		seg.views[i] = seg.views[last]
+		seg.views = seg.views[:last]
+	}
+}
@@ -0,0 +1,148 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAcquireView(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	key := []byte("abcd")
+	if _, err := cache.AcquireView(key); err != ErrNotFound {
+		t.Fatalf("AcquireView of missing key err = %v", err)
+	}
+	cache.Set(key, []byte("efgh"), 0)
+
+	view, err := cache.AcquireView(key)
+	if err != nil || string(view.Bytes()) != "efgh" || view.Len() != 4 {
+		t.Fatalf("AcquireView = %q, %v", view.Bytes(), err)
+	}
+	if cache.HitCount() != 1 {
+		t.Fatalf("HitCount = %d", cache.HitCount())
+	}
+	// the writes of the key don't wait for the view, nor overwrite the viewed value.
+	if err := cache.Set(key, []byte("ijkl"), 0); err != nil {
+		t.Fatal(err)
+	}
+	cache.Append(key, []byte("m"))
+	cache.UpdateInPlace(key, func(value []byte) error {
+		value[0] = 'I'
+		return nil
+	})
+	if string(view.Bytes()) != "efgh" {
+		t.Fatalf("viewed value changed to %q", view.Bytes())
+	}
+	if value, _ := cache.Get(key); string(value) != "Ijklm" {
+		t.Fatalf("value = %q", value)
+	}
+	copied := view
+	view.Release()
+	view.Release()
+	copied.Release()
+	if view.Bytes() != nil {
+		t.Fatal("released view still has a value")
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		view, _ := cache.AcquireView(key)
+		view.Release()
+	}); allocs > 0 {
+		t.Fatalf("AcquireView allocs = %v", allocs)
+	}
+}
+
+func TestAcquireViewEvacuation(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	seg := &cache.segments[0]
+	var hashVal uint64 = 0x100
+	key := []byte("viewed")
+	if err := seg.set(key, []byte("value"), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	cache.locks[0].Lock()
+	value, _, offset, _ := seg.viewEntryAt(key, hashVal, true)
+	id := seg.pinView(offset)
+	cache.locks[0].Unlock()
+	view := View{value: value, seg: seg, lock: &cache.locks[0], id: id}
+
+	// the writes reaching the viewed entry, the oldest of the segment, fail.
+	var err error
+	for i := 0; err == nil && i < 10000; i++ {
+		err = seg.set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), hashVal+uint64(i)<<8, 0)
+	}
+	if err != ErrViewed {
+		t.Fatalf("err = %v, want ErrViewed", err)
+	}
+	if string(view.Bytes()) != "value" {
+		t.Fatalf("viewed value changed to %q", view.Bytes())
+	}
+	if reclaimed := cache.Compact(0); reclaimed != 0 {
+		t.Fatalf("compacted %d bytes with a view", reclaimed)
+	}
+	view.Release()
+	if err := seg.set([]byte("key"), make([]byte, 100), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if errs := cache.Validate(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// Close doesn't wait for the views.
+	view, _ = cache.AcquireView([]byte("key"))
+	cache.Close()
+}
+
+func TestAcquireViewTimeout(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 100000}
+	cache := NewCacheWithOptions(512*1024, WithTimer(timer), WithViewTimeout(time.Second))
+	seg := &cache.segments[0]
+	var hashVal uint64 = 0x100
+	key := []byte("viewed")
+	if err := seg.set(key, []byte("value"), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	cache.locks[0].Lock()
+	_, _, offset, _ := seg.viewEntryAt(key, hashVal, true)
+	seg.pinView(offset)
+	cache.locks[0].Unlock()
+
+	// the ring buffer of a viewed segment isn't reallocated.
+	if err := cache.Resize(1024 * 1024); err != ErrViewed {
+		t.Fatalf("Resize err = %v, want ErrViewed", err)
+	}
+	if err := cache.Reset(1024 * 1024); err != ErrViewed {
+		t.Fatalf("Reset err = %v, want ErrViewed", err)
+	}
+	// the segment is still cleared, its pin only keeps the ring buffer.
+	if _, _, err := seg.get(key, nil, hashVal, true); err != ErrNotFound {
+		t.Fatalf("get after Reset err = %v", err)
+	}
+	if len(seg.views) != 1 || seg.views[0].offset != -1 {
+		t.Fatalf("views after Reset = %+v", seg.views)
+	}
+
+	// the pin of a view never released is dropped after the timeout.
+	if err := seg.set(key, []byte("value"), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	cache.locks[0].Lock()
+	_, _, offset, _ = seg.viewEntryAt(key, hashVal, true)
+	seg.views[0].offset = offset
+	cache.locks[0].Unlock()
+	var err error
+	for i := 0; err == nil && i < 10000; i++ {
+		err = seg.set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), hashVal+uint64(i)<<8, 0)
+	}
+	if err != ErrViewed {
+		t.Fatalf("err = %v, want ErrViewed", err)
+	}
+	timer.nowMs += 1000
+	if err := seg.set([]byte("key"), make([]byte, 100), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(seg.views) != 0 {
+		t.Fatalf("views = %+v", seg.views)
+	}
+	if err := cache.Resize(1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package freecache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const hitWindowBuckets = 60
+
+// hitWindow counts the hits and misses of a segment in a ring of buckets of interval milliseconds,
+// a bucket is reused when its epoch, the number of intervals since the unix epoch, is over.
+// It is guarded by the segment lock.
+type hitWindow struct {
+	interval int64
+	epochs   [hitWindowBuckets]int64
+	hits     [hitWindowBuckets]int64
+	misses   [hitWindowBuckets]int64
+}
+
+func newHitWindow(window time.Duration) *hitWindow {
+	interval := window.Milliseconds() / hitWindowBuckets
+	if interval < 1 {
+		interval = 1
+	}
+	return &hitWindow{interval: interval}
+}
+
+func (w *hitWindow) add(nowMs int64, hit bool) {
+	epoch := nowMs / w.interval
+	i := epoch % hitWindowBuckets
+	if w.epochs[i] != epoch {
+		w.epochs[i] = epoch
+		w.hits[i] = 0
+		w.misses[i] = 0
+	}
+	if hit {
+		w.hits[i]++
+	} else {
+		w.misses[i]++
+	}
+}
+
+// sum returns the hits and misses of the last n buckets, including the current one.
+func (w *hitWindow) sum(nowMs int64, n int64) (hits, misses int64) {
+	if n > hitWindowBuckets {
+		n = hitWindowBuckets
+	}
+	epoch := nowMs / w.interval
+	for i := range w.epochs {
+		if w.epochs[i] > epoch-n && w.epochs[i] <= epoch {
+			hits += w.hits[i]
+			misses += w.misses[i]
+		}
+	}
+	return
+}
+
+func (seg *segment) countHit() {
+	atomic.AddInt64(&seg.hitCount, 1)
+	if seg.window != nil {
+		seg.window.add(seg.timer.NowMilli(), true)
+	}
+}
+
+func (seg *segment) countMiss() {
+	atomic.AddInt64(&seg.missCount, 1)
+	if seg.window != nil {
+		seg.window.add(seg.timer.NowMilli(), false)
+	}
+}
+
+// HitRateWindow returns the ratio of hits over lookups in the last d, with the resolution of 1/60 of
+// the window of WithHitRateWindow, which d is limited to. It returns 0 if WithHitRateWindow is not set.
+func (cache *Cache) HitRateWindow(d time.Duration) float64 {
+	var hits, misses int64
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		cache.locks[i].Lock()
+		if seg.window != nil {
+			n := (d.Milliseconds() + seg.window.interval - 1) / seg.window.interval
+			h, m := seg.window.sum(seg.timer.NowMilli(), n)
+			hits += h
+			misses += m
+		}
+		cache.locks[i].Unlock()
+	}
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -0,0 +1,42 @@
+package freecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHitRateWindow(t *testing.T) {
+	timer := &mockMilliTimer{nowMs: 1000000}
+	cache := NewCacheWithOptions(512*1024, WithTimer(timer), WithHitRateWindow(time.Minute))
+	cache.Set([]byte("a"), []byte("1"), 0)
+	// a minute of misses, then 10 seconds of hits.
+	for i := 0; i < 60; i++ {
+		cache.Get([]byte("b"))
+		timer.nowMs += 1000
+	}
+	for i := 0; i < 10; i++ {
+		cache.Get([]byte("a"))
+		timer.nowMs += 1000
+	}
+	timer.nowMs -= 1000
+	if rate := cache.HitRateWindow(10 * time.Second); rate != 1 {
+		t.Fatalf("hit rate of 10s = %v, want 1", rate)
+	}
+	if rate := cache.HitRateWindow(20 * time.Second); rate != 0.5 {
+		t.Fatalf("hit rate of 20s = %v, want 0.5", rate)
+	}
+	// the window is limited to a minute.
+	if rate := cache.HitRateWindow(time.Hour); rate != 10.0/60 {
+		t.Fatalf("hit rate of 1h = %v, want %v", rate, 10.0/60)
+	}
+	if rate := cache.HitRate(); rate != 10.0/70 {
+		t.Fatalf("hit rate = %v, want %v", rate, 10.0/70)
+	}
+	timer.nowMs += time.Hour.Milliseconds()
+	if rate := cache.HitRateWindow(time.Minute); rate != 0 {
+		t.Fatalf("hit rate after an hour = %v, want 0", rate)
+	}
+	if rate := NewCache(512 * 1024).HitRateWindow(time.Minute); rate != 0 {
+		t.Fatalf("hit rate without window = %v, want 0", rate)
+	}
+}